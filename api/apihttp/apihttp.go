@@ -20,8 +20,9 @@ package apihttp
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/bbva/qed/balloon"
@@ -33,16 +34,22 @@ import (
 )
 
 type ClientApi interface {
+	QueryApi
 	Add(event []byte) (*balloon.Snapshot, error)
 	AddBulk(bulk [][]byte) ([]*balloon.Snapshot, error)
+	ClusterInfo() *consensus.ClusterInfo
+	Info() *consensus.NodeInfo
+	IsLeader() bool
+}
+
+// QueryApi is the part of ClientApi needed to serve proofs.
+type QueryApi interface {
 	QueryDigestMembershipConsistency(keyDigest hashing.Digest, version uint64) (*balloon.MembershipProof, error)
 	QueryMembershipConsistency(event []byte, version uint64) (*balloon.MembershipProof, error)
 	QueryDigestMembership(keyDigest hashing.Digest) (*balloon.MembershipProof, error)
 	QueryMembership(event []byte) (*balloon.MembershipProof, error)
 	QueryConsistency(start, end uint64) (*balloon.IncrementalProof, error)
-	ClusterInfo() *consensus.ClusterInfo
-	Info() *consensus.NodeInfo
-	IsLeader() bool
+	WaitForConsistency(level protocol.ReadConsistency, minVersion uint64) error
 }
 
 // HealthCheckResponse contains the response from HealthCheckHandler.
@@ -52,25 +59,59 @@ type HealthCheckResponse struct {
 }
 
 // NewApiHttp returns a new *http.ServeMux containing the current API handlers.
-//	/health-check -> Qed server healthcheck
-//	/events -> Add event operation
-//	/events/bulk -> Add event bulk operation
-//	/proofs/membership -> Membership query using event
-//	/proofs/digest-membership -> Membership query using event digest
-//	/proofs/incremental -> Incremental query
-//	/info -> Qed server information
-//	/info/shards -> Qed cluster information
+//	/v1/healthcheck -> Qed server healthcheck
+//	/v1/events -> Add event operation
+//	/v1/events/bulk -> Add event bulk operation
+//	/v1/events?async=true -> Asynchronous add event operation
+//	/v1/events/bulk?async=true -> Asynchronous add event bulk operation
+//	/v1/tickets/{id} -> Result of an asynchronous add operation
+//	/v1/proofs/membership -> Membership query using event
+//	/v1/proofs/digest-membership -> Membership query using event digest
+//	/v1/proofs/incremental -> Incremental query
+//	/v1/info -> Qed server information
+//	/v1/info/shards -> Qed cluster information
+//	/v1/quota -> Ingestion limits of the calling client
+//	/v1/openapi.json -> OpenAPI description of the API
+//
+// Every endpoint is also served without the /v1 prefix as a deprecated alias.
+//
+// Every unsuccessful response carries a protocol.Error JSON body whose
+// code identifies the kind of failure.
 func NewApiHttp(api ClientApi) *http.ServeMux {
+	return NewApiHttpWithLimiter(api, nil)
+}
 
+// NewApiHttpWithLimiter returns a new *http.ServeMux containing the current
+// API handlers, enforcing the limiter on the ingestion endpoints.
+// A nil limiter disables the limits.
+func NewApiHttpWithLimiter(api ClientApi, limiter *Limiter) *http.ServeMux {
+
+	tickets := NewTicketStore(DefaultMaxPendingTickets, DefaultTicketTTL)
+	return newServeMux(Routes(api, tickets, limiter))
+}
+
+// NewQueryOnlyApiHttp returns a new *http.ServeMux serving the healthcheck
+// and the proofs of the given api, which cannot add events:
+//	/v1/healthcheck -> Qed server healthcheck
+//	/v1/proofs/membership -> Membership query using event
+//	/v1/proofs/digest-membership -> Membership query using event digest
+//	/v1/proofs/incremental -> Incremental query
+//	/v1/openapi.json -> OpenAPI description of the API
+//
+// As in NewApiHttp, every endpoint is also served without the /v1 prefix.
+func NewQueryOnlyApiHttp(api QueryApi) *http.ServeMux {
+	return newServeMux(QueryOnlyRoutes(api))
+}
+
+func newServeMux(routes []Route) *http.ServeMux {
+	v1 := http.NewServeMux()
 	mux := http.NewServeMux()
-	mux.HandleFunc("/healthcheck", HealthCheckHandler())
-	mux.HandleFunc("/events", Add(api))
-	mux.HandleFunc("/events/bulk", AddBulk(api))
-	mux.HandleFunc("/proofs/membership", Membership(api))
-	mux.HandleFunc("/proofs/digest-membership", DigestMembership(api))
-	mux.HandleFunc("/proofs/incremental", Incremental(api))
-	mux.HandleFunc("/info", InfoHandler(api))
-	mux.HandleFunc("/info/shards", InfoShardsHandler(api))
+	for _, route := range routes {
+		v1.HandleFunc(route.pattern(), route.Handler)
+		mux.HandleFunc(route.pattern(), Deprecated(route.Handler))
+	}
+	v1.HandleFunc("/openapi.json", OpenAPIHandler(routes))
+	mux.Handle(APIVersion+"/", http.StripPrefix(APIVersion, v1))
 
 	return mux
 }
@@ -125,50 +166,40 @@ func Add(api ClientApi) http.HandlerFunc {
 		}
 
 		var event protocol.Event
-		err = json.NewDecoder(r.Body).Decode(&event)
+		err = decodeRequest(r, &event)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			WriteError(w, err)
+			return
+		}
+
+		// Requests redirected to the leader or failed are not charged.
+		refund, ok := consumeQuota(w, r, 1)
+		if !ok {
 			return
 		}
 
 		// Wait for the response
 		response, err := api.Add(event.Event)
+		if err != nil {
+			refund()
+		}
 		switch err {
 		case nil:
 			break
 		case raft.ErrNotLeader:
 			fallthrough
 		case raft.ErrLeadershipLost:
-			var scheme protocol.Scheme
-			if r.TLS != nil {
-				scheme = protocol.Https
-			} else {
-				scheme = protocol.Http
-			}
-
-			shards, err := getShards(api, scheme)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			out, err := json.Marshal(shards)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			w.Header().Set("Content-Type", "application/json")
-			_, _ = w.Write(out)
-			http.Redirect(w, r, shards.Shards[shards.LeaderId].HTTPAddr, http.StatusMovedPermanently)
+			redirectToLeader(w, r, api)
 			return
 		default:
-			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			WriteError(w, err)
 			return
 		}
 
 		snapshot := protocol.Snapshot(*response)
 		out, err := json.Marshal(&snapshot)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			WriteError(w, err)
 			return
 		}
 
@@ -212,49 +243,39 @@ func AddBulk(api ClientApi) http.HandlerFunc {
 		}
 
 		var eventBulk protocol.EventsBulk
-		err = json.NewDecoder(r.Body).Decode(&eventBulk)
+		err = decodeRequest(r, &eventBulk)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			WriteError(w, err)
+			return
+		}
+
+		// Requests redirected to the leader or failed are not charged.
+		refund, ok := consumeQuota(w, r, len(eventBulk.Events))
+		if !ok {
 			return
 		}
 
 		// Wait for the response
 		snapshotBulk, err := api.AddBulk(eventBulk.Events)
+		if err != nil {
+			refund()
+		}
 		switch err {
 		case nil:
 			break
 		case raft.ErrNotLeader:
 			fallthrough
 		case raft.ErrLeadershipLost:
-			var scheme protocol.Scheme
-			if r.TLS != nil {
-				scheme = protocol.Https
-			} else {
-				scheme = protocol.Http
-			}
-
-			shards, err := getShards(api, scheme)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			out, err := json.Marshal(shards)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			w.Header().Set("Content-Type", "application/json")
-			_, _ = w.Write(out)
-			http.Redirect(w, r, shards.Shards[shards.LeaderId].HTTPAddr, http.StatusMovedPermanently)
+			redirectToLeader(w, r, api)
 			return
 		default:
-			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			WriteError(w, err)
 			return
 		}
 
 		out, err := json.Marshal(snapshotBulk)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			WriteError(w, err)
 			return
 		}
 
@@ -265,11 +286,213 @@ func AddBulk(api ClientApi) http.HandlerFunc {
 	}
 }
 
+// AsyncSwitch routes the request to the async handler when the
+// "async" query parameter is set to true, and to the sync one otherwise.
+func AsyncSwitch(sync, async http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if isAsync, _ := strconv.ParseBool(r.URL.Query().Get("async")); isAsync {
+			async(w, r)
+			return
+		}
+		sync(w, r)
+	}
+}
+
+// AddAsync posts an event into the system without waiting for raft
+// to commit it:
+// The http post url is:
+//   POST /events?async=true
+//
+// The following statuses are expected:
+// If everything is alright, the HTTP status is 202, the Location header
+// points to the ticket and the body contains:
+//   {
+//     "ID": "0e0c1fa1a8ae8e2bf2c4f0a91b3a6f1c"
+//   }
+//
+// If the ticket store is full, the HTTP status is 503.
+func AddAsync(api ClientApi, tickets *TicketStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		AddAsyncRequest.Inc()
+		defer AddAsyncRequest.Dec()
+		// Make sure we can only be called with an HTTP POST request.
+		w, r, err = PostReqSanitizer(w, r)
+		if err != nil {
+			return
+		}
+
+		var event protocol.Event
+		err = decodeRequest(r, &event)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+
+		acceptTicket(w, r, api, tickets, 1, func() ([]*balloon.Snapshot, error) {
+			snapshot, err := api.Add(event.Event)
+			if err != nil {
+				return nil, err
+			}
+			return []*balloon.Snapshot{snapshot}, nil
+		})
+	}
+}
+
+// AddBulkAsync posts a bulk of events into the system without waiting
+// for raft to commit them:
+// The http post url is:
+//   POST /events/bulk?async=true
+//
+// The following statuses are expected:
+// If everything is alright, the HTTP status is 202, the Location header
+// points to the ticket and the body contains:
+//   {
+//     "ID": "0e0c1fa1a8ae8e2bf2c4f0a91b3a6f1c"
+//   }
+//
+// If the ticket store is full, the HTTP status is 503.
+func AddBulkAsync(api ClientApi, tickets *TicketStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		AddBulkAsyncRequest.Inc()
+		defer AddBulkAsyncRequest.Dec()
+		// Make sure we can only be called with an HTTP POST request.
+		w, r, err = PostReqSanitizer(w, r)
+		if err != nil {
+			return
+		}
+
+		var eventBulk protocol.EventsBulk
+		err = decodeRequest(r, &eventBulk)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+
+		acceptTicket(w, r, api, tickets, len(eventBulk.Events), func() ([]*balloon.Snapshot, error) {
+			return api.AddBulk(eventBulk.Events)
+		})
+	}
+}
+
+// acceptTicket charges the n events to the client quota, opens a new
+// ticket, answers the request with it and runs the add operation in
+// background, resolving the ticket when it finishes.
+func acceptTicket(w http.ResponseWriter, r *http.Request, api ClientApi, tickets *TicketStore, n int, add func() ([]*balloon.Snapshot, error)) {
+
+	// Only the leader can accept writes, so we redirect before
+	// handing out a ticket that would never be resolved.
+	if !api.IsLeader() {
+		redirectToLeader(w, r, api)
+		return
+	}
+
+	refund, ok := consumeQuota(w, r, n)
+	if !ok {
+		return
+	}
+
+	id, err := tickets.Open()
+	if err != nil {
+		refund()
+		WriteError(w, err)
+		return
+	}
+
+	go func() {
+		snapshots, err := add()
+		if err != nil {
+			refund()
+		}
+		tickets.Resolve(id, snapshots, err)
+	}()
+
+	out, err := json.Marshal(&protocol.Ticket{ID: id})
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", APIVersion+"/tickets/"+id)
+	w.WriteHeader(http.StatusAccepted)
+	_, _ = w.Write(out)
+}
+
+// TicketHandler returns the status of an asynchronous add operation:
+// The http get url is:
+//   GET /tickets/{id}
+//
+// The following statuses are expected:
+// If the ticket exists, the HTTP status is 200 and the body contains:
+//   {
+//     "ID": "0e0c1fa1a8ae8e2bf2c4f0a91b3a6f1c",
+//     "State": "done",
+//     "Snapshots": [
+//       {
+//         "EventDigest":   "5beeaf427ee0bfcd1a7b6f63010f2745110cf23ae088b859275cd0aad369561b",
+//         "HistoryDigest": "b8fdd4b2146fe560f94d7a48f8bb3eaf6938f7de6ac6d05bbe033787d8b71846",
+//         "HyperDigest":   "6a050f12acfc22989a7681f901a68ace8a9a3672428f8a877f4d21568123a0cb",
+//         "Version": 0
+//       }
+//     ],
+//     "Error": ""
+//   }
+//
+// If the ticket has expired, the HTTP status is 404 with the not_found
+// error code. If the ticket was not issued by this node, e.g. because the
+// leader that accepted the events has changed, the HTTP status is 404
+// with the ticket_unknown error code: the events may have been added, so
+// they should be checked with a membership query.
+func TicketHandler(tickets *TicketStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		TicketRequest.Inc()
+		defer TicketRequest.Dec()
+
+		var err error
+		// Make sure we can only be called with an HTTP GET request.
+		w, r, err = GetReqSanitizer(w, r)
+		if err != nil {
+			return
+		}
+
+		id := strings.TrimPrefix(r.URL.Path, "/tickets/")
+		status, ok := tickets.Get(id)
+		if !ok && !tickets.Issued(id) {
+			WriteError(w, protocol.NewError(protocol.ErrCodeTicketUnknown, "ticket %s was not issued by this node, the leadership may have changed", id))
+			return
+		}
+		if !ok {
+			WriteError(w, protocol.NewError(protocol.ErrCodeNotFound, "ticket %s not found", id))
+			return
+		}
+
+		out, err := json.Marshal(status)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(out)
+	}
+}
+
 // Membership returns the membership proof for a given event
 // The http post url is:
 //   POST /proofs/membership
 //
+// The Consistency field of the query sets how up to date the node must be
+// (stale, bounded or linearizable). Bounded reads require the node to have
+// applied MinVersion, so clients that have not observed any version yet
+// must send stale reads instead. Linearizable reads wait for the node to
+// catch up with the leader. The same applies to the rest of the proof handlers.
+//
 // The following statuses are expected:
+// If the node cannot satisfy the consistency level, the HTTP status is 503
+// with a stale_read error code, and the query can be sent to another node.
 // If everything is alright, the HTTP status is 200 and the body contains:
 // {
 // 	"Exists":           true,
@@ -280,7 +503,7 @@ func AddBulk(api ClientApi) http.HandlerFunc {
 //  "ActualVersion":	0,
 // 	"KeyDigest":		"5beeaf427ee0bfcd1a7b6f63010f2745110cf23ae088b859275cd0aad369561b"
 // }
-func Membership(api ClientApi) http.HandlerFunc {
+func Membership(api QueryApi) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		MembershipRequest.Inc()
 		defer MembershipRequest.Dec()
@@ -294,9 +517,14 @@ func Membership(api ClientApi) http.HandlerFunc {
 		}
 
 		var query protocol.MembershipQuery
-		err = json.NewDecoder(r.Body).Decode(&query)
+		err = decodeRequest(r, &query)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			WriteError(w, err)
+			return
+		}
+
+		if err := api.WaitForConsistency(query.Consistency, query.MinVersion); err != nil {
+			WriteError(w, err)
 			return
 		}
 
@@ -304,7 +532,7 @@ func Membership(api ClientApi) http.HandlerFunc {
 			// Wait for the response
 			proof, err = api.QueryMembership(query.Key)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusPreconditionFailed)
+				WriteError(w, err)
 				return
 			}
 		} else {
@@ -312,13 +540,13 @@ func Membership(api ClientApi) http.HandlerFunc {
 			// Wait for the response
 			proof, err = api.QueryMembershipConsistency(query.Key, *query.Version)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusPreconditionFailed)
+				WriteError(w, err)
 				return
 			}
 		}
 		out, err := json.Marshal(protocol.ToMembershipResult(query.Key, proof))
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			WriteError(w, err)
 			return
 		}
 
@@ -347,7 +575,7 @@ func Membership(api ClientApi) http.HandlerFunc {
 //  "ActualVersion":	0,
 //  "KeyDigest":		"5beeaf427ee0bfcd1a7b6f63010f2745110cf23ae088b859275cd0aad369561b"
 // }
-func DigestMembership(api ClientApi) http.HandlerFunc {
+func DigestMembership(api QueryApi) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		DigestMembershipRequest.Inc()
 		defer DigestMembershipRequest.Dec()
@@ -362,9 +590,14 @@ func DigestMembership(api ClientApi) http.HandlerFunc {
 		}
 
 		var query protocol.MembershipDigest
-		err = json.NewDecoder(r.Body).Decode(&query)
+		err = decodeRequest(r, &query)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			WriteError(w, err)
+			return
+		}
+
+		if err := api.WaitForConsistency(query.Consistency, query.MinVersion); err != nil {
+			WriteError(w, err)
 			return
 		}
 
@@ -372,7 +605,7 @@ func DigestMembership(api ClientApi) http.HandlerFunc {
 			// Wait for the response
 			proof, err = api.QueryDigestMembership(query.KeyDigest)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusPreconditionFailed)
+				WriteError(w, err)
 				return
 			}
 		} else {
@@ -380,14 +613,14 @@ func DigestMembership(api ClientApi) http.HandlerFunc {
 			// Wait for the response
 			proof, err = api.QueryDigestMembershipConsistency(query.KeyDigest, *query.Version)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusPreconditionFailed)
+				WriteError(w, err)
 				return
 			}
 		}
 
 		out, err := json.Marshal(protocol.ToMembershipResult(nil, proof))
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			WriteError(w, err)
 			return
 		}
 
@@ -409,7 +642,7 @@ func DigestMembership(api ClientApi) http.HandlerFunc {
 //     "End": "8",
 //     "AuditPath": ["<truncated for clarity in docs>"]
 //   }
-func Incremental(api ClientApi) http.HandlerFunc {
+func Incremental(api QueryApi) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		IncrementalRequest.Inc()
 		defer IncrementalRequest.Dec()
@@ -422,22 +655,27 @@ func Incremental(api ClientApi) http.HandlerFunc {
 		}
 
 		var request protocol.IncrementalRequest
-		err = json.NewDecoder(r.Body).Decode(&request)
+		err = decodeRequest(r, &request)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			WriteError(w, err)
+			return
+		}
+
+		if err := api.WaitForConsistency(request.Consistency, request.MinVersion); err != nil {
+			WriteError(w, err)
 			return
 		}
 
 		// Wait for the response
 		proof, err := api.QueryConsistency(request.Start, request.End)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			WriteError(w, err)
 			return
 		}
 
 		out, err := json.Marshal(protocol.ToIncrementalResponse(proof))
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			WriteError(w, err)
 			return
 		}
 
@@ -470,23 +708,53 @@ func Incremental(api ClientApi) http.HandlerFunc {
 //   }
 // }
 
+// redirectToLeader answers the request with the cluster shards and
+// a redirection to the current leader.
+func redirectToLeader(w http.ResponseWriter, r *http.Request, api ClientApi) {
+	var scheme protocol.Scheme
+	if r.TLS != nil {
+		scheme = protocol.Https
+	} else {
+		scheme = protocol.Http
+	}
+
+	shards, err := getShards(api, scheme)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	out, err := json.Marshal(shards)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(out)
+	http.Redirect(w, r, shards.Shards[shards.LeaderId].HTTPAddr, http.StatusMovedPermanently)
+}
+
 func getShards(api ClientApi, scheme protocol.Scheme) (*protocol.Shards, error) {
 	clusterInfo := api.ClusterInfo()
 	if clusterInfo.LeaderId == "" {
-		return nil, fmt.Errorf("Leader not found!")
+		return nil, protocol.NewError(protocol.ErrCodeNotLeader, "Leader not found!")
 	}
 
 	if len(clusterInfo.Nodes) == 0 {
-		return nil, fmt.Errorf("Nodes not found")
+		return nil, protocol.NewError(protocol.ErrCodeUnavailable, "Nodes not found")
 	}
 
 	nodeInfo := api.Info()
 	shardDetails := make(map[string]protocol.ShardDetail)
 
 	for _, node := range clusterInfo.Nodes {
+		nodeType := protocol.VoterNode
+		if node.Nonvoter {
+			nodeType = protocol.NonvoterNode
+		}
 		shardDetails[node.NodeId] = protocol.ShardDetail{
 			NodeId:   node.NodeId,
 			HTTPAddr: node.HttpAddr,
+			Type:     nodeType,
 		}
 	}
 
@@ -518,13 +786,13 @@ func InfoShardsHandler(api ClientApi) http.HandlerFunc {
 		}
 		shards, err := getShards(api, scheme)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			WriteError(w, err)
 			return
 		}
 
 		out, err := json.Marshal(shards)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			WriteError(w, err)
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
@@ -571,7 +839,7 @@ func InfoHandler(api ClientApi) http.HandlerFunc {
 
 		out, err := json.Marshal(api.Info())
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			WriteError(w, err)
 			return
 		}
 
@@ -591,7 +859,7 @@ func PostReqSanitizer(w http.ResponseWriter, r *http.Request) (http.ResponseWrit
 	}
 
 	if r.Body == nil {
-		http.Error(w, "Please send a request body", http.StatusBadRequest)
+		WriteError(w, protocol.NewError(protocol.ErrCodeBadRequest, "Please send a request body"))
 		return w, r, errors.New("Bad request: nil body.")
 	}
 
@@ -646,3 +914,11 @@ func (w *statusWriter) Write(b []byte) (int, error) {
 	w.length = len(b)
 	return w.ResponseWriter.Write(b)
 }
+
+// Flush sends the buffered data to the client, so streaming handlers
+// keep working behind LogHandler.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
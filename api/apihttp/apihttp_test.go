@@ -19,9 +19,12 @@ package apihttp
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/bbva/qed/testutils/spec"
 
@@ -120,6 +123,21 @@ func (b fakeRaftBalloon) QueryMembership(event []byte) (*balloon.MembershipProof
 	}, nil
 }
 
+// WaitForConsistency emulates a node that has applied up to version 10.
+func (b fakeRaftBalloon) WaitForConsistency(level protocol.ReadConsistency, minVersion uint64) error {
+	switch level {
+	case "", protocol.ReadStale, protocol.ReadLinearizable:
+		return nil
+	case protocol.ReadBounded:
+		if minVersion > 10 {
+			return consensus.ErrStaleRead
+		}
+		return nil
+	default:
+		return consensus.ErrUnknownConsistency
+	}
+}
+
 func (b fakeRaftBalloon) QueryConsistency(start, end uint64) (*balloon.IncrementalProof, error) {
 	var pathKey [10]byte
 	ip := balloon.IncrementalProof{
@@ -150,6 +168,13 @@ func (b fakeRaftBalloon) ClusterInfo() *consensus.ClusterInfo {
 			MgmtAddr: "127.0.0.1:8801",
 			HttpAddr: "127.0.0.1:8802",
 		},
+		"node02": &consensus.NodeInfo{
+			NodeId:   "node02",
+			RaftAddr: "127.0.0.1:8810",
+			MgmtAddr: "127.0.0.1:8811",
+			HttpAddr: "127.0.0.1:8812",
+			Nonvoter: true,
+		},
 	}
 	return c
 }
@@ -289,6 +314,148 @@ func TestAddBulk(t *testing.T) {
 	}
 }
 
+type fakeLeaderRaftBalloon struct {
+	fakeRaftBalloon
+}
+
+func (b fakeLeaderRaftBalloon) IsLeader() bool {
+	return true
+}
+
+func TestAddAsync(t *testing.T) {
+	data, _ := json.Marshal(&protocol.Event{Event: []byte("this is a sample event")})
+
+	req, err := http.NewRequest("POST", "/events?async=true", bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tickets := NewTicketStore(DefaultMaxPendingTickets, DefaultTicketTTL)
+	api := fakeLeaderRaftBalloon{}
+	handler := AsyncSwitch(Add(api), AddAsync(api, tickets))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	spec.Equal(t, http.StatusAccepted, rr.Code, "handler returned wrong status code")
+
+	ticket := new(protocol.Ticket)
+	_ = json.Unmarshal(rr.Body.Bytes(), ticket)
+	spec.Equal(t, "/v1/tickets/"+ticket.ID, rr.Header().Get("Location"), "Wrong ticket location")
+
+	// Poll the ticket until the fake balloon resolves it.
+	var status *protocol.TicketStatus
+	for i := 0; i < 100; i++ {
+		req, err = http.NewRequest("GET", "/tickets/"+ticket.ID, nil)
+		spec.NoError(t, err, "Error building ticket request")
+
+		rr = httptest.NewRecorder()
+		TicketHandler(tickets).ServeHTTP(rr, req)
+		spec.Equal(t, http.StatusOK, rr.Code, "handler returned wrong status code")
+
+		status = new(protocol.TicketStatus)
+		_ = json.Unmarshal(rr.Body.Bytes(), status)
+		if status.State != protocol.TicketPending {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	spec.Equal(t, protocol.TicketDone, status.State, "Wrong ticket state")
+	spec.Equal(t, 1, len(status.Snapshots), "Wrong number of snapshots")
+	spec.Equal(t, hashing.Digest{0x01}, status.Snapshots[0].HyperDigest, "Wrong hyper digest")
+}
+
+func TestAddAsyncNotLeader(t *testing.T) {
+	data, _ := json.Marshal(&protocol.Event{Event: []byte("this is a sample event")})
+
+	req, err := http.NewRequest("POST", "/events?async=true", bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tickets := NewTicketStore(DefaultMaxPendingTickets, DefaultTicketTTL)
+	rr := httptest.NewRecorder()
+	AddAsync(fakeRaftBalloon{}, tickets).ServeHTTP(rr, req)
+
+	// Followers must not hand out tickets, they answer with the shards instead.
+	shards := new(protocol.Shards)
+	_ = json.Unmarshal(rr.Body.Bytes(), shards)
+	spec.Equal(t, "node01", shards.LeaderId, "Wrong leader ID")
+}
+
+func TestAddAsyncTooManyPendingTickets(t *testing.T) {
+	data, _ := json.Marshal(&protocol.Event{Event: []byte("this is a sample event")})
+
+	tickets := NewTicketStore(1, DefaultTicketTTL)
+	_, err := tickets.Open()
+	spec.NoError(t, err, "Error opening ticket")
+
+	req, err := http.NewRequest("POST", "/events?async=true", bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	AddAsync(fakeLeaderRaftBalloon{}, tickets).ServeHTTP(rr, req)
+
+	spec.Equal(t, http.StatusServiceUnavailable, rr.Code, "handler returned wrong status code")
+	perr := new(protocol.Error)
+	_ = json.Unmarshal(rr.Body.Bytes(), perr)
+	spec.Equal(t, protocol.ErrCodeUnavailable, perr.Code, "Wrong error code")
+}
+
+func TestTicketNotFound(t *testing.T) {
+	tickets := NewTicketStore(DefaultMaxPendingTickets, time.Millisecond)
+	expired, err := tickets.Open()
+	spec.NoError(t, err, "Error opening ticket")
+	tickets.Resolve(expired, nil, nil)
+	time.Sleep(5 * time.Millisecond)
+
+	// a ticket issued by another leader
+	other, err := NewTicketStore(DefaultMaxPendingTickets, DefaultTicketTTL).Open()
+	spec.NoError(t, err, "Error opening ticket")
+
+	for id, code := range map[string]protocol.ErrorCode{
+		expired: protocol.ErrCodeNotFound,
+		other:   protocol.ErrCodeTicketUnknown,
+		"bad":   protocol.ErrCodeTicketUnknown,
+	} {
+		req, err := http.NewRequest("GET", "/tickets/"+id, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		TicketHandler(tickets).ServeHTTP(rr, req)
+
+		spec.Equal(t, http.StatusNotFound, rr.Code, "handler returned wrong status code")
+		perr := new(protocol.Error)
+		_ = json.Unmarshal(rr.Body.Bytes(), perr)
+		spec.Equal(t, code, perr.Code, "Wrong error code")
+	}
+}
+
+func TestTicketStore(t *testing.T) {
+	tickets := NewTicketStore(1, time.Millisecond)
+
+	id, err := tickets.Open()
+	spec.NoError(t, err, "Error opening ticket")
+
+	_, err = tickets.Open()
+	spec.Equal(t, ErrTooManyPendingTickets, err, "The store should be full")
+
+	tickets.Resolve(id, nil, errors.New("leadership lost"))
+	status, ok := tickets.Get(id)
+	spec.True(t, ok, "The ticket should exist")
+	spec.Equal(t, protocol.TicketFailed, status.State, "Wrong ticket state")
+	spec.Equal(t, "leadership lost", status.Error, "Wrong ticket error")
+
+	time.Sleep(5 * time.Millisecond)
+	_, ok = tickets.Get(id)
+	spec.False(t, ok, "The ticket should have expired")
+}
+
 func TestMembership(t *testing.T) {
 
 	key := []byte("this is a sample event")
@@ -433,8 +600,8 @@ func TestDigestMembershipConsistency(t *testing.T) {
 	eventDigest := hasher.Do([]byte("this is a sample event"))
 
 	query, _ := json.Marshal(protocol.MembershipDigest{
-		eventDigest,
-		&version,
+		KeyDigest: eventDigest,
+		Version:   &version,
 	})
 
 	req, err := http.NewRequest("POST", "/proofs/digest-membership", bytes.NewBuffer(query))
@@ -478,8 +645,8 @@ func TestIncremental(t *testing.T) {
 	start := uint64(2)
 	end := uint64(8)
 	query, _ := json.Marshal(protocol.IncrementalRequest{
-		start,
-		end,
+		Start: start,
+		End:   end,
 	})
 
 	req, err := http.NewRequest("POST", "/proofs/incremental", bytes.NewBuffer(query))
@@ -509,6 +676,66 @@ func TestIncremental(t *testing.T) {
 	spec.Equal(t, expectedResult, actualResult, "Incorrect proof")
 }
 
+type fakeFailingRaftBalloon struct {
+	fakeRaftBalloon
+}
+
+func (b fakeFailingRaftBalloon) QueryConsistency(start, end uint64) (*balloon.IncrementalProof, error) {
+	return nil, balloon.ErrInvalidRange
+}
+
+func TestIncrementalInvalidRange(t *testing.T) {
+	query, _ := json.Marshal(protocol.IncrementalRequest{Start: 8, End: 2})
+
+	req, err := http.NewRequest("POST", "/proofs/incremental", bytes.NewBuffer(query))
+	spec.NoError(t, err, "Error querying for incremental proof")
+
+	rr := httptest.NewRecorder()
+	Incremental(fakeFailingRaftBalloon{}).ServeHTTP(rr, req)
+
+	spec.Equal(t, http.StatusBadRequest, rr.Code, "handler returned wrong status code")
+
+	perr := new(protocol.Error)
+	_ = json.Unmarshal(rr.Body.Bytes(), perr)
+	spec.Equal(t, protocol.ErrCodeInvalidRange, perr.Code, "Wrong error code")
+}
+
+func TestAddBadRequest(t *testing.T) {
+	req, err := http.NewRequest("POST", "/events", bytes.NewBufferString("not json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	Add(fakeRaftBalloon{}).ServeHTTP(rr, req)
+
+	spec.Equal(t, http.StatusBadRequest, rr.Code, "handler returned wrong status code")
+
+	perr := new(protocol.Error)
+	_ = json.Unmarshal(rr.Body.Bytes(), perr)
+	spec.Equal(t, protocol.ErrCodeBadRequest, perr.Code, "Wrong error code")
+}
+
+func TestAddBulkPayloadTooLarge(t *testing.T) {
+	data, _ := json.Marshal(protocol.EventsBulk{Events: [][]byte{
+		make([]byte, MaxRequestBodySize),
+	}})
+
+	req, err := http.NewRequest("POST", "/events/bulk", bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	AddBulk(fakeRaftBalloon{}).ServeHTTP(rr, req)
+
+	spec.Equal(t, http.StatusRequestEntityTooLarge, rr.Code, "handler returned wrong status code")
+
+	perr := new(protocol.Error)
+	_ = json.Unmarshal(rr.Body.Bytes(), perr)
+	spec.Equal(t, protocol.ErrCodePayloadTooLarge, perr.Code, "Wrong error code")
+}
+
 func TestAuthHandlerMiddleware(t *testing.T) {
 
 	req, err := http.NewRequest("HEAD", "/healthcheck", nil)
@@ -585,5 +812,43 @@ func TestInfoShard(t *testing.T) {
 	spec.Equal(t, "node01", infoShards.NodeId, "Wrong node ID")
 	spec.Equal(t, "node01", infoShards.LeaderId, "Wrong leader ID")
 	spec.Equal(t, protocol.Scheme("http"), infoShards.URIScheme, "Wrong scheme")
-	spec.Equal(t, 1, len(infoShards.Shards), "Wrong number of shards")
+	spec.Equal(t, 2, len(infoShards.Shards), "Wrong number of shards")
+	spec.Equal(t, protocol.VoterNode, infoShards.Shards["node01"].Type, "Wrong node type")
+	spec.Equal(t, protocol.NonvoterNode, infoShards.Shards["node02"].Type, "Wrong node type")
+}
+
+func TestMembershipReadConsistency(t *testing.T) {
+	testCases := []struct {
+		consistency    protocol.ReadConsistency
+		minVersion     uint64
+		expectedStatus int
+		expectedCode   protocol.ErrorCode
+	}{
+		{"", 0, http.StatusOK, ""},
+		{protocol.ReadStale, 0, http.StatusOK, ""},
+		{protocol.ReadBounded, 10, http.StatusOK, ""},
+		{protocol.ReadBounded, 11, http.StatusServiceUnavailable, protocol.ErrCodeStaleRead},
+		{protocol.ReadLinearizable, 0, http.StatusOK, ""},
+		{"eventual", 0, http.StatusBadRequest, protocol.ErrCodeBadRequest},
+	}
+
+	for i, c := range testCases {
+		query, _ := json.Marshal(protocol.MembershipQuery{
+			Key:         []byte("this is a sample event"),
+			Consistency: c.consistency,
+			MinVersion:  c.minVersion,
+		})
+		req, err := http.NewRequest("POST", "/proofs/membership", bytes.NewBuffer(query))
+		spec.NoError(t, err, "Error building request")
+
+		rr := httptest.NewRecorder()
+		Membership(fakeRaftBalloon{}).ServeHTTP(rr, req)
+		spec.Equal(t, c.expectedStatus, rr.Code, "Wrong status code in test case "+strconv.Itoa(i))
+
+		if c.expectedCode != "" {
+			perr := new(protocol.Error)
+			_ = json.Unmarshal(rr.Body.Bytes(), perr)
+			spec.Equal(t, c.expectedCode, perr.Code, "Wrong error code in test case "+strconv.Itoa(i))
+		}
+	}
 }
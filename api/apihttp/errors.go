@@ -0,0 +1,119 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package apihttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/bbva/qed/balloon"
+	"github.com/bbva/qed/consensus"
+	"github.com/bbva/qed/protocol"
+	"github.com/hashicorp/raft"
+)
+
+// MaxRequestBodySize is the maximum number of bytes accepted in the body
+// of a request. Bigger requests are rejected with a payload_too_large error.
+const MaxRequestBodySize = 16 << 20
+
+// ToError translates an error returned by the ClientApi to its public
+// representation.
+func ToError(err error) *protocol.Error {
+	if perr, ok := err.(*protocol.Error); ok {
+		return perr
+	}
+	switch err {
+	case raft.ErrNotLeader, raft.ErrLeadershipLost, consensus.ErrNotLeader:
+		return &protocol.Error{Code: protocol.ErrCodeNotLeader, Message: err.Error()}
+	case consensus.ErrStaleRead:
+		return &protocol.Error{Code: protocol.ErrCodeStaleRead, Message: err.Error()}
+	case consensus.ErrNoLeader, consensus.ErrDraining:
+		return &protocol.Error{Code: protocol.ErrCodeUnavailable, Message: err.Error()}
+	case consensus.ErrUnknownConsistency:
+		return &protocol.Error{Code: protocol.ErrCodeBadRequest, Message: err.Error()}
+	case consensus.ErrReadOnly:
+		return &protocol.Error{Code: protocol.ErrCodeReadOnly, Message: err.Error()}
+	case consensus.ErrHashingInUse:
+		return &protocol.Error{Code: protocol.ErrCodeConflict, Message: err.Error()}
+	case consensus.ErrUnknownHashing, consensus.ErrInvalidSigningKey, consensus.ErrSigningKeyNotFound:
+		return &protocol.Error{Code: protocol.ErrCodeBadRequest, Message: err.Error()}
+	case consensus.ErrUnknownServer, consensus.ErrUnknownBackup:
+		return &protocol.Error{Code: protocol.ErrCodeNotFound, Message: err.Error()}
+	case balloon.ErrInvalidRange:
+		return &protocol.Error{Code: protocol.ErrCodeInvalidRange, Message: err.Error()}
+	case balloon.ErrVersionOutOfRange:
+		return &protocol.Error{Code: protocol.ErrCodeVersionOutOfRange, Message: err.Error()}
+	case ErrTooManyPendingTickets:
+		return &protocol.Error{Code: protocol.ErrCodeUnavailable, Message: err.Error()}
+	default:
+		return &protocol.Error{Code: protocol.ErrCodeInternal, Message: err.Error()}
+	}
+}
+
+// ErrorStatus returns the HTTP status code associated to an error code.
+func ErrorStatus(code protocol.ErrorCode) int {
+	switch code {
+	case protocol.ErrCodeNotLeader, protocol.ErrCodeUnavailable, protocol.ErrCodeStaleRead:
+		return http.StatusServiceUnavailable
+	case protocol.ErrCodeInvalidRange, protocol.ErrCodeBadRequest:
+		return http.StatusBadRequest
+	case protocol.ErrCodeVersionOutOfRange:
+		return http.StatusUnprocessableEntity
+	case protocol.ErrCodePayloadTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case protocol.ErrCodeNotFound, protocol.ErrCodeTicketUnknown:
+		return http.StatusNotFound
+	case protocol.ErrCodeReadOnly:
+		return http.StatusForbidden
+	case protocol.ErrCodeConflict:
+		return http.StatusConflict
+	case protocol.ErrCodeRateLimited, protocol.ErrCodeQuotaExceeded:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// WriteError writes the given error as a JSON body with the HTTP status
+// code matching its error code.
+func WriteError(w http.ResponseWriter, err error) {
+	perr := ToError(err)
+	out, _ := json.Marshal(perr)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(ErrorStatus(perr.Code))
+	_, _ = w.Write(out)
+}
+
+// decodeRequest reads the request body, up to MaxRequestBodySize bytes,
+// and unmarshals it into v.
+func decodeRequest(r *http.Request, v interface{}) error {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, MaxRequestBodySize+1))
+	if err != nil {
+		return &protocol.Error{Code: protocol.ErrCodeBadRequest, Message: err.Error()}
+	}
+	if len(body) > MaxRequestBodySize {
+		return protocol.NewError(protocol.ErrCodePayloadTooLarge, "request body exceeds %d bytes", MaxRequestBodySize)
+	}
+	if err := json.NewDecoder(bytes.NewReader(body)).Decode(v); err != nil {
+		return &protocol.Error{Code: protocol.ErrCodeBadRequest, Message: err.Error()}
+	}
+	return nil
+}
@@ -56,6 +56,78 @@ var (
 			Help:      "Number of current HTTP AddBulk requests.",
 		},
 	)
+	AddAsyncRequest = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subSystem,
+			Name:      "add_async_requests",
+			Help:      "Number of current HTTP asynchronous Add requests.",
+		},
+	)
+	AddBulkAsyncRequest = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subSystem,
+			Name:      "add_bulk_async_requests",
+			Help:      "Number of current HTTP asynchronous AddBulk requests.",
+		},
+	)
+	TicketRequest = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subSystem,
+			Name:      "ticket_requests",
+			Help:      "Number of current HTTP Ticket requests.",
+		},
+	)
+	PendingTickets = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subSystem,
+			Name:      "pending_tickets",
+			Help:      "Number of asynchronous add requests waiting to be committed.",
+		},
+	)
+	FailedTickets = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subSystem,
+			Name:      "failed_tickets_total",
+			Help:      "Number of asynchronous add requests that could not be committed.",
+		},
+	)
+	DeprecatedRequests = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subSystem,
+			Name:      "deprecated_requests_total",
+			Help:      "Number of HTTP requests to unversioned deprecated paths.",
+		},
+	)
+	QuotaRequest = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subSystem,
+			Name:      "quota_requests",
+			Help:      "Number of current HTTP Quota requests.",
+		},
+	)
+	RateLimitedRequests = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subSystem,
+			Name:      "rate_limited_requests_total",
+			Help:      "Number of HTTP add requests rejected by the per client rate limit.",
+		},
+	)
+	QuotaExceededRequests = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subSystem,
+			Name:      "quota_exceeded_requests_total",
+			Help:      "Number of HTTP add requests rejected by the per client daily quota.",
+		},
+	)
 	MembershipRequest = prometheus.NewGauge(
 		prometheus.GaugeOpts{
 			Namespace: namespace,
@@ -104,6 +176,15 @@ func RegisterMetrics(registry metrics.Registry) {
 			HealthCheckRequest,
 			AddRequest,
 			AddBulkRequest,
+			AddAsyncRequest,
+			AddBulkAsyncRequest,
+			TicketRequest,
+			PendingTickets,
+			FailedTickets,
+			DeprecatedRequests,
+			QuotaRequest,
+			RateLimitedRequests,
+			QuotaExceededRequests,
 			MembershipRequest,
 			DigestMembershipRequest,
 			IncrementalRequest,
@@ -0,0 +1,258 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package apihttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/bbva/qed/protocol"
+)
+
+// OpenAPI is the OpenAPI 3 description of the HTTP API.
+type OpenAPI struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       OpenAPIInfo                             `json:"info"`
+	Servers    []OpenAPIServer                         `json:"servers"`
+	Paths      map[string]map[string]*OpenAPIOperation `json:"paths"`
+	Components OpenAPIComponents                       `json:"components"`
+}
+
+// OpenAPIInfo is the metadata of the API.
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// OpenAPIServer is the base URL of the API.
+type OpenAPIServer struct {
+	URL string `json:"url"`
+}
+
+// OpenAPIOperation describes a single method on a path.
+type OpenAPIOperation struct {
+	OperationID string                      `json:"operationId"`
+	Summary     string                      `json:"summary"`
+	Parameters  []OpenAPIParameter          `json:"parameters,omitempty"`
+	RequestBody *OpenAPIBody                `json:"requestBody,omitempty"`
+	Responses   map[string]*OpenAPIResponse `json:"responses"`
+}
+
+// OpenAPIParameter describes a query or path parameter.
+type OpenAPIParameter struct {
+	Name        string        `json:"name"`
+	In          string        `json:"in"`
+	Description string        `json:"description,omitempty"`
+	Required    bool          `json:"required,omitempty"`
+	Schema      OpenAPISchema `json:"schema"`
+}
+
+// OpenAPIBody describes a request body.
+type OpenAPIBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]OpenAPIMediaType `json:"content"`
+}
+
+// OpenAPIResponse describes a response.
+type OpenAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]OpenAPIMediaType `json:"content,omitempty"`
+}
+
+// OpenAPIMediaType holds the schema of a body.
+type OpenAPIMediaType struct {
+	Schema OpenAPISchema `json:"schema"`
+}
+
+// OpenAPIComponents holds the reusable schemas.
+type OpenAPIComponents struct {
+	Schemas map[string]OpenAPISchema `json:"schemas"`
+}
+
+// OpenAPISchema is a JSON schema object.
+type OpenAPISchema map[string]interface{}
+
+// NewOpenAPI generates the OpenAPI description of the given routes,
+// deriving the schemas from the types of their request and response
+// samples.
+func NewOpenAPI(routes []Route) *OpenAPI {
+	doc := &OpenAPI{
+		OpenAPI: "3.0.2",
+		Info: OpenAPIInfo{
+			Title:   "QED HTTP API",
+			Version: "1.0.0",
+		},
+		Servers:    []OpenAPIServer{{URL: APIVersion}},
+		Paths:      make(map[string]map[string]*OpenAPIOperation),
+		Components: OpenAPIComponents{Schemas: make(map[string]OpenAPISchema)},
+	}
+
+	errorSchema := doc.schemaOf(reflect.TypeOf(protocol.Error{}))
+
+	for _, route := range routes {
+		op := &OpenAPIOperation{
+			OperationID: operationID(route),
+			Summary:     route.Summary,
+			Responses:   make(map[string]*OpenAPIResponse),
+		}
+
+		for _, p := range route.Params {
+			op.Parameters = append(op.Parameters, OpenAPIParameter{
+				Name:        p.Name,
+				In:          p.In,
+				Description: p.Description,
+				Required:    p.Required,
+				Schema:      OpenAPISchema{"type": p.Type},
+			})
+		}
+
+		if route.Request != nil {
+			op.RequestBody = &OpenAPIBody{
+				Required: true,
+				Content:  jsonContent(doc.schemaOf(reflect.TypeOf(route.Request))),
+			}
+		}
+
+		for status, sample := range route.Responses {
+			response := &OpenAPIResponse{Description: http.StatusText(status)}
+			if sample != nil {
+				response.Content = jsonContent(doc.schemaOf(reflect.TypeOf(sample)))
+			}
+			op.Responses[strconv.Itoa(status)] = response
+		}
+		for status, description := range route.Errors {
+			op.Responses[strconv.Itoa(status)] = &OpenAPIResponse{
+				Description: description,
+				Content:     jsonContent(errorSchema),
+			}
+		}
+		op.Responses["default"] = &OpenAPIResponse{
+			Description: "Error",
+			Content:     jsonContent(errorSchema),
+		}
+
+		if _, ok := doc.Paths[route.Path]; !ok {
+			doc.Paths[route.Path] = make(map[string]*OpenAPIOperation)
+		}
+		doc.Paths[route.Path][strings.ToLower(route.Method)] = op
+	}
+
+	return doc
+}
+
+// schemaOf returns the schema of the given type, registering named
+// structs as components.
+func (doc *OpenAPI) schemaOf(t reflect.Type) OpenAPISchema {
+	// []byte values are marshalled as base64 strings
+	if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+		return OpenAPISchema{"type": "string", "format": "byte"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return doc.schemaOf(t.Elem())
+	case reflect.Bool:
+		return OpenAPISchema{"type": "boolean"}
+	case reflect.String:
+		return OpenAPISchema{"type": "string"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return OpenAPISchema{"type": "integer", "format": "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		schema := OpenAPISchema{"type": "integer", "format": "int64"}
+		if t.Kind() != reflect.Int && t.Kind() != reflect.Int64 {
+			schema["minimum"] = 0
+		}
+		return schema
+	case reflect.Float32, reflect.Float64:
+		return OpenAPISchema{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return OpenAPISchema{"type": "array", "items": doc.schemaOf(t.Elem())}
+	case reflect.Map:
+		return OpenAPISchema{"type": "object", "additionalProperties": doc.schemaOf(t.Elem())}
+	case reflect.Struct:
+		ref := OpenAPISchema{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := doc.Components.Schemas[t.Name()]; ok {
+			return ref
+		}
+		properties := make(map[string]OpenAPISchema)
+		schema := OpenAPISchema{"type": "object", "properties": properties}
+		// register before walking the fields to support recursive types
+		doc.Components.Schemas[t.Name()] = schema
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue // unexported
+			}
+			name := field.Name
+			if tag := field.Tag.Get("json"); tag != "" {
+				if tag == "-" {
+					continue
+				}
+				if n := strings.Split(tag, ",")[0]; n != "" {
+					name = n
+				}
+			}
+			properties[name] = doc.schemaOf(field.Type)
+		}
+		return ref
+	default:
+		return OpenAPISchema{}
+	}
+}
+
+func jsonContent(schema OpenAPISchema) map[string]OpenAPIMediaType {
+	return map[string]OpenAPIMediaType{
+		"application/json": {Schema: schema},
+	}
+}
+
+// operationID builds a unique identifier from the route method and path,
+// e.g. POST /proofs/digest-membership -> postProofsDigestMembership.
+func operationID(route Route) string {
+	id := strings.ToLower(route.Method)
+	words := strings.FieldsFunc(route.Path, func(r rune) bool {
+		return r == '/' || r == '-' || r == '{' || r == '}'
+	})
+	for _, w := range words {
+		id += strings.ToUpper(w[:1]) + w[1:]
+	}
+	return id
+}
+
+// OpenAPIHandler serves the OpenAPI description of the given routes.
+// The http get url is:
+//   GET /v1/openapi.json
+func OpenAPIHandler(routes []Route) http.HandlerFunc {
+	out, err := json.MarshalIndent(NewOpenAPI(routes), "", "  ")
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Make sure we can only be called with an HTTP GET request.
+		w, _, reqErr := GetReqSanitizer(w, r)
+		if reqErr != nil {
+			return
+		}
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(out)
+	}
+}
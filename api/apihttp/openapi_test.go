@@ -0,0 +1,152 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package apihttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/testutils/spec"
+)
+
+func TestOpenAPIHandler(t *testing.T) {
+	mux := NewApiHttp(fakeLeaderRaftBalloon{})
+
+	req, err := http.NewRequest("GET", "/v1/openapi.json", nil)
+	spec.NoError(t, err, "Error building request")
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	spec.Equal(t, http.StatusOK, rr.Code, "handler returned wrong status code")
+
+	var doc OpenAPI
+	spec.NoError(t, json.Unmarshal(rr.Body.Bytes(), &doc), "Error decoding OpenAPI description")
+
+	routes := Routes(fakeLeaderRaftBalloon{}, NewTicketStore(DefaultMaxPendingTickets, DefaultTicketTTL), nil)
+	for _, route := range routes {
+		op, ok := doc.Paths[route.Path][strings.ToLower(route.Method)]
+		spec.True(t, ok, "Route "+route.Method+" "+route.Path+" is not documented")
+		spec.NotNil(t, op.Responses["default"], "Route "+route.Path+" does not document errors")
+	}
+	spec.NotNil(t, doc.Paths["/tickets/{id}"]["get"].Responses["404"], "Unknown tickets are not documented")
+	spec.Equal(t, "#/components/schemas/Snapshot",
+		doc.Paths["/events"]["post"].Responses["201"].Content["application/json"].Schema["$ref"],
+		"Wrong response schema")
+}
+
+// TestOpenAPIContract calls every documented operation against the real
+// handlers and checks the responses match their documented status codes
+// and body types.
+func TestOpenAPIContract(t *testing.T) {
+	api := fakeLeaderRaftBalloon{}
+	mux := NewApiHttp(api)
+	routes := Routes(api, NewTicketStore(DefaultMaxPendingTickets, DefaultTicketTTL), nil)
+	doc := NewOpenAPI(routes)
+
+	for _, route := range routes {
+		op := doc.Paths[route.Path][strings.ToLower(route.Method)]
+
+		var body []byte
+		if route.Request != nil {
+			body, _ = json.Marshal(route.Request)
+		}
+		path := strings.Replace(route.Path, "{id}", "unknown", 1)
+		req, err := http.NewRequest(route.Method, APIVersion+path, bytes.NewBuffer(body))
+		spec.NoError(t, err, "Error building request")
+
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		msg := route.Method + " " + route.Path
+		if _, ok := op.Responses[strconv.Itoa(rr.Code)]; !ok || route.Errors[rr.Code] != "" {
+			// error statuses must be described by the default response
+			// or by a documented error
+			perr := new(protocol.Error)
+			spec.NoError(t, json.Unmarshal(rr.Body.Bytes(), perr), msg+": undocumented response")
+			spec.True(t, perr.Code != "", msg+": missing error code")
+			continue
+		}
+
+		sample := route.Responses[rr.Code]
+		if sample == nil {
+			spec.Equal(t, 0, rr.Body.Len(), msg+": unexpected body")
+			continue
+		}
+		decoder := json.NewDecoder(rr.Body)
+		decoder.DisallowUnknownFields()
+		value := reflect.New(reflect.TypeOf(sample)).Interface()
+		spec.NoError(t, decoder.Decode(value), msg+": body does not match its schema")
+	}
+}
+
+func TestDeprecatedPaths(t *testing.T) {
+	mux := NewApiHttp(fakeRaftBalloon{})
+
+	req, err := http.NewRequest("GET", "/info", nil)
+	spec.NoError(t, err, "Error building request")
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	spec.Equal(t, http.StatusOK, rr.Code, "handler returned wrong status code")
+	spec.Equal(t, "true", rr.Header().Get("Deprecation"), "Missing deprecation header")
+	spec.Equal(t, "</v1/info>; rel=\"successor-version\"", rr.Header().Get("Link"), "Wrong successor link")
+
+	req, err = http.NewRequest("GET", "/v1/info", nil)
+	spec.NoError(t, err, "Error building request")
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	spec.Equal(t, http.StatusOK, rr.Code, "handler returned wrong status code")
+	spec.Equal(t, "", rr.Header().Get("Deprecation"), "Unexpected deprecation header")
+}
+
+func TestQueryOnlyApiHttp(t *testing.T) {
+	mux := NewQueryOnlyApiHttp(fakeRaftBalloon{})
+
+	testCases := []struct {
+		method, path string
+		status       int
+	}{
+		{"HEAD", "/v1/healthcheck", http.StatusNoContent},
+		{"GET", "/v1/openapi.json", http.StatusOK},
+		{"POST", "/v1/events", http.StatusNotFound},
+		{"GET", "/v1/info", http.StatusNotFound},
+	}
+	for _, c := range testCases {
+		req, err := http.NewRequest(c.method, c.path, nil)
+		spec.NoError(t, err, "Error building request")
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		spec.Equal(t, c.status, rr.Code, "Wrong status code for "+c.method+" "+c.path)
+	}
+
+	body, _ := json.Marshal(protocol.MembershipQuery{Key: []byte("this is a sample event")})
+	req, err := http.NewRequest("POST", "/v1/proofs/membership", bytes.NewBuffer(body))
+	spec.NoError(t, err, "Error building request")
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	spec.Equal(t, http.StatusOK, rr.Code, "Proofs should be served")
+}
@@ -0,0 +1,290 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package apihttp
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bbva/qed/protocol"
+)
+
+// QuotaConfig defines the ingestion limits applied to every client,
+// identified by its verified TLS client certificate or by one of the
+// configured API keys. Any other request is charged to a single shared
+// anonymous client, so rotating unknown keys does not get around the
+// limits.
+type QuotaConfig struct {
+	// Rate is the number of events per second refilled in the token
+	// bucket of each client. Zero disables rate limiting.
+	Rate float64
+	// Burst is the size of the token bucket of each client.
+	Burst int
+	// DailyQuota is the number of events each client can add per UTC
+	// day. Zero disables quotas.
+	DailyQuota uint64
+	// APIKeys are the keys, sent in the Api-Key header, that identify
+	// a client on their own.
+	APIKeys []string
+}
+
+// clientQuota is the limiting state of a single client.
+type clientQuota struct {
+	tokens    float64
+	lastFill  time.Time
+	day       time.Time // UTC midnight of the day dailyUsed refers to
+	dailyUsed uint64
+}
+
+// Limiter enforces a QuotaConfig on a per client basis.
+type Limiter struct {
+	sync.Mutex
+	conf      QuotaConfig
+	apiKeys   map[string]bool
+	clients   map[string]*clientQuota
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewLimiter returns a limiter enforcing the given configuration.
+func NewLimiter(conf QuotaConfig) *Limiter {
+	if conf.Burst <= 0 {
+		conf.Burst = int(math.Ceil(conf.Rate))
+	}
+	apiKeys := make(map[string]bool, len(conf.APIKeys))
+	for _, key := range conf.APIKeys {
+		apiKeys[key] = true
+	}
+	return &Limiter{
+		conf:    conf,
+		apiKeys: apiKeys,
+		clients: make(map[string]*clientQuota),
+		now:     time.Now,
+	}
+}
+
+// Allow consumes n events from the client limits. If the client has
+// exceeded them, it returns the error code and the time to wait before
+// retrying.
+func (l *Limiter) Allow(client string, n int) (protocol.ErrorCode, time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+	q := l.get(client, now)
+
+	if l.conf.DailyQuota > 0 && q.dailyUsed+uint64(n) > l.conf.DailyQuota {
+		QuotaExceededRequests.Inc()
+		return protocol.ErrCodeQuotaExceeded, q.day.Add(24 * time.Hour).Sub(now)
+	}
+
+	if l.conf.Rate > 0 {
+		// A bulk bigger than the bucket is accepted when the bucket is
+		// full, leaving the client in debt until it is refilled.
+		need := math.Min(float64(n), float64(l.conf.Burst))
+		if q.tokens < need {
+			RateLimitedRequests.Inc()
+			wait := time.Duration((need - q.tokens) / l.conf.Rate * float64(time.Second))
+			return protocol.ErrCodeRateLimited, wait
+		}
+		q.tokens -= float64(n)
+	}
+
+	q.dailyUsed += uint64(n)
+	return "", 0
+}
+
+// Refund gives back to the client n events consumed by Allow that were
+// finally not added.
+func (l *Limiter) Refund(client string, n int) {
+	l.Lock()
+	defer l.Unlock()
+
+	q := l.get(client, l.now())
+	if l.conf.Rate > 0 {
+		q.tokens = math.Min(float64(l.conf.Burst), q.tokens+float64(n))
+	}
+	if uint64(n) > q.dailyUsed {
+		q.dailyUsed = 0
+	} else {
+		q.dailyUsed -= uint64(n)
+	}
+}
+
+// Usage returns the limits of the client and how much of them has been used.
+func (l *Limiter) Usage(client string) *protocol.QuotaUsage {
+	l.Lock()
+	defer l.Unlock()
+
+	now := l.now()
+	q := l.get(client, now)
+
+	return &protocol.QuotaUsage{
+		Client:          client,
+		Rate:            l.conf.Rate,
+		Burst:           l.conf.Burst,
+		AvailableTokens: q.tokens,
+		DailyQuota:      l.conf.DailyQuota,
+		DailyUsed:       q.dailyUsed,
+		ResetsAt:        q.day.Add(24 * time.Hour),
+	}
+}
+
+// get returns the refilled state of the given client, creating it if needed.
+// It must be called with the lock held.
+func (l *Limiter) get(client string, now time.Time) *clientQuota {
+	day := now.UTC().Truncate(24 * time.Hour)
+
+	q, ok := l.clients[client]
+	if !ok {
+		q = &clientQuota{
+			tokens:   float64(l.conf.Burst),
+			lastFill: now,
+			day:      day,
+		}
+		l.clients[client] = q
+		return q
+	}
+
+	if day.After(q.day) {
+		q.day = day
+		q.dailyUsed = 0
+	}
+
+	elapsed := now.Sub(q.lastFill).Seconds()
+	q.tokens = math.Min(float64(l.conf.Burst), q.tokens+elapsed*l.conf.Rate)
+	q.lastFill = now
+
+	return q
+}
+
+// sweep forgets, once per minute, the idle clients that would be
+// indistinguishable from a new one: full bucket and no daily usage.
+// It must be called with the lock held.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	day := now.UTC().Truncate(24 * time.Hour)
+	for client, q := range l.clients {
+		refilled := q.tokens+now.Sub(q.lastFill).Seconds()*l.conf.Rate >= float64(l.conf.Burst)
+		if refilled && (day.After(q.day) || q.dailyUsed == 0) {
+			delete(l.clients, client)
+		}
+	}
+}
+
+// ClientIdentity returns the identity the limits are applied to: the
+// common name of the verified TLS client certificate, or the API key if
+// it is one of the configured ones. Other requests are anonymous.
+func (l *Limiter) ClientIdentity(r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return "cert:" + r.TLS.VerifiedChains[0][0].Subject.CommonName
+	}
+	if key := r.Header.Get("Api-Key"); key != "" && l != nil && l.apiKeys[key] {
+		return "key:" + key
+	}
+	return "anonymous"
+}
+
+type limiterKey struct{}
+
+// WithLimiter makes the limiter available to the wrapped handler, which
+// consumes the client limits through consumeQuota.
+func WithLimiter(limiter *Limiter, handler http.HandlerFunc) http.HandlerFunc {
+	if limiter == nil {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), limiterKey{}, limiter)
+		handler(w, r.WithContext(ctx))
+	}
+}
+
+// consumeQuota consumes n events from the limits of the client making
+// the request. If they are exceeded, it answers the request with
+// a 429 status and returns false. Otherwise it returns a function that
+// refunds the events, to be called if they are not finally added.
+func consumeQuota(w http.ResponseWriter, r *http.Request, n int) (refund func(), ok bool) {
+	limiter, ok := r.Context().Value(limiterKey{}).(*Limiter)
+	if !ok {
+		return func() {}, true
+	}
+
+	client := limiter.ClientIdentity(r)
+	code, wait := limiter.Allow(client, n)
+	if code == "" {
+		return func() { limiter.Refund(client, n) }, true
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	WriteError(w, protocol.NewError(code, "%d events rejected, retry in %s", n, wait))
+	return nil, false
+}
+
+// QuotaHandler returns the ingestion limits of the calling client.
+// The http get url is:
+//   GET /quota
+//
+// The following statuses are expected:
+// If everything is alright, the HTTP status is 200 and the body contains:
+//   {
+//     "Client": "key:my-api-key",
+//     "Rate": 100,
+//     "Burst": 200,
+//     "AvailableTokens": 154.5,
+//     "DailyQuota": 1000000,
+//     "DailyUsed": 2042,
+//     "ResetsAt": "2019-10-19T00:00:00Z"
+//   }
+func QuotaHandler(limiter *Limiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		QuotaRequest.Inc()
+		defer QuotaRequest.Dec()
+
+		var err error
+		// Make sure we can only be called with an HTTP GET request.
+		w, r, err = GetReqSanitizer(w, r)
+		if err != nil {
+			return
+		}
+
+		client := limiter.ClientIdentity(r)
+		usage := &protocol.QuotaUsage{Client: client}
+		if limiter != nil {
+			usage = limiter.Usage(client)
+		}
+
+		out, err := json.Marshal(usage)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(out)
+	}
+}
@@ -0,0 +1,183 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package apihttp
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/testutils/spec"
+)
+
+func newTestLimiter(conf QuotaConfig, now *time.Time) *Limiter {
+	limiter := NewLimiter(conf)
+	limiter.now = func() time.Time { return *now }
+	return limiter
+}
+
+func TestLimiterRate(t *testing.T) {
+	now := time.Date(2019, 10, 18, 12, 0, 0, 0, time.UTC)
+	limiter := newTestLimiter(QuotaConfig{Rate: 10, Burst: 20}, &now)
+
+	code, _ := limiter.Allow("key:a", 20)
+	spec.Equal(t, protocol.ErrorCode(""), code, "The burst should be accepted")
+
+	code, wait := limiter.Allow("key:a", 5)
+	spec.Equal(t, protocol.ErrCodeRateLimited, code, "The bucket should be empty")
+	spec.Equal(t, 500*time.Millisecond, wait, "Wrong retry time")
+
+	code, _ = limiter.Allow("key:b", 5)
+	spec.Equal(t, protocol.ErrorCode(""), code, "Other clients should not be limited")
+
+	now = now.Add(500 * time.Millisecond)
+	code, _ = limiter.Allow("key:a", 5)
+	spec.Equal(t, protocol.ErrorCode(""), code, "The bucket should have been refilled")
+
+	// bulks bigger than the burst are accepted with a full bucket
+	now = now.Add(time.Minute)
+	code, _ = limiter.Allow("key:a", 50)
+	spec.Equal(t, protocol.ErrorCode(""), code, "The big bulk should be accepted")
+	spec.Equal(t, -30.0, limiter.Usage("key:a").AvailableTokens, "The client should be in debt")
+}
+
+func TestLimiterDailyQuota(t *testing.T) {
+	now := time.Date(2019, 10, 18, 18, 0, 0, 0, time.UTC)
+	limiter := newTestLimiter(QuotaConfig{DailyQuota: 10}, &now)
+
+	code, _ := limiter.Allow("key:a", 8)
+	spec.Equal(t, protocol.ErrorCode(""), code, "The events should be accepted")
+
+	code, wait := limiter.Allow("key:a", 3)
+	spec.Equal(t, protocol.ErrCodeQuotaExceeded, code, "The quota should be exceeded")
+	spec.Equal(t, 6*time.Hour, wait, "The quota should reset at midnight")
+
+	usage := limiter.Usage("key:a")
+	spec.Equal(t, uint64(8), usage.DailyUsed, "Wrong daily usage")
+
+	now = now.Add(6 * time.Hour)
+	code, _ = limiter.Allow("key:a", 3)
+	spec.Equal(t, protocol.ErrorCode(""), code, "The quota should have been reset")
+}
+
+func TestLimiterRefund(t *testing.T) {
+	now := time.Date(2019, 10, 18, 12, 0, 0, 0, time.UTC)
+	limiter := newTestLimiter(QuotaConfig{Rate: 10, Burst: 20, DailyQuota: 100}, &now)
+
+	code, _ := limiter.Allow("key:a", 15)
+	spec.Equal(t, protocol.ErrorCode(""), code, "The events should be accepted")
+
+	limiter.Refund("key:a", 15)
+	usage := limiter.Usage("key:a")
+	spec.Equal(t, 20.0, usage.AvailableTokens, "The tokens should be given back")
+	spec.Equal(t, uint64(0), usage.DailyUsed, "The daily usage should be given back")
+}
+
+func TestAddRateLimited(t *testing.T) {
+	now := time.Now()
+	limiter := newTestLimiter(QuotaConfig{Rate: 1, Burst: 1, APIKeys: []string{"my-api-key"}}, &now)
+	handler := WithLimiter(limiter, Add(fakeRaftBalloon{}))
+
+	data, _ := json.Marshal(&protocol.Event{Event: []byte("this is a sample event")})
+	for i, expected := range []int{http.StatusCreated, http.StatusTooManyRequests} {
+		req, err := http.NewRequest("POST", "/events", bytes.NewBuffer(data))
+		spec.NoError(t, err, "Error building request")
+		req.Header.Set("Api-Key", "my-api-key")
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		spec.Equal(t, expected, rr.Code, "handler returned wrong status code")
+
+		if i == 1 {
+			spec.Equal(t, "1", rr.Header().Get("Retry-After"), "Wrong Retry-After header")
+			perr := new(protocol.Error)
+			_ = json.Unmarshal(rr.Body.Bytes(), perr)
+			spec.Equal(t, protocol.ErrCodeRateLimited, perr.Code, "Wrong error code")
+		}
+	}
+
+	req, err := http.NewRequest("GET", "/quota", nil)
+	spec.NoError(t, err, "Error building request")
+	req.Header.Set("Api-Key", "my-api-key")
+
+	rr := httptest.NewRecorder()
+	QuotaHandler(limiter).ServeHTTP(rr, req)
+	spec.Equal(t, http.StatusOK, rr.Code, "handler returned wrong status code")
+
+	usage := new(protocol.QuotaUsage)
+	_ = json.Unmarshal(rr.Body.Bytes(), usage)
+	spec.Equal(t, "key:my-api-key", usage.Client, "Wrong client identity")
+	spec.Equal(t, uint64(1), usage.DailyUsed, "Wrong daily usage")
+}
+
+func TestClientIdentity(t *testing.T) {
+	limiter := NewLimiter(QuotaConfig{Rate: 1, APIKeys: []string{"my-api-key"}})
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "producer"}}
+
+	testCases := []struct {
+		key      string
+		tls      *tls.ConnectionState
+		identity string
+	}{
+		{"my-api-key", nil, "key:my-api-key"},
+		{"other-api-key", nil, "anonymous"},
+		{"", nil, "anonymous"},
+		{"my-api-key", &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}, "cert:producer"},
+		// certificates are only trusted once verified
+		{"other-api-key", &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}, "anonymous"},
+	}
+	for _, c := range testCases {
+		req, err := http.NewRequest("GET", "/quota", nil)
+		spec.NoError(t, err, "Error building request")
+		req.Header.Set("Api-Key", c.key)
+		req.TLS = c.tls
+		spec.Equal(t, c.identity, limiter.ClientIdentity(req), "Wrong client identity")
+	}
+}
+
+func TestAddAsyncNotLeaderNotCharged(t *testing.T) {
+	now := time.Now()
+	limiter := newTestLimiter(QuotaConfig{Rate: 1, Burst: 1, APIKeys: []string{"my-api-key"}}, &now)
+	tickets := NewTicketStore(DefaultMaxPendingTickets, DefaultTicketTTL)
+	handler := WithLimiter(limiter, AddAsync(fakeRaftBalloon{}, tickets))
+
+	// followers redirect without consuming the limits of the client
+	data, _ := json.Marshal(&protocol.Event{Event: []byte("this is a sample event")})
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest("POST", "/events?async=true", bytes.NewBuffer(data))
+		spec.NoError(t, err, "Error building request")
+		req.Header.Set("Api-Key", "my-api-key")
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		shards := new(protocol.Shards)
+		_ = json.Unmarshal(rr.Body.Bytes(), shards)
+		spec.Equal(t, "node01", shards.LeaderId, "Wrong leader ID")
+	}
+
+	usage := limiter.Usage("key:my-api-key")
+	spec.Equal(t, uint64(0), usage.DailyUsed, "Wrong daily usage")
+	spec.Equal(t, 1.0, usage.AvailableTokens, "Wrong available tokens")
+}
@@ -0,0 +1,197 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package apihttp
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/bbva/qed/protocol"
+)
+
+// APIVersion is the prefix of the current version of the HTTP API.
+const APIVersion = "/v1"
+
+// Route describes an HTTP API endpoint: the handler serving it and the
+// information needed to document it in the OpenAPI description.
+type Route struct {
+	Method  string
+	Path    string // path relative to APIVersion, with {name} path params
+	Summary string
+	Handler http.HandlerFunc
+	Params  []RouteParam
+	// Request is a sample of the JSON request body, nil if there is none.
+	Request interface{}
+	// Responses maps every success status code to a sample of its JSON
+	// body, nil if there is none.
+	Responses map[int]interface{}
+	// Errors maps the error status codes worth documenting to their
+	// description. Any other error is described by the default response.
+	Errors map[int]string
+}
+
+// RouteParam describes a query or path parameter of a Route.
+type RouteParam struct {
+	Name        string
+	In          string // "query" or "path"
+	Description string
+	Type        string
+	Required    bool
+}
+
+// pattern returns the ServeMux pattern matching the route path.
+func (r Route) pattern() string {
+	if i := strings.Index(r.Path, "{"); i >= 0 {
+		return r.Path[:i]
+	}
+	return r.Path
+}
+
+// Routes returns the endpoints of the HTTP API.
+func Routes(api ClientApi, tickets *TicketStore, limiter *Limiter) []Route {
+	asyncParam := RouteParam{
+		Name:        "async",
+		In:          "query",
+		Description: "Return a ticket right away instead of waiting for the events to be committed.",
+		Type:        "boolean",
+	}
+	routes := []Route{
+		healthCheckRoute(),
+		{
+			Method:  "POST",
+			Path:    "/events",
+			Summary: "Add an event.",
+			Handler: WithLimiter(limiter, AsyncSwitch(Add(api), AddAsync(api, tickets))),
+			Params:  []RouteParam{asyncParam},
+			Request: protocol.Event{},
+			Responses: map[int]interface{}{
+				http.StatusCreated:  protocol.Snapshot{},
+				http.StatusAccepted: protocol.Ticket{},
+			},
+		},
+		{
+			Method:  "POST",
+			Path:    "/events/bulk",
+			Summary: "Add a bulk of events.",
+			Handler: WithLimiter(limiter, AsyncSwitch(AddBulk(api), AddBulkAsync(api, tickets))),
+			Params:  []RouteParam{asyncParam},
+			Request: protocol.EventsBulk{},
+			Responses: map[int]interface{}{
+				http.StatusCreated:  []protocol.Snapshot{},
+				http.StatusAccepted: protocol.Ticket{},
+			},
+		},
+		{
+			Method:  "GET",
+			Path:    "/tickets/{id}",
+			Summary: "Get the result of an asynchronous add operation.",
+			Handler: TicketHandler(tickets),
+			Params: []RouteParam{{
+				Name:        "id",
+				In:          "path",
+				Description: "Ticket ID returned by the asynchronous add operation.",
+				Type:        "string",
+				Required:    true,
+			}},
+			Responses: map[int]interface{}{http.StatusOK: protocol.TicketStatus{}},
+			Errors: map[int]string{
+				http.StatusNotFound: "The ticket has expired (not_found), or it was not issued by this node (ticket_unknown), " +
+					"e.g. after a leadership change. In the latter case the events may have been added: check them with a membership query.",
+			},
+		},
+	}
+	routes = append(routes, proofRoutes(api)...)
+	return append(routes, []Route{
+		{
+			Method:    "GET",
+			Path:      "/info",
+			Summary:   "Get the server information.",
+			Handler:   InfoHandler(api),
+			Responses: map[int]interface{}{http.StatusOK: protocol.NodeInfo{}},
+		},
+		{
+			Method:    "GET",
+			Path:      "/info/shards",
+			Summary:   "Get the cluster information.",
+			Handler:   InfoShardsHandler(api),
+			Responses: map[int]interface{}{http.StatusOK: protocol.Shards{}},
+		},
+		{
+			Method:    "GET",
+			Path:      "/quota",
+			Summary:   "Get the ingestion limits of the calling client.",
+			Handler:   QuotaHandler(limiter),
+			Responses: map[int]interface{}{http.StatusOK: protocol.QuotaUsage{}},
+		},
+	}...)
+}
+
+// QueryOnlyRoutes returns the endpoints of the HTTP API served by a
+// query-only server: the healthcheck and the proofs.
+func QueryOnlyRoutes(api QueryApi) []Route {
+	return append([]Route{healthCheckRoute()}, proofRoutes(api)...)
+}
+
+func healthCheckRoute() Route {
+	return Route{
+		Method:    "HEAD",
+		Path:      "/healthcheck",
+		Summary:   "Check the server status.",
+		Handler:   HealthCheckHandler(),
+		Responses: map[int]interface{}{http.StatusNoContent: nil},
+	}
+}
+
+func proofRoutes(api QueryApi) []Route {
+	return []Route{
+		{
+			Method:    "POST",
+			Path:      "/proofs/membership",
+			Summary:   "Get the membership proof of an event.",
+			Handler:   Membership(api),
+			Request:   protocol.MembershipQuery{},
+			Responses: map[int]interface{}{http.StatusOK: protocol.MembershipResult{}},
+		},
+		{
+			Method:    "POST",
+			Path:      "/proofs/digest-membership",
+			Summary:   "Get the membership proof of an event digest.",
+			Handler:   DigestMembership(api),
+			Request:   protocol.MembershipDigest{},
+			Responses: map[int]interface{}{http.StatusOK: protocol.MembershipResult{}},
+		},
+		{
+			Method:    "POST",
+			Path:      "/proofs/incremental",
+			Summary:   "Get the incremental proof between two versions.",
+			Handler:   Incremental(api),
+			Request:   protocol.IncrementalRequest{},
+			Responses: map[int]interface{}{http.StatusOK: protocol.IncrementalResponse{}},
+		},
+	}
+}
+
+// Deprecated wraps the handler of an unversioned path, announcing its
+// versioned successor in the Deprecation and Link headers.
+func Deprecated(handler http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		DeprecatedRequests.Inc()
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+APIVersion+r.URL.Path+">; rel=\"successor-version\"")
+		handler.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,183 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package apihttp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bbva/qed/balloon"
+	"github.com/bbva/qed/protocol"
+)
+
+const (
+	// DefaultMaxPendingTickets is the default number of asynchronous
+	// requests that can be waiting for raft at the same time.
+	DefaultMaxPendingTickets = 10000
+	// DefaultTicketTTL is the default time a resolved ticket is kept
+	// before being discarded.
+	DefaultTicketTTL = 5 * time.Minute
+
+	// ticketIDSize is the size in bytes of the ticket IDs, whose first
+	// ticketPrefixSize bytes identify the store that issued them.
+	ticketIDSize     = 16
+	ticketPrefixSize = 4
+)
+
+var (
+	// ErrTooManyPendingTickets is returned when the ticket store cannot
+	// accept more asynchronous requests.
+	ErrTooManyPendingTickets = errors.New("too many pending tickets")
+)
+
+type ticket struct {
+	status     protocol.TicketStatus
+	resolvedAt time.Time
+}
+
+// TicketStore keeps track of asynchronous ingestion requests until
+// their results are claimed or expire.
+type TicketStore struct {
+	sync.Mutex
+	prefix     string // hex prefix of the IDs issued by the store
+	tickets    map[string]*ticket
+	resolved   []string // resolved ticket IDs, oldest first
+	pending    int
+	maxPending int
+	ttl        time.Duration
+}
+
+// NewTicketStore returns a ticket store that accepts up to maxPending
+// unresolved tickets and forgets resolved ones after ttl.
+func NewTicketStore(maxPending int, ttl time.Duration) *TicketStore {
+	return &TicketStore{
+		tickets:    make(map[string]*ticket),
+		maxPending: maxPending,
+		ttl:        ttl,
+	}
+}
+
+// Open registers a new pending ticket and returns its ID.
+func (s *TicketStore) Open() (string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.prefix == "" {
+		prefix, err := randomHex(ticketPrefixSize)
+		if err != nil {
+			return "", err
+		}
+		s.prefix = prefix
+	}
+	suffix, err := randomHex(ticketIDSize - ticketPrefixSize)
+	if err != nil {
+		return "", err
+	}
+	id := s.prefix + suffix
+
+	s.purge(time.Now())
+	if s.pending >= s.maxPending {
+		return "", ErrTooManyPendingTickets
+	}
+	s.tickets[id] = &ticket{
+		status: protocol.TicketStatus{
+			ID:    id,
+			State: protocol.TicketPending,
+		},
+	}
+	s.pending++
+	PendingTickets.Set(float64(s.pending))
+
+	return id, nil
+}
+
+// Resolve stores the result of the request associated to the given ticket.
+func (s *TicketStore) Resolve(id string, snapshots []*balloon.Snapshot, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	t, ok := s.tickets[id]
+	if !ok || t.status.State != protocol.TicketPending {
+		return
+	}
+
+	if err != nil {
+		t.status.State = protocol.TicketFailed
+		t.status.Error = err.Error()
+		FailedTickets.Inc()
+	} else {
+		t.status.State = protocol.TicketDone
+		t.status.Snapshots = make([]*protocol.Snapshot, len(snapshots))
+		for i, snapshot := range snapshots {
+			s := protocol.Snapshot(*snapshot)
+			t.status.Snapshots[i] = &s
+		}
+	}
+	t.resolvedAt = time.Now()
+	s.resolved = append(s.resolved, id)
+	s.pending--
+	PendingTickets.Set(float64(s.pending))
+}
+
+// Get returns the status of the given ticket, if it is known.
+func (s *TicketStore) Get(id string) (*protocol.TicketStatus, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.purge(time.Now())
+	t, ok := s.tickets[id]
+	if !ok {
+		return nil, false
+	}
+	status := t.status
+	return &status, true
+}
+
+// Issued tells whether the given ticket was issued by this store, even
+// if it has expired since. Tickets issued by other nodes, or by this one
+// before restarting, are unknown to it.
+func (s *TicketStore) Issued(id string) bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.prefix != "" && len(id) == 2*ticketIDSize && strings.HasPrefix(id, s.prefix)
+}
+
+// purge removes the resolved tickets whose ttl has expired.
+// It must be called with the lock held.
+func (s *TicketStore) purge(now time.Time) {
+	var i int
+	for ; i < len(s.resolved); i++ {
+		id := s.resolved[i]
+		if now.Sub(s.tickets[id].resolvedAt) <= s.ttl {
+			break
+		}
+		delete(s.tickets, id)
+	}
+	s.resolved = s.resolved[i:]
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
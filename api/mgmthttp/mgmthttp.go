@@ -19,10 +19,14 @@ package mgmthttp
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/bbva/qed/api/apihttp"
+	"github.com/bbva/qed/protocol"
 	"github.com/bbva/qed/storage"
 )
 
@@ -30,16 +34,52 @@ type MgmtApi interface {
 	CreateBackup() error
 	ListBackups() []*storage.BackupInfo
 	DeleteBackup(backupID uint32) error
+	ExportBackup(backupID uint32, w io.Writer) error
+	VerifyBackup(backupID uint32) (*protocol.BackupVerification, error)
+	Servers() ([]*protocol.RaftServer, error)
+	RemoveServer(nodeID string) error
+	DemoteServer(nodeID string) error
+	TransferLeadership(nodeID string) error
+	Settings() *protocol.ClusterSettings
+	SetSigningKey(publicKey []byte) error
+	SetHashingScheme(scheme string) error
+	SetReadOnly(readOnly bool) error
+	SetBackupRetention(backups uint32) error
+	CreateCheckpoint(version int64) (*protocol.Checkpoint, error)
+	Changes(since uint64, stop <-chan struct{}, fn func(*protocol.Change) error) error
 }
 
 // NewMgmtHttp will return a mux server with endpoints to manage different
 // QED log service features: DDBB backups, Raft membership,...
 //	/backup -> Create or Delete a backup
 //	/backups -> List backups
+//	/backups/{id}/download -> Download a backup as a portable archive
+//	/backups/{id}/verify -> Check the roots of a backup
+//	/cluster/servers -> List or Remove Raft servers
+//	/cluster/demote -> Demote a Raft voter
+//	/cluster/leadership -> Transfer the Raft leadership
+//	/admin/settings -> Get the cluster settings
+//	/admin/signing-key -> Replace the snapshots signing key
+//	/admin/hashing -> Change the hashing scheme
+//	/admin/read-only -> Enable or disable the read-only mode
+//	/admin/retention -> Set the number of backups to keep
+//	/checkpoints -> Cut a checkpoint to be served by a query-only server
+//	/changes -> Stream the write batches committed to the balloon
 func NewMgmtHttp(api MgmtApi) *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/backup", ManageBackup(api))
 	mux.HandleFunc("/backups", ListBackups(api))
+	mux.HandleFunc("/backups/", BackupOperations(api))
+	mux.HandleFunc("/cluster/servers", ManageServers(api))
+	mux.HandleFunc("/cluster/demote", DemoteServer(api))
+	mux.HandleFunc("/cluster/leadership", TransferLeadership(api))
+	mux.HandleFunc("/admin/settings", GetSettings(api))
+	mux.HandleFunc("/admin/signing-key", SetSigningKey(api))
+	mux.HandleFunc("/admin/hashing", SetHashingScheme(api))
+	mux.HandleFunc("/admin/read-only", SetReadOnly(api))
+	mux.HandleFunc("/admin/retention", SetBackupRetention(api))
+	mux.HandleFunc("/checkpoints", CreateCheckpoint(api))
+	mux.HandleFunc("/changes", GetChanges(api))
 	return mux
 }
 
@@ -73,7 +113,7 @@ func CreateBackup(api MgmtApi, w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := api.CreateBackup(); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apihttp.WriteError(w, err)
 		return
 	}
 
@@ -114,13 +154,13 @@ func ListBackups(api MgmtApi) http.HandlerFunc {
 
 		backups := api.ListBackups()
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			apihttp.WriteError(w, err)
 			return
 		}
 
 		out, err := json.Marshal(backups)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			apihttp.WriteError(w, err)
 			return
 		}
 
@@ -144,17 +184,613 @@ func DeleteBackup(api MgmtApi, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	b := r.URL.Query()["backupID"]
-	backupID, err := strconv.ParseUint(b[0], 10, 32)
+	b := r.URL.Query().Get("backupID")
+	backupID, err := strconv.ParseUint(b, 10, 32)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		apihttp.WriteError(w, protocol.NewError(protocol.ErrCodeBadRequest, "invalid backupID %q", b))
 		return
 	}
 
 	if err := api.DeleteBackup(uint32(backupID)); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apihttp.WriteError(w, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// BackupOperations dispatches the operations on a single backup, given
+// its ID in the path:
+//	/backups/<id>/download -> Download the backup as a portable archive
+//	/backups/<id>/verify -> Recompute and check the roots of the backup
+func BackupOperations(api MgmtApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		// Make sure we can only be called with an HTTP GET request.
+		w, _, err = apihttp.GetReqSanitizer(w, r)
+		if err != nil {
+			return
+		}
+
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/backups/"), "/")
+		if len(parts) != 2 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		backupID, err := strconv.ParseUint(parts[0], 10, 32)
+		if err != nil {
+			apihttp.WriteError(w, protocol.NewError(protocol.ErrCodeBadRequest, "invalid backup id %q", parts[0]))
+			return
+		}
+
+		switch parts[1] {
+		case "download":
+			DownloadBackup(api, w, r, uint32(backupID))
+		case "verify":
+			VerifyBackup(api, w, r, uint32(backupID))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+}
+
+// DownloadBackup streams a backup (given its ID) as a portable archive: a
+// zstd compressed tar file whose first entry is a manifest with the
+// balloon version, its roots and the checksum of every file.
+// The http get url is:
+//   GET /backups/<id>/download
+//
+// The following statuses are expected:
+// If everything is alright, the HTTP status is 200 and the body contains
+// the archive.
+// If the backup does not exist, the HTTP status is 404.
+func DownloadBackup(api MgmtApi, w http.ResponseWriter, r *http.Request, backupID uint32) {
+	out := &archiveWriter{w: w, name: fmt.Sprintf("qed-backup-%d.tar.zst", backupID)}
+	if err := api.ExportBackup(backupID, out); err != nil && !out.started {
+		apihttp.WriteError(w, err)
+	}
+	// errors in the middle of the archive leave it truncated, which
+	// the client detects when reading it
+}
+
+// VerifyBackup restores a backup (given its ID) aside, recomputes the
+// history and hyper roots of its last version and checks that version
+// against the metadata of the backup. The backup itself is not modified.
+// The http get url is:
+//   GET /backups/<id>/verify
+//
+// The following statuses are expected:
+// If everything is alright, the HTTP status is 200 and the body contains:
+// {
+//   "BackupID": 1,
+//   "Metadata": "41",
+//   "Version": 41,
+//   "HistoryDigest": "<base64 digest>",
+//   "HyperDigest": "<base64 digest>",
+//   "Mismatch": ""
+// }
+// Mismatch explains why the roots do not match the metadata, if they
+// don't.
+// If the backup does not exist, the HTTP status is 404.
+func VerifyBackup(api MgmtApi, w http.ResponseWriter, r *http.Request, backupID uint32) {
+	result, err := api.VerifyBackup(backupID)
+	if err != nil {
+		apihttp.WriteError(w, err)
+		return
+	}
+
+	out, err := json.Marshal(result)
+	if err != nil {
+		apihttp.WriteError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(out)
+}
+
+// archiveWriter sends the response headers on the first write, so errors
+// found before writing anything can still be reported with a status code.
+type archiveWriter struct {
+	w       http.ResponseWriter
+	name    string
+	started bool
+}
+
+func (a *archiveWriter) Write(p []byte) (int, error) {
+	if !a.started {
+		a.started = true
+		a.w.Header().Set("Content-Type", "application/zstd")
+		a.w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", a.name))
+		a.w.WriteHeader(http.StatusOK)
+	}
+	return a.w.Write(p)
+}
+
+func ManageServers(api MgmtApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			ListServers(api, w, r)
+		case "DELETE":
+			RemoveServer(api, w, r)
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+	}
+}
+
+// ListServers returns the members of the Raft cluster along with their
+// suffrage and state. It can be called on any node.
+// The http get url is:
+//   GET /cluster/servers
+//
+// The following statuses are expected:
+// If everything is alright, the HTTP status is 200 and the body contains:
+// [
+//  {
+//    "nodeId": "server0",
+//    "raftAddr": "127.0.0.1:8500",
+//    "mgmtAddr": "127.0.0.1:8700",
+//    "suffrage": "Voter",
+//    "state": "Leader"
+//  },
+//  {
+//    "nodeId": "server1",
+//    "raftAddr": "127.0.0.1:8501",
+//    "mgmtAddr": "",
+//    "suffrage": "Voter",
+//    "state": "Unreachable"
+//  },
+//  ...
+// ]
+func ListServers(api MgmtApi, w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	servers, err := api.Servers()
+	if err != nil {
+		apihttp.WriteError(w, err)
+		return
+	}
+
+	out, err := json.Marshal(servers)
+	if err != nil {
+		apihttp.WriteError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(out)
+}
+
+// RemoveServer removes a node (given its ID) from the Raft cluster. It
+// must be called on the leader.
+// The http delete url is:
+//   DELETE /cluster/servers?nodeId=<id>
+//
+// The following statuses are expected:
+// If everything is alright, the HTTP status is 204 with an empty body.
+// If the node is not the leader, the HTTP status is 503 (not_leader).
+// If the given node is not a member of the cluster, the HTTP status is 404.
+func RemoveServer(api MgmtApi, w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != "DELETE" {
+		w.Header().Set("Allow", "DELETE")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	nodeID, ok := nodeIDParam(w, r, true)
+	if !ok {
+		return
+	}
+
+	if err := api.RemoveServer(nodeID); err != nil {
+		apihttp.WriteError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DemoteServer turns a voter (given its ID) into a non-voter. It must be
+// called on the leader.
+// The http post url is:
+//   POST /cluster/demote?nodeId=<id>
+//
+// The following statuses are expected:
+// If everything is alright, the HTTP status is 204 with an empty body.
+// If the node is not the leader, the HTTP status is 503 (not_leader).
+// If the given node is not a member of the cluster, the HTTP status is 404.
+func DemoteServer(api MgmtApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		nodeID, ok := nodeIDParam(w, r, true)
+		if !ok {
+			return
+		}
+
+		if err := api.DemoteServer(nodeID); err != nil {
+			apihttp.WriteError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// TransferLeadership hands the leadership over to the given node. If no
+// node is given, the most up to date follower is chosen. It must be
+// called on the leader.
+// The http post url is:
+//   POST /cluster/leadership[?nodeId=<id>]
+//
+// The following statuses are expected:
+// If everything is alright, the HTTP status is 204 with an empty body.
+// If the node is not the leader, the HTTP status is 503 (not_leader).
+// If the given node is not a member of the cluster, the HTTP status is 404.
+func TransferLeadership(api MgmtApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		nodeID, ok := nodeIDParam(w, r, false)
+		if !ok {
+			return
+		}
+
+		if err := api.TransferLeadership(nodeID); err != nil {
+			apihttp.WriteError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// GetSettings returns the cluster-wide settings changed through admin
+// commands, as applied by the node. It can be called on any node.
+// The http get url is:
+//   GET /admin/settings
+//
+// The following statuses are expected:
+// If everything is alright, the HTTP status is 200 and the body contains:
+// {
+//   "index": 42,
+//   "signingPublicKey": "<base64 public key>",
+//   "hashingScheme": "sha256",
+//   "readOnly": false,
+//   "backupRetention": 5
+// }
+func GetSettings(api MgmtApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		// Make sure we can only be called with an HTTP GET request.
+		w, _, err = apihttp.GetReqSanitizer(w, r)
+		if err != nil {
+			return
+		}
+
+		out, err := json.Marshal(api.Settings())
+		if err != nil {
+			apihttp.WriteError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(out)
+	}
+}
+
+// SetSigningKey replaces the Ed25519 key every node signs snapshots with.
+// Only the public key is sent: every node must hold the private key in its
+// signing keys directory. It must be called on the leader.
+// The http post url is:
+//   POST /admin/signing-key
+//
+// The body must contain:
+// {
+//   "publicKey": "<base64 public key>"
+// }
+//
+// The following statuses are expected:
+// If everything is alright, the HTTP status is 204 with an empty body.
+// If the node is not the leader, the HTTP status is 503 (not_leader).
+// If the key is not a valid Ed25519 public key, or the leader does not
+// hold its private key, the HTTP status is 400.
+func SetSigningKey(api MgmtApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req protocol.SigningKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apihttp.WriteError(w, protocol.NewError(protocol.ErrCodeBadRequest, "%v", err))
+			return
+		}
+
+		if err := api.SetSigningKey(req.PublicKey); err != nil {
+			apihttp.WriteError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// SetHashingScheme changes the hashing scheme of the balloon, which is
+// only allowed before any event is added. It must be called on the leader.
+// The http post url is:
+//   POST /admin/hashing?scheme=<sha256|blake2b>
+//
+// The following statuses are expected:
+// If everything is alright, the HTTP status is 204 with an empty body.
+// If the node is not the leader, the HTTP status is 503 (not_leader).
+// If the scheme is unknown, the HTTP status is 400.
+// If the balloon already contains events, the HTTP status is 409 (conflict).
+func SetHashingScheme(api MgmtApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		scheme := r.URL.Query().Get("scheme")
+		if scheme == "" {
+			apihttp.WriteError(w, protocol.NewError(protocol.ErrCodeBadRequest, "missing scheme parameter"))
+			return
+		}
+
+		if err := api.SetHashingScheme(scheme); err != nil {
+			apihttp.WriteError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// SetReadOnly enables or disables the read-only mode of the cluster. While
+// enabled, proofs are still served but new events are rejected with a 403
+// (read_only) error. It must be called on the leader.
+// The http post url is:
+//   POST /admin/read-only?enabled=<true|false>
+//
+// The following statuses are expected:
+// If everything is alright, the HTTP status is 204 with an empty body.
+// If the node is not the leader, the HTTP status is 503 (not_leader).
+func SetReadOnly(api MgmtApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		e := r.URL.Query().Get("enabled")
+		enabled, err := strconv.ParseBool(e)
+		if err != nil {
+			apihttp.WriteError(w, protocol.NewError(protocol.ErrCodeBadRequest, "invalid enabled %q", e))
+			return
+		}
+
+		if err := api.SetReadOnly(enabled); err != nil {
+			apihttp.WriteError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// SetBackupRetention sets how many backups each node keeps. The oldest
+// ones are deleted after every new backup. Zero keeps all of them. It must
+// be called on the leader.
+// The http post url is:
+//   POST /admin/retention?backups=<n>
+//
+// The following statuses are expected:
+// If everything is alright, the HTTP status is 204 with an empty body.
+// If the node is not the leader, the HTTP status is 503 (not_leader).
+func SetBackupRetention(api MgmtApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		b := r.URL.Query().Get("backups")
+		backups, err := strconv.ParseUint(b, 10, 32)
+		if err != nil {
+			apihttp.WriteError(w, protocol.NewError(protocol.ErrCodeBadRequest, "invalid backups %q", b))
+			return
+		}
+
+		if err := api.SetBackupRetention(uint32(backups)); err != nil {
+			apihttp.WriteError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// CreateCheckpoint cuts a consistent checkpoint of the database of the
+// node at its current balloon version, or at the given earlier version,
+// to be served with qed server query-only. It is written into the
+// checkpoints directory of the database, named after the version, and
+// must be deleted once it is no longer needed. Earlier versions are
+// rebuilt from the backups and the WAL of the node.
+// The http post url is:
+//   POST /checkpoints?version=<version>
+//
+// The following statuses are expected:
+// If everything is alright, the HTTP status is 201 and the body contains:
+// {
+//   "Path": "/var/tmp/qed0/db/checkpoints/41",
+//   "Version": 41,
+//   "HistoryDigest": "<base64 digest>",
+//   "HyperDigest": "<base64 digest>"
+// }
+// If a checkpoint of the same version exists, the HTTP status is 409
+// (conflict).
+// If the version has not been reached yet, the HTTP status is 422
+// (version_out_of_range), and if it cannot be rebuilt, the HTTP status is
+// 400 (bad_request).
+func CreateCheckpoint(api MgmtApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		version := int64(-1)
+		if v := r.URL.Query().Get("version"); v != "" {
+			parsed, err := strconv.ParseUint(v, 10, 63)
+			if err != nil {
+				apihttp.WriteError(w, protocol.NewError(protocol.ErrCodeBadRequest, "invalid version %q", v))
+				return
+			}
+			version = int64(parsed)
+		}
+
+		checkpoint, err := api.CreateCheckpoint(version)
+		if err != nil {
+			apihttp.WriteError(w, err)
+			return
+		}
+
+		out, err := json.Marshal(checkpoint)
+		if err != nil {
+			apihttp.WriteError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write(out)
+	}
+}
+
+// GetChanges streams the write batches committed to the balloon database
+// of the node after the given WAL sequence number, one JSON object per
+// line, and keeps the connection open sending new batches as they are
+// committed. With follow=false, it returns after sending the batches
+// committed so far. Consumers resume the feed with the Seq of the last
+// batch they processed.
+// The http get url is:
+//   GET /changes?since=<seq>&follow=<true|false>
+//
+// The following statuses are expected:
+// If everything is alright, the HTTP status is 200 and the body contains:
+// {"Seq":1,"Metadata":{"PreviousVersion":0,"NewVersion":0,"Settings":false,"EventDigests":["<base64 digest>"]},"Mutations":[{"Table":"hyper","Key":"<base64>","Value":"<base64>"},...]}
+// {"Seq":9,"Metadata":...}
+// ...
+// Metadata is null for batches written by maintenance tools. Only the
+// mutations of the history and hyper trees are sent.
+// If the sequence number is beyond the WAL, or the batches after it
+// have been purged, the HTTP status is 400 (invalid_range).
+func GetChanges(api MgmtApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var since uint64
+		if s := r.URL.Query().Get("since"); s != "" {
+			var err error
+			if since, err = strconv.ParseUint(s, 10, 64); err != nil {
+				apihttp.WriteError(w, protocol.NewError(protocol.ErrCodeBadRequest, "invalid since %q", s))
+				return
+			}
+		}
+
+		stop := r.Context().Done()
+		if f := r.URL.Query().Get("follow"); f != "" {
+			follow, err := strconv.ParseBool(f)
+			if err != nil {
+				apihttp.WriteError(w, protocol.NewError(protocol.ErrCodeBadRequest, "invalid follow %q", f))
+				return
+			}
+			if !follow {
+				closed := make(chan struct{})
+				close(closed)
+				stop = closed
+			}
+		}
+
+		started := false
+		start := func() {
+			if !started {
+				started = true
+				w.Header().Set("Content-Type", "application/x-ndjson")
+				w.WriteHeader(http.StatusOK)
+			}
+		}
+		flusher, _ := w.(http.Flusher)
+		encoder := json.NewEncoder(w)
+
+		err := api.Changes(since, stop, func(change *protocol.Change) error {
+			start()
+			if err := encoder.Encode(change); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			return nil
+		})
+		if err != nil && !started {
+			apihttp.WriteError(w, err)
+			return
+		}
+		// errors in the middle of the stream just end it, and the
+		// consumer resumes from the last batch it received
+		start()
+	}
+}
+
+// nodeIDParam extracts the nodeId query parameter, writing a bad_request
+// error if it is required and missing.
+func nodeIDParam(w http.ResponseWriter, r *http.Request, required bool) (string, bool) {
+	nodeID := r.URL.Query().Get("nodeId")
+	if nodeID == "" && required {
+		apihttp.WriteError(w, protocol.NewError(protocol.ErrCodeBadRequest, "missing nodeId parameter"))
+		return "", false
+	}
+	return nodeID, true
+}
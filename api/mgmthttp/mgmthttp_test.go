@@ -17,13 +17,17 @@
 package mgmthttp
 
 import (
+	"bytes"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
 
 	"github.com/bbva/qed/testutils/spec"
 
+	"github.com/bbva/qed/consensus"
 	"github.com/bbva/qed/protocol"
 	"github.com/bbva/qed/storage"
 )
@@ -48,6 +52,125 @@ func (b fakeRaftNode) DeleteBackup(backupID uint32) error {
 	return nil
 }
 
+func (b fakeRaftNode) ExportBackup(backupID uint32, w io.Writer) error {
+	if backupID != 1 {
+		return consensus.ErrUnknownBackup
+	}
+	_, err := w.Write([]byte("archive"))
+	return err
+}
+
+func (b fakeRaftNode) VerifyBackup(backupID uint32) (*protocol.BackupVerification, error) {
+	if backupID != 1 {
+		return nil, consensus.ErrUnknownBackup
+	}
+	return &protocol.BackupVerification{BackupID: 1, Metadata: "41", Version: 41}, nil
+}
+
+func (b fakeRaftNode) Servers() ([]*protocol.RaftServer, error) {
+	return []*protocol.RaftServer{
+		{NodeId: "server0", Suffrage: "Voter", State: "Leader"},
+		{NodeId: "server1", Suffrage: "Voter", State: "Unreachable"},
+	}, nil
+}
+
+func (b fakeRaftNode) RemoveServer(nodeID string) error {
+	return b.lookup(nodeID)
+}
+
+func (b fakeRaftNode) DemoteServer(nodeID string) error {
+	return b.lookup(nodeID)
+}
+
+func (b fakeRaftNode) TransferLeadership(nodeID string) error {
+	if nodeID == "" {
+		return nil
+	}
+	return b.lookup(nodeID)
+}
+
+func (b fakeRaftNode) Settings() *protocol.ClusterSettings {
+	return &protocol.ClusterSettings{Index: 42, HashingScheme: "sha256", BackupRetention: 5}
+}
+
+func (b fakeRaftNode) SetSigningKey(publicKey []byte) error {
+	if len(publicKey) != 32 {
+		return consensus.ErrInvalidSigningKey
+	}
+	if publicKey[0] != 0 {
+		return consensus.ErrSigningKeyNotFound
+	}
+	return nil
+}
+
+func (b fakeRaftNode) SetHashingScheme(scheme string) error {
+	switch scheme {
+	case "sha256":
+		return nil
+	case "blake2b":
+		return consensus.ErrHashingInUse
+	default:
+		return consensus.ErrUnknownHashing
+	}
+}
+
+func (b fakeRaftNode) SetReadOnly(readOnly bool) error {
+	return nil
+}
+
+func (b fakeRaftNode) SetBackupRetention(backups uint32) error {
+	return nil
+}
+
+func (b fakeRaftNode) CreateCheckpoint(version int64) (*protocol.Checkpoint, error) {
+	if version > 41 {
+		return nil, protocol.NewError(protocol.ErrCodeVersionOutOfRange, "version %d is beyond the last version 41", version)
+	}
+	if version < 0 {
+		version = 41
+	}
+	return &protocol.Checkpoint{Path: "/var/tmp/qed0/db/checkpoints/" + strconv.FormatInt(version, 10), Version: uint64(version)}, nil
+}
+
+func (b fakeRaftNode) Changes(since uint64, stop <-chan struct{}, fn func(*protocol.Change) error) error {
+	if since > 9 {
+		return protocol.NewError(protocol.ErrCodeInvalidRange, "sequence number %d is beyond the last one in the WAL (9)", since)
+	}
+	changes := []*protocol.Change{
+		{Seq: 1, Metadata: &protocol.ChangeMetadata{PreviousVersion: 0, NewVersion: 0}},
+		{Seq: 9, Metadata: &protocol.ChangeMetadata{PreviousVersion: 1, NewVersion: 1}},
+	}
+	for _, c := range changes {
+		if c.Seq <= since {
+			continue
+		}
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	<-stop
+	return nil
+}
+
+func (b fakeRaftNode) lookup(nodeID string) error {
+	if nodeID != "server0" && nodeID != "server1" {
+		return consensus.ErrUnknownServer
+	}
+	return nil
+}
+
+type fakeFollowerRaftNode struct {
+	fakeRaftNode
+}
+
+func (b fakeFollowerRaftNode) RemoveServer(nodeID string) error {
+	return consensus.ErrNotLeader
+}
+
+func (b fakeFollowerRaftNode) SetReadOnly(readOnly bool) error {
+	return consensus.ErrNotLeader
+}
+
 func TestCreateBackup(t *testing.T) {
 	req, err := http.NewRequest("POST", "/backup", nil)
 	if err != nil {
@@ -115,3 +238,236 @@ func TestDeleteBackup(t *testing.T) {
 			status, http.StatusNoContent)
 	}
 }
+
+func TestDownloadBackup(t *testing.T) {
+	mux := NewMgmtHttp(fakeRaftNode{})
+
+	testCases := []struct {
+		path   string
+		status int
+	}{
+		{"/backups/1/download", http.StatusOK},
+		{"/backups/2/download", http.StatusNotFound},
+		{"/backups/foo/download", http.StatusBadRequest},
+		{"/backups/1", http.StatusNotFound},
+	}
+
+	for _, c := range testCases {
+		req, err := http.NewRequest("GET", c.path, nil)
+		spec.NoError(t, err, "Error building request")
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		spec.Equal(t, c.status, rr.Code, "Wrong status code for "+c.path)
+		if c.status == http.StatusOK {
+			spec.Equal(t, "archive", rr.Body.String(), "The body should contain the archive")
+			spec.Equal(t, "application/zstd", rr.Header().Get("Content-Type"), "Wrong content type")
+		}
+	}
+}
+
+func TestVerifyBackup(t *testing.T) {
+	mux := NewMgmtHttp(fakeRaftNode{})
+
+	req, err := http.NewRequest("GET", "/backups/1/verify", nil)
+	spec.NoError(t, err, "Error building request")
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	spec.Equal(t, http.StatusOK, rr.Code, "Wrong status code")
+
+	var result protocol.BackupVerification
+	spec.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result), "Error decoding the verification")
+	spec.Equal(t, uint64(41), result.Version, "Wrong version")
+
+	req, err = http.NewRequest("GET", "/backups/2/verify", nil)
+	spec.NoError(t, err, "Error building request")
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	spec.Equal(t, http.StatusNotFound, rr.Code, "Unknown backups should not be found")
+}
+
+func TestListServers(t *testing.T) {
+	req, err := http.NewRequest("GET", "/cluster/servers", nil)
+	spec.NoError(t, err, "Error building request")
+
+	rr := httptest.NewRecorder()
+	ManageServers(fakeRaftNode{}).ServeHTTP(rr, req)
+	spec.Equal(t, http.StatusOK, rr.Code, "handler returned wrong status code")
+
+	var servers []*protocol.RaftServer
+	_ = json.Unmarshal(rr.Body.Bytes(), &servers)
+	spec.Equal(t, 2, len(servers), "Servers list must have 2 elements.")
+	spec.Equal(t, "Leader", servers[0].State, "Wrong server state")
+}
+
+func TestMembershipOperations(t *testing.T) {
+	testCases := []struct {
+		api            MgmtApi
+		method, url    string
+		expectedStatus int
+		expectedCode   protocol.ErrorCode
+	}{
+		{fakeRaftNode{}, "DELETE", "/cluster/servers?nodeId=server1", http.StatusNoContent, ""},
+		{fakeRaftNode{}, "DELETE", "/cluster/servers?nodeId=server9", http.StatusNotFound, protocol.ErrCodeNotFound},
+		{fakeRaftNode{}, "DELETE", "/cluster/servers", http.StatusBadRequest, protocol.ErrCodeBadRequest},
+		{fakeFollowerRaftNode{}, "DELETE", "/cluster/servers?nodeId=server1", http.StatusServiceUnavailable, protocol.ErrCodeNotLeader},
+		{fakeRaftNode{}, "POST", "/cluster/demote?nodeId=server1", http.StatusNoContent, ""},
+		{fakeRaftNode{}, "POST", "/cluster/demote", http.StatusBadRequest, protocol.ErrCodeBadRequest},
+		{fakeRaftNode{}, "POST", "/cluster/leadership", http.StatusNoContent, ""},
+		{fakeRaftNode{}, "POST", "/cluster/leadership?nodeId=server1", http.StatusNoContent, ""},
+		{fakeRaftNode{}, "POST", "/cluster/leadership?nodeId=server9", http.StatusNotFound, protocol.ErrCodeNotFound},
+		{fakeRaftNode{}, "GET", "/cluster/leadership", http.StatusMethodNotAllowed, ""},
+	}
+
+	for i, c := range testCases {
+		req, err := http.NewRequest(c.method, c.url, nil)
+		spec.NoError(t, err, "Error building request")
+
+		rr := httptest.NewRecorder()
+		NewMgmtHttp(c.api).ServeHTTP(rr, req)
+		spec.Equal(t, c.expectedStatus, rr.Code, "Wrong status code in test case "+strconv.Itoa(i))
+
+		if c.expectedCode != "" {
+			perr := new(protocol.Error)
+			spec.NoError(t, json.Unmarshal(rr.Body.Bytes(), perr), "Error decoding error body in test case "+strconv.Itoa(i))
+			spec.Equal(t, c.expectedCode, perr.Code, "Wrong error code in test case "+strconv.Itoa(i))
+		}
+	}
+}
+
+
+func TestGetSettings(t *testing.T) {
+	req, err := http.NewRequest("GET", "/admin/settings", nil)
+	spec.NoError(t, err, "Error building request")
+
+	rr := httptest.NewRecorder()
+	NewMgmtHttp(fakeRaftNode{}).ServeHTTP(rr, req)
+	spec.Equal(t, http.StatusOK, rr.Code, "Wrong status code")
+
+	settings := new(protocol.ClusterSettings)
+	spec.NoError(t, json.Unmarshal(rr.Body.Bytes(), settings), "Error decoding settings")
+	spec.Equal(t, fakeRaftNode{}.Settings(), settings, "Wrong settings")
+}
+
+func TestAdminCommands(t *testing.T) {
+	key, _ := json.Marshal(protocol.SigningKeyRequest{PublicKey: make([]byte, 32)})
+	shortKey, _ := json.Marshal(protocol.SigningKeyRequest{PublicKey: make([]byte, 8)})
+	unknownKey, _ := json.Marshal(protocol.SigningKeyRequest{PublicKey: append([]byte{1}, make([]byte, 31)...)})
+
+	testCases := []struct {
+		api            MgmtApi
+		method, url    string
+		body           []byte
+		expectedStatus int
+		expectedCode   protocol.ErrorCode
+	}{
+		{fakeRaftNode{}, "POST", "/admin/signing-key", key, http.StatusNoContent, ""},
+		{fakeRaftNode{}, "POST", "/admin/signing-key", shortKey, http.StatusBadRequest, protocol.ErrCodeBadRequest},
+		{fakeRaftNode{}, "POST", "/admin/signing-key", unknownKey, http.StatusBadRequest, protocol.ErrCodeBadRequest},
+		{fakeRaftNode{}, "POST", "/admin/signing-key", []byte("{"), http.StatusBadRequest, protocol.ErrCodeBadRequest},
+		{fakeRaftNode{}, "POST", "/admin/hashing?scheme=sha256", nil, http.StatusNoContent, ""},
+		{fakeRaftNode{}, "POST", "/admin/hashing?scheme=blake2b", nil, http.StatusConflict, protocol.ErrCodeConflict},
+		{fakeRaftNode{}, "POST", "/admin/hashing?scheme=md5", nil, http.StatusBadRequest, protocol.ErrCodeBadRequest},
+		{fakeRaftNode{}, "POST", "/admin/hashing", nil, http.StatusBadRequest, protocol.ErrCodeBadRequest},
+		{fakeRaftNode{}, "POST", "/admin/read-only?enabled=true", nil, http.StatusNoContent, ""},
+		{fakeRaftNode{}, "POST", "/admin/read-only?enabled=maybe", nil, http.StatusBadRequest, protocol.ErrCodeBadRequest},
+		{fakeFollowerRaftNode{}, "POST", "/admin/read-only?enabled=true", nil, http.StatusServiceUnavailable, protocol.ErrCodeNotLeader},
+		{fakeRaftNode{}, "POST", "/admin/retention?backups=5", nil, http.StatusNoContent, ""},
+		{fakeRaftNode{}, "POST", "/admin/retention?backups=-1", nil, http.StatusBadRequest, protocol.ErrCodeBadRequest},
+		{fakeRaftNode{}, "GET", "/admin/retention", nil, http.StatusMethodNotAllowed, ""},
+	}
+
+	for i, c := range testCases {
+		req, err := http.NewRequest(c.method, c.url, bytes.NewReader(c.body))
+		spec.NoError(t, err, "Error building request")
+
+		rr := httptest.NewRecorder()
+		NewMgmtHttp(c.api).ServeHTTP(rr, req)
+		spec.Equal(t, c.expectedStatus, rr.Code, "Wrong status code in test case "+strconv.Itoa(i))
+
+		if c.expectedCode != "" {
+			perr := new(protocol.Error)
+			spec.NoError(t, json.Unmarshal(rr.Body.Bytes(), perr), "Error decoding error body in test case "+strconv.Itoa(i))
+			spec.Equal(t, c.expectedCode, perr.Code, "Wrong error code in test case "+strconv.Itoa(i))
+		}
+	}
+}
+
+func TestCreateCheckpoint(t *testing.T) {
+	mux := NewMgmtHttp(fakeRaftNode{})
+
+	req, err := http.NewRequest("POST", "/checkpoints", nil)
+	spec.NoError(t, err, "Error building request")
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	spec.Equal(t, http.StatusCreated, rr.Code, "Wrong status code")
+
+	var checkpoint protocol.Checkpoint
+	spec.NoError(t, json.Unmarshal(rr.Body.Bytes(), &checkpoint), "Error decoding the checkpoint")
+	spec.Equal(t, uint64(41), checkpoint.Version, "Wrong version")
+
+	testCases := []struct {
+		query   string
+		status  int
+		version uint64
+	}{
+		{"version=7", http.StatusCreated, 7},
+		{"version=42", http.StatusUnprocessableEntity, 0},
+		{"version=-1", http.StatusBadRequest, 0},
+		{"version=foo", http.StatusBadRequest, 0},
+	}
+	for _, c := range testCases {
+		req, err := http.NewRequest("POST", "/checkpoints?"+c.query, nil)
+		spec.NoError(t, err, "Error building request")
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		spec.Equal(t, c.status, rr.Code, "Wrong status code for "+c.query)
+		if c.status == http.StatusCreated {
+			var checkpoint protocol.Checkpoint
+			spec.NoError(t, json.Unmarshal(rr.Body.Bytes(), &checkpoint), "Error decoding the checkpoint")
+			spec.Equal(t, c.version, checkpoint.Version, "Wrong version for "+c.query)
+		}
+	}
+
+	req, err = http.NewRequest("GET", "/checkpoints", nil)
+	spec.NoError(t, err, "Error building request")
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	spec.Equal(t, http.StatusMethodNotAllowed, rr.Code, "Checkpoints are created with POST")
+}
+
+func TestGetChanges(t *testing.T) {
+	mux := NewMgmtHttp(fakeRaftNode{})
+
+	testCases := []struct {
+		query  string
+		status int
+		seqs   []uint64
+	}{
+		{"follow=false", http.StatusOK, []uint64{1, 9}},
+		{"since=1&follow=false", http.StatusOK, []uint64{9}},
+		{"since=9&follow=false", http.StatusOK, nil},
+		{"since=10&follow=false", http.StatusBadRequest, nil},
+		{"since=foo", http.StatusBadRequest, nil},
+		{"follow=foo", http.StatusBadRequest, nil},
+	}
+
+	for _, c := range testCases {
+		req, err := http.NewRequest("GET", "/changes?"+c.query, nil)
+		spec.NoError(t, err, "Error building request")
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		spec.Equal(t, c.status, rr.Code, "Wrong status code for "+c.query)
+		if c.status != http.StatusOK {
+			continue
+		}
+
+		var seqs []uint64
+		decoder := json.NewDecoder(rr.Body)
+		for decoder.More() {
+			var change protocol.Change
+			spec.NoError(t, decoder.Decode(&change), "Error decoding the change")
+			seqs = append(seqs, change.Seq)
+		}
+		spec.Equal(t, c.seqs, seqs, "Wrong changes for "+c.query)
+	}
+}
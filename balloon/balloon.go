@@ -35,6 +35,14 @@ import (
 
 var (
 	BalloonVersionKey = []byte("version")
+
+	// ErrInvalidRange is returned when the start of an incremental proof
+	// is greater than its end.
+	ErrInvalidRange = errors.New("unable to process proof from history tree: invalid range")
+
+	// ErrVersionOutOfRange is returned when a proof is requested for a
+	// version the balloon has not reached yet.
+	ErrVersionOutOfRange = errors.New("unable to process proof from history tree: version out of range")
 )
 
 // Balloon exposes the necesary API to interact with
@@ -89,6 +97,13 @@ type Snapshot struct {
 	Version       uint64
 }
 
+// Roots holds the root hashes of both trees at a given version.
+type Roots struct {
+	HistoryDigest hashing.Digest
+	HyperDigest   hashing.Digest
+	Version       uint64
+}
+
 type Verifiable interface {
 	Verify(key []byte, expectedDigest hashing.Digest) bool
 }
@@ -412,8 +427,11 @@ func (b *Balloon) QueryConsistency(start, end uint64) (*IncrementalProof, error)
 	defer b.RUnlock()
 	var proof IncrementalProof
 
-	if start >= b.version || end >= b.version || start > end {
-		return nil, errors.New("unable to process proof from history tree: invalid range")
+	if start > end {
+		return nil, ErrInvalidRange
+	}
+	if start >= b.version || end >= b.version {
+		return nil, ErrVersionOutOfRange
 	}
 
 	proof.Start = start
@@ -429,6 +447,52 @@ func (b *Balloon) QueryConsistency(start, end uint64) (*IncrementalProof, error)
 	return &proof, nil
 }
 
+// Roots function returns the root hashes of both trees at the last version,
+// so the content of a store can be checked against a published snapshot.
+func (b *Balloon) Roots() (*Roots, error) {
+	b.RLock()
+	defer b.RUnlock()
+
+	if b.version == 0 {
+		return nil, ErrVersionOutOfRange
+	}
+	version := b.version - 1
+
+	return &Roots{
+		HistoryDigest: b.historyTree.RootHash(version),
+		HyperDigest:   b.hyperTree.RootHash(),
+		Version:       version,
+	}, nil
+}
+
+// RecomputeRoots function rebuilds the roots of the last version from the
+// leaves of both trees, without trusting any stored interior node or
+// cached batch.
+func (b *Balloon) RecomputeRoots() (*Roots, error) {
+	b.RLock()
+	defer b.RUnlock()
+
+	if b.version == 0 {
+		return nil, ErrVersionOutOfRange
+	}
+	version := b.version - 1
+
+	historyDigest, err := b.historyTree.RecomputeRootHash(version)
+	if err != nil {
+		return nil, err
+	}
+	hyperDigest, err := b.hyperTree.RecomputeRootHash()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Roots{
+		HistoryDigest: historyDigest,
+		HyperDigest:   hyperDigest,
+		Version:       version,
+	}, nil
+}
+
 // Close function closes both history and hyper trees, and restarts balloon version.
 func (b *Balloon) Close() {
 	b.Lock()
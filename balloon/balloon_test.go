@@ -26,6 +26,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/bbva/qed/crypto/hashing"
+	"github.com/bbva/qed/storage"
 	metrics_utils "github.com/bbva/qed/testutils/metrics"
 	"github.com/bbva/qed/testutils/rand"
 	storage_utils "github.com/bbva/qed/testutils/storage"
@@ -56,6 +57,42 @@ func TestAdd(t *testing.T) {
 	}
 
 }
+
+func TestRoots(t *testing.T) {
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	h := hashing.NewSha256Hasher()
+	balloon, err := NewBalloon(store, hashing.NewSha256Hasher)
+	require.NoError(t, err)
+
+	_, err = balloon.Roots()
+	require.Equal(t, ErrVersionOutOfRange, err, "An empty balloon has no roots")
+
+	var snapshot *Snapshot
+	for i := 0; i < 10; i++ {
+		var mutations []*storage.Mutation
+		snapshot, mutations, err = balloon.Add(h.Do(rand.Bytes(128)))
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations, nil))
+
+		roots, err := balloon.Roots()
+		require.NoError(t, err)
+		require.Equal(t, snapshot.Version, roots.Version)
+		require.Equal(t, snapshot.HistoryDigest, roots.HistoryDigest, "The history root should match in test %d", i)
+		require.Equal(t, snapshot.HyperDigest, roots.HyperDigest, "The hyper root should match in test %d", i)
+	}
+
+	// a balloon opened on the same store computes the same roots
+	reopened, err := NewBalloon(store, hashing.NewSha256Hasher)
+	require.NoError(t, err)
+	roots, err := reopened.Roots()
+	require.NoError(t, err)
+	require.Equal(t, snapshot.HistoryDigest, roots.HistoryDigest)
+	require.Equal(t, snapshot.HyperDigest, roots.HyperDigest)
+}
+
 func TestAddBulk(t *testing.T) {
 
 	store, closeF := storage_utils.OpenBPlusTreeStore()
@@ -20,6 +20,8 @@
 package history
 
 import (
+	"fmt"
+
 	"github.com/bbva/qed/balloon/cache"
 	"github.com/bbva/qed/crypto/hashing"
 	"github.com/bbva/qed/log"
@@ -119,6 +121,55 @@ func (t *HistoryTree) ProveConsistency(start, end uint64) (*IncrementalProof, er
 	return proof, nil
 }
 
+// RootHash function returns the root hash of the tree at the given version.
+// It recomputes the root from the audit path of a consistency proof between
+// the version and itself.
+func (t *HistoryTree) RootHash(version uint64) hashing.Digest {
+
+	visitor := newAuditPathVisitor(t.hasherF(), t.readCache)
+	pruneToCheckConsistency(version, version).Accept(visitor)
+
+	computer := newComputeHashVisitor(t.hasherF(), visitor.Result())
+	return pruneToVerifyIncrementalStart(version).Accept(computer)
+}
+
+// RecomputeRootHash function rebuilds the root hash of the tree at the
+// given version from the stored leaves alone, ignoring every stored
+// interior node.
+func (t *HistoryTree) RecomputeRootHash(version uint64) (hashing.Digest, error) {
+
+	hasher := t.hasherF()
+
+	var traverse func(*position) (hashing.Digest, error)
+	traverse = func(pos *position) (hashing.Digest, error) {
+
+		if pos.IsLeaf() {
+			hash, ok := t.readCache.Get(pos.Bytes())
+			if !ok {
+				return nil, fmt.Errorf("missing history leaf of version %d", pos.Index)
+			}
+			return hash, nil
+		}
+
+		left, err := traverse(pos.Left())
+		if err != nil {
+			return nil, err
+		}
+
+		rightPos := pos.Right()
+		if version < rightPos.Index {
+			return hasher.Salted(pos.Bytes(), left), nil
+		}
+		right, err := traverse(rightPos)
+		if err != nil {
+			return nil, err
+		}
+		return hasher.Salted(pos.Bytes(), left, right), nil
+	}
+
+	return traverse(newRootPosition(version))
+}
+
 // Close function resets history tree's write and read caches, and hasher.
 func (t *HistoryTree) Close() {
 	t.hasher = nil
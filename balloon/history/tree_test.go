@@ -494,6 +494,26 @@ func TestProveConsistencySameVersions(t *testing.T) {
 	}
 }
 
+func TestRecomputeRootHash(t *testing.T) {
+
+	store := bplus.NewBPlusTreeStore()
+	tree := NewHistoryTree(hashing.NewSha256Hasher, store, 30)
+	hasher := hashing.NewSha256Hasher()
+
+	for i := uint64(0); i < 100; i++ {
+		rootHash, mutations, err := tree.Add(hasher.Do(rand.Bytes(64)), i)
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations, nil))
+
+		recomputed, err := tree.RecomputeRootHash(i)
+		require.NoErrorf(t, err, "Error recomputing the root hash of version %d", i)
+		assert.Equalf(t, rootHash, recomputed, "The recomputed root hash should match for version %d", i)
+	}
+
+	_, err := tree.RecomputeRootHash(100)
+	require.Error(t, err, "A version without leaf cannot be recomputed")
+}
+
 func max(x, y int) int {
 	if x > y {
 		return x
@@ -118,6 +118,23 @@ func parseBatchNode(nodeSize int, value []byte) *batchNode {
 	return newBatchNode(nodeSize, parseBatch(nodeSize, value))
 }
 
+// ParseBatchLeaves returns the keys and values of the leaves of a batch
+// serialized as in the hyper table, which holds batches of nodes of
+// nodeSize bytes. Values are versions padded to the size of the keys.
+func ParseBatchLeaves(nodeSize int, value []byte) (keys, values [][]byte) {
+	batch := parseBatchNode(nodeSize, value)
+	// leaves hold their key and value in the two children positions,
+	// so they only occur in the upper levels
+	for i := int8(0); i < 15; i++ {
+		if batch.HasLeafAt(i) {
+			key, value := batch.GetLeafKVAt(i)
+			keys = append(keys, key)
+			values = append(values, value)
+		}
+	}
+	return keys, values
+}
+
 func bitIsSet(bits []byte, i int) bool {
 	return bits[i/8]&(1<<uint(7-i%8)) != 0
 }
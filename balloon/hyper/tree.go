@@ -26,6 +26,7 @@ import (
 	"github.com/bbva/qed/crypto/hashing"
 	"github.com/bbva/qed/log"
 	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/storage/bplus"
 	"github.com/bbva/qed/util"
 )
 
@@ -211,6 +212,64 @@ func (t *HyperTree) RebuildCache() {
 	t.log.Info("Warming up finished")
 }
 
+// RootHash function returns the current root hash of the tree, which is
+// kept in the root batch of the cache, or nil if the tree is empty.
+func (t *HyperTree) RootHash() hashing.Digest {
+	t.Lock()
+	defer t.Unlock()
+
+	batch := t.batchLoader.Load(newRootPosition(t.hasher.Len() / 8))
+	if !batch.HasElementAt(0) {
+		return nil
+	}
+	return batch.GetElementAt(0)
+}
+
+// RecomputeRootHash function rebuilds the tree from the leaves stored in
+// its batches into an in-memory store, ignoring every stored hash and
+// cached batch, and returns the root hash of the rebuilt tree, or nil if
+// the tree is empty.
+func (t *HyperTree) RecomputeRootHash() (hashing.Digest, error) {
+	t.Lock()
+	defer t.Unlock()
+
+	store := bplus.NewBPlusTreeStore()
+	defer store.Close()
+	tree := NewHyperTreeWithLogger(t.hasherF, store, NewBatchCache(DefaultBatchLevels), t.log)
+	defer tree.Close()
+
+	nodeSize := t.hasher.Len() / 8
+	reader := t.store.GetAll(storage.HyperTable)
+	defer reader.Close()
+	batches := make([]*storage.KVPair, 1000)
+	for {
+		n, err := reader.Read(batches)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			break
+		}
+
+		for i := 0; i < n; i++ {
+			keys, values := ParseBatchLeaves(int(nodeSize), batches[i].Value)
+			for j, key := range keys {
+				// values are versions padded to the size of the keys
+				version := util.BytesAsUint64(values[j][len(values[j])-8:])
+				_, mutations, err := tree.Add(key, version)
+				if err != nil {
+					return nil, err
+				}
+				if err := store.Mutate(mutations, nil); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return tree.RootHash(), nil
+}
+
 // Close function resets all hyper tree stuff.
 func (t *HyperTree) Close() {
 	t.Lock()
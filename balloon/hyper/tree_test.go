@@ -374,6 +374,31 @@ func TestRebuildCache(t *testing.T) {
 	require.True(t, firstCache.Equal(secondCache), "The caches should be equal")
 }
 
+func TestRecomputeRootHash(t *testing.T) {
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	tree := NewHyperTree(hashing.NewSha256Hasher, store, cache.NewSimpleCache(0))
+	hasher := hashing.NewSha256Hasher()
+
+	recomputed, err := tree.RecomputeRootHash()
+	require.NoError(t, err)
+	require.Nil(t, recomputed, "An empty tree has no root hash")
+
+	var rootHash hashing.Digest
+	var mutations []*storage.Mutation
+	for i := uint64(0); i < 1000; i++ {
+		rootHash, mutations, err = tree.Add(hasher.Do(rand.Bytes(64)), i)
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations, nil))
+	}
+
+	recomputed, err = tree.RecomputeRootHash()
+	require.NoError(t, err)
+	require.Equal(t, rootHash, recomputed, "The recomputed root hash should match")
+}
+
 func TestAddAndQuery(t *testing.T) {
 
 	store, closeF := storage_utils.OpenRocksDBStore(t, "/var/tmp/hyper_tree_test.db")
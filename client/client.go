@@ -26,6 +26,7 @@ import (
 	"net/http"
 	"net/url"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bbva/qed/balloon"
@@ -36,12 +37,18 @@ import (
 
 // HTTPClient is an HTTP QED client.
 type HTTPClient struct {
+	// lastVersion is the highest balloon version observed by this client
+	// plus one, or zero if it has not observed any version yet.
+	// It must be the first field to be 64-bit aligned for atomic operations.
+	lastVersion uint64
+
 	httpClient          *http.Client
 	retrier             RequestRetrier
 	topology            *topology
 	snapshotStore       *endpoint
 	apiKey              string
 	readPreference      ReadPref
+	readConsistency     protocol.ReadConsistency
 	maxRetries          int
 	healthCheckEnabled  bool
 	healthCheckTimeout  time.Duration
@@ -56,6 +63,25 @@ type HTTPClient struct {
 	discoveryStopCh   chan bool // notify sniffer to stop, and notify back
 }
 
+// splitShards classifies the URLs of the given shards into the primary,
+// the secondaries and the non-voting replicas.
+func splitShards(shards *protocol.Shards) (primary string, secondaries, replicas []string) {
+	secondaries = make([]string, 0)
+	replicas = make([]string, 0)
+	for id, shard := range shards.Shards {
+		url := fmt.Sprintf("%s://%s", shards.URIScheme, shard.HTTPAddr)
+		switch {
+		case id == shards.LeaderId:
+			primary = url
+		case shard.Type == protocol.NonvoterNode:
+			replicas = append(replicas, url)
+		default:
+			secondaries = append(secondaries, url)
+		}
+	}
+	return primary, secondaries, replicas
+}
+
 func newCheckRedirect(client *HTTPClient) func(req *http.Request, via []*http.Request) error {
 	return func(req *http.Request, via []*http.Request) error {
 		var shards protocol.Shards
@@ -68,16 +94,7 @@ func newCheckRedirect(client *HTTPClient) func(req *http.Request, via []*http.Re
 			return err
 		}
 
-		var primary string
-		secondaries := make([]string, 0)
-		for id, shard := range shards.Shards {
-			if id == shards.LeaderId {
-				primary = fmt.Sprintf("%s://%s", shards.URIScheme, shard.HTTPAddr)
-			} else {
-				secondaries = append(secondaries, fmt.Sprintf("%s://%s", shards.URIScheme, shard.HTTPAddr))
-			}
-		}
-		client.topology.Update(primary, secondaries...)
+		client.topology.UpdateWithReplicas(splitShards(&shards))
 		req.Host = req.URL.Host
 		return nil
 	}
@@ -337,6 +354,11 @@ func (c *HTTPClient) callAny(method, path string, data []byte) ([]byte, error) {
 		if errRequest == nil {
 			break
 		}
+		// the node is alive but rejected the request, so there is
+		// no point in asking another one
+		if serr, ok := errRequest.(*ServerError); ok && serr.StatusCode < 500 {
+			return nil, serr
+		}
 		endpoint.MarkAsDead()
 	}
 	if errRequest != nil {
@@ -382,6 +404,9 @@ func (c *HTTPClient) doReq(method string, endpoint *endpoint, path string, data
 	}
 
 	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		if serr := newServerError(resp.StatusCode, bodyBytes); serr != nil {
+			return nil, serr
+		}
 		return nil, fmt.Errorf("Invalid request %v", string(bodyBytes))
 	}
 
@@ -458,16 +483,7 @@ func (c *HTTPClient) discover() error {
 				return err
 			}
 
-			var primary string
-			secondaries := make([]string, 0)
-			for id, shard := range shards.Shards {
-				if id == shards.LeaderId {
-					primary = fmt.Sprintf("%s://%s", shards.URIScheme, shard.HTTPAddr)
-				} else {
-					secondaries = append(secondaries, fmt.Sprintf("%s://%s", shards.URIScheme, shard.HTTPAddr))
-				}
-			}
-			c.topology.Update(primary, secondaries...)
+			c.topology.UpdateWithReplicas(splitShards(&shards))
 			break
 		}
 	}
@@ -504,6 +520,32 @@ func (c *HTTPClient) Ping() error {
 	return nil
 }
 
+// observeVersion records the given balloon version if it is the highest
+// one seen by this client, so later bounded reads do not go back in time.
+func (c *HTTPClient) observeVersion(version uint64) {
+	for {
+		last := atomic.LoadUint64(&c.lastVersion)
+		if version < last || atomic.CompareAndSwapUint64(&c.lastVersion, last, version+1) {
+			return
+		}
+	}
+}
+
+// readConsistencyLevel returns the consistency level and the minimum
+// version to send with proof queries. Bounded reads are sent as stale
+// until the client observes its first version, as there is nothing to
+// bound them to.
+func (c *HTTPClient) readConsistencyLevel() (protocol.ReadConsistency, uint64) {
+	if c.readConsistency != protocol.ReadBounded {
+		return c.readConsistency, 0
+	}
+	last := atomic.LoadUint64(&c.lastVersion)
+	if last == 0 {
+		return protocol.ReadStale, 0
+	}
+	return c.readConsistency, last - 1
+}
+
 // Add will do a request to the server with a post data to store a new event.
 func (c *HTTPClient) Add(event string) (*protocol.Snapshot, error) {
 
@@ -518,6 +560,7 @@ func (c *HTTPClient) Add(event string) (*protocol.Snapshot, error) {
 	if err != nil {
 		return nil, err
 	}
+	c.observeVersion(snapshot.Version)
 
 	return &snapshot, nil
 }
@@ -541,25 +584,23 @@ func (c *HTTPClient) AddBulk(events []string) ([]*protocol.Snapshot, error) {
 	if err != nil {
 		return nil, err
 	}
+	for _, snapshot := range bs {
+		c.observeVersion(snapshot.Version)
+	}
 
 	return bs, nil
 }
 
 // Membership will ask for a Proof to the server.
 func (c *HTTPClient) Membership(key []byte, version *uint64) (*balloon.MembershipProof, error) {
-	var query []byte
-
-	if version == nil {
-		query, _ = json.Marshal(&protocol.MembershipQuery{
-			Key: key,
-		})
-	} else {
-		query, _ = json.Marshal(&protocol.MembershipQuery{
-			Key:     key,
-			Version: version,
-		})
+	consistency, minVersion := c.readConsistencyLevel()
+	query, _ := json.Marshal(&protocol.MembershipQuery{
+		Key:         key,
+		Version:     version,
+		Consistency: consistency,
+		MinVersion:  minVersion,
+	})
 
-	}
 	body, err := c.callAny("POST", "/proofs/membership", query)
 	if err != nil {
 		return nil, err
@@ -570,6 +611,7 @@ func (c *HTTPClient) Membership(key []byte, version *uint64) (*balloon.Membershi
 	if err != nil {
 		return nil, err
 	}
+	c.observeVersion(result.CurrentVersion)
 
 	proof := protocol.ToBalloonProof(result, c.hasherF)
 	return proof, nil
@@ -577,18 +619,13 @@ func (c *HTTPClient) Membership(key []byte, version *uint64) (*balloon.Membershi
 
 // Membership will ask for a Proof to the server.
 func (c *HTTPClient) MembershipDigest(keyDigest hashing.Digest, version *uint64) (*balloon.MembershipProof, error) {
-	var query []byte
-
-	if version == nil {
-		query, _ = json.Marshal(&protocol.MembershipDigest{
-			KeyDigest: keyDigest,
-		})
-	} else {
-		query, _ = json.Marshal(&protocol.MembershipDigest{
-			KeyDigest: keyDigest,
-			Version:   version,
-		})
-	}
+	consistency, minVersion := c.readConsistencyLevel()
+	query, _ := json.Marshal(&protocol.MembershipDigest{
+		KeyDigest:   keyDigest,
+		Version:     version,
+		Consistency: consistency,
+		MinVersion:  minVersion,
+	})
 
 	body, err := c.callAny("POST", "/proofs/digest-membership", query)
 	if err != nil {
@@ -600,6 +637,7 @@ func (c *HTTPClient) MembershipDigest(keyDigest hashing.Digest, version *uint64)
 	if err != nil {
 		return nil, err
 	}
+	c.observeVersion(result.CurrentVersion)
 
 	proof := protocol.ToBalloonProof(result, c.hasherF)
 	return proof, nil
@@ -679,9 +717,12 @@ func (c *HTTPClient) GetSnapshot(version uint64) (*protocol.Snapshot, error) {
 // Incremental will ask for an IncrementalProof to the server.
 func (c *HTTPClient) Incremental(start, end uint64) (*balloon.IncrementalProof, error) {
 
+	consistency, minVersion := c.readConsistencyLevel()
 	query, _ := json.Marshal(&protocol.IncrementalRequest{
-		Start: start,
-		End:   end,
+		Start:       start,
+		End:         end,
+		Consistency: consistency,
+		MinVersion:  minVersion,
 	})
 
 	body, err := c.callAny("POST", "/proofs/incremental", query)
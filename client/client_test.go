@@ -803,6 +803,110 @@ func TestAddWithServerFailure(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestAddAsyncSuccess(t *testing.T) {
+
+	event := "Hello world!"
+	snap := &protocol.Snapshot{
+		HistoryDigest: []byte("history"),
+		HyperDigest:   []byte("hyper"),
+		Version:       0,
+		EventDigest:   []byte(event),
+	}
+	ticket, _ := json.Marshal(&protocol.Ticket{ID: "ticket01"})
+	status, _ := json.Marshal(&protocol.TicketStatus{
+		ID:        "ticket01",
+		State:     protocol.TicketDone,
+		Snapshots: []*protocol.Snapshot{snap},
+	})
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	mux.HandleFunc("/events", defaultHandler(ticket))
+	mux.HandleFunc("/tickets/ticket01", defaultHandler(status))
+
+	client := setupClient(t, []string{server.URL})
+
+	future, err := client.AddAsync(event)
+	require.NoError(t, err)
+	require.Equal(t, "ticket01", future.Ticket(), "The ticket should match")
+
+	snapshots, err := future.Get(time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, []*protocol.Snapshot{snap}, snapshots, "The snapshots should match")
+}
+
+func TestAddAsyncTimeout(t *testing.T) {
+
+	ticket, _ := json.Marshal(&protocol.Ticket{ID: "ticket01"})
+	status, _ := json.Marshal(&protocol.TicketStatus{
+		ID:    "ticket01",
+		State: protocol.TicketPending,
+	})
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	mux.HandleFunc("/events/bulk", defaultHandler(ticket))
+	mux.HandleFunc("/tickets/ticket01", defaultHandler(status))
+
+	client := setupClient(t, []string{server.URL})
+
+	future, err := client.AddBulkAsync([]string{"This is event 1", "This is event 2"})
+	require.NoError(t, err)
+
+	_, err = future.Get(3 * DefaultTicketPollInterval)
+	assert.Equal(t, ErrTimeout, err, "The future should time out")
+}
+
+func TestAddAsyncTicketUnknown(t *testing.T) {
+
+	ticket, _ := json.Marshal(&protocol.Ticket{ID: "ticket01"})
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	mux.HandleFunc("/events", defaultHandler(ticket))
+	mux.HandleFunc("/tickets/ticket01", func(w http.ResponseWriter, r *http.Request) {
+		out, _ := json.Marshal(protocol.NewError(protocol.ErrCodeTicketUnknown, "ticket unknown"))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write(out)
+	})
+
+	client := setupClient(t, []string{server.URL})
+
+	future, err := client.AddAsync("Hello world!")
+	require.NoError(t, err)
+
+	_, err = future.Get(time.Second)
+	require.IsType(t, &ServerError{}, err, "The error should be a server error")
+	assert.True(t, err.(*ServerError).Is(ErrTicketUnknown), "The error should match its code")
+	assert.False(t, err.(*ServerError).Is(ErrNotFound), "The error should not match other codes")
+}
+
+func TestIncrementalWithServerError(t *testing.T) {
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	mux.HandleFunc("/proofs/incremental", func(w http.ResponseWriter, r *http.Request) {
+		out, _ := json.Marshal(protocol.NewError(protocol.ErrCodeInvalidRange, "invalid range"))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write(out)
+	})
+
+	client := setupClient(t, []string{server.URL})
+
+	_, err := client.Incremental(8, 2)
+	require.IsType(t, &ServerError{}, err, "The error should be a server error")
+	serr := err.(*ServerError)
+	assert.Equal(t, http.StatusBadRequest, serr.StatusCode, "The status code should match")
+	assert.True(t, serr.Is(ErrInvalidRange), "The error should match its code")
+	assert.False(t, serr.Is(ErrInternal), "The error should not match other codes")
+}
+
 func TestMembership(t *testing.T) {
 
 	event := []byte{0x0}
@@ -836,6 +940,50 @@ func TestMembership(t *testing.T) {
 	client.Close()
 }
 
+func TestMembershipBoundedConsistency(t *testing.T) {
+
+	var query protocol.MembershipQuery
+	fakeHttpClient := NewTestHttpClient(func(req *http.Request) (*http.Response, error) {
+		switch req.URL.Path {
+		case "/events":
+			body, _ := json.Marshal(protocol.Snapshot{Version: 42})
+			return buildResponse(http.StatusCreated, string(body)), nil
+		case "/proofs/membership":
+			_ = json.NewDecoder(req.Body).Decode(&query)
+			body, _ := json.Marshal(protocol.MembershipResult{CurrentVersion: 40})
+			return buildResponse(http.StatusOK, string(body)), nil
+		}
+		return nil, errors.New("Unreachable")
+	})
+
+	client, err := NewHTTPClient(
+		SetHttpClient(fakeHttpClient),
+		SetURLs("http://primary.foo"),
+		SetReadConsistency(protocol.ReadBounded),
+		SetMaxRetries(0),
+		SetTopologyDiscovery(false),
+		SetHealthChecks(false),
+		SetHasherFunction(hashing.NewFakeXorHasher),
+	)
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, err = client.Membership([]byte("Hello world!"), nil)
+	require.NoError(t, err)
+	require.Equal(t, protocol.ReadStale, query.Consistency, "Reads without an observed version cannot be bounded")
+
+	_, err = client.Add("Hello world!")
+	require.NoError(t, err)
+
+	_, err = client.Membership([]byte("Hello world!"), nil)
+	require.NoError(t, err)
+	require.Equal(t, protocol.ReadBounded, query.Consistency, "The consistency level should be sent")
+	require.Equal(t, uint64(42), query.MinVersion, "The last observed version should be required")
+
+	_, err = NewHTTPClient(SetReadConsistency("eventual"))
+	require.Error(t, err, "Unknown consistency levels should be rejected")
+}
+
 func TestMembershipDigest(t *testing.T) {
 
 	eventDigest := hashing.Digest([]byte{0x0})
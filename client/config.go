@@ -20,6 +20,7 @@ import (
 	"time"
 
 	"github.com/bbva/qed/crypto/hashing"
+	"github.com/bbva/qed/protocol"
 )
 
 // ReadPref specifies the preferred type of node in the cluster
@@ -48,11 +49,23 @@ const (
 	// a result, secondaries will service reads at roughly the same rate as the
 	// primary. In addition, although replication is synchronous, there is some amount
 	// of dely between event replication to secondaries and change application
-	// to the corresponding balloon. Reading from a secondary can return stale data.
+	// to the corresponding balloon. Reading from a secondary can return stale data
+	// unless a bounded or linearizable ReadConsistency is requested.
 	SecondaryPreferred
 
 	// Any forces to read from any node in the cluster including the leader.
 	Any
+
+	// Replica forces to read only from non-voting replicas.
+	Replica
+
+	// ReplicaPreferred aims to read from non-voting replicas.
+	//
+	// Use ReplicaPreferred to offload proof queries to read replicas while
+	// falling back to the rest of the secondaries, and finally to the primary,
+	// when no replica is available. As with secondaries, reading from a
+	// replica can return stale data.
+	ReplicaPreferred
 )
 
 const (
@@ -120,6 +133,11 @@ type Config struct {
 	// Controls how the client will route all queries to members of the cluster.
 	ReadPreference ReadPref `flag:"-"`
 
+	// ReadConsistency sets how up to date the node serving a proof must be:
+	// stale, bounded or linearizable. Bounded reads require the node to have
+	// applied the last version observed by this client.
+	ReadConsistency protocol.ReadConsistency `flag:"-"`
+
 	// MaxRetries sets the maximum number of retries before giving up
 	// when performing an HTTP request to QED.
 	MaxRetries int `desc:"Sets the maximum number of retries before giving up"`
@@ -156,6 +174,7 @@ func DefaultConfig() *Config {
 		DialTimeout:              DefaultDialTimeout,
 		HandshakeTimeout:         DefaultHandshakeTimeout,
 		ReadPreference:           Primary,
+		ReadConsistency:          protocol.ReadStale,
 		MaxRetries:               DefaultMaxRetries,
 		EnableTopologyDiscovery:  DefaultTopologyDiscoveryEnabled,
 		EnableHealthChecks:       DefaultHealthCheckEnabled,
@@ -28,6 +28,7 @@ const (
 	primary nodeType = iota
 	secondary
 	store
+	replica
 )
 
 // endpoint represents status information of a single endpointection to a node in a cluster
@@ -16,7 +16,15 @@
 
 package client
 
-import "errors"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/bbva/qed/protocol"
+)
 
 var (
 	// ErrNoEndpoint is raised when no QED node is available.
@@ -34,4 +42,123 @@ var (
 
 	// ErrTimeout is raised when a request timed out.
 	ErrTimeout = errors.New("timeout")
+
+	// ErrNotLeader is raised when the QED node cannot find the cluster leader.
+	ErrNotLeader = errors.New("no QED leader available")
+
+	// ErrInvalidRange is raised when a query range is malformed.
+	ErrInvalidRange = errors.New("invalid range")
+
+	// ErrVersionOutOfRange is raised when a query refers to a version
+	// QED has not reached yet.
+	ErrVersionOutOfRange = errors.New("version out of range")
+
+	// ErrPayloadTooLarge is raised when a request body is too large.
+	ErrPayloadTooLarge = errors.New("payload too large")
+
+	// ErrRateLimited is raised when QED throttles the client requests.
+	ErrRateLimited = errors.New("rate limited")
+
+	// ErrQuotaExceeded is raised when the client has used up its daily
+	// quota of events.
+	ErrQuotaExceeded = errors.New("daily quota exceeded")
+
+	// ErrStaleRead is raised when the node has not applied the version
+	// required by the consistency level of a query.
+	ErrStaleRead = errors.New("stale read")
+
+	// ErrReadOnly is raised when the cluster does not accept new events
+	// because it has been switched to read-only mode.
+	ErrReadOnly = errors.New("cluster in read-only mode")
+
+	// ErrConflict is raised when a request conflicts with the current
+	// state of the cluster.
+	ErrConflict = errors.New("conflict")
+
+	// ErrBadRequest is raised when QED cannot parse the request.
+	ErrBadRequest = errors.New("bad request")
+
+	// ErrNotFound is raised when the requested resource does not exist,
+	// e.g. an expired ticket.
+	ErrNotFound = errors.New("not found")
+
+	// ErrTicketUnknown is raised when the node polled does not know the
+	// ticket, e.g. after a leadership change. The events may have been
+	// added anyway, so check them with a membership query.
+	ErrTicketUnknown = errors.New("ticket unknown")
+
+	// ErrUnavailable is raised when the node is temporarily unable to
+	// accept the request.
+	ErrUnavailable = errors.New("service unavailable")
+
+	// ErrInternal is raised when QED fails unexpectedly.
+	ErrInternal = errors.New("internal server error")
 )
+
+// codeErrors maps the server error codes to their client errors.
+var codeErrors = map[protocol.ErrorCode]error{
+	protocol.ErrCodeNotLeader:         ErrNotLeader,
+	protocol.ErrCodeInvalidRange:      ErrInvalidRange,
+	protocol.ErrCodeVersionOutOfRange: ErrVersionOutOfRange,
+	protocol.ErrCodePayloadTooLarge:   ErrPayloadTooLarge,
+	protocol.ErrCodeRateLimited:       ErrRateLimited,
+	protocol.ErrCodeQuotaExceeded:     ErrQuotaExceeded,
+	protocol.ErrCodeStaleRead:         ErrStaleRead,
+	protocol.ErrCodeReadOnly:          ErrReadOnly,
+	protocol.ErrCodeConflict:          ErrConflict,
+	protocol.ErrCodeBadRequest:        ErrBadRequest,
+	protocol.ErrCodeNotFound:          ErrNotFound,
+	protocol.ErrCodeTicketUnknown:     ErrTicketUnknown,
+	protocol.ErrCodeUnavailable:       ErrUnavailable,
+	protocol.ErrCodeInternal:          ErrInternal,
+}
+
+// ServerError is the error raised when a QED node answers with
+// a protocol.Error. It can be compared with errors.Is against the
+// client errors matching its code, e.g. ErrInvalidRange.
+type ServerError struct {
+	StatusCode int
+	Code       protocol.ErrorCode
+	Message    string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("QED error %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Is reports whether the target is the client error matching the
+// server error code.
+func (e *ServerError) Is(target error) bool {
+	err, ok := codeErrors[e.Code]
+	return ok && err == target
+}
+
+// newServerError builds a ServerError from an unsuccessful response,
+// returning nil if its body is not a protocol.Error.
+func newServerError(statusCode int, body []byte) *ServerError {
+	var perr protocol.Error
+	if err := json.Unmarshal(body, &perr); err != nil || perr.Code == "" {
+		return nil
+	}
+	return &ServerError{
+		StatusCode: statusCode,
+		Code:       perr.Code,
+		Message:    perr.Message,
+	}
+}
+
+// giveUpError is the error returned by the retriers once they stop
+// retrying. If the last response carried a protocol.Error it is returned
+// as a ServerError, otherwise a generic error is built.
+func giveUpError(req *RetriableRequest, resp *http.Response, attempts int) error {
+	if resp != nil && resp.Body != nil {
+		body, err := ioutil.ReadAll(resp.Body)
+		if err == nil {
+			if serr := newServerError(resp.StatusCode, body); serr != nil {
+				return serr
+			}
+		}
+	}
+	return fmt.Errorf("%s %s giving up after %d attempts",
+		req.Method, req.URL, attempts)
+}
@@ -0,0 +1,143 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/bbva/qed/protocol"
+)
+
+// DefaultTicketPollInterval is the time an AddFuture waits between
+// two consecutive ticket status requests.
+const DefaultTicketPollInterval = 100 * time.Millisecond
+
+// AddFuture is the pending result of an asynchronous add operation.
+type AddFuture struct {
+	client    *HTTPClient
+	ticket    protocol.Ticket
+	snapshots []*protocol.Snapshot
+	err       error
+	done      bool
+}
+
+// Ticket returns the ID of the ticket the server assigned to the request.
+func (f *AddFuture) Ticket() string {
+	return f.ticket.ID
+}
+
+// Get polls the server until the ticket is resolved or the timeout
+// expires, and returns one snapshot per added event.
+// A timeout of 0 means no timeout.
+// If the leader polled does not know the ticket, e.g. because the
+// leadership changed, the error matches ErrTicketUnknown: the events may
+// have been added anyway, so check them with a membership query.
+func (f *AddFuture) Get(timeout time.Duration) ([]*protocol.Snapshot, error) {
+	if f.done {
+		return f.snapshots, f.err
+	}
+
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	ticker := time.NewTicker(DefaultTicketPollInterval)
+	defer ticker.Stop()
+
+	for {
+		status, err := f.client.ticketStatus(f.ticket.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		switch status.State {
+		case protocol.TicketDone:
+			for _, snapshot := range status.Snapshots {
+				f.client.observeVersion(snapshot.Version)
+			}
+			f.snapshots, f.done = status.Snapshots, true
+			return f.snapshots, nil
+		case protocol.TicketFailed:
+			f.err, f.done = errors.New(status.Error), true
+			return nil, f.err
+		}
+
+		select {
+		case <-deadline:
+			return nil, ErrTimeout
+		case <-ticker.C:
+		}
+	}
+}
+
+// AddAsync will do a request to the server to store a new event without
+// waiting for it to be committed. The returned future resolves to
+// a single snapshot.
+func (c *HTTPClient) AddAsync(event string) (*AddFuture, error) {
+
+	data, _ := json.Marshal(&protocol.Event{Event: []byte(event)})
+	return c.addAsync("/events?async=true", data)
+}
+
+// AddBulkAsync will do a request to the server to store a bulk of new
+// events without waiting for them to be committed. The returned future
+// resolves to one snapshot per event.
+func (c *HTTPClient) AddBulkAsync(events []string) (*AddFuture, error) {
+
+	eventBulk := protocol.EventsBulk{}
+	for _, e := range events {
+		eventBulk.Events = append(eventBulk.Events, []byte(e))
+	}
+
+	data, _ := json.Marshal(eventBulk)
+	return c.addAsync("/events/bulk?async=true", data)
+}
+
+func (c *HTTPClient) addAsync(path string, data []byte) (*AddFuture, error) {
+	body, err := c.callPrimary("POST", path, data)
+	if err != nil {
+		return nil, err
+	}
+
+	future := &AddFuture{client: c}
+	err = json.Unmarshal(body, &future.ticket)
+	if err != nil {
+		return nil, err
+	}
+
+	return future, nil
+}
+
+func (c *HTTPClient) ticketStatus(id string) (*protocol.TicketStatus, error) {
+	body, err := c.callPrimary("GET", "/tickets/"+id, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var status protocol.TicketStatus
+	err = json.Unmarshal(body, &status)
+	if err != nil {
+		return nil, err
+	}
+
+	return &status, nil
+}
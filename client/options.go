@@ -19,12 +19,14 @@ package client
 import (
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"net"
 	"net/http"
 	"time"
 
 	"github.com/bbva/qed/crypto/hashing"
 	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/protocol"
 )
 
 // HTTPClientOptionF is a function that configures an HTTPClient.
@@ -36,6 +38,7 @@ func configToOptions(conf *Config) ([]HTTPClientOptionF, error) {
 		options = []HTTPClientOptionF{
 			SetSnapshotStoreURL(conf.SnapshotStoreURL),
 			SetReadPreference(conf.ReadPreference),
+			SetReadConsistency(conf.ReadConsistency),
 			SetMaxRetries(conf.MaxRetries),
 			SetTopologyDiscovery(conf.EnableTopologyDiscovery),
 			SetHealthChecks(conf.EnableHealthChecks),
@@ -126,6 +129,17 @@ func SetReadPreference(preference ReadPref) HTTPClientOptionF {
 	}
 }
 
+func SetReadConsistency(level protocol.ReadConsistency) HTTPClientOptionF {
+	return func(c *HTTPClient) error {
+		switch level {
+		case "", protocol.ReadStale, protocol.ReadBounded, protocol.ReadLinearizable:
+			c.readConsistency = level
+			return nil
+		}
+		return fmt.Errorf("Unknown read consistency level %q", level)
+	}
+}
+
 func SetMaxRetries(retries int) HTTPClientOptionF {
 	return func(c *HTTPClient) error {
 		c.maxRetries = retries
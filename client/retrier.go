@@ -72,8 +72,10 @@ func (r *NoRequestRetrier) DoReq(req *RetriableRequest) (*http.Response, error)
 	if err == nil && resp.StatusCode > 0 && resp.StatusCode < 500 {
 		return resp, nil
 	}
-	return nil, fmt.Errorf("%s %s: giving up after %d attempts",
-		req.Method, req.URL, 1)
+	if err == nil && resp != nil {
+		defer resp.Body.Close()
+	}
+	return nil, giveUpError(req, resp, 1)
 }
 
 // BackoffRequestRetrier is an implementation that uses the given backoff strategy.
@@ -179,10 +181,9 @@ func (r *BackoffRequestRetrier) DoReq(req *RetriableRequest) (*http.Response, er
 	// By default, we close the response body and return an error without
 	// returning the response
 	if resp != nil {
-		resp.Body.Close()
+		defer resp.Body.Close()
 	}
 
-	return nil, fmt.Errorf("%s %s giving up after %d attempts",
-		req.Method, req.URL, r.maxRetries+1)
+	return nil, giveUpError(req, resp, r.maxRetries+1)
 
 }
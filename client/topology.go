@@ -39,6 +39,12 @@ func newTopology(attemptToRevive bool) *topology {
 }
 
 func (t *topology) Update(primaryNode string, secondaries ...string) {
+	t.UpdateWithReplicas(primaryNode, secondaries, nil)
+}
+
+// UpdateWithReplicas replaces the known endpoints with the given primary,
+// secondaries and non-voting replicas.
+func (t *topology) UpdateWithReplicas(primaryNode string, secondaries, replicas []string) {
 	t.Lock()
 	defer t.Unlock()
 
@@ -51,21 +57,25 @@ func (t *topology) Update(primaryNode string, secondaries ...string) {
 		newEndpoints = append(newEndpoints, t.primary)
 	}
 
-	for _, url := range secondaries {
-		var found bool
+	add := func(url string, nodeType nodeType) {
 		for _, oldEndpoint := range t.endpoints {
-			if oldEndpoint.url == url {
+			if oldEndpoint.url == url && oldEndpoint.nodeType == nodeType {
 				// Take over the old endpoint
 				newEndpoints = append(newEndpoints, oldEndpoint)
-				found = true
-				break
+				return
 			}
 		}
-		if !found && url != "" {
+		if url != "" {
 			// New endpoint didn't exist, so add it to our list of new endpoints.
-			newEndpoints = append(newEndpoints, newEndpoint(url, secondary))
+			newEndpoints = append(newEndpoints, newEndpoint(url, nodeType))
 		}
 	}
+	for _, url := range secondaries {
+		add(url, secondary)
+	}
+	for _, url := range replicas {
+		add(url, replica)
+	}
 	t.endpoints = newEndpoints
 	t.cIndex = -1
 }
@@ -117,7 +127,7 @@ func (t *topology) NextReadEndpoint(pref ReadPref) (*endpoint, error) {
 					t.cIndex = 0
 				}
 				endpoint := t.endpoints[t.cIndex]
-				if endpoint.nodeType == secondary && !endpoint.IsDead() {
+				if endpoint.nodeType != primary && !endpoint.IsDead() {
 					return endpoint, nil
 				}
 				i++
@@ -138,7 +148,7 @@ func (t *topology) NextReadEndpoint(pref ReadPref) (*endpoint, error) {
 					t.cIndex = 0
 				}
 				endpoint := t.endpoints[t.cIndex]
-				if endpoint.nodeType == secondary && !endpoint.IsDead() {
+				if endpoint.nodeType != primary && !endpoint.IsDead() {
 					return endpoint, nil
 				}
 				i++
@@ -152,6 +162,24 @@ func (t *topology) NextReadEndpoint(pref ReadPref) (*endpoint, error) {
 		}
 		break
 
+	case Replica:
+		if endpoint := t.nextEndpoint(replica); endpoint != nil {
+			return endpoint, nil
+		}
+		break
+
+	case ReplicaPreferred:
+		if endpoint := t.nextEndpoint(replica); endpoint != nil {
+			return endpoint, nil
+		}
+		if endpoint := t.nextEndpoint(secondary); endpoint != nil {
+			return endpoint, nil
+		}
+		if t.primary != nil && !t.primary.IsDead() {
+			return t.primary, nil
+		}
+		break
+
 	case Any:
 		i := 0
 		numEndpoints := len(t.endpoints)
@@ -188,6 +216,23 @@ func (t *topology) NextReadEndpoint(pref ReadPref) (*endpoint, error) {
 	return nil, ErrNoEndpoint
 }
 
+// nextEndpoint returns the next alive endpoint of the given type in a
+// round-robin manner, or nil if there is none.
+func (t *topology) nextEndpoint(nodeType nodeType) *endpoint {
+	numEndpoints := len(t.endpoints)
+	for i := 0; i < numEndpoints; i++ {
+		t.cIndex++
+		if t.cIndex >= numEndpoints {
+			t.cIndex = 0
+		}
+		endpoint := t.endpoints[t.cIndex]
+		if endpoint.nodeType == nodeType && !endpoint.IsDead() {
+			return endpoint
+		}
+	}
+	return nil
+}
+
 // HasActivePrimary returns true if there is an active primary endpoint.
 func (t *topology) HasActivePrimary() bool {
 	t.Lock()
@@ -245,6 +245,45 @@ func TestTopologyNextReadEndpoint(t *testing.T) {
 
 }
 
+func TestTopologyReplicas(t *testing.T) {
+	topology := newTopology(false)
+	topology.UpdateWithReplicas(
+		"http://primary:8080",
+		[]string{"http://secondary1:8080"},
+		[]string{"http://replica1:8080", "http://replica2:8080"},
+	)
+
+	// Replica only returns non-voting replicas
+	for i := 0; i < 4; i++ {
+		endpoint, err := topology.NextReadEndpoint(Replica)
+		require.NoError(t, err)
+		require.Equalf(t, replica, endpoint.nodeType, "The type of node should match")
+	}
+
+	// Secondary includes replicas
+	collected := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		endpoint, err := topology.NextReadEndpoint(Secondary)
+		require.NoError(t, err)
+		collected[endpoint.URL()] = true
+	}
+	require.Len(t, collected, 3, "Secondary should round-robin across secondaries and replicas")
+
+	// ReplicaPreferred falls back to secondaries and then to the primary
+	topology.UpdateWithReplicas("http://primary:8080", []string{"http://secondary1:8080"}, nil)
+	endpoint, err := topology.NextReadEndpoint(ReplicaPreferred)
+	require.NoError(t, err)
+	require.Equalf(t, "http://secondary1:8080", endpoint.URL(), "The URL should match")
+
+	endpoint.MarkAsDead()
+	endpoint, err = topology.NextReadEndpoint(ReplicaPreferred)
+	require.NoError(t, err)
+	require.Equalf(t, "http://primary:8080", endpoint.URL(), "The URL should match")
+
+	_, err = topology.NextReadEndpoint(Replica)
+	require.Error(t, err, "There should be no replicas available")
+}
+
 func TestTopologyHasActivePrimary(t *testing.T) {
 	topology := newTopology(false)
 	require.False(t, topology.HasActivePrimary())
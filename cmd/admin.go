@@ -0,0 +1,67 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/octago/sflags/gen/gpflag"
+	"github.com/spf13/cobra"
+)
+
+var adminCmd *cobra.Command = &cobra.Command{
+	Use:   "admin",
+	Short: "Manages QED log cluster-wide settings",
+	Long: `Manages the QED log settings shared by every node of the cluster.
+Changes go through Raft, so every node switches them at the same point
+of the log. They must be sent to the leader management endpoint.`,
+	TraverseChildren:  true,
+	PersistentPreRunE: runCluster,
+}
+
+var adminCtx context.Context
+
+func init() {
+	adminCtx = configAdmin()
+	Root.AddCommand(adminCmd)
+}
+
+func configAdmin() context.Context {
+
+	conf := defaultClusterConfig()
+
+	err := gpflag.ParseTo(conf, adminCmd.PersistentFlags())
+	if err != nil {
+		fmt.Printf("Cannot parse command flags: %v\n", err)
+		fmt.Println("Exiting...")
+		os.Exit(1)
+	}
+	return context.WithValue(Ctx, k("admin.config"), conf)
+}
+
+// configAdminParams parses the flags of an admin subcommand into params.
+func configAdminParams(cmd *cobra.Command, key string, params interface{}) context.Context {
+	err := gpflag.ParseTo(params, cmd.PersistentFlags())
+	if err != nil {
+		fmt.Printf("Cannot parse command flags: %v\n", err)
+		fmt.Println("Exiting...")
+		os.Exit(1)
+	}
+	return context.WithValue(Ctx, k(key), params)
+}
@@ -0,0 +1,61 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/spf13/cobra"
+)
+
+type adminHashingParams struct {
+	Scheme string `desc:"Hashing scheme of the balloon: sha256 or blake2b"`
+}
+
+var adminHashingCmd *cobra.Command = &cobra.Command{
+	Use:   "hashing",
+	Short: "Change the QED Log hashing scheme",
+	Long: `Change the hashing scheme of the QED Log balloon. It can only be
+changed before any event is added, since existing proofs would break.`,
+	RunE: runAdminHashing,
+}
+
+var adminHashingCtx context.Context
+
+func init() {
+	adminHashingCtx = configAdminParams(adminHashingCmd, "admin.hashing.params", &adminHashingParams{})
+	adminCmd.AddCommand(adminHashingCmd)
+}
+
+func runAdminHashing(cmd *cobra.Command, args []string) error {
+	params := adminHashingCtx.Value(k("admin.hashing.params")).(*adminHashingParams)
+	if params.Scheme == "" {
+		return fmt.Errorf("Hashing scheme is required")
+	}
+
+	config := adminCtx.Value(k("admin.config")).(*ClusterConfig)
+
+	_, err := clusterRequest(config, "POST", "/admin/hashing?scheme="+url.QueryEscape(params.Scheme))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Hashing scheme changed to %s!\n", params.Scheme)
+	return nil
+}
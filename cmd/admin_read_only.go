@@ -0,0 +1,63 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+type adminReadOnlyParams struct {
+	Disable bool `desc:"Leave the read-only mode and accept events again"`
+}
+
+var adminReadOnlyCmd *cobra.Command = &cobra.Command{
+	Use:   "read-only",
+	Short: "Switch the QED Log cluster to read-only mode",
+	Long: `Switch the QED Log cluster to read-only mode, in which proofs are
+still served but new events are rejected. Use --disable to leave it.`,
+	RunE: runAdminReadOnly,
+}
+
+var adminReadOnlyCtx context.Context
+
+func init() {
+	adminReadOnlyCtx = configAdminParams(adminReadOnlyCmd, "admin.read-only.params", &adminReadOnlyParams{})
+	adminCmd.AddCommand(adminReadOnlyCmd)
+}
+
+func runAdminReadOnly(cmd *cobra.Command, args []string) error {
+	params := adminReadOnlyCtx.Value(k("admin.read-only.params")).(*adminReadOnlyParams)
+
+	config := adminCtx.Value(k("admin.config")).(*ClusterConfig)
+
+	enabled := !params.Disable
+	_, err := clusterRequest(config, "POST", "/admin/read-only?enabled="+strconv.FormatBool(enabled))
+	if err != nil {
+		return err
+	}
+
+	if enabled {
+		fmt.Println("Read-only mode enabled!")
+	} else {
+		fmt.Println("Read-only mode disabled!")
+	}
+	return nil
+}
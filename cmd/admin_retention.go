@@ -0,0 +1,57 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+type adminRetentionParams struct {
+	Backups uint32 `desc:"Number of backups each node keeps (0 keeps all of them)"`
+}
+
+var adminRetentionCmd *cobra.Command = &cobra.Command{
+	Use:   "retention",
+	Short: "Set how many backups QED Log servers keep",
+	RunE:  runAdminRetention,
+}
+
+var adminRetentionCtx context.Context
+
+func init() {
+	adminRetentionCtx = configAdminParams(adminRetentionCmd, "admin.retention.params", &adminRetentionParams{})
+	adminCmd.AddCommand(adminRetentionCmd)
+}
+
+func runAdminRetention(cmd *cobra.Command, args []string) error {
+	params := adminRetentionCtx.Value(k("admin.retention.params")).(*adminRetentionParams)
+
+	config := adminCtx.Value(k("admin.config")).(*ClusterConfig)
+
+	path := "/admin/retention?backups=" + strconv.FormatUint(uint64(params.Backups), 10)
+	_, err := clusterRequest(config, "POST", path)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Backup retention set to %d!\n", params.Backups)
+	return nil
+}
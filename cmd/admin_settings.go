@@ -0,0 +1,64 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bbva/qed/protocol"
+)
+
+var adminSettingsCmd *cobra.Command = &cobra.Command{
+	Use:   "settings",
+	Short: "Show the QED Log cluster-wide settings",
+	RunE:  runAdminSettings,
+}
+
+func init() {
+	adminCmd.AddCommand(adminSettingsCmd)
+}
+
+func runAdminSettings(cmd *cobra.Command, args []string) error {
+
+	config := adminCtx.Value(k("admin.config")).(*ClusterConfig)
+
+	body, err := clusterRequest(config, "GET", "/admin/settings")
+	if err != nil {
+		return err
+	}
+
+	var settings protocol.ClusterSettings
+	err = json.Unmarshal(body, &settings)
+	if err != nil {
+		return err
+	}
+
+	signingKey := "local key of each node"
+	if len(settings.SigningPublicKey) > 0 {
+		signingKey = base64.StdEncoding.EncodeToString(settings.SigningPublicKey)
+	}
+	fmt.Printf("Index: %d\n", settings.Index)
+	fmt.Printf("Signing public key: %s\n", signingKey)
+	fmt.Printf("Hashing scheme: %s\n", settings.HashingScheme)
+	fmt.Printf("Read-only: %v\n", settings.ReadOnly)
+	fmt.Printf("Backup retention: %d\n", settings.BackupRetention)
+	return nil
+}
@@ -0,0 +1,74 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bbva/qed/protocol"
+)
+
+type adminSigningKeyParams struct {
+	PublicKeyPath string `desc:"Path to the Ed25519 public key of the key every node will sign snapshots with"`
+}
+
+var adminSigningKeyCmd *cobra.Command = &cobra.Command{
+	Use:   "signing-key",
+	Short: "Replace the key QED Log servers sign snapshots with",
+	Long: `Replace the key QED Log servers sign snapshots with. Only the public key
+is sent to the cluster: the private key must be installed beforehand in the
+--signing-keys-path directory of every server.`,
+	RunE: runAdminSigningKey,
+}
+
+var adminSigningKeyCtx context.Context
+
+func init() {
+	adminSigningKeyCtx = configAdminParams(adminSigningKeyCmd, "admin.signing-key.params", &adminSigningKeyParams{})
+	adminCmd.AddCommand(adminSigningKeyCmd)
+}
+
+func runAdminSigningKey(cmd *cobra.Command, args []string) error {
+	params := adminSigningKeyCtx.Value(k("admin.signing-key.params")).(*adminSigningKeyParams)
+	if params.PublicKeyPath == "" {
+		return fmt.Errorf("Public key path is required")
+	}
+
+	publicKey, err := ioutil.ReadFile(params.PublicKeyPath)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(protocol.SigningKeyRequest{PublicKey: publicKey})
+	if err != nil {
+		return err
+	}
+
+	config := adminCtx.Value(k("admin.config")).(*ClusterConfig)
+
+	_, err = clusterRequestWithBody(config, "POST", "/admin/signing-key", body)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Signing key replaced!")
+	return nil
+}
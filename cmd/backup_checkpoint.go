@@ -0,0 +1,123 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/octago/sflags/gen/gpflag"
+	"github.com/spf13/cobra"
+
+	"github.com/bbva/qed/protocol"
+)
+
+var backupCheckpointCmd *cobra.Command = &cobra.Command{
+	Use:   "checkpoint",
+	Short: "Cut a checkpoint of a QED Log server database",
+	Long: `Cuts a consistent checkpoint of the database of the server, to be
+served with "qed server query-only". By default the checkpoint holds the
+last balloon version of the server. An earlier version is rebuilt from the
+nearest backup and the WAL of the server, and refused if it cannot be
+reached exactly, e.g. when it falls in the middle of a bulk of events.`,
+	RunE: runBackupCheckpoint,
+}
+
+var backupCheckpointCtx context.Context
+
+type checkpointParams struct {
+	Version int64 `desc:"Balloon version of the checkpoint (-1 for the last one)"`
+}
+
+func init() {
+	backupCheckpointCtx = configBackupCheckpoint()
+	backupCmd.AddCommand(backupCheckpointCmd)
+}
+
+func configBackupCheckpoint() context.Context {
+	conf := &checkpointParams{Version: -1}
+
+	err := gpflag.ParseTo(conf, backupCheckpointCmd.PersistentFlags())
+	if err != nil {
+		fmt.Printf("Cannot parse command flags: %v\n", err)
+		fmt.Println("Exiting...")
+		os.Exit(1)
+	}
+	return context.WithValue(Ctx, k("backup.checkpoint.params"), conf)
+}
+
+func runBackupCheckpoint(cmd *cobra.Command, args []string) error {
+
+	config := backupCtx.Value(k("backup.config")).(*BackupConfig)
+	params := backupCheckpointCtx.Value(k("backup.checkpoint.params")).(*checkpointParams)
+
+	checkpoint, err := createCheckpoint(config, params.Version)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Checkpoint of version %d created at %s\n", checkpoint.Version, checkpoint.Path)
+	fmt.Printf(" HistoryDigest: %x\n HyperDigest: %x\n", checkpoint.HistoryDigest, checkpoint.HyperDigest)
+	return nil
+}
+
+func createCheckpoint(config *BackupConfig, version int64) (*protocol.Checkpoint, error) {
+
+	// Build request
+	url := config.Endpoint + "/checkpoints"
+	if version >= 0 {
+		url += "?version=" + strconv.FormatInt(version, 10)
+	}
+	req, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Api-Key", config.APIKey)
+
+	// Get response
+	client := http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Printf("Request error: %v\n", err)
+		return nil, err
+	}
+
+	var bodyBytes []byte
+	if resp.Body != nil {
+		defer resp.Body.Close()
+		bodyBytes, err = ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("Invalid request %v", string(bodyBytes))
+	}
+
+	checkpoint := new(protocol.Checkpoint)
+	if err := json.Unmarshal(bodyBytes, checkpoint); err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
@@ -0,0 +1,125 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/octago/sflags/gen/gpflag"
+	"github.com/spf13/cobra"
+)
+
+var backupExportCmd *cobra.Command = &cobra.Command{
+	Use:   "export",
+	Short: "Download a QED Log backup as a portable archive",
+	Long: `Downloads a backup from the management API of a QED Log server as a
+zstd compressed tar archive. The archive carries a manifest with the
+balloon version, its roots and the checksums of the files, and can be
+imported on any node with "qed backup import".`,
+	RunE: runBackupExport,
+}
+
+var backupExportCtx context.Context
+
+type exportParams struct {
+	ID  uint32 `desc:"QED backup to export"`
+	Out string `desc:"Path of the archive to write (.tar.zst)"`
+}
+
+func init() {
+	backupExportCtx = configBackupExport()
+	backupCmd.AddCommand(backupExportCmd)
+}
+
+func configBackupExport() context.Context {
+	conf := &exportParams{}
+
+	err := gpflag.ParseTo(conf, backupExportCmd.PersistentFlags())
+	if err != nil {
+		fmt.Printf("Cannot parse command flags: %v\n", err)
+		fmt.Println("Exiting...")
+		os.Exit(1)
+	}
+	return context.WithValue(Ctx, k("backup.export.params"), conf)
+}
+
+func runBackupExport(cmd *cobra.Command, args []string) error {
+	params := backupExportCtx.Value(k("backup.export.params")).(*exportParams)
+	if params.ID == 0 {
+		return errors.New("Backup ID is required.")
+	}
+	if params.Out == "" {
+		return errors.New("Output file is required.")
+	}
+
+	config := backupCtx.Value(k("backup.config")).(*BackupConfig)
+
+	size, err := exportBackup(config, params.ID, params.Out)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Backup %d exported to %s (%d bytes)\n", params.ID, params.Out, size)
+	return nil
+}
+
+func exportBackup(config *BackupConfig, backupID uint32, out string) (int64, error) {
+
+	// Build request
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/backups/%d/download", config.Endpoint, backupID), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Api-Key", config.APIKey)
+
+	// Get response
+	client := http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Printf("Request error: %v\n", err)
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := ioutil.ReadAll(resp.Body)
+		return 0, fmt.Errorf("Invalid request %v", string(bodyBytes))
+	}
+
+	// write to a temporary file so a failed download does not leave a
+	// truncated archive behind
+	tmp := out + ".part"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	size, err := io.Copy(f, resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return size, os.Rename(tmp, out)
+}
@@ -0,0 +1,89 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/octago/sflags/gen/gpflag"
+	"github.com/spf13/cobra"
+
+	"github.com/bbva/qed/storage/archive"
+)
+
+var backupImportCmd *cobra.Command = &cobra.Command{
+	Use:   "import",
+	Short: "Import a QED Log backup archive into a database directory",
+	Long: `Extracts an archive created with "qed backup export" into an empty
+database directory, checking every file against the manifest. The
+directory can then be used as the database path of a node started with
+the storage engine printed by this command.`,
+	RunE: runBackupImport,
+}
+
+var backupImportCtx context.Context
+
+type importParams struct {
+	Archive string `desc:"Path of the archive to import (.tar.zst)"`
+	DBPath  string `desc:"Empty directory where the database is extracted"`
+}
+
+func init() {
+	backupImportCtx = configBackupImport()
+	backupCmd.AddCommand(backupImportCmd)
+}
+
+func configBackupImport() context.Context {
+	conf := &importParams{}
+
+	err := gpflag.ParseTo(conf, backupImportCmd.PersistentFlags())
+	if err != nil {
+		fmt.Printf("Cannot parse command flags: %v\n", err)
+		fmt.Println("Exiting...")
+		os.Exit(1)
+	}
+	return context.WithValue(Ctx, k("backup.import.params"), conf)
+}
+
+func runBackupImport(cmd *cobra.Command, args []string) error {
+	params := backupImportCtx.Value(k("backup.import.params")).(*importParams)
+	if params.Archive == "" {
+		return errors.New("Archive is required.")
+	}
+	if params.DBPath == "" {
+		return errors.New("Database path is required.")
+	}
+
+	f, err := os.Open(params.Archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	m, err := archive.Extract(f, params.DBPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Backup %d imported into %s\n", m.BackupID, params.DBPath)
+	fmt.Printf("Engine: %s\tTimestamp: %s\tVersion: %d\tNum.Files: %d\n", m.Engine, formatTimestamp(m.Timestamp), m.BalloonVersion, len(m.Files))
+	fmt.Printf("HistoryDigest: %s\nHyperDigest: %s\n", m.HistoryDigest, m.HyperDigest)
+	return nil
+}
@@ -0,0 +1,272 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/octago/sflags/gen/gpflag"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ed25519"
+
+	"github.com/bbva/qed/consensus"
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/storage/archive"
+	"github.com/bbva/qed/storage/encrypted"
+)
+
+var backupVerifyCmd *cobra.Command = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify the roots of a QED Log backup",
+	Long: `Restores a backup aside, without modifying it, rebuilds the history
+and hyper trees from their leaves, checks the recomputed roots of its
+last balloon version against the stored ones and that version against
+the metadata of the backup.
+
+The backup is either one of the backups of a running server, given by
+its ID, or an archive created with "qed backup export", which is
+verified locally. The roots can also be checked against the signed
+snapshot of the same version, fetched from a snapshot store or read from
+a checkpoint file holding a signed snapshot as served by the store.`,
+	RunE: runBackupVerify,
+}
+
+var backupVerifyCtx context.Context
+
+type verifyParams struct {
+	ID                 uint32 `desc:"QED backup to verify on the server"`
+	Archive            string `desc:"Path of an exported backup archive to verify locally"`
+	SnapshotStoreURL   string `desc:"Snapshot store to fetch the signed snapshot of the backup version from"`
+	Checkpoint         string `desc:"Path of a file with the signed snapshot of the backup version"`
+	PublicKeyPath      string `desc:"Path of the public key to check the snapshot signature with"`
+	EncryptionKeysPath string `desc:"Path of the keys an encrypted archive was encrypted with (defaults to $QED_ENCRYPTION_KEYS)"`
+}
+
+func init() {
+	backupVerifyCtx = configBackupVerify()
+	backupCmd.AddCommand(backupVerifyCmd)
+}
+
+func configBackupVerify() context.Context {
+	conf := &verifyParams{}
+
+	err := gpflag.ParseTo(conf, backupVerifyCmd.PersistentFlags())
+	if err != nil {
+		fmt.Printf("Cannot parse command flags: %v\n", err)
+		fmt.Println("Exiting...")
+		os.Exit(1)
+	}
+	return context.WithValue(Ctx, k("backup.verify.params"), conf)
+}
+
+func runBackupVerify(cmd *cobra.Command, args []string) error {
+	params := backupVerifyCtx.Value(k("backup.verify.params")).(*verifyParams)
+	if (params.ID == 0) == (params.Archive == "") {
+		return errors.New("Either a backup ID or an archive is required.")
+	}
+	if params.SnapshotStoreURL != "" && params.Checkpoint != "" {
+		return errors.New("Snapshot store and checkpoint are mutually exclusive.")
+	}
+
+	var result *protocol.BackupVerification
+	var err error
+	if params.Archive != "" {
+		result, err = verifyArchive(params.Archive, params.EncryptionKeysPath)
+	} else {
+		config := backupCtx.Value(k("backup.config")).(*BackupConfig)
+		result, err = verifyBackup(config, params.ID)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Backup %d\n", result.BackupID)
+	fmt.Printf(" Metadata: %s\n", result.Metadata)
+	fmt.Printf(" Version: %d\n", int64(result.Version))
+	fmt.Printf(" HistoryDigest: %x\n", result.HistoryDigest)
+	fmt.Printf(" HyperDigest: %x\n", result.HyperDigest)
+	if result.Mismatch != "" {
+		return fmt.Errorf("Backup verification failed: %s", result.Mismatch)
+	}
+
+	var signed *protocol.SignedSnapshot
+	switch {
+	case params.SnapshotStoreURL != "":
+		signed, err = fetchSignedSnapshot(params.SnapshotStoreURL, result.Version)
+	case params.Checkpoint != "":
+		signed, err = readSignedSnapshot(params.Checkpoint)
+	}
+	if err != nil {
+		return err
+	}
+	if signed != nil {
+		if err := checkSignedSnapshot(signed, result, params.PublicKeyPath); err != nil {
+			return fmt.Errorf("Backup verification failed: %v", err)
+		}
+		fmt.Printf("Roots match the signed snapshot of version %d\n", signed.Snapshot.Version)
+	}
+
+	fmt.Println("Backup verified!")
+	return nil
+}
+
+func verifyBackup(config *BackupConfig, backupID uint32) (*protocol.BackupVerification, error) {
+
+	// Build request
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/backups/%d/verify", config.Endpoint, backupID), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Api-Key", config.APIKey)
+
+	// Get response
+	client := http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Printf("Request error: %v\n", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Invalid request %v", string(bodyBytes))
+	}
+
+	var result protocol.BackupVerification
+	if err := json.Unmarshal(bodyBytes, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// verifyArchive extracts an archive into a temporary directory and checks
+// the recomputed roots against both the metadata of the backup and the
+// roots recorded in the manifest.
+func verifyArchive(path, keysPath string) (*protocol.BackupVerification, error) {
+	keyring, err := encrypted.LoadKeyringOrEnv(keysPath)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dir, err := ioutil.TempDir("", "qed-backup-verify-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	m, err := archive.Extract(f, dir)
+	if err != nil {
+		return nil, err
+	}
+	result, err := consensus.VerifyDatabase(dir, m.BackupID, m.Metadata, keyring, log.L().Named("verify"))
+	if err != nil {
+		return nil, err
+	}
+	if result.Mismatch == "" &&
+		(hex.EncodeToString(result.HistoryDigest) != m.HistoryDigest || hex.EncodeToString(result.HyperDigest) != m.HyperDigest) {
+		result.Mismatch = "the roots do not match the archive manifest"
+	}
+	return result, nil
+}
+
+func fetchSignedSnapshot(url string, version uint64) (*protocol.SignedSnapshot, error) {
+	resp, err := http.Get(fmt.Sprintf("%s/snapshot?v=%d", url, version))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Cannot get the snapshot of version %d: %s", version, string(bodyBytes))
+	}
+
+	var signed protocol.SignedSnapshot
+	if err := signed.Decode(bodyBytes); err != nil {
+		return nil, err
+	}
+	return &signed, nil
+}
+
+func readSignedSnapshot(path string) (*protocol.SignedSnapshot, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var signed protocol.SignedSnapshot
+	if err := signed.Decode(data); err != nil {
+		return nil, err
+	}
+	return &signed, nil
+}
+
+// checkSignedSnapshot compares the roots of a backup with a signed
+// snapshot of the same version, checking its signature if a public key
+// is given.
+func checkSignedSnapshot(signed *protocol.SignedSnapshot, result *protocol.BackupVerification, publicKeyPath string) error {
+	s := signed.Snapshot
+	if s == nil {
+		return errors.New("the signed snapshot is empty")
+	}
+	if s.Version != result.Version {
+		return fmt.Errorf("the snapshot is for version %d, not %d", s.Version, result.Version)
+	}
+	if !bytes.Equal(s.HistoryDigest, result.HistoryDigest) {
+		return fmt.Errorf("history digest %x does not match the snapshot %x", result.HistoryDigest, s.HistoryDigest)
+	}
+	if !bytes.Equal(s.HyperDigest, result.HyperDigest) {
+		return fmt.Errorf("hyper digest %x does not match the snapshot %x", result.HyperDigest, s.HyperDigest)
+	}
+
+	if publicKeyPath == "" {
+		return nil
+	}
+	publicKey, err := ioutil.ReadFile(publicKeyPath)
+	if err != nil {
+		return err
+	}
+	if len(publicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid public key size %d", len(publicKey))
+	}
+	// servers sign the text representation of the snapshot
+	if !ed25519.Verify(publicKey, []byte(fmt.Sprintf("%v", s)), signed.Signature) {
+		return errors.New("invalid snapshot signature")
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/octago/sflags/gen/gpflag"
+	"github.com/spf13/cobra"
+
+	"github.com/bbva/qed/protocol"
+)
+
+type ClusterConfig struct {
+	// Endpoint [host:port] to ask for QED management APIs.
+	Endpoint string `desc:"QED Log service management endpoint http://ip:port"`
+
+	// ApiKey to query the server endpoint.
+	APIKey string `desc:"Set API Key to talk to QED Log service"`
+}
+
+func defaultClusterConfig() *ClusterConfig {
+	return &ClusterConfig{
+		Endpoint: "http://127.0.0.1:8700",
+		APIKey:   "my-key",
+	}
+}
+
+// clusterNodeParams identifies the target node of a membership operation.
+type clusterNodeParams struct {
+	NodeID string `desc:"Raft node ID of the target server"`
+}
+
+var clusterCmd *cobra.Command = &cobra.Command{
+	Use:               "cluster",
+	Short:             "Manages QED log Raft cluster membership and leadership",
+	TraverseChildren:  true,
+	PersistentPreRunE: runCluster,
+}
+
+var clusterCtx context.Context
+
+func init() {
+	clusterCtx = configCluster()
+	Root.AddCommand(clusterCmd)
+}
+
+func configCluster() context.Context {
+
+	conf := defaultClusterConfig()
+
+	err := gpflag.ParseTo(conf, clusterCmd.PersistentFlags())
+	if err != nil {
+		fmt.Printf("Cannot parse command flags: %v\n", err)
+		fmt.Println("Exiting...")
+		os.Exit(1)
+	}
+	return context.WithValue(Ctx, k("cluster.config"), conf)
+}
+
+func configClusterNode(cmd *cobra.Command, key string) context.Context {
+	conf := &clusterNodeParams{}
+
+	err := gpflag.ParseTo(conf, cmd.PersistentFlags())
+	if err != nil {
+		fmt.Printf("Cannot parse command flags: %v\n", err)
+		fmt.Println("Exiting...")
+		os.Exit(1)
+	}
+	return context.WithValue(Ctx, k(key), conf)
+}
+
+func runCluster(cmd *cobra.Command, args []string) error {
+	var err error
+
+	endpoint, _ := cmd.Flags().GetString("endpoint")
+	err = urlParse(endpoint)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// clusterRequest calls the management API and returns the response body,
+// or the error returned by the server.
+func clusterRequest(config *ClusterConfig, method, path string) ([]byte, error) {
+	return clusterRequestWithBody(config, method, path, nil)
+}
+
+// clusterRequestWithBody is like clusterRequest, sending the given body.
+func clusterRequestWithBody(config *ClusterConfig, method, path string, body []byte) ([]byte, error) {
+
+	// Build request
+	req, err := http.NewRequest(method, config.Endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Api-Key", config.APIKey)
+
+	// Get response
+	client := http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Printf("Request error: %v\n", err)
+		return nil, err
+	}
+
+	var bodyBytes []byte
+	if resp.Body != nil {
+		defer resp.Body.Close()
+		bodyBytes, err = ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode >= 400 {
+		perr := new(protocol.Error)
+		if err := json.Unmarshal(bodyBytes, perr); err == nil && perr.Code != "" {
+			if perr.Code == protocol.ErrCodeNotLeader {
+				return nil, fmt.Errorf("%v: run the command against the leader management endpoint (see 'qed cluster list')", perr)
+			}
+			return nil, perr
+		}
+		return nil, fmt.Errorf("Invalid request %v", string(bodyBytes))
+	}
+
+	return bodyBytes, nil
+}
@@ -0,0 +1,55 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/spf13/cobra"
+)
+
+var clusterDemoteCmd *cobra.Command = &cobra.Command{
+	Use:   "demote",
+	Short: "Turn a QED Log Raft voter into a non-voter",
+	RunE:  runClusterDemote,
+}
+
+var clusterDemoteCtx context.Context
+
+func init() {
+	clusterDemoteCtx = configClusterNode(clusterDemoteCmd, "cluster.demote.params")
+	clusterCmd.AddCommand(clusterDemoteCmd)
+}
+
+func runClusterDemote(cmd *cobra.Command, args []string) error {
+	params := clusterDemoteCtx.Value(k("cluster.demote.params")).(*clusterNodeParams)
+	if params.NodeID == "" {
+		return fmt.Errorf("Node ID is required")
+	}
+
+	config := clusterCtx.Value(k("cluster.config")).(*ClusterConfig)
+
+	_, err := clusterRequest(config, "POST", "/cluster/demote?nodeId="+url.QueryEscape(params.NodeID))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Server %s demoted!\n", params.NodeID)
+	return nil
+}
@@ -0,0 +1,58 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/spf13/cobra"
+)
+
+var clusterLeadershipCmd *cobra.Command = &cobra.Command{
+	Use:   "transfer-leadership",
+	Short: "Transfer the QED Log Raft leadership to another server",
+	Long: `Transfer the QED Log Raft leadership to the given server. If no
+server is given, the most up to date follower is chosen.`,
+	RunE: runClusterLeadership,
+}
+
+var clusterLeadershipCtx context.Context
+
+func init() {
+	clusterLeadershipCtx = configClusterNode(clusterLeadershipCmd, "cluster.leadership.params")
+	clusterCmd.AddCommand(clusterLeadershipCmd)
+}
+
+func runClusterLeadership(cmd *cobra.Command, args []string) error {
+	params := clusterLeadershipCtx.Value(k("cluster.leadership.params")).(*clusterNodeParams)
+
+	config := clusterCtx.Value(k("cluster.config")).(*ClusterConfig)
+
+	path := "/cluster/leadership"
+	if params.NodeID != "" {
+		path += "?nodeId=" + url.QueryEscape(params.NodeID)
+	}
+	_, err := clusterRequest(config, "POST", path)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Leadership transferred!")
+	return nil
+}
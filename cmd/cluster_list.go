@@ -0,0 +1,58 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bbva/qed/protocol"
+)
+
+var clusterListCmd *cobra.Command = &cobra.Command{
+	Use:   "list",
+	Short: "List the QED Log Raft servers",
+	RunE:  runClusterList,
+}
+
+func init() {
+	clusterCmd.AddCommand(clusterListCmd)
+}
+
+func runClusterList(cmd *cobra.Command, args []string) error {
+
+	config := clusterCtx.Value(k("cluster.config")).(*ClusterConfig)
+
+	body, err := clusterRequest(config, "GET", "/cluster/servers")
+	if err != nil {
+		return err
+	}
+
+	var servers []protocol.RaftServer
+	err = json.Unmarshal(body, &servers)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Server list:")
+	for _, s := range servers {
+		fmt.Printf("Id: %s\tRaft: %s\tMgmt: %s\tSuffrage: %s\tState: %s\t \n", s.NodeId, s.RaftAddr, s.MgmtAddr, s.Suffrage, s.State)
+	}
+	return nil
+}
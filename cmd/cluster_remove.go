@@ -0,0 +1,55 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/spf13/cobra"
+)
+
+var clusterRemoveCmd *cobra.Command = &cobra.Command{
+	Use:   "remove",
+	Short: "Remove a server from the QED Log Raft cluster",
+	RunE:  runClusterRemove,
+}
+
+var clusterRemoveCtx context.Context
+
+func init() {
+	clusterRemoveCtx = configClusterNode(clusterRemoveCmd, "cluster.remove.params")
+	clusterCmd.AddCommand(clusterRemoveCmd)
+}
+
+func runClusterRemove(cmd *cobra.Command, args []string) error {
+	params := clusterRemoveCtx.Value(k("cluster.remove.params")).(*clusterNodeParams)
+	if params.NodeID == "" {
+		return fmt.Errorf("Node ID is required")
+	}
+
+	config := clusterCtx.Value(k("cluster.config")).(*ClusterConfig)
+
+	_, err := clusterRequest(config, "DELETE", "/cluster/servers?nodeId="+url.QueryEscape(params.NodeID))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Server %s removed!\n", params.NodeID)
+	return nil
+}
@@ -0,0 +1,133 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/octago/sflags/gen/gpflag"
+	"github.com/spf13/cobra"
+
+	"github.com/bbva/qed/consensus"
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/storage/encrypted"
+	"github.com/bbva/qed/storage/engine"
+)
+
+type DBMigrateConfig struct {
+	// Source database.
+	From string `desc:"Database of the stopped node, as <engine>:<path> or just <path> to detect its engine"`
+
+	// Target database.
+	To string `desc:"Empty directory of the new database, as <engine>:<path>"`
+
+	// Path to the encryption keys.
+	EncryptionKeysPath string `desc:"Path of the keys the database is encrypted with (defaults to $QED_ENCRYPTION_KEYS)"`
+}
+
+var dbMigrateCmd *cobra.Command = &cobra.Command{
+	Use:   "migrate",
+	Short: "Copy the QED log database to another location or storage engine",
+	Long: `Copies every table of the database of a stopped node, including the
+FSM state and the cluster settings, into a new database, and checks that
+the balloon roots of both databases match. The engine is one of rocksdb
+(or rocks), lsm or bplus. Backups and the WAL are not copied.`,
+	Example: "  qed db migrate --from rocks:/var/tmp/qed/db --to lsm:/var/tmp/qed/db-lsm",
+	RunE:    runDBMigrate,
+}
+
+var dbMigrateCtx context.Context
+
+func init() {
+	dbMigrateCtx = configDBMigrate()
+	dbCmd.AddCommand(dbMigrateCmd)
+}
+
+func configDBMigrate() context.Context {
+
+	conf := &DBMigrateConfig{}
+
+	err := gpflag.ParseTo(conf, dbMigrateCmd.PersistentFlags())
+	if err != nil {
+		fmt.Printf("Cannot parse command flags: %v\n", err)
+		fmt.Println("Exiting...")
+		os.Exit(1)
+	}
+	return context.WithValue(Ctx, k("db.migrate.config"), conf)
+}
+
+func runDBMigrate(cmd *cobra.Command, args []string) error {
+
+	params := dbMigrateCtx.Value(k("db.migrate.config")).(*DBMigrateConfig)
+
+	if params.From == "" {
+		return errors.New("Source database is empty.")
+	}
+	if params.To == "" {
+		return errors.New("Target database is empty.")
+	}
+	fromEngine, fromPath := parseDBLocation(params.From)
+	toEngine, toPath := parseDBLocation(params.To)
+	if toEngine == "" {
+		return errors.New("Target database engine is empty.")
+	}
+
+	keyring, err := encrypted.LoadKeyringOrEnv(params.EncryptionKeysPath)
+	if err != nil {
+		return err
+	}
+
+	result, err := consensus.Migrate(&consensus.MigrateOptions{
+		FromEngine: fromEngine,
+		FromPath:   fromPath,
+		ToEngine:   toEngine,
+		ToPath:     toPath,
+		Keyring:    keyring,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, table := range storage.Tables {
+		fmt.Printf("Table %s: %d pairs copied\n", table, result.Pairs[table])
+	}
+	if r := result.Roots; r != nil {
+		fmt.Printf("Roots of version %d match:\n HistoryDigest: %x\n HyperDigest: %x\n", r.Version, r.HistoryDigest, r.HyperDigest)
+	}
+	fmt.Printf("Database migrated to %s!\n", toPath)
+	return nil
+}
+
+// parseDBLocation splits a database location given as <engine>:<path>.
+// If the location does not start with an engine name, it is just a path
+// and the engine is empty.
+func parseDBLocation(location string) (string, string) {
+	parts := strings.SplitN(location, ":", 2)
+	if len(parts) == 2 {
+		switch parts[0] {
+		case "rocks", engine.RocksDB:
+			return engine.RocksDB, parts[1]
+		case engine.LSM, engine.BPlus:
+			return parts[0], parts[1]
+		}
+	}
+	return "", location
+}
@@ -0,0 +1,94 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/octago/sflags/gen/gpflag"
+	"github.com/spf13/cobra"
+
+	"github.com/bbva/qed/storage/encrypted"
+	"github.com/bbva/qed/storage/engine"
+)
+
+type DBRekeyConfig struct {
+	// Path to the database of a stopped node.
+	DBPath string `desc:"Database of the stopped node"`
+
+	// Path to the encryption keys.
+	EncryptionKeysPath string `desc:"Path of the encryption keys (defaults to $QED_ENCRYPTION_KEYS)"`
+}
+
+var dbRekeyCmd *cobra.Command = &cobra.Command{
+	Use:   "rekey",
+	Short: "Re-encrypt the QED log database with the active key",
+	Long: `Re-encrypts every value of the database of a stopped node with the
+active encryption key, the one with the highest id, so the older keys can
+be removed afterwards. A database that is not encrypted yet is encrypted.
+Existing backups keep the keys they were taken with.`,
+	RunE: runDBRekey,
+}
+
+var dbRekeyCtx context.Context
+
+func init() {
+	dbRekeyCtx = configDBRekey()
+	dbCmd.AddCommand(dbRekeyCmd)
+}
+
+func configDBRekey() context.Context {
+
+	conf := &DBRekeyConfig{}
+
+	err := gpflag.ParseTo(conf, dbRekeyCmd.PersistentFlags())
+	if err != nil {
+		fmt.Printf("Cannot parse command flags: %v\n", err)
+		fmt.Println("Exiting...")
+		os.Exit(1)
+	}
+	return context.WithValue(Ctx, k("db.rekey.config"), conf)
+}
+
+func runDBRekey(cmd *cobra.Command, args []string) error {
+
+	params := dbRekeyCtx.Value(k("db.rekey.config")).(*DBRekeyConfig)
+
+	if params.DBPath == "" {
+		return errors.New("Database directory is empty.")
+	}
+	keyring, err := encrypted.LoadKeyringOrEnv(params.EncryptionKeysPath)
+	if err != nil {
+		return err
+	}
+	if keyring == nil {
+		return errors.New("Encryption keys not found.")
+	}
+
+	name := engine.Detect(params.DBPath)
+	if name == "" {
+		return fmt.Errorf("No database found at %s", params.DBPath)
+	}
+	if err := engine.Rekey(name, params.DBPath, keyring); err != nil {
+		return err
+	}
+	fmt.Printf("Database re-encrypted with key %d\n", keyring.Active())
+	return nil
+}
@@ -0,0 +1,52 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bbva/qed/storage/encrypted"
+)
+
+var generateEncryptionKey *cobra.Command = &cobra.Command{
+	Use:   "encryptionkey",
+	Short: "Generate a key to encrypt the database at rest",
+	Long: `Appends a new random key to the qed_encryption.keys file in the output
+directory, creating it if needed. The new key becomes the active one,
+while the previous keys keep decrypting the values written with them.`,
+	RunE: runGenerateEncryptionKey,
+}
+
+func init() {
+	generateCmd.AddCommand(generateEncryptionKey)
+}
+
+func runGenerateEncryptionKey(cmd *cobra.Command, args []string) error {
+	conf := generateCtx.Value(k("generate.config")).(*GenerateConfig)
+
+	path := filepath.Join(conf.Path, "qed_encryption.keys")
+	id, err := encrypted.NewKeyFile(path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("New encryption key %d generated at:\n%v\n", id, path)
+
+	return nil
+}
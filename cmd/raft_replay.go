@@ -0,0 +1,160 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/hashicorp/raft"
+	"github.com/octago/sflags/gen/gpflag"
+	"github.com/spf13/cobra"
+
+	"github.com/bbva/qed/consensus"
+	"github.com/bbva/qed/storage/encrypted"
+)
+
+type RaftReplayConfig struct {
+	// Path to the Raft storage directory of a stopped node.
+	RaftPath string `desc:"Raft storage directory of the stopped node"`
+
+	// Path where a fresh balloon is rebuilt.
+	ScratchPath string `desc:"Empty directory where the balloon is rebuilt"`
+
+	// Path to an existing database to compare with.
+	CheckDBPath string `desc:"Database to compare the replay with, reporting the first mismatch"`
+
+	// Last log index to replay.
+	UntilIndex uint64 `desc:"Last log index to replay (0 replays the whole log)"`
+
+	// Balloon version where the replay stops.
+	UntilVersion int64 `desc:"Balloon version where the replay stops (-1 replays every version)"`
+
+	// Path to the keys the databases are encrypted with.
+	EncryptionKeysPath string `desc:"Path of the keys the databases are encrypted with (defaults to $QED_ENCRYPTION_KEYS)"`
+}
+
+func defaultRaftReplayConfig() *RaftReplayConfig {
+	return &RaftReplayConfig{
+		UntilVersion: -1,
+	}
+}
+
+var raftReplayCmd *cobra.Command = &cobra.Command{
+	Use:   "replay",
+	Short: "Replay a QED log Raft log into a fresh balloon",
+	Long: `Read the Raft log of a stopped node, apply every entry to a fresh
+balloon built in a scratch directory and print the snapshot produced at
+each index. If a database is given, the replay stops at the first version
+whose history digest does not match it.`,
+	RunE: runRaftReplay,
+}
+
+var raftReplayCtx context.Context
+
+func init() {
+	raftReplayCtx = configRaftReplay()
+	raftCmd.AddCommand(raftReplayCmd)
+}
+
+func configRaftReplay() context.Context {
+
+	conf := defaultRaftReplayConfig()
+
+	err := gpflag.ParseTo(conf, raftReplayCmd.PersistentFlags())
+	if err != nil {
+		fmt.Printf("Cannot parse command flags: %v\n", err)
+		fmt.Println("Exiting...")
+		os.Exit(1)
+	}
+	return context.WithValue(Ctx, k("raft.replay.config"), conf)
+}
+
+func runRaftReplay(cmd *cobra.Command, args []string) error {
+
+	params := raftReplayCtx.Value(k("raft.replay.config")).(*RaftReplayConfig)
+
+	if params.RaftPath == "" {
+		return errors.New("Raft directory is empty.")
+	}
+	if params.ScratchPath == "" {
+		return errors.New("Scratch directory is empty.")
+	}
+
+	keyring, err := encrypted.LoadKeyringOrEnv(params.EncryptionKeysPath)
+	if err != nil {
+		return err
+	}
+
+	opts := &consensus.ReplayOptions{
+		RaftLogPath:  params.RaftPath,
+		ScratchPath:  params.ScratchPath,
+		CheckDBPath:  params.CheckDBPath,
+		UntilIndex:   params.UntilIndex,
+		UntilVersion: params.UntilVersion,
+		Keyring:      keyring,
+	}
+
+	result, err := consensus.Replay(opts, printReplayStep)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Replayed log entries %d to %d, balloon version %d\n", result.FirstIndex, result.LastIndex, int64(result.Version)-1)
+	if result.Mismatch != nil {
+		m := result.Mismatch
+		return fmt.Errorf("First mismatch at index %d, version %d: %s", m.Index, m.Version, m.Reason)
+	}
+	if params.CheckDBPath != "" {
+		fmt.Println("No mismatch found!")
+	}
+	return nil
+}
+
+func printReplayStep(step *consensus.ReplayStep) {
+	if step.Type != raft.LogCommand {
+		fmt.Printf("Index: %d\t%s\n", step.Index, logTypeName(step.Type))
+		return
+	}
+	if step.Err != nil {
+		fmt.Printf("Index: %d\t%s\tError: %v\n", step.Index, step.Command, step.Err)
+		return
+	}
+	if len(step.Snapshots) == 0 {
+		fmt.Printf("Index: %d\t%s\n", step.Index, step.Command)
+	}
+	for _, s := range step.Snapshots {
+		fmt.Printf("Index: %d\t%s\tVersion: %d\tEvent: %x\tHistory: %x\tHyper: %x\n", step.Index, step.Command, s.Version, s.EventDigest, s.HistoryDigest, s.HyperDigest)
+	}
+}
+
+func logTypeName(t raft.LogType) string {
+	switch t {
+	case raft.LogCommand:
+		return "command"
+	case raft.LogNoop:
+		return "noop"
+	case raft.LogBarrier:
+		return "barrier"
+	case raft.LogConfiguration:
+		return "configuration"
+	default:
+		return fmt.Sprintf("type(%d)", t)
+	}
+}
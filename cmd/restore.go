@@ -21,11 +21,13 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/octago/sflags/gen/gpflag"
 	"github.com/spf13/cobra"
 
-	"github.com/bbva/qed/rocksdb"
+	"github.com/bbva/qed/consensus"
+	"github.com/bbva/qed/storage/encrypted"
 )
 
 type RestoreConfig struct {
@@ -37,6 +39,18 @@ type RestoreConfig struct {
 
 	// Path to restore a backup.
 	RestorePath string `desc:"Path to restore a backup"`
+
+	// Path to the database of a stopped node, for point-in-time restores.
+	DBPath string `desc:"Database of a stopped node to restore at a given version"`
+
+	// Balloon version to restore.
+	Version int64 `desc:"Balloon version to restore from the node backups and WAL (-1 restores a whole backup)"`
+
+	// WAL TTL of the node.
+	DbWalTtl time.Duration `desc:"WAL TTL the node runs with"`
+
+	// Path to the keys the database is encrypted with.
+	EncryptionKeysPath string `desc:"Path of the keys the database is encrypted with (defaults to $QED_ENCRYPTION_KEYS)"`
 }
 
 func defaultRestoreConfig() *RestoreConfig {
@@ -44,6 +58,7 @@ func defaultRestoreConfig() *RestoreConfig {
 		BackupDir:   "",
 		BackupID:    0,
 		RestorePath: "",
+		Version:     -1,
 	}
 }
 
@@ -52,6 +67,13 @@ var restoreCmd *cobra.Command = &cobra.Command{
 	Short:            "Restore a QED log backup",
 	TraverseChildren: true,
 	RunE:             runRestore,
+	Long: `Restore a whole backup from a backup directory or, given the
+database of a stopped node and a balloon version, restore the database as
+it was at that version: the nearest earlier backup is restored and the
+write batches that follow it in the node WAL are replayed up to the
+version. Only the database is restored: raft log entries not yet applied
+to it are not replayed. Batches of events cannot be split, so the restore
+may stop below the requested version, and the version reached is reported.`,
 }
 
 var restoreCtx context.Context
@@ -78,34 +100,58 @@ func runRestore(cmd *cobra.Command, args []string) error {
 
 	params := restoreCtx.Value(k("restore.config")).(*RestoreConfig)
 
+	if params.Version >= 0 {
+		return runPointInTimeRestore(params)
+	}
 	if params.BackupDir == "" {
 		return errors.New("Backup directory is empty.")
 	}
 	if params.RestorePath == "" {
 		return errors.New("Restore directory is empty.")
 	}
+	return restoreBackup(params)
+}
+
+func runPointInTimeRestore(params *RestoreConfig) error {
+
+	if params.DBPath == "" {
+		return errors.New("Database directory is empty.")
+	}
+	if params.RestorePath == "" {
+		return errors.New("Restore directory is empty.")
+	}
 
-	bo := rocksdb.NewDefaultOptions()
-	be, err := rocksdb.OpenBackupEngine(bo, params.BackupDir)
+	keyring, err := encrypted.LoadKeyringOrEnv(params.EncryptionKeysPath)
 	if err != nil {
 		return err
 	}
 
-	ro := rocksdb.NewRestoreOptions()
-	defer ro.Destroy()
+	result, err := consensus.RestoreToVersion(&consensus.RestoreOptions{
+		DBPath:      params.DBPath,
+		RestorePath: params.RestorePath,
+		Version:     uint64(params.Version),
+		WALTtl:      params.DbWalTtl,
+		Keyring:     keyring,
+	})
+	if err != nil {
+		return err
+	}
 
-	if params.BackupID == 0 {
-		err = be.RestoreDBFromLatestBackup(params.RestorePath, params.RestorePath, ro)
-		if err != nil {
-			return err
-		}
-		fmt.Println("Restore from latest backup completed!")
-	} else {
-		err = be.RestoreDBFromBackup(params.BackupID, params.RestorePath, params.RestorePath, ro)
-		if err != nil {
-			return err
+	if result.BackupID > 0 {
+		fmt.Printf("Restored backup %d until version %d\n", result.BackupID, int64(result.BackupVersion)-1)
+	}
+	fmt.Printf("Replayed %d write batches from the WAL\n", result.Batches)
+	if result.Reached < params.Version {
+		if next := result.NextBatch; next != nil {
+			fmt.Printf("Version %d belongs to the batch of versions %d to %d, which cannot be split\n", params.Version, result.Reached+1, next.NewVersion)
+		} else {
+			fmt.Printf("The WAL ends at version %d\n", result.Reached)
 		}
-		fmt.Printf("Restore from backup %d completed!\n", params.BackupID)
+		fmt.Printf("Requested version %d, reached version %d\n", params.Version, result.Reached)
+	}
+	fmt.Printf("Restore until version %d completed!\n", result.Reached)
+	if r := result.Roots; r != nil {
+		fmt.Printf(" HistoryDigest: %x\n HyperDigest: %x\n", r.HistoryDigest, r.HyperDigest)
 	}
 	return nil
 }
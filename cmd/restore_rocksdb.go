@@ -0,0 +1,54 @@
+//go:build cgo
+// +build cgo
+
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/bbva/qed/rocksdb"
+)
+
+// restoreBackup restores a whole backup with the rocksdb backup engine.
+func restoreBackup(params *RestoreConfig) error {
+
+	bo := rocksdb.NewDefaultOptions()
+	be, err := rocksdb.OpenBackupEngine(bo, params.BackupDir)
+	if err != nil {
+		return err
+	}
+
+	ro := rocksdb.NewRestoreOptions()
+	defer ro.Destroy()
+
+	if params.BackupID == 0 {
+		err = be.RestoreDBFromLatestBackup(params.RestorePath, params.RestorePath, ro)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Restore from latest backup completed!")
+	} else {
+		err = be.RestoreDBFromBackup(params.BackupID, params.RestorePath, params.RestorePath, ro)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Restore from backup %d completed!\n", params.BackupID)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/octago/sflags/gen/gpflag"
+	"github.com/spf13/cobra"
+
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/server"
+	"github.com/bbva/qed/util"
+)
+
+type QueryOnlyConfig struct {
+	// Path to the checkpoint.
+	Checkpoint string `desc:"Checkpoint directory, cut with POST /checkpoints on the management API"`
+}
+
+var serverQueryOnly *cobra.Command = &cobra.Command{
+	Use:   "query-only",
+	Short: "Serves the proofs of a QED log checkpoint",
+	Long: `Opens a checkpoint of the QED log database and serves the /proofs/*
+endpoints of the HTTP API for its frozen balloon version, without joining
+the cluster. The checkpoint is opened read-only, so several servers can
+share it, and events cannot be added. It takes the HTTP and TLS flags of
+the server and the encryption keys of the checkpoint, if any.`,
+	Example: "  qed server query-only --checkpoint /var/tmp/qed0/db/checkpoints/41 --http-addr 127.0.0.1:8900",
+	RunE:    runServerQueryOnly,
+}
+
+var serverQueryOnlyCtx context.Context
+
+func init() {
+	serverQueryOnlyCtx = configServerQueryOnly()
+	serverCmd.AddCommand(serverQueryOnly)
+}
+
+func configServerQueryOnly() context.Context {
+
+	conf := &QueryOnlyConfig{}
+
+	err := gpflag.ParseTo(conf, serverQueryOnly.PersistentFlags())
+	if err != nil {
+		fmt.Printf("Cannot parse command flags: %v\n", err)
+		fmt.Println("Exiting...")
+		os.Exit(1)
+	}
+	return context.WithValue(Ctx, k("server.query-only.config"), conf)
+}
+
+func runServerQueryOnly(cmd *cobra.Command, args []string) error {
+
+	conf := serverCtx.Value(k("server.config")).(*server.Config)
+	params := serverQueryOnlyCtx.Value(k("server.query-only.config")).(*QueryOnlyConfig)
+
+	if params.Checkpoint == "" {
+		return errors.New("Checkpoint is empty.")
+	}
+
+	// create main logger
+	logOpts := &log.LoggerOptions{
+		Name:            "qed",
+		IncludeLocation: true,
+		Level:           log.LevelFromString(conf.Log),
+		Output:          log.DefaultOutput,
+		TimeFormat:      log.DefaultTimeFormat,
+	}
+	log.SetDefault(log.New(logOpts))
+
+	if err := urlParseNoSchemaRequired(conf.HTTPAddr); err != nil {
+		log.L().Fatalf("Wrong parameters: %v", err)
+	}
+
+	srv, err := server.NewQueryOnlyServerWithLogger(conf, params.Checkpoint, log.L().Named("server"))
+	if err != nil {
+		log.L().Fatalf("Can't create QED query-only server: %v", err)
+	}
+
+	if err := srv.Start(); err != nil {
+		log.L().Fatalf("Can't start QED query-only server: %v", err)
+	}
+
+	util.AwaitTermSignal(srv.Stop)
+
+	log.L().Info("Stopping server, about to exit...")
+
+	return nil
+}
@@ -104,5 +104,10 @@ func checkServerParams(conf *server.Config) error {
 		return err
 	}
 
+	err = urlParse(conf.BackupAlertEndpoint...)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
@@ -0,0 +1,371 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package consensus
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ed25519"
+
+	"github.com/bbva/qed/balloon"
+	"github.com/bbva/qed/crypto/hashing"
+	"github.com/bbva/qed/crypto/sign"
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/storage"
+)
+
+const defaultHashingScheme = "sha256"
+
+// hashingSchemes are the hashing functions a balloon can be built with.
+var hashingSchemes = map[string]func() hashing.Hasher{
+	"sha256":  hashing.NewSha256Hasher,
+	"blake2b": hashing.NewBlake2bHasher,
+}
+
+var (
+	// ErrReadOnly is raised when an event is added while the cluster is
+	// in read-only mode.
+	ErrReadOnly = errors.New("Cluster is in read-only mode")
+
+	// ErrUnknownHashing is raised when changing to an unknown hashing scheme.
+	ErrUnknownHashing = errors.New("Unknown hashing scheme")
+
+	// ErrHashingInUse is raised when changing the hashing scheme of a
+	// balloon that already contains events, whose proofs would break.
+	ErrHashingInUse = errors.New("Hashing scheme cannot be changed once events are added")
+
+	// ErrInvalidSigningKey is raised when the given signing key is not a
+	// valid Ed25519 public key.
+	ErrInvalidSigningKey = errors.New("Invalid signing key")
+
+	// ErrSigningKeyNotFound is raised when the private key of a signing
+	// key is not among the local signing keys of the node.
+	ErrSigningKeyNotFound = errors.New("Signing key not found")
+)
+
+// fsmSettings are the cluster-wide settings changed through admin
+// commands. They are persisted in the FSM state table, so every replica
+// switches them at the same log index and keeps them across restarts and
+// snapshots.
+type fsmSettings struct {
+	Index           uint64 // Raft log index of the last admin command applied.
+	SigningKey      []byte // Ed25519 public key of the key used to sign snapshots.
+	HashingScheme   string
+	ReadOnly        bool
+	BackupRetention uint32 // Number of backups to keep, 0 keeps all of them.
+}
+
+func (s *fsmSettings) encode() ([]byte, error) {
+	return encodeMsgPack(s)
+}
+
+func (s *fsmSettings) decode(value []byte) error {
+	return decodeMsgPack(value, s)
+}
+
+func (s *fsmSettings) hashingScheme() string {
+	if s.HashingScheme == "" {
+		return defaultHashingScheme
+	}
+	return s.HashingScheme
+}
+
+func (n *RaftNode) loadSettings() error {
+	settings := new(fsmSettings)
+	kv, err := n.db.Get(storage.FSMStateTable, storage.FSMSettingsTableKey)
+	switch {
+	case err == storage.ErrKeyNotFound:
+	case err != nil:
+		return fmt.Errorf("loading settings failed: %v", err)
+	default:
+		if err := settings.decode(kv.Value); err != nil {
+			return fmt.Errorf("unable to decode settings: %v", err)
+		}
+	}
+	return n.installSettings(settings)
+}
+
+// installSettings makes the given settings effective on this node.
+func (n *RaftNode) installSettings(settings *fsmSettings) error {
+	var signer sign.Signer
+	if len(settings.SigningKey) > 0 {
+		var err error
+		signer, err = n.signingKey(settings.SigningKey)
+		if err != nil {
+			n.log.Errorf("Unable to load signing key %x, snapshots will not be signed: %v", settings.SigningKey, err)
+			signer = unavailableSigner(settings.SigningKey)
+		}
+	}
+
+	hasherF, ok := hashingSchemes[settings.hashingScheme()]
+	if !ok {
+		return ErrUnknownHashing
+	}
+
+	n.settingsLock.Lock()
+	defer n.settingsLock.Unlock()
+
+	if n.balloon != nil && settings.hashingScheme() != n.settings.hashingScheme() {
+		b, err := balloon.NewBalloonWithLogger(n.db, hasherF, n.log.Named("balloon"))
+		if err != nil {
+			return err
+		}
+		n.balloon = b
+	}
+	n.hasherF = hasherF
+	n.signer = signer
+	n.settings = settings
+	return nil
+}
+
+// currentBalloon returns the balloon of the node, which installSettings
+// replaces when the hashing scheme changes.
+func (n *RaftNode) currentBalloon() *balloon.Balloon {
+	n.settingsLock.RLock()
+	defer n.settingsLock.RUnlock()
+	return n.balloon
+}
+
+func (n *RaftNode) currentSettings() *fsmSettings {
+	n.settingsLock.RLock()
+	defer n.settingsLock.RUnlock()
+	return n.settings
+}
+
+// Settings returns the cluster-wide settings applied by this node.
+func (n *RaftNode) Settings() *protocol.ClusterSettings {
+	n.settingsLock.RLock()
+	defer n.settingsLock.RUnlock()
+	return &protocol.ClusterSettings{
+		Index:            n.settings.Index,
+		SigningPublicKey: n.settings.SigningKey,
+		HashingScheme:    n.settings.hashingScheme(),
+		ReadOnly:         n.settings.ReadOnly,
+		BackupRetention:  n.settings.BackupRetention,
+	}
+}
+
+// Signer returns the signer replicated through the cluster settings, or
+// nil if no signing key has been set and each node uses its own key.
+func (n *RaftNode) Signer() sign.Signer {
+	n.settingsLock.RLock()
+	defer n.settingsLock.RUnlock()
+	return n.signer
+}
+
+// SetSigningKey replaces the key every node signs snapshots with by the
+// one with the given public key. Only the public key is replicated: every
+// node must hold the private key among its local signing keys.
+// This must be called from the Leader or it will fail.
+func (n *RaftNode) SetSigningKey(publicKey []byte) error {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return ErrInvalidSigningKey
+	}
+	if _, err := n.signingKey(publicKey); err != nil {
+		return err
+	}
+	return n.proposeAdmin(setSigningKeyCommandType, publicKey)
+}
+
+// signingKey returns a signer with the private key of the given public
+// key, looked up in the signing keys directory of the node, where keys
+// are stored as generated by qed generate signerkeys.
+func (n *RaftNode) signingKey(publicKey []byte) (sign.Signer, error) {
+	if n.signingKeysPath == "" {
+		return nil, ErrSigningKeyNotFound
+	}
+	paths, err := filepath.Glob(filepath.Join(n.signingKeysPath, "*.pub"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		key, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(key, publicKey) {
+			continue
+		}
+		privateKey, err := ioutil.ReadFile(strings.TrimSuffix(path, ".pub"))
+		if err != nil {
+			return nil, err
+		}
+		signer, err := sign.NewEd25519SignerFromKey(privateKey)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(signer.(*sign.Ed25519Signer).PublicKey(), publicKey) {
+			return nil, fmt.Errorf("private key %s does not match its public key", strings.TrimSuffix(path, ".pub"))
+		}
+		return signer, nil
+	}
+	return nil, ErrSigningKeyNotFound
+}
+
+// unavailableSigner stands for a replicated signing key whose private key
+// the node does not hold. It refuses to sign instead of signing with a key
+// the auditors do not expect.
+type unavailableSigner ed25519.PublicKey
+
+func (s unavailableSigner) Sign(message []byte) ([]byte, error) {
+	return nil, ErrSigningKeyNotFound
+}
+
+func (s unavailableSigner) Verify(message, sig []byte) (bool, error) {
+	return ed25519.Verify(ed25519.PublicKey(s), message, sig), nil
+}
+
+// SetHashingScheme changes the hashing function of the balloon. It is
+// only allowed while the balloon is empty.
+// This must be called from the Leader or it will fail.
+func (n *RaftNode) SetHashingScheme(scheme string) error {
+	if _, ok := hashingSchemes[scheme]; !ok {
+		return ErrUnknownHashing
+	}
+	if n.currentBalloon().Version() > 0 {
+		return ErrHashingInUse
+	}
+	return n.proposeAdmin(setHashingCommandType, scheme)
+}
+
+// SetReadOnly enables or disables the read-only mode, in which the
+// cluster keeps serving proofs but rejects new events.
+// This must be called from the Leader or it will fail.
+func (n *RaftNode) SetReadOnly(readOnly bool) error {
+	return n.proposeAdmin(setReadOnlyCommandType, readOnly)
+}
+
+// SetBackupRetention sets how many backups each node keeps. Older backups
+// are deleted after a new one is created. Zero keeps all of them.
+// This must be called from the Leader or it will fail.
+func (n *RaftNode) SetBackupRetention(backups uint32) error {
+	return n.proposeAdmin(setBackupRetentionCommandType, backups)
+}
+
+func (n *RaftNode) proposeAdmin(t commandType, value interface{}) error {
+	if err := n.beginWrite(); err != nil {
+		return err
+	}
+	defer n.endWrite()
+
+	cmd := newCommand(t)
+	if err := cmd.encode(value); err != nil {
+		return err
+	}
+	resp, err := n.propose(cmd)
+	if err != nil {
+		return err
+	}
+	return resp.(*fsmResponse).err
+}
+
+// applyAdmin applies an admin command at the given log index. Invalid
+// commands are rejected by every replica alike, leaving the settings
+// untouched.
+func (n *RaftNode) applyAdmin(cmd *command, index uint64) *fsmResponse {
+	current := n.currentSettings()
+	if current.Index >= index && current.Index != 0 {
+		return &fsmResponse{fmt.Errorf("settings already applied!: %d -> %d", current.Index, index), nil}
+	}
+
+	settings := *current
+	settings.Index = index
+
+	var err error
+	switch cmd.id {
+	case setSigningKeyCommandType:
+		err = cmd.decode(&settings.SigningKey)
+		if err == nil && len(settings.SigningKey) != ed25519.PublicKeySize {
+			return &fsmResponse{ErrInvalidSigningKey, nil}
+		}
+	case setHashingCommandType:
+		err = cmd.decode(&settings.HashingScheme)
+		if err == nil {
+			if _, ok := hashingSchemes[settings.HashingScheme]; !ok {
+				return &fsmResponse{ErrUnknownHashing, nil}
+			}
+			if settings.hashingScheme() != current.hashingScheme() && n.currentBalloon().Version() > 0 {
+				return &fsmResponse{ErrHashingInUse, nil}
+			}
+		}
+	case setReadOnlyCommandType:
+		err = cmd.decode(&settings.ReadOnly)
+	case setBackupRetentionCommandType:
+		err = cmd.decode(&settings.BackupRetention)
+	}
+	if err != nil {
+		n.log.Panicf("Unable to decode command: %v", err)
+	}
+
+	settingsBuff, err := settings.encode()
+	if err != nil {
+		n.log.Panicf("Unable to encode settings: %v", err)
+	}
+	// settings are not tied to a balloon version, so snapshots must
+	// always transfer them.
+	meta := &VersionMetadata{
+		PreviousVersion: n.state.BalloonVersion,
+		NewVersion:      n.state.BalloonVersion,
+		Settings:        true,
+	}
+	metaBytes, err := meta.encode()
+	if err != nil {
+		n.log.Panicf("Unable to encode version metadata: %v", err)
+	}
+	mutations := []*storage.Mutation{
+		storage.NewMutation(storage.FSMStateTable, storage.FSMSettingsTableKey, settingsBuff),
+	}
+	if err := n.db.Mutate(mutations, metaBytes); err != nil {
+		n.log.Panicf("Unable to mutate database: %v", err)
+	}
+
+	if err := n.installSettings(&settings); err != nil {
+		n.log.Panicf("Unable to install settings: %v", err)
+	}
+	n.metrics.AdminCommands.Inc()
+	n.log.Infof("Cluster settings changed on index [%d]", index)
+
+	return &fsmResponse{}
+}
+
+// pruneBackups deletes the oldest backups beyond the retention setting.
+func (n *RaftNode) pruneBackups() error {
+	retention := int(n.currentSettings().BackupRetention)
+	if retention == 0 {
+		return nil
+	}
+	backups := n.db.GetBackupsInfo()
+	if len(backups) <= retention {
+		return nil
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].ID < backups[j].ID
+	})
+	for _, b := range backups[:len(backups)-retention] {
+		n.log.Debugf("Deleting backup %d beyond retention", b.ID)
+		if err := n.db.DeleteBackup(uint32(b.ID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -17,18 +17,43 @@
 package consensus
 
 import (
+	"bytes"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
 
+	"github.com/bbva/qed/balloon"
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/protocol"
 	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/storage/archive"
+	"github.com/bbva/qed/storage/encrypted"
+	"github.com/bbva/qed/storage/engine"
 )
 
+// ErrUnknownBackup is raised when a backup operation targets a backup
+// that does not exist.
+var ErrUnknownBackup = errors.New("Unknown backup")
+
 // Backup function calls store's backup function, passing certain metadata.
-// Previously, it gets balloon version to build this metadata.
+// Previously, it gets balloon version to build this metadata. The oldest
+// backups beyond the cluster retention setting are deleted afterwards.
 func (n *RaftNode) CreateBackup() error {
+	return n.createBackup(true)
+}
+
+// createBackup takes a backup and, if prune is set, deletes the oldest
+// backups beyond the cluster retention setting.
+func (n *RaftNode) createBackup(prune bool) error {
 	n.Lock()
 	defer n.Unlock()
 
-	v := n.balloon.Version()
+	v := n.currentBalloon().Version()
 	metadata := fmt.Sprintf("%d", v-1)
 	err := n.db.Backup(metadata)
 	if err != nil {
@@ -36,7 +61,10 @@ func (n *RaftNode) CreateBackup() error {
 	}
 	n.log.Debugf("Generating backup until version: %d", v-1)
 
-	return nil
+	if !prune {
+		return nil
+	}
+	return n.pruneBackups()
 }
 
 // DeleteBackup function is a passthough to store's equivalent funcion.
@@ -50,3 +78,183 @@ func (n *RaftNode) ListBackups() []*storage.BackupInfo {
 	n.log.Debugf("Retrieving backups information")
 	return n.db.GetBackupsInfo()
 }
+
+// ExportBackup writes the backup identified by backupID to w as a portable
+// archive. The backup is restored into a temporary directory, which is
+// opened to record the balloon version and roots in the manifest, and
+// then archived.
+func (n *RaftNode) ExportBackup(backupID uint32, w io.Writer) error {
+	n.log.Debugf("Exporting backup %d", backupID)
+	info, dir, err := n.restoreBackup(backupID)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	manifest := &archive.Manifest{
+		BackupID:  backupID,
+		Timestamp: info.Timestamp,
+		Metadata:  info.Metadata,
+		Engine:    engine.Detect(dir),
+	}
+	roots, err := readRoots(dir, n.keyring, n.log.Named("export"))
+	if err != nil {
+		return err
+	}
+	if roots != nil {
+		manifest.BalloonVersion = roots.Version
+		manifest.HistoryDigest = hex.EncodeToString(roots.HistoryDigest)
+		manifest.HyperDigest = hex.EncodeToString(roots.HyperDigest)
+	}
+
+	// the stores create their backups directory when opened
+	return archive.Write(w, dir, manifest, "backups")
+}
+
+// VerifyBackup restores the backup identified by backupID into a
+// temporary directory and verifies it with VerifyDatabase.
+func (n *RaftNode) VerifyBackup(backupID uint32) (*protocol.BackupVerification, error) {
+	n.log.Debugf("Verifying backup %d", backupID)
+	info, dir, err := n.restoreBackup(backupID)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	return VerifyDatabase(dir, backupID, info.Metadata, n.keyring, n.log.Named("verify"))
+}
+
+// restoreBackup restores a backup into a new temporary directory, which
+// the caller must remove.
+func (n *RaftNode) restoreBackup(backupID uint32) (*storage.BackupInfo, string, error) {
+	var info *storage.BackupInfo
+	for _, b := range n.db.GetBackupsInfo() {
+		if b.ID == int64(backupID) {
+			info = b
+		}
+	}
+	if info == nil {
+		return nil, "", ErrUnknownBackup
+	}
+
+	dir, err := ioutil.TempDir("", "qed-backup-")
+	if err != nil {
+		return nil, "", err
+	}
+	if err := n.db.RestoreFromBackup(backupID, dir, dir); err != nil {
+		os.RemoveAll(dir)
+		return nil, "", err
+	}
+	return info, dir, nil
+}
+
+// VerifyDatabase rebuilds both trees of the database at path, restored
+// from a backup, from their leaves and checks the recomputed roots of the
+// last balloon version against the stored ones, and that version against
+// the metadata of the backup. The result holds the recomputed roots. The
+// keyring is only required if the database is encrypted.
+func VerifyDatabase(path string, backupID uint32, metadata string, keyring *encrypted.Keyring, logger log.Logger) (*protocol.BackupVerification, error) {
+	name := engine.Detect(path)
+	if name == "" {
+		return nil, fmt.Errorf("no database found at %s", path)
+	}
+	store, err := engine.OpenWithOptions(name, path, engine.Options{Keyring: keyring})
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+
+	b, err := openBalloon(store, logger)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+
+	// the metadata of a backup of an empty balloon is its version minus
+	// one, which wraps around
+	result := &protocol.BackupVerification{
+		BackupID: backupID,
+		Metadata: metadata,
+		Version:  math.MaxUint64,
+	}
+	recomputed, err := b.RecomputeRoots()
+	switch {
+	case err == balloon.ErrVersionOutOfRange:
+	case err != nil:
+		return nil, err
+	default:
+		result.Version = recomputed.Version
+		result.HistoryDigest = recomputed.HistoryDigest
+		result.HyperDigest = recomputed.HyperDigest
+
+		stored, err := b.Roots()
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(stored.HistoryDigest, recomputed.HistoryDigest) {
+			result.Mismatch = fmt.Sprintf("the stored history root %x does not match the root %x rebuilt from its leaves", stored.HistoryDigest, recomputed.HistoryDigest)
+			return result, nil
+		}
+		if !bytes.Equal(stored.HyperDigest, recomputed.HyperDigest) {
+			result.Mismatch = fmt.Sprintf("the stored hyper root %x does not match the root %x rebuilt from its leaves", stored.HyperDigest, recomputed.HyperDigest)
+			return result, nil
+		}
+	}
+
+	expected, err := strconv.ParseUint(metadata, 10, 64)
+	switch {
+	case err != nil:
+		result.Mismatch = fmt.Sprintf("invalid backup metadata %q", metadata)
+	case expected != result.Version:
+		result.Mismatch = fmt.Sprintf("the metadata records version %d but the backup ends at version %d", int64(expected), int64(result.Version))
+	}
+	return result, nil
+}
+
+// readRoots opens the database at path and returns the roots of the last
+// version of its balloon, or nil if the balloon is empty.
+func readRoots(path string, keyring *encrypted.Keyring, logger log.Logger) (*balloon.Roots, error) {
+	name := engine.Detect(path)
+	if name == "" {
+		return nil, fmt.Errorf("no database found at %s", path)
+	}
+	store, err := engine.OpenWithOptions(name, path, engine.Options{Keyring: keyring})
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+	return storeRoots(store, logger)
+}
+
+// storeRoots returns the roots of the last version of the balloon stored
+// in store, or nil if the balloon is empty.
+func storeRoots(store storage.Store, logger log.Logger) (*balloon.Roots, error) {
+	b, err := openBalloon(store, logger)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+
+	roots, err := b.Roots()
+	if err == balloon.ErrVersionOutOfRange {
+		return nil, nil
+	}
+	return roots, err
+}
+
+// openBalloon builds the balloon of an existing database with the hashing
+// scheme stored in its settings.
+func openBalloon(store storage.Store, logger log.Logger) (*balloon.Balloon, error) {
+	settings := new(fsmSettings)
+	kv, err := store.Get(storage.FSMStateTable, storage.FSMSettingsTableKey)
+	if err == nil {
+		err = settings.decode(kv.Value)
+	}
+	if err != nil && err != storage.ErrKeyNotFound {
+		return nil, err
+	}
+	hasherF, ok := hashingSchemes[settings.hashingScheme()]
+	if !ok {
+		return nil, ErrUnknownHashing
+	}
+	return balloon.NewBalloonWithLogger(store, hasherF, logger)
+}
@@ -0,0 +1,271 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package consensus
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/metrics"
+	"github.com/bbva/qed/storage"
+)
+
+// BackupRetentionPolicy tells which backups are kept after a scheduled
+// backup. A backup is kept if it is one of the last ones, or the newest
+// backup of one of the last days or weeks with backups. The rest are
+// deleted. If every field is zero, scheduled backups are pruned with the
+// cluster backup retention instead, like any other backup.
+type BackupRetentionPolicy struct {
+	KeepLast   int // Number of most recent backups kept.
+	KeepDaily  int // Number of days whose newest backup is kept.
+	KeepWeekly int // Number of ISO weeks whose newest backup is kept.
+}
+
+// empty tells whether the policy keeps every backup.
+func (p BackupRetentionPolicy) empty() bool {
+	return p.KeepLast == 0 && p.KeepDaily == 0 && p.KeepWeekly == 0
+}
+
+// expired returns the backups the policy does not keep.
+func (p BackupRetentionPolicy) expired(backups []*storage.BackupInfo) []*storage.BackupInfo {
+	if p.empty() {
+		return nil
+	}
+
+	// newest first
+	sorted := make([]*storage.BackupInfo, len(backups))
+	copy(sorted, backups)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ID > sorted[j].ID
+	})
+
+	days := make(map[string]bool)
+	weeks := make(map[string]bool)
+	var expired []*storage.BackupInfo
+	for i, b := range sorted {
+		keep := i < p.KeepLast
+
+		t := time.Unix(b.Timestamp, 0)
+		day := t.Format("2006-01-02")
+		if !days[day] && len(days) < p.KeepDaily {
+			days[day] = true
+			keep = true
+		}
+		year, w := t.ISOWeek()
+		week := fmt.Sprintf("%d-%d", year, w)
+		if !weeks[week] && len(weeks) < p.KeepWeekly {
+			weeks[week] = true
+			keep = true
+		}
+
+		if !keep {
+			expired = append(expired, b)
+		}
+	}
+	return expired
+}
+
+// BackupSchedulerOptions configures the backups taken periodically by a
+// node.
+type BackupSchedulerOptions struct {
+	Interval  time.Duration         // Time between backups. Ignored if Schedule is set.
+	Schedule  string                // Cron-like schedule of the backups. See Schedule.
+	Node      string                // ID of the node taking the backups. Empty means the leader.
+	Retention BackupRetentionPolicy // Backups kept after each scheduled backup.
+	Alert     func(msg string)      // Optional function called when a scheduled backup fails.
+}
+
+// BackupScheduler takes backups of the node database periodically and
+// prunes the old ones following a retention policy. Every node runs its
+// own scheduler, but only the leader, or the chosen node, takes backups.
+type BackupScheduler struct {
+	node     *RaftNode
+	opts     *BackupSchedulerOptions
+	schedule *Schedule
+	metrics  *backupSchedulerMetrics
+	log      log.Logger
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+// NewBackupScheduler creates a scheduler that takes backups of the given
+// node. It does nothing until started.
+func NewBackupScheduler(node *RaftNode, opts *BackupSchedulerOptions, logger log.Logger) (*BackupScheduler, error) {
+	s := &BackupScheduler{
+		node:    node,
+		opts:    opts,
+		log:     logger,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if opts.Schedule != "" {
+		var err error
+		s.schedule, err = ParseSchedule(opts.Schedule)
+		if err != nil {
+			return nil, err
+		}
+	} else if opts.Interval <= 0 {
+		return nil, errors.New("backup schedule or interval required")
+	}
+	s.metrics = newBackupSchedulerMetrics()
+	return s, nil
+}
+
+// Start runs the scheduler in the background.
+func (s *BackupScheduler) Start() {
+	go s.run()
+}
+
+// Stop stops the scheduler and waits for the backup in progress, if any.
+func (s *BackupScheduler) Stop() {
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	close(s.done)
+	<-s.stopped
+}
+
+func (s *BackupScheduler) run() {
+	defer close(s.stopped)
+	for {
+		now := time.Now()
+		next := now.Add(s.opts.Interval)
+		if s.schedule != nil {
+			next = s.schedule.Next(now)
+			if next.IsZero() {
+				s.log.Warnf("Backup schedule %q never runs", s.opts.Schedule)
+				return
+			}
+		}
+		s.log.Debugf("Next scheduled backup at %v", next)
+
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-s.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+			s.backup()
+		}
+	}
+}
+
+// backup takes a backup and prunes the old ones if this node is in
+// charge of the scheduled backups. A retention policy replaces the
+// cluster backup retention, so that only one of them deletes backups.
+func (s *BackupScheduler) backup() {
+	if s.opts.Node != "" && s.opts.Node != s.node.info.NodeId {
+		return
+	}
+	if s.opts.Node == "" && !s.node.IsLeader() {
+		s.log.Debugf("Skipping scheduled backup: not the leader")
+		return
+	}
+
+	start := time.Now()
+	if err := s.node.createBackup(s.opts.Retention.empty()); err != nil {
+		s.fail(fmt.Sprintf("Node %s is unable to take a scheduled backup: %v", s.node.info.NodeId, err))
+		return
+	}
+	s.metrics.Backups.Inc()
+	s.metrics.LastBackup.SetToCurrentTime()
+	s.log.Infof("Scheduled backup taken in %v", time.Since(start))
+
+	for _, b := range s.opts.Retention.expired(s.node.ListBackups()) {
+		s.log.Debugf("Deleting backup %d beyond the retention policy", b.ID)
+		if err := s.node.DeleteBackup(uint32(b.ID)); err != nil {
+			s.fail(fmt.Sprintf("Node %s is unable to delete backup %d: %v", s.node.info.NodeId, b.ID, err))
+			return
+		}
+		s.metrics.Pruned.Inc()
+	}
+}
+
+func (s *BackupScheduler) fail(msg string) {
+	s.log.Error(msg)
+	s.metrics.Failures.Inc()
+	if s.opts.Alert != nil {
+		s.opts.Alert(msg)
+	}
+}
+
+func (s *BackupScheduler) RegisterMetrics(registry metrics.Registry) {
+	if registry != nil {
+		registry.MustRegister(s.metrics.collectors()...)
+	}
+}
+
+type backupSchedulerMetrics struct {
+	Backups    prometheus.Counter
+	Failures   prometheus.Counter
+	Pruned     prometheus.Counter
+	LastBackup prometheus.Gauge
+}
+
+func newBackupSchedulerMetrics() *backupSchedulerMetrics {
+	return &backupSchedulerMetrics{
+		Backups: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Subsystem: subSystem,
+				Name:      "scheduled_backups",
+				Help:      "Number of scheduled backups taken.",
+			},
+		),
+		Failures: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Subsystem: subSystem,
+				Name:      "scheduled_backup_failures",
+				Help:      "Number of scheduled backups or prunes that failed.",
+			},
+		),
+		Pruned: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Subsystem: subSystem,
+				Name:      "pruned_backups",
+				Help:      "Number of backups deleted by the retention policy.",
+			},
+		),
+		LastBackup: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: subSystem,
+				Name:      "last_scheduled_backup_timestamp_seconds",
+				Help:      "Time of the last successful scheduled backup.",
+			},
+		),
+	}
+}
+
+// collectors satisfies the prom.PrometheusCollector interface.
+func (m *backupSchedulerMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.Backups,
+		m.Failures,
+		m.Pruned,
+		m.LastBackup,
+	}
+}
@@ -0,0 +1,91 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package consensus
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/storage/engine"
+)
+
+func TestBackupRetentionPolicy(t *testing.T) {
+	// two backups a day during three weeks
+	start := time.Date(2019, time.May, 1, 0, 0, 0, 0, time.Local)
+	var backups []*storage.BackupInfo
+	for i := 0; i < 42; i++ {
+		ts := start.Add(time.Duration(i) * 12 * time.Hour)
+		backups = append(backups, &storage.BackupInfo{ID: int64(i + 1), Timestamp: ts.Unix()})
+	}
+
+	kept := func(p BackupRetentionPolicy) []int64 {
+		expired := make(map[int64]bool)
+		for _, b := range p.expired(backups) {
+			expired[b.ID] = true
+		}
+		var ids []int64
+		for _, b := range backups {
+			if !expired[b.ID] {
+				ids = append(ids, b.ID)
+			}
+		}
+		return ids
+	}
+
+	require.Equal(t, 42, len(kept(BackupRetentionPolicy{})), "An empty policy keeps every backup")
+	require.Equal(t, []int64{40, 41, 42}, kept(BackupRetentionPolicy{KeepLast: 3}))
+	require.Equal(t, []int64{38, 40, 42}, kept(BackupRetentionPolicy{KeepDaily: 3}))
+	require.Equal(t, []int64{40, 42}, kept(BackupRetentionPolicy{KeepLast: 1, KeepDaily: 2}))
+
+	// May 1st 2019 is a Wednesday, so the weeks end on May 5th, 12th
+	// and 19th, at the 10th, 24th and 38th backups
+	require.Equal(t, []int64{10, 24, 38, 42}, kept(BackupRetentionPolicy{KeepWeekly: 4}))
+	require.Equal(t, []int64{24, 38, 40, 41, 42}, kept(BackupRetentionPolicy{KeepLast: 2, KeepDaily: 2, KeepWeekly: 3}))
+}
+
+func TestScheduledBackupRetention(t *testing.T) {
+	path := mustTempDir()
+	defer os.RemoveAll(path)
+
+	store, err := engine.Open(engine.BPlus, path, 0)
+	require.NoError(t, err)
+	defer store.Close()
+	node := newTestNode(t, store)
+	node.info = &NodeInfo{NodeId: "node01"}
+	node.settings.BackupRetention = 1
+
+	// the retention policy replaces the cluster backup retention
+	opts := &BackupSchedulerOptions{Interval: time.Hour, Node: "node01", Retention: BackupRetentionPolicy{KeepLast: 3}}
+	s, err := NewBackupScheduler(node, opts, log.L())
+	require.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		s.backup()
+	}
+	require.Equal(t, 3, len(node.ListBackups()))
+
+	// without a policy, the cluster backup retention applies
+	opts = &BackupSchedulerOptions{Interval: time.Hour, Node: "node01"}
+	s, err = NewBackupScheduler(node, opts, log.L())
+	require.NoError(t, err)
+	s.backup()
+	require.Equal(t, 1, len(node.ListBackups()))
+}
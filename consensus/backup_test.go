@@ -17,9 +17,19 @@
 package consensus
 
 import (
+	"fmt"
+	"math"
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/bbva/qed/balloon"
+	"github.com/bbva/qed/crypto/hashing"
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/storage/engine"
+	"github.com/bbva/qed/util"
 )
 
 func TestBackup(t *testing.T) {
@@ -74,3 +84,66 @@ func TestDeleteBackup(t *testing.T) {
 	err = raftNode.DeleteBackup(12345)
 	require.Error(t, err, "Deleting an unknown backup must return an error")
 }
+
+func TestVerifyDatabase(t *testing.T) {
+	path := mustTempDir()
+	defer os.RemoveAll(path)
+
+	_, err := VerifyDatabase(path, 1, "0", nil, log.L())
+	require.Error(t, err, "An empty directory is not a database")
+
+	store, err := engine.Open(engine.BPlus, path, 0)
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	// an empty balloon is backed up with version -1
+	result, err := VerifyDatabase(path, 1, fmt.Sprintf("%d", uint64(math.MaxUint64)), nil, log.L())
+	require.NoError(t, err)
+	require.Empty(t, result.Mismatch)
+
+	store, err = engine.Open(engine.BPlus, path, 0)
+	require.NoError(t, err)
+	b, err := balloon.NewBalloon(store, hashing.NewSha256Hasher)
+	require.NoError(t, err)
+	hasher := hashing.NewSha256Hasher()
+	var snapshot *balloon.Snapshot
+	for i := 0; i < 10; i++ {
+		digests := make([]hashing.Digest, 100)
+		for j := range digests {
+			digests[j] = hasher.Do([]byte(fmt.Sprintf("event %d", i*100+j)))
+		}
+		snapshots, mutations, err := b.AddBulk(digests)
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations, nil))
+		snapshot = snapshots[len(snapshots)-1]
+	}
+	require.NoError(t, store.Close())
+
+	result, err = VerifyDatabase(path, 1, "999", nil, log.L())
+	require.NoError(t, err)
+	require.Empty(t, result.Mismatch)
+	require.Equal(t, snapshot.Version, result.Version)
+	require.Equal(t, snapshot.HistoryDigest, result.HistoryDigest)
+	require.Equal(t, snapshot.HyperDigest, result.HyperDigest)
+
+	result, err = VerifyDatabase(path, 1, "1002", nil, log.L())
+	require.NoError(t, err)
+	require.NotEmpty(t, result.Mismatch, "A backup that ends before its metadata version should not be valid")
+
+	result, err = VerifyDatabase(path, 1, "foo", nil, log.L())
+	require.NoError(t, err)
+	require.NotEmpty(t, result.Mismatch)
+
+	// forge the first history leaf, which only the recomputed root uses
+	store, err = engine.Open(engine.BPlus, path, 0)
+	require.NoError(t, err)
+	leaf := append(util.Uint64AsBytes(0), util.Uint16AsBytes(0)...)
+	forged := hasher.Do([]byte("forged event"))
+	require.NoError(t, store.Mutate([]*storage.Mutation{storage.NewMutation(storage.HistoryTable, leaf, forged)}, nil))
+	require.NoError(t, store.Close())
+
+	result, err = VerifyDatabase(path, 1, "999", nil, log.L())
+	require.NoError(t, err)
+	require.NotEmpty(t, result.Mismatch, "A forged leaf should not go unnoticed")
+	require.NotEqual(t, snapshot.HistoryDigest, result.HistoryDigest)
+}
@@ -0,0 +1,297 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package consensus
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/bbva/qed/balloon"
+	"github.com/bbva/qed/crypto/hashing"
+	"github.com/bbva/qed/log"
+)
+
+// ErrBatcherClosed is raised when an event is added after the group
+// commit batcher has been stopped.
+var ErrBatcherClosed = errors.New("Group commit batcher is closed")
+
+type batchRequest struct {
+	hashes []hashing.Digest
+	respCh chan *batchResult
+}
+
+type batchResult struct {
+	snapshots []*balloon.Snapshot
+	err       error
+}
+
+// batcher merges concurrent add requests into a single raft entry, so
+// their cost (a log append, an fsync and a round trip to the followers)
+// is paid once per batch instead of once per request.
+//
+// A batch is proposed once it reaches maxSize events or once window
+// has passed since its first request. Requests arriving while a batch
+// is being proposed are queued and go into the next one.
+type batcher struct {
+	propose func(hashes []hashing.Digest) ([]*balloon.Snapshot, error)
+	window  time.Duration
+	maxSize int
+
+	requests chan *batchRequest
+	next     *batchRequest // request that did not fit in the previous batch
+	metrics  *batcherMetrics
+	log      log.Logger
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func newBatcher(propose func([]hashing.Digest) ([]*balloon.Snapshot, error), window time.Duration, maxSize int, logger log.Logger) *batcher {
+	b := &batcher{
+		propose:  propose,
+		window:   window,
+		maxSize:  maxSize,
+		requests: make(chan *batchRequest, maxSize),
+		log:      logger,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	b.metrics = newBatcherMetrics(b)
+	go b.run()
+	return b
+}
+
+// add queues the given hashes and waits until the batch containing them
+// is committed. It returns one snapshot per hash.
+func (b *batcher) add(hashes []hashing.Digest) ([]*balloon.Snapshot, error) {
+	req := &batchRequest{
+		hashes: hashes,
+		respCh: make(chan *batchResult, 1),
+	}
+
+	select {
+	case b.requests <- req:
+	case <-b.done:
+		return nil, ErrBatcherClosed
+	}
+
+	select {
+	case res := <-req.respCh:
+		return res.snapshots, res.err
+	case <-b.stopped:
+		// the batch may have been committed right before stopping
+		select {
+		case res := <-req.respCh:
+			return res.snapshots, res.err
+		default:
+			return nil, ErrBatcherClosed
+		}
+	}
+}
+
+// stop waits for the batch in progress and fails the queued requests.
+func (b *batcher) stop() {
+	select {
+	case <-b.done:
+		return
+	default:
+	}
+	close(b.done)
+	<-b.stopped
+}
+
+func (b *batcher) run() {
+	defer close(b.stopped)
+	for {
+		batch, ok := b.collect()
+		if !ok {
+			b.failPending()
+			return
+		}
+		b.commit(batch)
+	}
+}
+
+// collect waits for the first request of a batch and then gathers more
+// requests until the batch is full or the window expires.
+func (b *batcher) collect() ([]*batchRequest, bool) {
+	first := b.next
+	b.next = nil
+	if first == nil {
+		select {
+		case first = <-b.requests:
+		case <-b.done:
+			return nil, false
+		}
+	}
+
+	batch := []*batchRequest{first}
+	size := len(first.hashes)
+
+	var timeout <-chan time.Time
+	if b.window > 0 {
+		timer := time.NewTimer(b.window)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for size < b.maxSize {
+		var req *batchRequest
+		if timeout == nil {
+			// no window: take only the requests already queued
+			select {
+			case req = <-b.requests:
+			default:
+				return batch, true
+			}
+		} else {
+			select {
+			case req = <-b.requests:
+			case <-timeout:
+				return batch, true
+			case <-b.done:
+				return batch, true
+			}
+		}
+		if size+len(req.hashes) > b.maxSize {
+			b.next = req
+			return batch, true
+		}
+		batch = append(batch, req)
+		size += len(req.hashes)
+	}
+	return batch, true
+}
+
+// commit proposes the hashes of every request in the batch as a single
+// command and hands each request its own snapshots.
+func (b *batcher) commit(batch []*batchRequest) {
+	var hashes []hashing.Digest
+	for _, req := range batch {
+		hashes = append(hashes, req.hashes...)
+	}
+
+	b.metrics.Batches.Inc()
+	b.metrics.BatchEvents.Observe(float64(len(hashes)))
+	b.metrics.BatchRequests.Observe(float64(len(batch)))
+
+	snapshots, err := b.propose(hashes)
+	if err == nil && len(snapshots) != len(hashes) {
+		b.log.Errorf("Group commit returned %d snapshots for %d events", len(snapshots), len(hashes))
+		err = errors.New("Unexpected number of snapshots")
+	}
+	if err != nil {
+		b.metrics.FailedBatches.Inc()
+	}
+
+	offset := 0
+	for _, req := range batch {
+		res := &batchResult{err: err}
+		if err == nil {
+			res.snapshots = snapshots[offset : offset+len(req.hashes)]
+		}
+		offset += len(req.hashes)
+		req.respCh <- res
+	}
+}
+
+// failPending rejects the requests that will never be committed.
+func (b *batcher) failPending() {
+	if b.next != nil {
+		b.next.respCh <- &batchResult{err: ErrBatcherClosed}
+		b.next = nil
+	}
+	for {
+		select {
+		case req := <-b.requests:
+			req.respCh <- &batchResult{err: ErrBatcherClosed}
+		default:
+			return
+		}
+	}
+}
+
+type batcherMetrics struct {
+	Batches         prometheus.Counter
+	FailedBatches   prometheus.Counter
+	BatchEvents     prometheus.Histogram
+	BatchRequests   prometheus.Histogram
+	PendingRequests prometheus.GaugeFunc
+}
+
+func newBatcherMetrics(b *batcher) *batcherMetrics {
+	return &batcherMetrics{
+		Batches: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Subsystem: subSystem,
+				Name:      "group_commit_batches",
+				Help:      "Number of batches proposed by the group commit.",
+			},
+		),
+		FailedBatches: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Subsystem: subSystem,
+				Name:      "group_commit_failed_batches",
+				Help:      "Number of batches proposed by the group commit that failed.",
+			},
+		),
+		BatchEvents: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Namespace: namespace,
+				Subsystem: subSystem,
+				Name:      "group_commit_batch_events",
+				Help:      "Number of events per group commit batch.",
+				Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+			},
+		),
+		BatchRequests: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Namespace: namespace,
+				Subsystem: subSystem,
+				Name:      "group_commit_batch_requests",
+				Help:      "Number of add requests merged per group commit batch.",
+				Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+			},
+		),
+		PendingRequests: prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: subSystem,
+				Name:      "group_commit_pending_requests",
+				Help:      "Number of add requests waiting for the next group commit batch.",
+			},
+			func() float64 {
+				return float64(len(b.requests))
+			},
+		),
+	}
+}
+
+// collectors satisfies the prom.PrometheusCollector interface.
+func (m *batcherMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.Batches,
+		m.FailedBatches,
+		m.BatchEvents,
+		m.BatchRequests,
+		m.PendingRequests,
+	}
+}
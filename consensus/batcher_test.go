@@ -0,0 +1,139 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package consensus
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/bbva/qed/balloon"
+	"github.com/bbva/qed/crypto/hashing"
+	"github.com/bbva/qed/log"
+)
+
+// fakePropose assigns a consecutive version to every hash and records
+// the size of each proposed batch.
+type fakePropose struct {
+	sync.Mutex
+	version uint64
+	batches []int
+	delay   time.Duration
+	err     error
+}
+
+func (f *fakePropose) propose(hashes []hashing.Digest) ([]*balloon.Snapshot, error) {
+	time.Sleep(f.delay)
+	f.Lock()
+	defer f.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.batches = append(f.batches, len(hashes))
+	snapshots := make([]*balloon.Snapshot, len(hashes))
+	for i, h := range hashes {
+		snapshots[i] = &balloon.Snapshot{EventDigest: h, Version: f.version}
+		f.version++
+	}
+	return snapshots, nil
+}
+
+func TestBatcherMergesConcurrentAdds(t *testing.T) {
+	fake := &fakePropose{}
+	b := newBatcher(fake.propose, 50*time.Millisecond, 1024, log.L())
+	defer b.stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			hashes := []hashing.Digest{{byte(i), 0}, {byte(i), 1}}
+			snapshots, err := b.add(hashes)
+			require.NoError(t, err)
+			require.Len(t, snapshots, 2)
+			// each caller gets back its own snapshots, in order
+			require.Equal(t, hashes[0], snapshots[0].EventDigest)
+			require.Equal(t, hashes[1], snapshots[1].EventDigest)
+			require.Equal(t, snapshots[0].Version+1, snapshots[1].Version)
+		}(i)
+	}
+	wg.Wait()
+
+	require.Equal(t, []int{20}, fake.batches, "All adds should be committed in a single batch")
+}
+
+func TestBatcherMaxSize(t *testing.T) {
+	fake := &fakePropose{}
+	b := newBatcher(fake.propose, 50*time.Millisecond, 4, log.L())
+	defer b.stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			snapshots, err := b.add([]hashing.Digest{{byte(i)}, {byte(i)}, {byte(i)}})
+			require.NoError(t, err)
+			require.Len(t, snapshots, 3)
+		}(i)
+	}
+	wg.Wait()
+
+	// a request that overflows the batch is carried over to the next one
+	require.Len(t, fake.batches, 6)
+	for _, size := range fake.batches {
+		require.Equal(t, 3, size)
+	}
+
+	// requests larger than the limit are committed on their own
+	snapshots, err := b.add(make([]hashing.Digest, 10))
+	require.NoError(t, err)
+	require.Len(t, snapshots, 10)
+}
+
+func TestBatcherError(t *testing.T) {
+	fake := &fakePropose{err: errors.New("not leader")}
+	b := newBatcher(fake.propose, 0, 1024, log.L())
+	defer b.stop()
+
+	_, err := b.add([]hashing.Digest{{0x0}})
+	require.Equal(t, fake.err, err)
+}
+
+func TestBatcherStop(t *testing.T) {
+	fake := &fakePropose{delay: 50 * time.Millisecond}
+	b := newBatcher(fake.propose, 0, 1024, log.L())
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// committed before stopping
+		_, err := b.add([]hashing.Digest{{0x0}})
+		require.NoError(t, err)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	b.stop()
+	wg.Wait()
+
+	_, err := b.add([]hashing.Digest{{0x1}})
+	require.Equal(t, ErrBatcherClosed, err)
+}
@@ -0,0 +1,227 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package consensus
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/bbva/qed/balloon/hyper"
+	"github.com/bbva/qed/crypto/hashing"
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/storage/encrypted"
+	"github.com/bbva/qed/util"
+)
+
+// changeFeedPollInterval is how often the WAL is checked for new batches
+// while following the change feed.
+const changeFeedPollInterval = 100 * time.Millisecond
+
+// Changes sends to fn, in order, every write batch committed to the
+// balloon database after the WAL sequence number since, along with its
+// version metadata. Once the batches committed so far are sent, it keeps
+// waiting for new ones until stop is closed or fn returns an error, so a
+// closed stop channel just reads what is already in the WAL.
+// Consumers resume the feed passing the sequence number of the last batch
+// they processed. Only the batches still in the WAL can be read, which
+// depends on the WAL TTL of the node.
+func (n *RaftNode) Changes(since uint64, stop <-chan struct{}, fn func(*protocol.Change) error) error {
+	if last := n.db.LastWALSequenceNumber(); since > last {
+		return protocol.NewError(protocol.ErrCodeInvalidRange, "sequence number %d is beyond the last one in the WAL (%d)", since, last)
+	}
+
+	for {
+		last := n.db.LastWALSequenceNumber()
+		if last > since {
+			if err := n.readChanges(since, last, fn); err != nil {
+				return err
+			}
+			since = last
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(changeFeedPollInterval):
+		}
+	}
+}
+
+// readChanges sends to fn the batches in the WAL between the sequence
+// numbers since (exclusive) and until (inclusive). It fails with an
+// invalid range error if the WAL no longer holds the batches after since.
+func (n *RaftNode) readChanges(since, until uint64, fn func(*protocol.Change) error) error {
+	r, w := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := n.db.FetchSnapshot(nopCloser{w}, since, until, func([]byte) (bool, error) {
+			return true, nil
+		})
+		w.CloseWithError(err)
+		done <- err
+	}()
+
+	err := func() error {
+		for {
+			chunk, err := storage.ReadChunk(r)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			change, err := n.toChange(chunk)
+			if err != nil {
+				return err
+			}
+			if err := fn(change); err != nil {
+				return err
+			}
+		}
+	}()
+	// unblock the writer if we stopped reading halfway
+	r.CloseWithError(io.ErrClosedPipe)
+	if fetchErr := <-done; err == nil && fetchErr != io.ErrClosedPipe {
+		err = fetchErr
+	}
+	if err == storage.ErrWALPurged {
+		return protocol.NewError(protocol.ErrCodeInvalidRange, "the batches after sequence number %d have been purged from the WAL", since)
+	}
+	return err
+}
+
+// feedTables are the tables sent by the change feed, which only holds
+// balloon data. The FSM state and the cluster settings, which hold the
+// signing key, never leave the node.
+var feedTables = map[storage.Table]bool{
+	storage.HyperTable:      true,
+	storage.HyperCacheTable: true,
+	storage.HistoryTable:    true,
+}
+
+// toChange decodes a write batch fetched from the WAL, decrypting the
+// values of the balloon tables if the database is encrypted at rest, and
+// dropping the mutations of any other table.
+func (n *RaftNode) toChange(data []byte) (*protocol.Change, error) {
+	batch, err := storage.DecodeWriteBatch(data)
+	if err != nil {
+		return nil, err
+	}
+
+	change := &protocol.Change{
+		Seq:       batch.Seq,
+		Mutations: make([]*protocol.ChangeMutation, 0, len(batch.Mutations)),
+	}
+	var historyMutations, hyperMutations []*storage.Mutation
+	for _, m := range batch.Mutations {
+		if !feedTables[m.Table] {
+			continue
+		}
+		if es, ok := n.db.(*encrypted.EncryptedStore); ok {
+			if m, err = es.DecryptMutation(m); err != nil {
+				return nil, err
+			}
+		}
+		switch m.Table {
+		case storage.HistoryTable:
+			historyMutations = append(historyMutations, m)
+		case storage.HyperTable:
+			hyperMutations = append(hyperMutations, m)
+		}
+		change.Mutations = append(change.Mutations, &protocol.ChangeMutation{
+			Table: m.Table.String(),
+			Key:   m.Key,
+			Value: m.Value,
+		})
+	}
+
+	if len(batch.Metadata) > 0 {
+		meta := new(VersionMetadata)
+		if err := meta.decode(batch.Metadata); err != nil {
+			return nil, err
+		}
+		change.Metadata = &protocol.ChangeMetadata{
+			PreviousVersion: meta.PreviousVersion,
+			NewVersion:      meta.NewVersion,
+			Settings:        meta.Settings,
+		}
+		change.Metadata.EventDigests, err = n.eventDigests(historyMutations, hyperMutations)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return change, nil
+}
+
+// eventDigests returns the digests of the events added by a write batch,
+// in order. The history leaves written by the batch tell the versions
+// added and hold a salted hash of their event digests, which are the keys
+// of the hyper leaves written along.
+func (n *RaftNode) eventDigests(historyMutations, hyperMutations []*storage.Mutation) ([]hashing.Digest, error) {
+	n.settingsLock.RLock()
+	hasher := n.hasherF()
+	n.settingsLock.RUnlock()
+
+	nodeSize := int(hasher.Len() / 8)
+	byVersion := make(map[uint64]hashing.Digest)
+	var leaves []hashing.Digest
+	for _, m := range hyperMutations {
+		keys, values := hyper.ParseBatchLeaves(nodeSize, m.Value)
+		for i, key := range keys {
+			// values are versions padded to the size of the keys
+			byVersion[util.BytesAsUint64(values[i][len(values[i])-8:])] = key
+			leaves = append(leaves, key)
+		}
+	}
+
+	var digests []hashing.Digest
+	for _, m := range historyMutations {
+		// history leaves are the nodes at height zero, keyed by their
+		// version and height
+		if len(m.Key) != 10 || util.BytesAsUint16(m.Key[8:]) != 0 {
+			continue
+		}
+		digest := byVersion[util.BytesAsUint64(m.Key[:8])]
+		if digest == nil || !bytes.Equal(hasher.Salted(m.Key, digest), m.Value) {
+			// an event added twice keeps a single hyper leaf, with
+			// one of its versions
+			digest = nil
+			for _, leaf := range leaves {
+				if bytes.Equal(hasher.Salted(m.Key, leaf), m.Value) {
+					digest = leaf
+					break
+				}
+			}
+		}
+		if digest == nil {
+			return nil, fmt.Errorf("no event digest found for version %d", util.BytesAsUint64(m.Key[:8]))
+		}
+		digests = append(digests, digest)
+	}
+	return digests, nil
+}
+
+// nopCloser keeps FetchSnapshot from closing the pipe, so its error, if
+// any, reaches the reader instead of a plain EOF.
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
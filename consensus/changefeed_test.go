@@ -0,0 +1,140 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package consensus
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/bbva/qed/crypto/hashing"
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/storage/bplus"
+	"github.com/bbva/qed/storage/encrypted"
+	"github.com/bbva/qed/storage/engine"
+)
+
+func TestChanges(t *testing.T) {
+	path := mustTempDir()
+	defer os.RemoveAll(path)
+
+	keyring, err := encrypted.ParseKeyring("1:000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	require.NoError(t, err)
+	store, err := engine.OpenWithOptions(engine.LSM, path, engine.Options{Keyring: keyring})
+	require.NoError(t, err)
+	defer store.Close()
+
+	snapshots := addBulks(t, store, 3, 10)
+	node := newTestNode(t, store)
+
+	closed := make(chan struct{})
+	close(closed)
+	var changes []*protocol.Change
+	require.NoError(t, node.Changes(0, closed, func(c *protocol.Change) error {
+		changes = append(changes, c)
+		return nil
+	}))
+
+	// the encryption marker is written without metadata
+	require.Len(t, changes, 4)
+	require.Nil(t, changes[0].Metadata)
+	for i, c := range changes[1:] {
+		require.True(t, c.Seq > changes[i].Seq, "Sequence numbers must increase")
+		require.Equal(t, uint64(i*10+9), c.Metadata.NewVersion)
+		require.Len(t, c.Metadata.EventDigests, 10)
+		for j, digest := range c.Metadata.EventDigests {
+			require.Equal(t, snapshots[i*10+j].EventDigest, digest)
+		}
+	}
+
+	// only balloon values are sent, decrypted
+	for _, m := range changes[len(changes)-1].Mutations {
+		require.NotEqual(t, storage.FSMStateTable.String(), m.Table)
+		if m.Table == storage.HistoryTable.String() {
+			stored, err := store.Get(storage.HistoryTable, m.Key)
+			require.NoError(t, err)
+			require.Equal(t, stored.Value, m.Value)
+		}
+	}
+
+	// resume after the second bulk and follow new batches
+	stop := make(chan struct{})
+	received := make(chan *protocol.Change, 10)
+	done := make(chan error)
+	go func() {
+		done <- node.Changes(changes[2].Seq, stop, func(c *protocol.Change) error {
+			received <- c
+			return nil
+		})
+	}()
+	require.Equal(t, changes[3].Seq, (<-received).Seq)
+
+	addBulks(t, store, 1, 10)
+	select {
+	case c := <-received:
+		require.Equal(t, uint64(29), c.Metadata.PreviousVersion)
+		require.Equal(t, uint64(39), c.Metadata.NewVersion)
+	case <-time.After(5 * time.Second):
+		t.Fatal("New batches should be sent while following the feed")
+	}
+
+	// events added twice keep a single hyper leaf
+	first := snapshots[0].EventDigest
+	added := hashing.NewSha256Hasher().Do([]byte("added twice"))
+	digests := []hashing.Digest{added, first, added}
+	node = newTestNode(t, store)
+	node.applyAdd(digests, &fsmState{node.state.Index + 1, node.balloon.Version() + 2})
+	select {
+	case c := <-received:
+		require.Equal(t, digests, c.Metadata.EventDigests)
+	case <-time.After(5 * time.Second):
+		t.Fatal("New batches should be sent while following the feed")
+	}
+	close(stop)
+	require.NoError(t, <-done)
+
+	// sequence numbers beyond the WAL are rejected
+	err = node.Changes(store.LastWALSequenceNumber()+1, closed, func(*protocol.Change) error { return nil })
+	require.Error(t, err)
+}
+
+func TestChangesPurgedWAL(t *testing.T) {
+	store := bplus.NewBPlusTreeStore()
+	defer store.Close()
+	addBulks(t, store, 3, 10)
+	var buf bytes.Buffer
+	require.NoError(t, store.Dump(&buf))
+
+	// a store loaded from a dump has no WAL before it
+	path := mustTempDir()
+	defer os.RemoveAll(path)
+	restored, err := bplus.OpenBPlusTreeStore(path)
+	require.NoError(t, err)
+	defer restored.Close()
+	require.NoError(t, restored.Load(&buf))
+	node := newTestNode(t, restored)
+
+	closed := make(chan struct{})
+	close(closed)
+	err = node.Changes(0, closed, func(*protocol.Change) error { return nil })
+	require.Error(t, err)
+	require.Equal(t, protocol.ErrCodeInvalidRange, err.(*protocol.Error).Code)
+}
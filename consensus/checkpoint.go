@@ -0,0 +1,213 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package consensus
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/bbva/qed/balloon"
+	"github.com/bbva/qed/crypto/hashing"
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/storage/encrypted"
+	"github.com/bbva/qed/storage/engine"
+)
+
+// ErrEmptyBalloon is raised when a checkpoint holds no events, so there
+// is no version to serve.
+var ErrEmptyBalloon = errors.New("The balloon is empty")
+
+// CreateCheckpoint cuts a consistent checkpoint of the database of the
+// node into <checkpoints path>/<version>, so it can be served by a
+// query-only server. With a negative version, the checkpoint holds the
+// last balloon version of the node. Earlier versions are rebuilt with the
+// nearest backup and the WAL of the node, as RestoreToVersion does, and
+// refused if that does not reach exactly the given version, e.g. when the
+// version falls in the middle of a bulk or the WAL has been purged.
+// Checkpoints are not managed by the node: delete them once they are no
+// longer needed.
+func (n *RaftNode) CreateCheckpoint(version int64) (*protocol.Checkpoint, error) {
+	n.Lock()
+	defer n.Unlock()
+
+	if n.checkpointsPath == "" {
+		return nil, errors.New("No checkpoints path configured")
+	}
+	if err := os.MkdirAll(n.checkpointsPath, 0755); err != nil {
+		return nil, err
+	}
+
+	// the version is read from the checkpoint itself, as events keep
+	// being applied while it is cut
+	tmp := filepath.Join(n.checkpointsPath, "tmp")
+	if err := os.RemoveAll(tmp); err != nil {
+		return nil, err
+	}
+	if err := n.db.Checkpoint(tmp); err != nil {
+		return nil, err
+	}
+	roots, err := readRoots(tmp, n.keyring, n.log.Named("checkpoint"))
+	if err == nil && roots == nil {
+		err = ErrEmptyBalloon
+	}
+	if err == nil && version > int64(roots.Version) {
+		err = protocol.NewError(protocol.ErrCodeVersionOutOfRange, "version %d is beyond the last version %d", version, roots.Version)
+	}
+	if err == nil && version >= 0 && version < int64(roots.Version) {
+		roots, err = n.rebuildCheckpoint(tmp, uint64(version))
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+		return nil, err
+	}
+
+	dir := filepath.Join(n.checkpointsPath, strconv.FormatUint(roots.Version, 10))
+	if _, err := os.Stat(dir); err == nil {
+		os.RemoveAll(tmp)
+		return nil, protocol.NewError(protocol.ErrCodeConflict, "a checkpoint of version %d already exists", roots.Version)
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		os.RemoveAll(tmp)
+		return nil, err
+	}
+	n.log.Infof("Checkpoint of version %d created at %s", roots.Version, dir)
+
+	return &protocol.Checkpoint{
+		Path:          dir,
+		Version:       roots.Version,
+		HistoryDigest: roots.HistoryDigest,
+		HyperDigest:   roots.HyperDigest,
+	}, nil
+}
+
+// rebuildCheckpoint replaces the checkpoint at path, cut at a later
+// version, with the database of the node restored at the given version.
+func (n *RaftNode) rebuildCheckpoint(path string, version uint64) (*balloon.Roots, error) {
+	name := engine.Detect(path)
+	if err := os.RemoveAll(path); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	opts := &RestoreOptions{RestorePath: path, Version: version, Keyring: n.keyring}
+	result, err := restoreToVersion(n.db, name, opts, n.log.Named("checkpoint"))
+	if err == storage.ErrWALPurged {
+		err = protocol.NewError(protocol.ErrCodeBadRequest, "version %d cannot be rebuilt: %v", version, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if result.Reached != int64(version) {
+		return nil, protocol.NewError(protocol.ErrCodeBadRequest, "version %d cannot be rebuilt, the nearest one is %d", version, result.Reached)
+	}
+	return result.Roots, nil
+}
+
+// QueryOnlyNode serves the proofs of the balloon frozen in a checkpoint.
+// It runs no Raft node, so the balloon never changes.
+type QueryOnlyNode struct {
+	db      storage.ManagedStore
+	balloon *balloon.Balloon
+}
+
+// OpenQueryOnlyNode opens the checkpoint at path, cut with
+// RaftNode.CreateCheckpoint, to serve its proofs.
+func OpenQueryOnlyNode(path string, keyring *encrypted.Keyring, logger log.Logger) (*QueryOnlyNode, error) {
+	name := engine.Detect(path)
+	if name == "" {
+		return nil, fmt.Errorf("no database found at %s", path)
+	}
+	store, err := engine.OpenWithOptions(name, path, engine.Options{Keyring: keyring, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	b, err := openBalloon(store, logger)
+	if err == nil && b.Version() == 0 {
+		err = ErrEmptyBalloon
+	}
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	return &QueryOnlyNode{db: store, balloon: b}, nil
+}
+
+// Version returns the last balloon version of the checkpoint.
+func (n *QueryOnlyNode) Version() uint64 {
+	return n.balloon.Version() - 1
+}
+
+// Close closes the balloon and the database of the checkpoint.
+func (n *QueryOnlyNode) Close() error {
+	n.balloon.Close()
+	return n.db.Close()
+}
+
+// QueryDigestMembershipConsistency acts as a passthrough when an event digest is given to
+// request a membership proof against a certain balloon version.
+func (n *QueryOnlyNode) QueryDigestMembershipConsistency(keyDigest hashing.Digest, version uint64) (*balloon.MembershipProof, error) {
+	return n.balloon.QueryDigestMembershipConsistency(keyDigest, version)
+}
+
+// QueryMembershipConsistency acts as a passthrough when an event is given to request a
+// membership proof against a certain balloon version.
+func (n *QueryOnlyNode) QueryMembershipConsistency(event []byte, version uint64) (*balloon.MembershipProof, error) {
+	return n.balloon.QueryMembershipConsistency(event, version)
+}
+
+// QueryDigestMembership acts as a passthrough when an event digest is given to request a
+// membership proof against the version of the checkpoint.
+func (n *QueryOnlyNode) QueryDigestMembership(keyDigest hashing.Digest) (*balloon.MembershipProof, error) {
+	return n.balloon.QueryDigestMembership(keyDigest)
+}
+
+// QueryMembership acts as a passthrough when an event is given to request a membership proof
+// against the version of the checkpoint.
+func (n *QueryOnlyNode) QueryMembership(event []byte) (*balloon.MembershipProof, error) {
+	return n.balloon.QueryMembership(event)
+}
+
+// QueryConsistency acts as a passthrough when requesting an incremental proof.
+func (n *QueryOnlyNode) QueryConsistency(start, end uint64) (*balloon.IncrementalProof, error) {
+	return n.balloon.QueryConsistency(start, end)
+}
+
+// WaitForConsistency checks that the frozen balloon satisfies the given
+// consistency level. Stale reads are always served, bounded reads only
+// if the checkpoint holds minVersion, and linearizable reads never, as
+// the checkpoint does not follow the cluster.
+func (n *QueryOnlyNode) WaitForConsistency(level protocol.ReadConsistency, minVersion uint64) error {
+	switch level {
+	case "", protocol.ReadStale:
+		return nil
+	case protocol.ReadBounded:
+		if n.balloon.Version() <= minVersion {
+			return ErrStaleRead
+		}
+		return nil
+	case protocol.ReadLinearizable:
+		return ErrStaleRead
+	default:
+		return ErrUnknownConsistency
+	}
+}
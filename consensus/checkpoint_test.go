@@ -0,0 +1,107 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package consensus
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/storage/engine"
+)
+
+func TestCheckpoint(t *testing.T) {
+	path := mustTempDir()
+	defer os.RemoveAll(path)
+	dbPath := filepath.Join(path, "db")
+
+	store, err := engine.Open(engine.BPlus, dbPath, 0)
+	require.NoError(t, err)
+	defer store.Close()
+	node := &RaftNode{db: store, log: log.L(), checkpointsPath: filepath.Join(dbPath, "checkpoints")}
+
+	// an empty balloon has no version to serve
+	_, err = node.CreateCheckpoint(-1)
+	require.Equal(t, ErrEmptyBalloon, err)
+
+	snapshots := addBulks(t, store, 5, 10)
+	checkpoint, err := node.CreateCheckpoint(-1)
+	require.NoError(t, err)
+	last := snapshots[len(snapshots)-1]
+	require.Equal(t, last.Version, checkpoint.Version)
+	require.Equal(t, last.HyperDigest, checkpoint.HyperDigest)
+	require.Equal(t, filepath.Join(dbPath, "checkpoints", "49"), checkpoint.Path)
+
+	_, err = node.CreateCheckpoint(-1)
+	require.Error(t, err, "The checkpoint of the same version already exists")
+
+	// the checkpoint is frozen at its version
+	addBulks(t, store, 1, 10)
+	query, err := OpenQueryOnlyNode(checkpoint.Path, nil, log.L())
+	require.NoError(t, err)
+	defer query.Close()
+	require.Equal(t, uint64(49), query.Version())
+
+	proof, err := query.QueryDigestMembership(snapshots[20].EventDigest)
+	require.NoError(t, err)
+	require.True(t, proof.Exists)
+	require.Equal(t, uint64(49), proof.CurrentVersion)
+
+	_, err = query.QueryConsistency(10, 49)
+	require.NoError(t, err)
+	_, err = query.QueryConsistency(10, 55)
+	require.Error(t, err, "Versions beyond the checkpoint cannot be proved")
+
+	require.NoError(t, query.WaitForConsistency(protocol.ReadStale, 0))
+	require.NoError(t, query.WaitForConsistency(protocol.ReadBounded, 49))
+	require.Equal(t, ErrStaleRead, query.WaitForConsistency(protocol.ReadBounded, 50))
+	require.Equal(t, ErrStaleRead, query.WaitForConsistency(protocol.ReadLinearizable, 0))
+
+	// several servers can share the same checkpoint
+	other, err := OpenQueryOnlyNode(checkpoint.Path, nil, log.L())
+	require.NoError(t, err)
+	require.Equal(t, uint64(49), other.Version())
+	require.NoError(t, other.Close())
+	proof, err = query.QueryDigestMembership(snapshots[30].EventDigest)
+	require.NoError(t, err)
+	require.True(t, proof.Exists)
+
+	// earlier versions are rebuilt from the WAL
+	previous, err := node.CreateCheckpoint(29)
+	require.NoError(t, err)
+	require.Equal(t, uint64(29), previous.Version)
+	require.Equal(t, snapshots[29].HistoryDigest, previous.HistoryDigest)
+	require.Equal(t, snapshots[29].HyperDigest, previous.HyperDigest)
+	require.Equal(t, filepath.Join(dbPath, "checkpoints", "29"), previous.Path)
+	rebuilt, err := OpenQueryOnlyNode(previous.Path, nil, log.L())
+	require.NoError(t, err)
+	require.Equal(t, uint64(29), rebuilt.Version())
+	require.NoError(t, rebuilt.Close())
+
+	_, err = node.CreateCheckpoint(25)
+	require.Error(t, err, "Version 25 is in the middle of a bulk")
+	require.Equal(t, protocol.ErrCodeBadRequest, err.(*protocol.Error).Code)
+	_, err = node.CreateCheckpoint(60)
+	require.Error(t, err, "Version 60 has not been reached")
+	require.Equal(t, protocol.ErrCodeVersionOutOfRange, err.(*protocol.Error).Code)
+	_, err = os.Stat(filepath.Join(dbPath, "checkpoints", "tmp"))
+	require.True(t, os.IsNotExist(err), "Refused checkpoints must be cleaned up")
+}
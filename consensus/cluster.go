@@ -31,11 +31,13 @@ import (
 
 	"github.com/bbva/qed/balloon"
 	"github.com/bbva/qed/crypto/hashing"
+	"github.com/bbva/qed/crypto/sign"
 	"github.com/bbva/qed/crypto/tlsutil"
 	"github.com/bbva/qed/log"
 	"github.com/bbva/qed/metrics"
 	"github.com/bbva/qed/protocol"
 	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/storage/encrypted"
 )
 
 const (
@@ -53,6 +55,10 @@ var (
 
 	// ErrCannotSync is raised when a node cannot synchronize its cluster info.
 	ErrCannotSync = errors.New("Unable to sync cluster info")
+
+	// ErrNonvoterBootstrap is raised when a non-voting node is asked to
+	// bootstrap a cluster.
+	ErrNonvoterBootstrap = errors.New("A non-voter cannot bootstrap the cluster")
 )
 
 // ClusteringOptions contains node options related to clustering.
@@ -63,7 +69,11 @@ type ClusteringOptions struct {
 	HttpAddr          string   // IP address where clients can connect (this is used to populate node info)
 	Bootstrap         bool     // Bootstrap the cluster as a seed node if there is no existing state.
 	Seeds             []string // List of cluster peer node IDs to bootstrap the cluster state.
+	Nonvoter          bool     // Join the cluster as a non-voting read replica.
+	JoinToken         string   // Pre-shared token sent when joining and required from joining nodes.
+	JoinAllowList     []string // Certificate identities allowed to join, as "<node-id>=<identity>" pairs.
 	RaftLogPath       string   // Path to Raft log store directory.
+	RaftLogStore      string   // Raft log store: "rocksdb" or "file". Defaults to "file" in builds without cgo.
 	LogCacheSize      int      // Number of Raft log entries to cache in memory to reduce disk IO.
 	LogSnapshots      int      // Number of Raft log snapshots to retain.
 	SnapshotThreshold uint64   // Controls how many outstanding logs there must be before we perform a snapshot.
@@ -71,28 +81,46 @@ type ClusteringOptions struct {
 	Sync              bool     // Do a file sync after every write to the Raft log and stable store.
 	RaftLogging       bool     // Enable logging of Raft library (disabled by default since really verbose).
 
+	// Keys the database is encrypted with, if any, to open its backups.
+	Keyring *encrypted.Keyring
+
+	// Directory where the checkpoints of the database are cut.
+	CheckpointsPath string
+
+	// Directory with the private keys that can be chosen as the cluster
+	// signing key, stored as generated by qed generate signerkeys.
+	SigningKeysPath string
+
 	// These will be set to some sane defaults. Change only if experiencing raft issues.
 	RaftHeartbeatTimeout time.Duration
 	RaftElectionTimeout  time.Duration
 	RaftLeaseTimeout     time.Duration
 	RaftCommitTimeout    time.Duration
 	RaftApplyTimeout     time.Duration // Amount of time we wait for the command to be started.
+
+	// Group commit of concurrent adds into a single Raft entry.
+	GroupCommitWindow  time.Duration // Maximum time an add waits for other adds to join its batch.
+	GroupCommitMaxSize int           // Maximum number of events per batch. Zero disables group commit.
 }
 
 func DefaultClusteringOptions() *ClusteringOptions {
 	return &ClusteringOptions{
-		NodeID:            "",
-		Addr:              "",
-		Bootstrap:         false,
-		Seeds:             make([]string, 0),
-		RaftLogPath:       "",
-		LogCacheSize:      512,
-		LogSnapshots:      2,
-		SnapshotThreshold: 8192,
-		TrailingLogs:      10240,
-		RaftApplyTimeout:  10 * time.Second,
-		Sync:              false,
-		RaftLogging:       false,
+		NodeID:             "",
+		Addr:               "",
+		Bootstrap:          false,
+		Seeds:              make([]string, 0),
+		Nonvoter:           false,
+		RaftLogPath:        "",
+		RaftLogStore:       DefaultLogStore,
+		LogCacheSize:       512,
+		LogSnapshots:       2,
+		SnapshotThreshold:  8192,
+		TrailingLogs:       10240,
+		RaftApplyTimeout:   10 * time.Second,
+		GroupCommitWindow:  time.Millisecond,
+		GroupCommitMaxSize: 0,
+		Sync:               false,
+		RaftLogging:        false,
 	}
 }
 
@@ -102,24 +130,39 @@ type RaftNode struct {
 	applyTimeout time.Duration
 
 	db        storage.ManagedStore    // Persistent database
-	raftLog   *raftLog                // Underlying rocksdb-backed persistent log store
+	keyring   *encrypted.Keyring      // Keys the database is encrypted with, if any.
+	raftLog   logStore                // Underlying persistent log store
 	snapshots *raft.FileSnapshotStore // Persistent snapstop store
 
+	checkpointsPath string // Directory where the checkpoints are cut.
+	signingKeysPath string // Directory with the local signing keys.
+
 	raft            *raft.Raft             // The consensus mechanism
 	transport       *raft.NetworkTransport // Raft network transport
 	raftConfig      *raft.Config           // Config provides any necessary configuration for the Raft server.
 	tlsConfigurator *tlsutil.TLSConfigurator
+	joinToken       string          // Token sent when joining the cluster.
+	joinAuthorizer  *joinAuthorizer // Authorization of the nodes joining the cluster.
 
-	balloon     *balloon.Balloon // Balloon's finite state machine
+	balloon     *balloon.Balloon // Balloon's finite state machine, replaced under settingsLock
 	state       *fsmState
 	snapshotsCh chan *protocol.Snapshot // channel to publish snapshots
 
 	hasherF     func() hashing.Hasher
+	batcher     *batcher             // Group commit of concurrent adds.
 	metrics     *raftNodeMetrics     // Raft node metrics.
 	raftMetrics *raftInternalMetrics // Raft internal metrics.
 
+	settings     *fsmSettings // Cluster settings changed through admin commands.
+	signer       sign.Signer  // Signer with the replicated signing key, if any.
+	settingsLock sync.RWMutex
+
 	log log.Logger
 
+	draining  bool           // Reject new writes while shutting down.
+	writes    sync.WaitGroup // Writes in progress.
+	drainLock sync.RWMutex
+
 	sync.Mutex
 	closed bool
 	done   chan struct{}
@@ -131,6 +174,10 @@ func NewRaftNode(opts *ClusteringOptions, store storage.ManagedStore, snapshotsC
 
 func NewRaftNodeWithLogger(opts *ClusteringOptions, store storage.ManagedStore, snapshotsCh chan *protocol.Snapshot, tlsConfigurator *tlsutil.TLSConfigurator, logger log.Logger) (*RaftNode, error) {
 
+	if opts.Bootstrap && opts.Nonvoter {
+		return nil, ErrNonvoterBootstrap
+	}
+
 	// We try to resolve the raft addr to avoid binding to hostnames
 	// because Raft library does not support FQDNs
 	addr, err := net.ResolveTCPAddr("tcp", opts.Addr)
@@ -146,27 +193,37 @@ func NewRaftNodeWithLogger(opts *ClusteringOptions, store storage.ManagedStore,
 		RaftAddr: addr.String(),
 		MgmtAddr: opts.MgmtAddr,
 		HttpAddr: opts.HttpAddr,
+		Nonvoter: opts.Nonvoter,
 	}
 
 	if tlsConfigurator == nil {
 		tlsConfigurator = tlsutil.NewTLSConfigurator(&tlsutil.Config{})
 	}
 
+	joinAuthorizer, err := newJoinAuthorizer(opts.JoinToken, opts.JoinAllowList)
+	if err != nil {
+		return nil, err
+	}
+	if !joinAuthorizer.enabled() {
+		logger.Warn("Join authorization disabled: any node reaching the Raft address can join the cluster")
+	}
+
 	node := &RaftNode{
 		info:            info,
 		snapshotsCh:     snapshotsCh,
 		log:             logger,
 		tlsConfigurator: tlsConfigurator,
+		joinToken:       opts.JoinToken,
+		joinAuthorizer:  joinAuthorizer,
 		applyTimeout:    opts.RaftApplyTimeout,
+		keyring:         opts.Keyring,
+		checkpointsPath: opts.CheckpointsPath,
+		signingKeysPath: opts.SigningKeysPath,
 		done:            make(chan struct{}),
 	}
 
 	// Create the log store
-	raftLog, err := newRaftLogOpts(raftLogOptions{
-		Path:             opts.RaftLogPath + "/wal",
-		NoSync:           !opts.Sync,
-		EnableStatistics: true},
-	)
+	raftLog, err := openLogStore(opts.RaftLogStore, opts.RaftLogPath, !opts.Sync, logger)
 	if err != nil {
 		return nil, fmt.Errorf("cannot create a new Raft log: %s", err)
 	}
@@ -177,12 +234,15 @@ func NewRaftNodeWithLogger(opts *ClusteringOptions, store storage.ManagedStore,
 	node.db = store
 	node.raftLog = raftLog
 
-	// Set hashing function
-	hasherF := hashing.NewSha256Hasher
-	node.hasherF = hasherF
+	// Load the cluster settings, which set the hashing function
+	err = node.loadSettings()
+	if err != nil {
+		node.log.Error("There was an error recovering the cluster settings!!")
+		return nil, err
+	}
 
 	// Instantiate balloon FSM
-	node.balloon, err = balloon.NewBalloonWithLogger(store, hasherF, node.log.Named("balloon"))
+	node.balloon, err = balloon.NewBalloonWithLogger(store, node.hasherF, node.log.Named("balloon"))
 	if err != nil {
 		return nil, err
 	}
@@ -219,6 +279,9 @@ func NewRaftNodeWithLogger(opts *ClusteringOptions, store storage.ManagedStore,
 
 	node.raftConfig = conf
 
+	// register metrics before serving any request
+	node.metrics = newRaftNodeMetrics(node)
+
 	node.transport, err = NewCMuxTCPTransportWithLogger(node.info.RaftAddr, 3, 10*time.Second, tlsConfigurator, func(srv *grpc.Server) {
 		srv.RegisterService(&_ClusterService_serviceDesc, node)
 	}, node.log.Named("transport")) // TODO export params
@@ -243,8 +306,9 @@ func NewRaftNodeWithLogger(opts *ClusteringOptions, store storage.ManagedStore,
 		return nil, fmt.Errorf("new raft: %s", err)
 	}
 
-	// register metrics
-	node.metrics = newRaftNodeMetrics(node)
+	if opts.GroupCommitMaxSize > 0 {
+		node.batcher = newBatcher(node.proposeAdd, opts.GroupCommitWindow, opts.GroupCommitMaxSize, node.log)
+	}
 	node.raftMetrics = newRaftInternalMetrics(node.raft)
 
 	// check existing state
@@ -296,6 +360,11 @@ func (n *RaftNode) Close(wait bool) error {
 	n.closed = true
 	n.Unlock()
 
+	// stop group commit before Raft, so no batch is left half proposed
+	if n.batcher != nil {
+		n.batcher.stop()
+	}
+
 	// shutdown Raft
 	if n.raft != nil {
 		f := n.raft.Shutdown()
@@ -325,11 +394,13 @@ func (n *RaftNode) Close(wait bool) error {
 	}
 
 	// close fsm
+	n.settingsLock.Lock()
 	if n.balloon != nil {
 		n.balloon.Close()
 		n.balloon = nil
 		n.log.Trace("RaftNode closed balloon")
 	}
+	n.settingsLock.Unlock()
 
 	// close the database
 	if n.db != nil {
@@ -373,6 +444,12 @@ func (n *RaftNode) WaitForLeader(timeout time.Duration) error {
 // This must be called from the Leader or it will fail.
 func (n *RaftNode) JoinCluster(ctx context.Context, req *RaftJoinRequest) (*RaftJoinResponse, error) {
 
+	if err := n.joinAuthorizer.authorize(ctx, req); err != nil {
+		n.metrics.JoinRejections.Inc()
+		n.log.Warnf("rejected join request for remote node %q at %q from %s: %v", req.NodeId, req.RaftAddr, peerAddr(ctx), err)
+		return nil, ErrJoinUnauthorized
+	}
+
 	// Drop the request if we're not the leader. There's no race condition
 	// after this check because even if we proceed with the cluster add, it
 	// will fail if the node is not the leader as cluster changes go
@@ -381,7 +458,7 @@ func (n *RaftNode) JoinCluster(ctx context.Context, req *RaftJoinRequest) (*Raft
 		return nil, ErrNotLeader
 	}
 
-	n.log.Infof("received join request for remote node %q at %q", req.NodeId, req.RaftAddr)
+	n.log.Infof("received join request for remote node %q at %q (non-voter: %v)", req.NodeId, req.RaftAddr, req.Nonvoter)
 
 	configFuture := n.raft.GetConfiguration()
 	if err := configFuture.Error(); err != nil {
@@ -394,9 +471,22 @@ func (n *RaftNode) JoinCluster(ctx context.Context, req *RaftJoinRequest) (*Raft
 		// that node may need to be removed from the config first.
 		if srv.ID == raft.ServerID(req.NodeId) || srv.Address == raft.ServerAddress(req.RaftAddr) {
 			// However if *both* the ID and the address are the same, then nothing -- not even
-			// a join operation -- is needed.
+			// a join operation -- is needed, unless the node changed its suffrage.
 			if srv.Address == raft.ServerAddress(req.RaftAddr) && srv.ID == raft.ServerID(req.NodeId) {
-				n.log.Infof("node %s at %s already member of cluster, ignoring join request", req.NodeId, req.RaftAddr)
+				switch {
+				case req.Nonvoter && srv.Suffrage == raft.Voter:
+					n.log.Infof("node %s at %s rejoined as a non-voter, demoting it", req.NodeId, req.RaftAddr)
+					if err := n.raft.DemoteVoter(srv.ID, 0, 0).Error(); err != nil {
+						return nil, err
+					}
+				case !req.Nonvoter && srv.Suffrage != raft.Voter:
+					n.log.Infof("node %s at %s rejoined as a voter, promoting it", req.NodeId, req.RaftAddr)
+					if err := n.raft.AddVoter(srv.ID, srv.Address, 0, 0).Error(); err != nil {
+						return nil, err
+					}
+				default:
+					n.log.Infof("node %s at %s already member of cluster, ignoring join request", req.NodeId, req.RaftAddr)
+				}
 				return new(RaftJoinResponse), nil
 			}
 			n.log.Infof("New node already exists with either the same joining node ID or address: %q [%s]", req.NodeId, req.RaftAddr)
@@ -418,9 +508,14 @@ func (n *RaftNode) JoinCluster(ctx context.Context, req *RaftJoinRequest) (*Raft
 		}
 	}
 
-	// Add the node as a voter. This is idempotent. No-op if the request
-	// came from ourselves.
-	f := n.raft.AddVoter(raft.ServerID(req.NodeId), raft.ServerAddress(req.RaftAddr), 0, 0)
+	// Add the node as a non-voter if it is a read replica, or as a voter
+	// otherwise. This is idempotent. No-op if the request came from ourselves.
+	var f raft.IndexFuture
+	if req.Nonvoter {
+		f = n.raft.AddNonvoter(raft.ServerID(req.NodeId), raft.ServerAddress(req.RaftAddr), 0, 0)
+	} else {
+		f = n.raft.AddVoter(raft.ServerID(req.NodeId), raft.ServerAddress(req.RaftAddr), 0, 0)
+	}
 	if err := f.Error(); err != nil {
 		return nil, err
 	}
@@ -448,6 +543,8 @@ func (n *RaftNode) attemptToJoinCluster(addrs []string) error {
 		req := new(RaftJoinRequest)
 		req.NodeId = n.info.NodeId
 		req.RaftAddr = string(n.transport.LocalAddr())
+		req.Nonvoter = n.info.Nonvoter
+		req.JoinToken = n.joinToken
 		_, err = client.JoinCluster(context.Background(), req)
 		if err == nil {
 			return nil
@@ -464,6 +561,9 @@ func (n *RaftNode) RegisterMetrics(registry metrics.Registry) {
 	}
 	registry.MustRegister(n.metrics.collectors()...)
 	registry.MustRegister(n.raftMetrics.collectors()...)
+	if n.batcher != nil {
+		registry.MustRegister(n.batcher.metrics.collectors()...)
+	}
 }
 
 func (n *RaftNode) bootstrapCluster() error {
@@ -30,6 +30,7 @@ type NodeInfo struct {
 	MgmtAddr             string   `protobuf:"bytes,3,opt,name=mgmt_addr,json=mgmtAddr,proto3" json:"mgmt_addr,omitempty"`
 	HttpAddr             string   `protobuf:"bytes,4,opt,name=http_addr,json=httpAddr,proto3" json:"http_addr,omitempty"`
 	MetricsAddr          string   `protobuf:"bytes,5,opt,name=metrics_addr,json=metricsAddr,proto3" json:"metrics_addr,omitempty"`
+	Nonvoter             bool     `protobuf:"varint,6,opt,name=nonvoter,proto3" json:"nonvoter,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -95,6 +96,13 @@ func (m *NodeInfo) GetMetricsAddr() string {
 	return ""
 }
 
+func (m *NodeInfo) GetNonvoter() bool {
+	if m != nil {
+		return m.Nonvoter
+	}
+	return false
+}
+
 type ClusterInfo struct {
 	LeaderId             string               `protobuf:"bytes,1,opt,name=leader_id,json=leaderId,proto3" json:"leader_id,omitempty"`
 	Nodes                map[string]*NodeInfo `protobuf:"bytes,2,rep,name=nodes,proto3" json:"nodes,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
@@ -145,6 +153,8 @@ func (m *ClusterInfo) GetNodes() map[string]*NodeInfo {
 type RaftJoinRequest struct {
 	NodeId               string   `protobuf:"bytes,1,opt,name=node_id,json=nodeId,proto3" json:"node_id,omitempty"`
 	RaftAddr             string   `protobuf:"bytes,2,opt,name=raft_addr,json=raftAddr,proto3" json:"raft_addr,omitempty"`
+	Nonvoter             bool     `protobuf:"varint,3,opt,name=nonvoter,proto3" json:"nonvoter,omitempty"`
+	JoinToken            string   `protobuf:"bytes,4,opt,name=join_token,json=joinToken,proto3" json:"join_token,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -189,6 +199,20 @@ func (m *RaftJoinRequest) GetRaftAddr() string {
 	return ""
 }
 
+func (m *RaftJoinRequest) GetNonvoter() bool {
+	if m != nil {
+		return m.Nonvoter
+	}
+	return false
+}
+
+func (m *RaftJoinRequest) GetJoinToken() string {
+	if m != nil {
+		return m.JoinToken
+	}
+	return ""
+}
+
 type RaftJoinResponse struct {
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
@@ -224,6 +248,7 @@ type FetchSnapshotRequest struct {
 	LastAppliedVersion   uint64   `protobuf:"varint,1,opt,name=lastAppliedVersion,proto3" json:"lastAppliedVersion,omitempty"`
 	StartSeqNum          uint64   `protobuf:"varint,2,opt,name=startSeqNum,proto3" json:"startSeqNum,omitempty"`
 	EndSeqNum            uint64   `protobuf:"varint,3,opt,name=endSeqNum,proto3" json:"endSeqNum,omitempty"`
+	Checksums            bool     `protobuf:"varint,4,opt,name=checksums,proto3" json:"checksums,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -275,8 +300,19 @@ func (m *FetchSnapshotRequest) GetEndSeqNum() uint64 {
 	return 0
 }
 
+func (m *FetchSnapshotRequest) GetChecksums() bool {
+	if m != nil {
+		return m.Checksums
+	}
+	return false
+}
+
 type Chunk struct {
 	Content              []byte   `protobuf:"bytes,1,opt,name=Content,proto3" json:"Content,omitempty"`
+	Checksum             uint32   `protobuf:"varint,2,opt,name=checksum,proto3" json:"checksum,omitempty"`
+	Last                 bool     `protobuf:"varint,3,opt,name=last,proto3" json:"last,omitempty"`
+	StreamChecksum       uint32   `protobuf:"varint,4,opt,name=stream_checksum,json=streamChecksum,proto3" json:"stream_checksum,omitempty"`
+	Checksummed          bool     `protobuf:"varint,5,opt,name=checksummed,proto3" json:"checksummed,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -314,8 +350,37 @@ func (m *Chunk) GetContent() []byte {
 	return nil
 }
 
+func (m *Chunk) GetChecksum() uint32 {
+	if m != nil {
+		return m.Checksum
+	}
+	return 0
+}
+
+func (m *Chunk) GetLast() bool {
+	if m != nil {
+		return m.Last
+	}
+	return false
+}
+
+func (m *Chunk) GetStreamChecksum() uint32 {
+	if m != nil {
+		return m.StreamChecksum
+	}
+	return 0
+}
+
+func (m *Chunk) GetChecksummed() bool {
+	if m != nil {
+		return m.Checksummed
+	}
+	return false
+}
+
 type InfoResponse struct {
 	NodeInfo             *NodeInfo `protobuf:"bytes,1,opt,name=node_info,json=nodeInfo,proto3" json:"node_info,omitempty"`
+	LastIndex            uint64    `protobuf:"varint,2,opt,name=last_index,json=lastIndex,proto3" json:"last_index,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
 	XXX_unrecognized     []byte    `json:"-"`
 	XXX_sizecache        int32     `json:"-"`
@@ -353,6 +418,13 @@ func (m *InfoResponse) GetNodeInfo() *NodeInfo {
 	return nil
 }
 
+func (m *InfoResponse) GetLastIndex() uint64 {
+	if m != nil {
+		return m.LastIndex
+	}
+	return 0
+}
+
 type InfoRequest struct {
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
@@ -384,6 +456,76 @@ func (m *InfoRequest) XXX_DiscardUnknown() {
 
 var xxx_messageInfo_InfoRequest proto.InternalMessageInfo
 
+type ReadIndexRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ReadIndexRequest) Reset()         { *m = ReadIndexRequest{} }
+func (m *ReadIndexRequest) String() string { return proto.CompactTextString(m) }
+func (*ReadIndexRequest) ProtoMessage()    {}
+func (*ReadIndexRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3cfb3b8ec240c376, []int{8}
+}
+
+func (m *ReadIndexRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ReadIndexRequest.Unmarshal(m, b)
+}
+func (m *ReadIndexRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ReadIndexRequest.Marshal(b, m, deterministic)
+}
+func (m *ReadIndexRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ReadIndexRequest.Merge(m, src)
+}
+func (m *ReadIndexRequest) XXX_Size() int {
+	return xxx_messageInfo_ReadIndexRequest.Size(m)
+}
+func (m *ReadIndexRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ReadIndexRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ReadIndexRequest proto.InternalMessageInfo
+
+type ReadIndexResponse struct {
+	Version              uint64   `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ReadIndexResponse) Reset()         { *m = ReadIndexResponse{} }
+func (m *ReadIndexResponse) String() string { return proto.CompactTextString(m) }
+func (*ReadIndexResponse) ProtoMessage()    {}
+func (*ReadIndexResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3cfb3b8ec240c376, []int{9}
+}
+
+func (m *ReadIndexResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ReadIndexResponse.Unmarshal(m, b)
+}
+func (m *ReadIndexResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ReadIndexResponse.Marshal(b, m, deterministic)
+}
+func (m *ReadIndexResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ReadIndexResponse.Merge(m, src)
+}
+func (m *ReadIndexResponse) XXX_Size() int {
+	return xxx_messageInfo_ReadIndexResponse.Size(m)
+}
+func (m *ReadIndexResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ReadIndexResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ReadIndexResponse proto.InternalMessageInfo
+
+func (m *ReadIndexResponse) GetVersion() uint64 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*NodeInfo)(nil), "consensus.NodeInfo")
 	proto.RegisterType((*ClusterInfo)(nil), "consensus.ClusterInfo")
@@ -394,43 +536,54 @@ func init() {
 	proto.RegisterType((*Chunk)(nil), "consensus.Chunk")
 	proto.RegisterType((*InfoResponse)(nil), "consensus.InfoResponse")
 	proto.RegisterType((*InfoRequest)(nil), "consensus.InfoRequest")
+	proto.RegisterType((*ReadIndexRequest)(nil), "consensus.ReadIndexRequest")
+	proto.RegisterType((*ReadIndexResponse)(nil), "consensus.ReadIndexResponse")
 }
 
 func init() { proto.RegisterFile("cluster.proto", fileDescriptor_3cfb3b8ec240c376) }
 
 var fileDescriptor_3cfb3b8ec240c376 = []byte{
-	// 481 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x93, 0xcf, 0x6e, 0xd3, 0x40,
-	0x10, 0xc6, 0xe5, 0xfc, 0x69, 0xe3, 0x71, 0x02, 0xd1, 0x82, 0x68, 0xe4, 0x20, 0x91, 0xf8, 0x54,
-	0x2e, 0x56, 0x15, 0x0e, 0x20, 0x4e, 0x2d, 0x81, 0xa2, 0x20, 0xd1, 0x83, 0x23, 0x71, 0xe0, 0x52,
-	0x19, 0xef, 0x84, 0x58, 0x75, 0x76, 0xdd, 0xdd, 0x75, 0xa5, 0xbe, 0x00, 0x6f, 0xc1, 0x4b, 0xf0,
-	0x44, 0x3c, 0x0a, 0xda, 0x3f, 0x8e, 0x0d, 0x84, 0x0b, 0x37, 0xef, 0xf7, 0x1b, 0xcd, 0x7c, 0xf3,
-	0xad, 0x17, 0x46, 0x59, 0x51, 0x49, 0x85, 0x22, 0x2e, 0x05, 0x57, 0x9c, 0xf8, 0x19, 0x67, 0x12,
-	0x99, 0xac, 0x64, 0xf4, 0xdd, 0x83, 0xc1, 0x15, 0xa7, 0xb8, 0x62, 0x1b, 0x4e, 0x4e, 0xe0, 0x98,
-	0x71, 0x8a, 0xd7, 0x39, 0x9d, 0x78, 0x33, 0xef, 0xd4, 0x4f, 0x8e, 0xf4, 0x71, 0x45, 0xc9, 0x14,
-	0x7c, 0x91, 0x6e, 0xd4, 0x75, 0x4a, 0xa9, 0x98, 0x74, 0x0c, 0x1a, 0x68, 0xe1, 0x82, 0x52, 0xa1,
-	0xe1, 0xee, 0xeb, 0xce, 0xc1, 0xae, 0x85, 0x5a, 0xa8, 0xe1, 0x56, 0xa9, 0xd2, 0xc2, 0x9e, 0x85,
-	0x5a, 0x30, 0x70, 0x0e, 0xc3, 0x1d, 0x2a, 0x91, 0x67, 0xd2, 0xf2, 0xbe, 0xe1, 0x81, 0xd3, 0x74,
-	0x49, 0xf4, 0xc3, 0x83, 0x60, 0x69, 0xcd, 0x1b, 0x8b, 0x53, 0xf0, 0x0b, 0x4c, 0x29, 0x8a, 0xc6,
-	0xe4, 0xc0, 0x0a, 0x2b, 0x4a, 0x5e, 0x42, 0x5f, 0x1b, 0x96, 0x93, 0xce, 0xac, 0x7b, 0x1a, 0x2c,
-	0xe6, 0xf1, 0x7e, 0xcf, 0xb8, 0xd5, 0x23, 0xd6, 0xfb, 0xca, 0x77, 0x4c, 0x89, 0xfb, 0xc4, 0xd6,
-	0x87, 0x1f, 0x01, 0x1a, 0x91, 0x8c, 0xa1, 0x7b, 0x83, 0xf7, 0xae, 0xbb, 0xfe, 0x24, 0xcf, 0xa1,
-	0x7f, 0x97, 0x16, 0x15, 0x9a, 0xdd, 0x83, 0xc5, 0xa3, 0x56, 0xe3, 0x3a, 0xbc, 0xc4, 0x56, 0xbc,
-	0xee, 0xbc, 0xf2, 0xa2, 0xf7, 0xf0, 0x30, 0x49, 0x37, 0xea, 0x03, 0xcf, 0x59, 0x82, 0xb7, 0x15,
-	0x4a, 0xf5, 0x7f, 0xd1, 0x46, 0x04, 0xc6, 0x4d, 0x23, 0x59, 0xea, 0xa1, 0xd1, 0x37, 0x0f, 0x1e,
-	0x5f, 0xa2, 0xca, 0xb6, 0x6b, 0x96, 0x96, 0x72, 0xcb, 0x55, 0x3d, 0x22, 0x06, 0x52, 0xa4, 0x52,
-	0x5d, 0x94, 0x65, 0x91, 0x23, 0xfd, 0x84, 0x42, 0xe6, 0x9c, 0x99, 0x69, 0xbd, 0xe4, 0x00, 0x21,
-	0x33, 0x08, 0xa4, 0x4a, 0x85, 0x5a, 0xe3, 0xed, 0x55, 0xb5, 0x33, 0xb3, 0x7b, 0x49, 0x5b, 0x22,
-	0x4f, 0xc1, 0x47, 0x46, 0x1d, 0xef, 0x1a, 0xde, 0x08, 0xd1, 0x1c, 0xfa, 0xcb, 0x6d, 0xc5, 0x6e,
-	0xc8, 0x04, 0x8e, 0x97, 0x9c, 0x29, 0x64, 0xca, 0x4c, 0x1b, 0x26, 0xf5, 0x31, 0x3a, 0x87, 0xa1,
-	0xc9, 0xc6, 0x79, 0x27, 0x67, 0xe0, 0xdb, 0x14, 0xd8, 0x86, 0x9b, 0xda, 0x7f, 0x64, 0x39, 0x60,
-	0xee, 0x2b, 0x1a, 0x41, 0x60, 0x3b, 0x98, 0x1d, 0x17, 0x3f, 0x3d, 0x78, 0xe0, 0xae, 0x72, 0x8d,
-	0xe2, 0x2e, 0xcf, 0x90, 0x5c, 0x42, 0xa0, 0xf3, 0x71, 0x2a, 0x09, 0x5b, 0xfd, 0xfe, 0xb8, 0x84,
-	0x70, 0x7a, 0x90, 0x39, 0x6f, 0x6f, 0x61, 0xf4, 0x5b, 0xac, 0xe4, 0x59, 0xab, 0xfa, 0x50, 0xe0,
-	0xe1, 0xb8, 0xfd, 0x7f, 0xe9, 0x24, 0xce, 0x3c, 0x72, 0xee, 0xba, 0xec, 0xdf, 0xd4, 0x93, 0x56,
-	0x51, 0x6b, 0x93, 0xf0, 0xe4, 0x2f, 0xdd, 0xfa, 0x78, 0x13, 0x7c, 0x6e, 0x9e, 0xe7, 0x97, 0x23,
-	0xf3, 0x60, 0x5f, 0xfc, 0x0a, 0x00, 0x00, 0xff, 0xff, 0x96, 0xe2, 0xd1, 0xdc, 0xc1, 0x03, 0x00,
-	0x00,
+	// 631 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x54, 0xcf, 0x6f, 0xd3, 0x30,
+	0x14, 0x56, 0xfa, 0x63, 0x4b, 0x5e, 0xd6, 0x6d, 0x18, 0xc4, 0xa2, 0x6c, 0x88, 0x2e, 0x17, 0xc6,
+	0x81, 0x6a, 0x1a, 0x07, 0x10, 0x27, 0x46, 0x61, 0x52, 0x91, 0xd8, 0x21, 0x43, 0x1c, 0xb8, 0x54,
+	0x21, 0x7e, 0xa5, 0xa1, 0x8d, 0xdd, 0xd9, 0x4e, 0xc5, 0xae, 0xfc, 0x1d, 0x1c, 0x39, 0x70, 0xe6,
+	0xc6, 0x7f, 0x87, 0xec, 0x38, 0x4d, 0x3a, 0xca, 0x85, 0x9b, 0xdf, 0xf7, 0x3d, 0x3f, 0x7f, 0xfe,
+	0xde, 0xb3, 0xa1, 0x97, 0xce, 0x0b, 0xa9, 0x50, 0x0c, 0x16, 0x82, 0x2b, 0x4e, 0xbc, 0x94, 0x33,
+	0x89, 0x4c, 0x16, 0x32, 0xfa, 0xed, 0x80, 0x7b, 0xc9, 0x29, 0x8e, 0xd8, 0x84, 0x93, 0x03, 0xd8,
+	0x66, 0x9c, 0xe2, 0x38, 0xa3, 0x81, 0xd3, 0x77, 0x4e, 0xbc, 0x78, 0x4b, 0x87, 0x23, 0x4a, 0x0e,
+	0xc1, 0x13, 0xc9, 0x44, 0x8d, 0x13, 0x4a, 0x45, 0xd0, 0x32, 0x94, 0xab, 0x81, 0x73, 0x4a, 0x85,
+	0x26, 0xf3, 0xcf, 0xb9, 0x25, 0xdb, 0x25, 0xa9, 0x81, 0x8a, 0x9c, 0x2a, 0xb5, 0x28, 0xc9, 0x4e,
+	0x49, 0x6a, 0xc0, 0x90, 0xc7, 0xb0, 0x93, 0xa3, 0x12, 0x59, 0x2a, 0x4b, 0xbe, 0x6b, 0x78, 0xdf,
+	0x62, 0x26, 0x25, 0x04, 0x97, 0x71, 0xb6, 0xe4, 0x0a, 0x45, 0xb0, 0xd5, 0x77, 0x4e, 0xdc, 0x78,
+	0x15, 0x47, 0xbf, 0x1c, 0xf0, 0x87, 0xe5, 0xc5, 0x8c, 0xfc, 0x43, 0xf0, 0xe6, 0x98, 0x50, 0x14,
+	0xf5, 0x05, 0xdc, 0x12, 0x18, 0x51, 0xf2, 0x0c, 0xba, 0xfa, 0x32, 0x32, 0x68, 0xf5, 0xdb, 0x27,
+	0xfe, 0xd9, 0xf1, 0x60, 0xe5, 0xc1, 0xa0, 0x51, 0x63, 0xa0, 0xbd, 0x90, 0x6f, 0x98, 0x12, 0x37,
+	0x71, 0x99, 0x1f, 0xbe, 0x03, 0xa8, 0x41, 0xb2, 0x0f, 0xed, 0x19, 0xde, 0xd8, 0xea, 0x7a, 0x49,
+	0x1e, 0x43, 0x77, 0x99, 0xcc, 0x0b, 0x34, 0xbe, 0xf8, 0x67, 0x77, 0x1b, 0x85, 0x2b, 0x63, 0xe3,
+	0x32, 0xe3, 0x45, 0xeb, 0xb9, 0x13, 0x7d, 0x73, 0x60, 0x2f, 0x4e, 0x26, 0xea, 0x2d, 0xcf, 0x58,
+	0x8c, 0xd7, 0x05, 0x4a, 0xf5, 0x9f, 0xbe, 0x37, 0xad, 0x69, 0xaf, 0x5b, 0x43, 0x1e, 0x00, 0x7c,
+	0xe1, 0x19, 0x1b, 0x2b, 0x3e, 0x43, 0x66, 0x7d, 0xf7, 0x34, 0xf2, 0x5e, 0x03, 0x11, 0x81, 0xfd,
+	0x5a, 0x83, 0x5c, 0x68, 0xc1, 0xd1, 0x0f, 0x07, 0xee, 0x5d, 0xa0, 0x4a, 0xa7, 0x57, 0x2c, 0x59,
+	0xc8, 0x29, 0x57, 0x95, 0xba, 0x01, 0x90, 0x79, 0x22, 0xd5, 0xf9, 0x62, 0x31, 0xcf, 0x90, 0x7e,
+	0x40, 0x21, 0x33, 0xce, 0x8c, 0xd0, 0x4e, 0xbc, 0x81, 0x21, 0x7d, 0xf0, 0xa5, 0x4a, 0x84, 0xba,
+	0xc2, 0xeb, 0xcb, 0x22, 0x37, 0xb2, 0x3b, 0x71, 0x13, 0x22, 0x47, 0xe0, 0x21, 0xa3, 0x96, 0x6f,
+	0x1b, 0xbe, 0x06, 0x34, 0x9b, 0x4e, 0x31, 0x9d, 0xc9, 0x22, 0x97, 0x46, 0xba, 0x1b, 0xd7, 0x40,
+	0xf4, 0xdd, 0x81, 0xee, 0x70, 0x5a, 0xb0, 0x19, 0x09, 0x60, 0x7b, 0xc8, 0x99, 0x42, 0xa6, 0x8c,
+	0x98, 0x9d, 0xb8, 0x0a, 0xb5, 0x33, 0xd5, 0x06, 0x73, 0x7c, 0x2f, 0x5e, 0xc5, 0x84, 0x40, 0x47,
+	0x6b, 0xb6, 0x8e, 0x99, 0x35, 0x79, 0x04, 0x7b, 0x52, 0x09, 0x4c, 0xf2, 0xf1, 0x6a, 0x5b, 0xc7,
+	0x6c, 0xdb, 0x2d, 0xe1, 0x61, 0xb5, 0xb9, 0x0f, 0x7e, 0x95, 0x91, 0x23, 0x35, 0xf3, 0xea, 0xc6,
+	0x4d, 0x28, 0x1a, 0xc3, 0x8e, 0xe9, 0xb8, 0x75, 0x95, 0x9c, 0x82, 0x57, 0xb6, 0x96, 0x4d, 0x78,
+	0xe0, 0xfc, 0x7b, 0x42, 0x5c, 0x66, 0x57, 0xba, 0x75, 0x5a, 0xd4, 0x38, 0x63, 0x14, 0xbf, 0x5a,
+	0xf7, 0x3c, 0x8d, 0x8c, 0x34, 0x10, 0xf5, 0xc0, 0x2f, 0x0f, 0x30, 0xcd, 0x31, 0x9d, 0xc4, 0x84,
+	0x1a, 0xae, 0xc2, 0x9e, 0xc0, 0x9d, 0x06, 0x66, 0x85, 0x04, 0xb0, 0xbd, 0x5c, 0x6b, 0x5d, 0x15,
+	0x9e, 0xfd, 0x6c, 0xc1, 0xae, 0x7d, 0x02, 0x57, 0x28, 0x96, 0x59, 0x8a, 0xe4, 0x02, 0x7c, 0x3d,
+	0x1b, 0x16, 0x25, 0x61, 0x43, 0xf1, 0xad, 0xd9, 0x0d, 0x0f, 0x37, 0x72, 0xf6, 0xd0, 0xd7, 0xd0,
+	0x5b, 0x1b, 0x29, 0xf2, 0xb0, 0x91, 0xbd, 0x69, 0xd8, 0xc2, 0xfd, 0xe6, 0xbb, 0xd4, 0x6d, 0x3e,
+	0x75, 0xc8, 0x4b, 0x5b, 0x65, 0xf5, 0x4f, 0xdd, 0x6f, 0x24, 0x35, 0xcc, 0x08, 0x0f, 0xfe, 0xc2,
+	0xad, 0x8e, 0x0b, 0xf0, 0x56, 0x8e, 0x90, 0x35, 0xc5, 0xb7, 0xbc, 0x0b, 0x8f, 0x36, 0x93, 0x65,
+	0x9d, 0x57, 0xfe, 0xc7, 0xfa, 0xeb, 0xfc, 0xb4, 0x65, 0x3e, 0xd3, 0xa7, 0x7f, 0x06, 0x00, 0xa7,
+	0x1a, 0x8b, 0x14, 0x5d, 0x05, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -448,6 +601,7 @@ type ClusterServiceClient interface {
 	JoinCluster(ctx context.Context, in *RaftJoinRequest, opts ...grpc.CallOption) (*RaftJoinResponse, error)
 	FetchSnapshot(ctx context.Context, in *FetchSnapshotRequest, opts ...grpc.CallOption) (ClusterService_FetchSnapshotClient, error)
 	FetchNodeInfo(ctx context.Context, in *InfoRequest, opts ...grpc.CallOption) (*InfoResponse, error)
+	ReadIndex(ctx context.Context, in *ReadIndexRequest, opts ...grpc.CallOption) (*ReadIndexResponse, error)
 }
 
 type clusterServiceClient struct {
@@ -508,11 +662,21 @@ func (c *clusterServiceClient) FetchNodeInfo(ctx context.Context, in *InfoReques
 	return out, nil
 }
 
+func (c *clusterServiceClient) ReadIndex(ctx context.Context, in *ReadIndexRequest, opts ...grpc.CallOption) (*ReadIndexResponse, error) {
+	out := new(ReadIndexResponse)
+	err := c.cc.Invoke(ctx, "/consensus.ClusterService/ReadIndex", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ClusterServiceServer is the server API for ClusterService service.
 type ClusterServiceServer interface {
 	JoinCluster(context.Context, *RaftJoinRequest) (*RaftJoinResponse, error)
 	FetchSnapshot(*FetchSnapshotRequest, ClusterService_FetchSnapshotServer) error
 	FetchNodeInfo(context.Context, *InfoRequest) (*InfoResponse, error)
+	ReadIndex(context.Context, *ReadIndexRequest) (*ReadIndexResponse, error)
 }
 
 // UnimplementedClusterServiceServer can be embedded to have forward compatible implementations.
@@ -528,6 +692,9 @@ func (*UnimplementedClusterServiceServer) FetchSnapshot(req *FetchSnapshotReques
 func (*UnimplementedClusterServiceServer) FetchNodeInfo(ctx context.Context, req *InfoRequest) (*InfoResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method FetchNodeInfo not implemented")
 }
+func (*UnimplementedClusterServiceServer) ReadIndex(ctx context.Context, req *ReadIndexRequest) (*ReadIndexResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReadIndex not implemented")
+}
 
 func RegisterClusterServiceServer(s *grpc.Server, srv ClusterServiceServer) {
 	s.RegisterService(&_ClusterService_serviceDesc, srv)
@@ -590,6 +757,24 @@ func _ClusterService_FetchNodeInfo_Handler(srv interface{}, ctx context.Context,
 	return interceptor(ctx, in, info, handler)
 }
 
+func _ClusterService_ReadIndex_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReadIndexRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ClusterServiceServer).ReadIndex(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/consensus.ClusterService/ReadIndex",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ClusterServiceServer).ReadIndex(ctx, req.(*ReadIndexRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _ClusterService_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "consensus.ClusterService",
 	HandlerType: (*ClusterServiceServer)(nil),
@@ -602,6 +787,10 @@ var _ClusterService_serviceDesc = grpc.ServiceDesc{
 			MethodName: "FetchNodeInfo",
 			Handler:    _ClusterService_FetchNodeInfo_Handler,
 		},
+		{
+			MethodName: "ReadIndex",
+			Handler:    _ClusterService_ReadIndex_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -17,11 +17,18 @@
 package consensus
 
 import (
+	"context"
+	"crypto/rand"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"testing"
 	"time"
 
+	"github.com/hashicorp/raft"
+	"golang.org/x/crypto/ed25519"
+
+	"github.com/bbva/qed/crypto"
 	"github.com/bbva/qed/log"
 	"github.com/bbva/qed/protocol"
 	"github.com/bbva/qed/storage/rocks"
@@ -257,6 +264,264 @@ func TestMultiRaftLeaveLeadership(t *testing.T) {
 	}, "The leader has to have changed")
 }
 
+func TestMultiRaftNodeMembership(t *testing.T) {
+
+	// start one seed
+	r0, clean0, err := newSeed(t.Name(), 0)
+	spec.NoError(t, err)
+	defer func() {
+		spec.NoError(t, r0.Close(true))
+		clean0(true)
+	}()
+
+	// check leader
+	spec.RetryOnFalse(t, 50, 200*time.Millisecond, r0.IsLeader, "A single node is not leader!")
+
+	// start two replicas
+	r1, clean1, err := newFollower(t.Name(), 1, r0.info.RaftAddr)
+	spec.NoError(t, err)
+	defer func() {
+		spec.NoError(t, r1.Close(true))
+		clean1(true)
+	}()
+	r2, clean2, err := newFollower(t.Name(), 2, r0.info.RaftAddr)
+	spec.NoError(t, err)
+	defer func() {
+		spec.NoError(t, r2.Close(true))
+		clean2(true)
+	}()
+
+	// list servers
+	servers, err := r0.Servers()
+	spec.NoError(t, err)
+	spec.Equal(t, 3, len(servers), "The number of servers does not match")
+	spec.Equal(t, "Leader", servers[0].State, "The seed should be the leader")
+
+	// operations are rejected on followers or for unknown nodes
+	spec.Equal(t, ErrNotLeader, r1.RemoveServer(r2.info.NodeId), "Followers cannot remove servers")
+	spec.Equal(t, ErrUnknownServer, r0.DemoteServer("unknown"), "Unknown servers cannot be demoted")
+
+	// demote and remove the last replica
+	spec.NoError(t, r0.DemoteServer(r2.info.NodeId))
+	servers, err = r0.Servers()
+	spec.NoError(t, err)
+	spec.Equal(t, "Nonvoter", servers[2].Suffrage, "The server should have been demoted")
+
+	spec.NoError(t, r0.RemoveServer(r2.info.NodeId))
+	servers, err = r0.Servers()
+	spec.NoError(t, err)
+	spec.Equal(t, 2, len(servers), "The server should have been removed")
+
+	// transfer leadership to the first replica
+	spec.NoError(t, r0.TransferLeadership(r1.info.NodeId))
+	spec.RetryOnFalse(t, 50, 200*time.Millisecond, r1.IsLeader, "The leadership should have been transferred")
+}
+
+func TestMultiRaftNodeNonvoterJoin(t *testing.T) {
+
+	// start one seed
+	r0, clean0, err := newSeed(t.Name(), 0)
+	spec.NoError(t, err)
+	defer func() {
+		spec.NoError(t, r0.Close(true))
+		clean0(true)
+	}()
+
+	spec.RetryOnFalse(t, 50, 200*time.Millisecond, r0.IsLeader, "A single node is not leader!")
+
+	// start one read replica and join the cluster
+	r1, clean1, err := newReplica(t.Name(), 1, r0.info.RaftAddr)
+	spec.NoError(t, err)
+	defer func() {
+		spec.NoError(t, r1.Close(true))
+		clean1(true)
+	}()
+
+	servers, err := r0.Servers()
+	spec.NoError(t, err)
+	spec.Equal(t, 2, len(servers), "The number of servers does not match")
+	spec.Equal(t, "Nonvoter", servers[1].Suffrage, "The replica should join as a non-voter")
+
+	// the replica shows up in the cluster info
+	spec.RetryOnFalse(t, 50, 200*time.Millisecond, func() bool {
+		node, ok := r0.ClusterInfo().Nodes[r1.info.NodeId]
+		return ok && node.Nonvoter
+	}, "The replica should be reported as a non-voter")
+
+	// the replica applies the events
+	_, err = r0.Add([]byte("Test event"))
+	spec.NoError(t, err)
+	spec.RetryOnFalse(t, 50, 200*time.Millisecond, func() bool {
+		return r1.balloon.Version() == 1
+	}, "The replica should apply the events")
+}
+
+func TestMultiRaftNodeDrain(t *testing.T) {
+
+	// start one seed
+	r0, clean0, err := newSeed(t.Name(), 0)
+	spec.NoError(t, err)
+	defer func() {
+		spec.NoError(t, r0.Close(true))
+		clean0(true)
+	}()
+
+	spec.RetryOnFalse(t, 50, 200*time.Millisecond, r0.IsLeader, "A single node is not leader!")
+
+	// start a read replica and a follower
+	r1, clean1, err := newReplica(t.Name(), 1, r0.info.RaftAddr)
+	spec.NoError(t, err)
+	defer func() {
+		spec.NoError(t, r1.Close(true))
+		clean1(true)
+	}()
+	r2, clean2, err := newFollower(t.Name(), 2, r0.info.RaftAddr)
+	spec.NoError(t, err)
+	defer func() {
+		spec.NoError(t, r2.Close(true))
+		clean2(true)
+	}()
+
+	_, err = r0.Add([]byte("Test event"))
+	spec.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	spec.NoError(t, r0.Drain(ctx))
+
+	// new writes are rejected
+	_, err = r0.Add([]byte("Another event"))
+	spec.Equal(t, ErrDraining, err, "A draining node should reject writes")
+
+	// the leadership goes to the follower, not to the replica
+	spec.RetryOnFalse(t, 50, 200*time.Millisecond, r2.IsLeader, "The follower should be the new leader")
+	spec.Equal(t, uint64(1), r2.balloon.Version(), "The new leader should have all the events")
+}
+
+func TestRaftNodeNonvoterCannotBootstrap(t *testing.T) {
+	opts := DefaultClusteringOptions()
+	opts.Bootstrap = true
+	opts.Nonvoter = true
+	_, err := NewRaftNode(opts, nil, nil, nil)
+	spec.Equal(t, ErrNonvoterBootstrap, err, "A non-voter should not bootstrap")
+}
+
+func TestMultiRaftNodeReadConsistency(t *testing.T) {
+
+	// start one seed
+	r0, clean0, err := newSeed(t.Name(), 0)
+	spec.NoError(t, err)
+	defer func() {
+		spec.NoError(t, r0.Close(true))
+		clean0(true)
+	}()
+
+	spec.RetryOnFalse(t, 50, 200*time.Millisecond, r0.IsLeader, "A single node is not leader!")
+
+	// start one follower and join the cluster
+	r1, clean1, err := newFollower(t.Name(), 1, r0.info.RaftAddr)
+	spec.NoError(t, err)
+	defer func() {
+		spec.NoError(t, r1.Close(true))
+		clean1(true)
+	}()
+
+	_, err = r0.AddBulk([][]byte{[]byte("Test event 1"), []byte("Test event 2")})
+	spec.NoError(t, err)
+
+	// stale reads are always served
+	spec.NoError(t, r1.WaitForConsistency(protocol.ReadStale, 0))
+
+	// bounded reads are rejected if the version is not applied
+	spec.Equal(t, ErrStaleRead, r1.WaitForConsistency(protocol.ReadBounded, 100), "The read should be rejected")
+
+	// linearizable reads wait until the follower catches up with the leader
+	spec.NoError(t, r1.WaitForConsistency(protocol.ReadLinearizable, 0))
+	spec.Equal(t, uint64(2), r1.balloon.Version(), "The follower should have applied all the events")
+	spec.NoError(t, r1.WaitForConsistency(protocol.ReadBounded, 1))
+
+	spec.Equal(t, ErrUnknownConsistency, r1.WaitForConsistency("eventual", 0), "Unknown levels should be rejected")
+}
+
+func TestMultiRaftNodeAdminCommands(t *testing.T) {
+
+	// start one seed
+	r0, clean0, err := newSeed(t.Name(), 0)
+	spec.NoError(t, err)
+	defer func() {
+		spec.NoError(t, r0.Close(true))
+		clean0(true)
+	}()
+
+	spec.RetryOnFalse(t, 50, 200*time.Millisecond, r0.IsLeader, "A single node is not leader!")
+
+	// start one follower and join the cluster
+	r1, clean1, err := newFollower(t.Name(), 1, r0.info.RaftAddr)
+	spec.NoError(t, err)
+	defer func() {
+		spec.NoError(t, r1.Close(true))
+		clean1(true)
+	}()
+
+	// the hashing scheme can only be changed on an empty balloon
+	spec.NoError(t, r0.SetHashingScheme("blake2b"))
+	spec.Equal(t, ErrUnknownHashing, r0.SetHashingScheme("md5"), "Unknown schemes should be rejected")
+
+	_, err = r0.Add([]byte("Test event 1"))
+	spec.NoError(t, err)
+	spec.Equal(t, ErrHashingInUse, r0.SetHashingScheme("sha256"), "The scheme of a non empty balloon should not change")
+
+	// admin commands must be sent to the leader
+	spec.Equal(t, raft.ErrNotLeader, r1.SetReadOnly(true), "Followers should not accept admin commands")
+
+	// only the public key is replicated, every node holds the private one
+	keysPath := mustTempDir()
+	defer os.RemoveAll(keysPath)
+	publicKeyPath, _, err := crypto.NewEd25519SignerKeysFile(keysPath)
+	spec.NoError(t, err)
+	publicKey, err := ioutil.ReadFile(publicKeyPath)
+	spec.NoError(t, err)
+	unknownKey, _, err := ed25519.GenerateKey(rand.Reader)
+	spec.NoError(t, err)
+
+	spec.Equal(t, ErrSigningKeyNotFound, r0.SetSigningKey(publicKey), "Keys not held by the leader should be rejected")
+	r0.signingKeysPath, r1.signingKeysPath = keysPath, keysPath
+	spec.NoError(t, r0.SetSigningKey(publicKey))
+	spec.Equal(t, ErrInvalidSigningKey, r0.SetSigningKey([]byte("short key")), "Invalid keys should be rejected")
+	spec.Equal(t, ErrSigningKeyNotFound, r0.SetSigningKey(unknownKey), "Unknown keys should be rejected")
+	spec.NoError(t, r0.SetBackupRetention(2))
+	spec.NoError(t, r0.SetReadOnly(true))
+
+	// read-only mode rejects events on every node
+	_, err = r0.Add([]byte("Test event 2"))
+	spec.Equal(t, ErrReadOnly, err, "Events should be rejected in read-only mode")
+
+	// followers switch to the same settings
+	spec.NoError(t, r1.WaitForConsistency(protocol.ReadLinearizable, 0))
+	spec.RetryOnFalse(t, 50, 100*time.Millisecond, func() bool {
+		return r1.Settings().ReadOnly
+	}, "The follower should apply the admin commands")
+	spec.Equal(t, r0.Settings(), r1.Settings(), "Settings should be the same on every node")
+	spec.Equal(t, "blake2b", r1.Settings().HashingScheme, "Wrong hashing scheme")
+	spec.Equal(t, uint32(2), r1.Settings().BackupRetention, "Wrong backup retention")
+	spec.Equal(t, publicKey, r1.Settings().SigningPublicKey, "Wrong signing key")
+
+	message := []byte("signed by the cluster")
+	sig, err := r1.Signer().Sign(message)
+	spec.NoError(t, err)
+	spec.True(t, ed25519.Verify(publicKey, message, sig), "The follower should sign with the replicated key")
+
+	// backups beyond the retention are deleted
+	for i := 0; i < 3; i++ {
+		spec.NoError(t, r0.CreateBackup())
+	}
+	spec.Equal(t, 2, len(r0.ListBackups()), "Only the last backups should be kept")
+
+	spec.NoError(t, r0.SetReadOnly(false))
+	_, err = r0.Add([]byte("Test event 2"))
+	spec.NoError(t, err)
+}
+
 type closeF func(dir bool)
 
 func raftAddr(id int) string {
@@ -306,6 +571,25 @@ func newFollower(name string, id int, seeds ...string) (*RaftNode, closeF, error
 	return newNode(opts, rocksOpts)
 }
 
+func newReplica(name string, id int, seeds ...string) (*RaftNode, closeF, error) {
+	opts := DefaultClusteringOptions()
+	opts.NodeID = fmt.Sprintf("%s_%d", name, id)
+	opts.Addr = raftAddr(id)
+	opts.MgmtAddr = mgmtAddr(id)
+	opts.HttpAddr = httpAddr(id)
+	opts.Bootstrap = false
+	opts.Nonvoter = true
+	opts.SnapshotThreshold = 0
+	opts.TrailingLogs = 0
+	opts.RaftLogging = true
+	opts.RaftHeartbeatTimeout = 2000 * time.Millisecond
+	opts.RaftElectionTimeout = 2000 * time.Millisecond
+	opts.RaftLeaseTimeout = 2000 * time.Millisecond
+	opts.Seeds = seeds
+	rocksOpts := rocks.DefaultOptions()
+	return newNode(opts, rocksOpts)
+}
+
 func newNode(opts *ClusteringOptions, rocksOpts *rocks.Options) (*RaftNode, closeF, error) {
 
 	snapshotsCh := make(chan *protocol.Snapshot, 25000)
@@ -17,6 +17,7 @@
 package consensus
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
@@ -30,6 +31,7 @@ import (
 	"github.com/hashicorp/raft"
 	"github.com/soheilhy/cmux"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 var (
@@ -91,7 +93,11 @@ func NewCMuxTCPStreamLayer(bindAddr string, tlsC *tlsutil.TLSConfigurator, grpcS
 
 	// Create the protocol Server
 	var grpcS *grpc.Server
-	grpcS = grpc.NewServer()
+	if tlsConf != nil {
+		grpcS = grpc.NewServer(grpc.Creds(listenerTLSCredentials{}))
+	} else {
+		grpcS = grpc.NewServer()
+	}
 	grpcServiceRegister(grpcS)
 
 	// Use the muxed listeners for your servers
@@ -125,6 +131,38 @@ func NewCMuxTCPStreamLayer(bindAddr string, tlsC *tlsutil.TLSConfigurator, grpcS
 	return stream, nil
 }
 
+// listenerTLSCredentials exposes the TLS state of the connections accepted
+// by the TLS listener to the gRPC services, so they can authorize peers by
+// their certificates. The TLS handshake itself is done by the listener.
+type listenerTLSCredentials struct{}
+
+func (listenerTLSCredentials) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, errors.New("listener credentials cannot be used by clients")
+}
+
+func (listenerTLSCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	c := conn
+	if mc, ok := c.(*cmux.MuxConn); ok {
+		c = mc.Conn
+	}
+	if tc, ok := c.(*tls.Conn); ok {
+		return conn, credentials.TLSInfo{State: tc.ConnectionState()}, nil
+	}
+	return conn, nil, nil
+}
+
+func (listenerTLSCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "tls"}
+}
+
+func (c listenerTLSCredentials) Clone() credentials.TransportCredentials {
+	return c
+}
+
+func (listenerTLSCredentials) OverrideServerName(string) error {
+	return nil
+}
+
 // Dial implements the StreamLayer interface.
 func (l *CMuxTCPStreamLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
 	dialer := &net.Dialer{Timeout: timeout}
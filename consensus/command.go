@@ -27,8 +27,31 @@ type commandType uint8
 
 const (
 	addEventCommandType commandType = iota // Commands which modify the database.
+
+	// Admin commands which modify the cluster settings.
+	setSigningKeyCommandType
+	setHashingCommandType
+	setReadOnlyCommandType
+	setBackupRetentionCommandType
 )
 
+func (t commandType) String() string {
+	switch t {
+	case addEventCommandType:
+		return "add"
+	case setSigningKeyCommandType:
+		return "set-signing-key"
+	case setHashingCommandType:
+		return "set-hashing"
+	case setReadOnlyCommandType:
+		return "set-read-only"
+	case setBackupRetentionCommandType:
+		return "set-backup-retention"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(t))
+	}
+}
+
 type command struct {
 	id   commandType
 	data []byte
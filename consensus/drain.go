@@ -0,0 +1,120 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package consensus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/raft"
+)
+
+// ErrDraining is raised when a write reaches a node that is shutting down.
+var ErrDraining = errors.New("Node is draining")
+
+// beginWrite registers a new write, unless the node is draining. Every
+// successful call must be followed by a call to endWrite.
+func (n *RaftNode) beginWrite() error {
+	n.drainLock.RLock()
+	defer n.drainLock.RUnlock()
+	if n.draining {
+		return ErrDraining
+	}
+	n.writes.Add(1)
+	return nil
+}
+
+func (n *RaftNode) endWrite() {
+	n.writes.Done()
+}
+
+// Drain prepares the node to shut down. It stops accepting new writes,
+// waits for the pending proposals to finish and, if the node is the
+// leader, hands the leadership over to the most up to date voter, so
+// clients only wait for a leadership transfer instead of an election
+// timeout. The context bounds the whole sequence.
+func (n *RaftNode) Drain(ctx context.Context) error {
+	n.drainLock.Lock()
+	n.draining = true
+	n.drainLock.Unlock()
+
+	n.log.Info("Draining: waiting for pending writes")
+	done := make(chan struct{})
+	go func() {
+		n.writes.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for pending writes: %v", ctx.Err())
+	}
+
+	if !n.IsLeader() {
+		return nil
+	}
+
+	target, err := n.pickLeadershipTarget(ctx)
+	if err != nil {
+		return err
+	}
+	if target == nil {
+		n.log.Info("Draining: no voter to hand the leadership over to")
+		return nil
+	}
+
+	n.log.Infof("Draining: transferring leadership to %q at %q", target.ID, target.Address)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- n.raft.LeadershipTransferToServer(target.ID, target.Address).Error()
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("transferring leadership: %v", ctx.Err())
+	}
+}
+
+// pickLeadershipTarget returns the reachable voter with the highest
+// last log index, or nil if there is none. Non-voters are skipped as
+// they cannot take the leadership.
+func (n *RaftNode) pickLeadershipTarget(ctx context.Context) (*raft.Server, error) {
+	configFuture := n.raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return nil, err
+	}
+
+	var target *raft.Server
+	var lastIndex uint64
+	for _, srv := range configFuture.Configuration().Servers {
+		if srv.Suffrage != raft.Voter || srv.ID == raft.ServerID(n.info.NodeId) {
+			continue
+		}
+		resp, err := n.grpcFetchInfo(ctx, string(srv.Address))
+		if err != nil {
+			n.log.Infof("Draining: error getting node info from %s: %v", srv.Address, err)
+			continue
+		}
+		if target == nil || resp.LastIndex > lastIndex {
+			srv := srv
+			target, lastIndex = &srv, resp.LastIndex
+		}
+	}
+	return target, nil
+}
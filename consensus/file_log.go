@@ -0,0 +1,584 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package consensus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bbva/qed/metrics"
+	"github.com/bbva/qed/util"
+	"github.com/hashicorp/go-msgpack/codec"
+	"github.com/hashicorp/raft"
+)
+
+const (
+	// fileLogSegmentSize is the size after which a new segment is started.
+	fileLogSegmentSize = 64 * 1024 * 1024
+
+	fileLogSegmentExt  = ".log"
+	fileLogStableFile  = "stable"
+	fileLogHeadFile    = "head"
+	fileLogHeaderBytes = 8 // checksum + length
+)
+
+// ErrLogChecksum is raised when a Raft log entry read from disk does not
+// match its checksum.
+var ErrLogChecksum = errors.New("Raft log entry checksum mismatch")
+
+// fileLog implements both the raft LogStore and Stable interfaces
+// on plain files, so the Raft log does not depend on RocksDB.
+//
+// Log entries are appended to segment files named after the index of their
+// first entry. Every entry is written as a record with a CRC-32C checksum and
+// its length, and an in-memory index keeps the offset of each record. Raft
+// only deletes entries from the head (compaction) or the tail (conflicts):
+// head deletions drop whole segments and persist the new first index, while
+// tail deletions truncate the segment files.
+//
+// The stable store is a small msgpack file which is atomically rewritten
+// on every set, as raft only updates it on elections.
+type fileLog struct {
+	sync.RWMutex
+
+	// The path to the log directory.
+	path        string
+	noSync      bool
+	segmentSize int64
+	codec       *codec.MsgpackHandle
+
+	segments []*logSegment
+	// head is the first index not deleted from the first segment.
+	head uint64
+
+	stable map[string][]byte
+
+	// metrics
+	metrics *fileLogMetrics
+}
+
+// logSegment is a segment file holding consecutive log entries.
+type logSegment struct {
+	first   uint64
+	offsets []int64 // offset of each record
+	size    int64
+	file    *os.File
+}
+
+func (s *logSegment) last() uint64 {
+	return s.first + uint64(len(s.offsets)) - 1
+}
+
+// newFileLog opens or creates the file log at the given path.
+func newFileLog(path string, noSync bool) (*fileLog, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+
+	store := &fileLog{
+		path:        path,
+		noSync:      noSync,
+		segmentSize: fileLogSegmentSize,
+		codec:       &codec.MsgpackHandle{},
+		stable:      make(map[string][]byte),
+	}
+
+	if err := store.loadStable(); err != nil {
+		return nil, err
+	}
+	if err := store.loadSegments(); err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	store.metrics = newFileLogMetrics(store)
+
+	return store, nil
+}
+
+func (s *fileLog) loadStable() error {
+	buf, err := ioutil.ReadFile(filepath.Join(s.path, fileLogStableFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return codec.NewDecoderBytes(buf, s.codec).Decode(&s.stable)
+}
+
+func (s *fileLog) loadSegments() error {
+	buf, err := ioutil.ReadFile(filepath.Join(s.path, fileLogHeadFile))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(buf) == 8 {
+		s.head = util.BytesAsUint64(buf)
+	}
+
+	names, err := filepath.Glob(filepath.Join(s.path, "*"+fileLogSegmentExt))
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		var first uint64
+		base := strings.TrimSuffix(filepath.Base(name), fileLogSegmentExt)
+		if _, err := fmt.Sscanf(base, "%d", &first); err != nil {
+			return fmt.Errorf("invalid log segment name %s", name)
+		}
+		seg, err := openLogSegment(name, first, i == len(names)-1)
+		if err != nil {
+			return err
+		}
+		// segments left behind by an interrupted deletion
+		if len(seg.offsets) == 0 || seg.last() < s.head {
+			seg.file.Close()
+			if err := os.Remove(name); err != nil {
+				return err
+			}
+			continue
+		}
+		if n := len(s.segments); n > 0 && s.segments[n-1].last()+1 != seg.first {
+			seg.file.Close()
+			return fmt.Errorf("gap in the Raft log before segment %s", name)
+		}
+		s.segments = append(s.segments, seg)
+	}
+
+	return nil
+}
+
+// openLogSegment opens and indexes a segment file. A partially written
+// record at the end of the last segment is discarded.
+func openLogSegment(name string, first uint64, last bool) (*logSegment, error) {
+	file, err := os.OpenFile(name, os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	seg := &logSegment{first: first, file: file}
+
+	r := &offsetReader{r: file}
+	header := make([]byte, fileLogHeaderBytes)
+	for {
+		offset := r.offset
+		_, err := io.ReadFull(r, header)
+		if err == io.EOF {
+			break
+		}
+		var data []byte
+		if err == nil {
+			data = make([]byte, binary.BigEndian.Uint32(header[4:]))
+			_, err = io.ReadFull(r, data)
+		}
+		if err == nil && crc32.Checksum(data, crcTable) != binary.BigEndian.Uint32(header) {
+			err = ErrLogChecksum
+		}
+		if err != nil {
+			if !last || (err != io.ErrUnexpectedEOF && err != ErrLogChecksum) {
+				file.Close()
+				return nil, fmt.Errorf("corrupted log segment %s: %v", name, err)
+			}
+			if err := file.Truncate(offset); err != nil {
+				file.Close()
+				return nil, err
+			}
+			break
+		}
+		seg.offsets = append(seg.offsets, offset)
+		seg.size = r.offset
+	}
+
+	return seg, nil
+}
+
+// offsetReader tracks the number of bytes read.
+type offsetReader struct {
+	r      io.Reader
+	offset int64
+}
+
+func (r *offsetReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+// Close is used to gracefully close the log files.
+func (s *fileLog) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	var err error
+	for _, seg := range s.segments {
+		if cerr := seg.file.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	s.segments = nil
+	return err
+}
+
+// FirstIndex returns the first known index from the Raft log.
+func (s *fileLog) FirstIndex() (uint64, error) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.firstIndex(), nil
+}
+
+func (s *fileLog) firstIndex() uint64 {
+	if len(s.segments) == 0 {
+		return 0
+	}
+	if first := s.segments[0].first; first > s.head {
+		return first
+	}
+	return s.head
+}
+
+// LastIndex returns the last known index from the Raft log.
+func (s *fileLog) LastIndex() (uint64, error) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.lastIndex(), nil
+}
+
+func (s *fileLog) lastIndex() uint64 {
+	if len(s.segments) == 0 {
+		return 0
+	}
+	return s.segments[len(s.segments)-1].last()
+}
+
+// GetLog gets a log entry at a given index.
+func (s *fileLog) GetLog(index uint64, log *raft.Log) error {
+	s.RLock()
+	defer s.RUnlock()
+
+	if len(s.segments) == 0 || index < s.firstIndex() || index > s.lastIndex() {
+		return raft.ErrLogNotFound
+	}
+	i := sort.Search(len(s.segments), func(i int) bool {
+		return s.segments[i].last() >= index
+	})
+	seg := s.segments[i]
+	pos := index - seg.first
+	offset := seg.offsets[pos]
+	end := seg.size
+	if pos+1 < uint64(len(seg.offsets)) {
+		end = seg.offsets[pos+1]
+	}
+
+	buf := make([]byte, end-offset)
+	if _, err := seg.file.ReadAt(buf, offset); err != nil {
+		return err
+	}
+	data := buf[fileLogHeaderBytes:]
+	if crc32.Checksum(data, crcTable) != binary.BigEndian.Uint32(buf) {
+		return ErrLogChecksum
+	}
+	s.metrics.read(len(buf))
+
+	return codec.NewDecoderBytes(data, s.codec).Decode(log)
+}
+
+// StoreLog stores a single raft log.
+func (s *fileLog) StoreLog(log *raft.Log) error {
+	return s.StoreLogs([]*raft.Log{log})
+}
+
+// StoreLogs stores a set of raft logs.
+func (s *fileLog) StoreLogs(logs []*raft.Log) error {
+	s.Lock()
+	defer s.Unlock()
+
+	var buf []byte
+	var seg *logSegment
+	var written int
+	flush := func() error {
+		if seg == nil || len(buf) == 0 {
+			return nil
+		}
+		if _, err := seg.file.WriteAt(buf, seg.size); err != nil {
+			return err
+		}
+		seg.size += int64(len(buf))
+		written += len(buf)
+		buf = buf[:0]
+		return s.sync(seg.file)
+	}
+	// rollback forgets the entries of the batch that were not written
+	rollback := func(err error) error {
+		if seg != nil {
+			for n := len(seg.offsets); n > 0 && seg.offsets[n-1] >= seg.size; n-- {
+				seg.offsets = seg.offsets[:n-1]
+			}
+			if len(seg.offsets) == 0 {
+				s.truncate(seg.first)
+			}
+		}
+		return err
+	}
+
+	for _, log := range logs {
+		if len(s.segments) > 0 && log.Index != s.lastIndex()+1 {
+			return rollback(fmt.Errorf("out of order Raft log entry %d, expected %d", log.Index, s.lastIndex()+1))
+		}
+
+		data, err := s.encodeRaftLog(log)
+		if err != nil {
+			return rollback(err)
+		}
+
+		if seg == nil || seg.size+int64(len(buf)) >= s.segmentSize {
+			if err := flush(); err != nil {
+				return rollback(err)
+			}
+			seg, err = s.activeSegment(log.Index)
+			if err != nil {
+				return rollback(err)
+			}
+		}
+
+		header := make([]byte, fileLogHeaderBytes)
+		binary.BigEndian.PutUint32(header, crc32.Checksum(data, crcTable))
+		binary.BigEndian.PutUint32(header[4:], uint32(len(data)))
+		seg.offsets = append(seg.offsets, seg.size+int64(len(buf)))
+		buf = append(buf, header...)
+		buf = append(buf, data...)
+	}
+
+	if err := flush(); err != nil {
+		return rollback(err)
+	}
+	s.metrics.write(len(logs), written)
+	return nil
+}
+
+// activeSegment returns the segment where the given index is appended,
+// creating a new one if the log is empty or the last segment is full.
+func (s *fileLog) activeSegment(index uint64) (*logSegment, error) {
+	if n := len(s.segments); n > 0 && s.segments[n-1].size < s.segmentSize {
+		return s.segments[n-1], nil
+	}
+
+	name := filepath.Join(s.path, fmt.Sprintf("%020d%s", index, fileLogSegmentExt))
+	file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.syncDir(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	seg := &logSegment{first: index, file: file}
+	s.segments = append(s.segments, seg)
+	return seg, nil
+}
+
+// DeleteRange deletes logs within a given range inclusively.
+func (s *fileLog) DeleteRange(min, max uint64) error {
+	s.Lock()
+	defer s.Unlock()
+
+	first, last := s.firstIndex(), s.lastIndex()
+	if len(s.segments) == 0 || max < first || min > last {
+		return nil
+	}
+
+	switch {
+	case min <= first && max >= last:
+		if err := s.setHead(0); err != nil {
+			return err
+		}
+		return s.removeSegments(len(s.segments))
+	case min <= first:
+		// compaction: persist the new first index before removing
+		// the segments, so an interrupted deletion is completed
+		// when the log is opened again.
+		if err := s.setHead(max + 1); err != nil {
+			return err
+		}
+		n := sort.Search(len(s.segments), func(i int) bool {
+			return s.segments[i].last() > max
+		})
+		return s.removeSegments(n)
+	case max >= last:
+		return s.truncate(min)
+	default:
+		return fmt.Errorf("cannot delete Raft log entries %d to %d from the middle of the log", min, max)
+	}
+}
+
+// removeSegments removes the first n segments.
+func (s *fileLog) removeSegments(n int) error {
+	for _, seg := range s.segments[:n] {
+		seg.file.Close()
+		if err := os.Remove(seg.file.Name()); err != nil {
+			return err
+		}
+	}
+	s.segments = s.segments[n:]
+	return s.syncDir()
+}
+
+// truncate removes every entry from the given index onwards.
+func (s *fileLog) truncate(index uint64) error {
+	for len(s.segments) > 0 {
+		seg := s.segments[len(s.segments)-1]
+		if seg.first >= index {
+			seg.file.Close()
+			if err := os.Remove(seg.file.Name()); err != nil {
+				return err
+			}
+			s.segments = s.segments[:len(s.segments)-1]
+			continue
+		}
+		if index <= seg.last() {
+			pos := index - seg.first
+			if err := seg.file.Truncate(seg.offsets[pos]); err != nil {
+				return err
+			}
+			seg.size = seg.offsets[pos]
+			seg.offsets = seg.offsets[:pos]
+			if err := s.sync(seg.file); err != nil {
+				return err
+			}
+		}
+		break
+	}
+	return s.syncDir()
+}
+
+func (s *fileLog) setHead(index uint64) error {
+	s.head = index
+	return s.writeFile(fileLogHeadFile, util.Uint64AsBytes(index))
+}
+
+// Set is used to set a key/value set outside of the raft log.
+func (s *fileLog) Set(key []byte, val []byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	stable := make(map[string][]byte, len(s.stable)+1)
+	for k, v := range s.stable {
+		stable[k] = v
+	}
+	stable[string(key)] = append([]byte(nil), val...)
+
+	var buf []byte
+	if err := codec.NewEncoderBytes(&buf, s.codec).Encode(stable); err != nil {
+		return err
+	}
+	if err := s.writeFile(fileLogStableFile, buf); err != nil {
+		return err
+	}
+	s.stable = stable
+	return nil
+}
+
+// Get is used to retrieve a value from the k/v store by key
+func (s *fileLog) Get(key []byte) ([]byte, error) {
+	s.RLock()
+	defer s.RUnlock()
+	val, ok := s.stable[string(key)]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return val, nil
+}
+
+// SetUint64 is like Set, but handles uint64 values
+func (s *fileLog) SetUint64(key []byte, val uint64) error {
+	return s.Set(key, util.Uint64AsBytes(val))
+}
+
+// GetUint64 is like Get, but handles uint64 values
+func (s *fileLog) GetUint64(key []byte) (uint64, error) {
+	val, err := s.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	return util.BytesAsUint64(val), nil
+}
+
+func (s *fileLog) RegisterMetrics(registry metrics.Registry) {
+	if registry != nil {
+		registry.MustRegister(s.metrics.collectors()...)
+	}
+}
+
+// writeFile atomically replaces the content of the given file.
+func (s *fileLog) writeFile(name string, data []byte) error {
+	path := filepath.Join(s.path, name)
+	tmp, err := os.OpenFile(path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := s.sync(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	return s.syncDir()
+}
+
+func (s *fileLog) sync(file *os.File) error {
+	if s.noSync {
+		return nil
+	}
+	s.metrics.synced()
+	return file.Sync()
+}
+
+func (s *fileLog) syncDir() error {
+	if s.noSync {
+		return nil
+	}
+	dir, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// Encode writes an encoded object to a new bytes buffer
+func (s *fileLog) encodeRaftLog(in *raft.Log) ([]byte, error) {
+	var buf []byte
+	enc := codec.NewEncoderBytes(&buf, s.codec)
+	err := enc.Encode(in)
+	return buf, err
+}
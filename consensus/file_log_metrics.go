@@ -0,0 +1,183 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package consensus
+
+import (
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// fileLogMetrics are the metrics of the file log. They are published with
+// the same names as the equivalent RocksDB log metrics, so dashboards keep
+// working when switching the log store.
+type fileLogMetrics struct {
+	keysWritten  uint64
+	keysRead     uint64
+	bytesWritten uint64
+	bytesRead    uint64
+	syncs        uint64
+
+	KeysWritten     prometheus.GaugeFunc
+	KeysRead        prometheus.GaugeFunc
+	BytesRead       prometheus.GaugeFunc
+	BytesWritten    prometheus.GaugeFunc
+	WALFilesSynced  prometheus.GaugeFunc
+	WALFileBytes    prometheus.GaugeFunc
+	LogNumKeys      prometheus.GaugeFunc
+	LogLiveDataSize prometheus.GaugeFunc
+	StableNumKeys   prometheus.GaugeFunc
+}
+
+func newFileLogMetrics(store *fileLog) *fileLogMetrics {
+	m := new(fileLogMetrics)
+	counter := func(v *uint64) func() float64 {
+		return func() float64 {
+			return float64(atomic.LoadUint64(v))
+		}
+	}
+	m.KeysWritten = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: raftLogNamespace,
+			Subsystem: ioSubsystem,
+			Name:      "keys_written",
+			Help:      "Number of keys written via puts and writes.",
+		},
+		counter(&m.keysWritten),
+	)
+	m.KeysRead = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: raftLogNamespace,
+			Subsystem: ioSubsystem,
+			Name:      "keys_read",
+			Help:      "Number of keys read.",
+		},
+		counter(&m.keysRead),
+	)
+	m.BytesRead = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: raftLogNamespace,
+			Subsystem: ioSubsystem,
+			Name:      "bytes_read",
+			Help:      "Number of uncompressed bytes read.",
+		},
+		counter(&m.bytesRead),
+	)
+	m.BytesWritten = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: raftLogNamespace,
+			Subsystem: ioSubsystem,
+			Name:      "bytes_written",
+			Help:      "Number of uncompressed bytes written.",
+		},
+		counter(&m.bytesWritten),
+	)
+	m.WALFilesSynced = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: raftLogNamespace,
+			Subsystem: ioSubsystem,
+			Name:      "wal_files_synced",
+			Help:      "Number of times WAL sync is done.",
+		},
+		counter(&m.syncs),
+	)
+	m.WALFileBytes = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: raftLogNamespace,
+			Subsystem: ioSubsystem,
+			Name:      "wal_file_bytes",
+			Help:      "Number of bytes written to WAL.",
+		},
+		counter(&m.bytesWritten),
+	)
+	m.LogNumKeys = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: raftLogNamespace,
+			Subsystem: "cf_" + logTable.String(),
+			Name:      "estimated_num_keys",
+			Help:      "Estimated number of total keys in the storage.",
+		},
+		func() float64 {
+			store.RLock()
+			defer store.RUnlock()
+			if len(store.segments) == 0 {
+				return 0
+			}
+			return float64(store.lastIndex() - store.firstIndex() + 1)
+		},
+	)
+	m.LogLiveDataSize = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: raftLogNamespace,
+			Subsystem: "cf_" + logTable.String(),
+			Name:      "estimated_live_data_size",
+			Help:      "Estimate of the amount of live data (bytes).",
+		},
+		func() float64 {
+			store.RLock()
+			defer store.RUnlock()
+			var size int64
+			for _, seg := range store.segments {
+				size += seg.size
+			}
+			return float64(size)
+		},
+	)
+	m.StableNumKeys = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: raftLogNamespace,
+			Subsystem: "cf_" + stableTable.String(),
+			Name:      "estimated_num_keys",
+			Help:      "Estimated number of total keys in the storage.",
+		},
+		func() float64 {
+			store.RLock()
+			defer store.RUnlock()
+			return float64(len(store.stable))
+		},
+	)
+	return m
+}
+
+func (m *fileLogMetrics) write(keys, bytes int) {
+	atomic.AddUint64(&m.keysWritten, uint64(keys))
+	atomic.AddUint64(&m.bytesWritten, uint64(bytes))
+}
+
+func (m *fileLogMetrics) read(bytes int) {
+	atomic.AddUint64(&m.keysRead, 1)
+	atomic.AddUint64(&m.bytesRead, uint64(bytes))
+}
+
+func (m *fileLogMetrics) synced() {
+	atomic.AddUint64(&m.syncs, 1)
+}
+
+// collectors satisfies the prom.PrometheusCollector interface.
+func (m *fileLogMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.KeysWritten,
+		m.KeysRead,
+		m.BytesRead,
+		m.BytesWritten,
+		m.WALFilesSynced,
+		m.WALFileBytes,
+		m.LogNumKeys,
+		m.LogLiveDataSize,
+		m.StableNumKeys,
+	}
+}
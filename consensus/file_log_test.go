@@ -0,0 +1,200 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package consensus
+
+import (
+	"os"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileLogImplementsInterfaces(t *testing.T) {
+	var store interface{} = &fileLog{}
+	if _, ok := store.(raft.StableStore); !ok {
+		t.Fatalf("fileLog does not implement raft.StableStore")
+	}
+	if _, ok := store.(raft.LogStore); !ok {
+		t.Fatalf("fileLog does not implement raft.LogStore")
+	}
+}
+
+func TestFileLogStoreAndGet(t *testing.T) {
+
+	store, _, closeF := openFileLog(t)
+	defer closeF()
+
+	// Should get 0 indexes on empty log
+	first, err := store.FirstIndex()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), first)
+	last, err := store.LastIndex()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), last)
+
+	require.NoError(t, store.StoreLog(fakeRaftLog(1, "log1")))
+	require.NoError(t, store.StoreLogs([]*raft.Log{
+		fakeRaftLog(2, "log2"),
+		fakeRaftLog(3, "log3"),
+	}))
+
+	first, _ = store.FirstIndex()
+	last, _ = store.LastIndex()
+	require.Equal(t, uint64(1), first)
+	require.Equal(t, uint64(3), last)
+
+	for i, data := range []string{"log1", "log2", "log3"} {
+		var l raft.Log
+		require.NoError(t, store.GetLog(uint64(i+1), &l))
+		require.Equal(t, fakeRaftLog(uint64(i+1), data), &l)
+	}
+
+	err = store.GetLog(4, new(raft.Log))
+	require.Equal(t, raft.ErrLogNotFound, err)
+
+	// Entries must be consecutive
+	require.Error(t, store.StoreLog(fakeRaftLog(5, "log5")))
+	last, _ = store.LastIndex()
+	require.Equal(t, uint64(3), last)
+
+}
+
+func TestFileLogDeleteRange(t *testing.T) {
+
+	store, path, closeF := openFileLog(t)
+	defer closeF()
+	store.segmentSize = 64 // a few entries per segment
+
+	logs := make([]*raft.Log, 0)
+	for i := uint64(1); i <= 100; i++ {
+		logs = append(logs, fakeRaftLog(i, "log"))
+	}
+	require.NoError(t, store.StoreLogs(logs))
+	require.True(t, len(store.segments) > 1)
+
+	// compaction
+	require.NoError(t, store.DeleteRange(1, 42))
+	first, _ := store.FirstIndex()
+	require.Equal(t, uint64(43), first)
+	require.Equal(t, raft.ErrLogNotFound, store.GetLog(42, new(raft.Log)))
+	require.NoError(t, store.GetLog(43, new(raft.Log)))
+
+	// conflicting tail
+	require.NoError(t, store.DeleteRange(90, 100))
+	last, _ := store.LastIndex()
+	require.Equal(t, uint64(89), last)
+	require.Equal(t, raft.ErrLogNotFound, store.GetLog(90, new(raft.Log)))
+	require.NoError(t, store.StoreLog(fakeRaftLog(90, "new")))
+
+	// middle of the log
+	require.Error(t, store.DeleteRange(50, 60))
+
+	// reopen the log
+	require.NoError(t, store.Close())
+	store, err := newFileLog(path, true)
+	require.NoError(t, err)
+	defer store.Close()
+
+	first, _ = store.FirstIndex()
+	last, _ = store.LastIndex()
+	require.Equal(t, uint64(43), first)
+	require.Equal(t, uint64(90), last)
+	var l raft.Log
+	require.NoError(t, store.GetLog(90, &l))
+	require.Equal(t, []byte("new"), l.Data)
+
+	// delete everything
+	require.NoError(t, store.DeleteRange(first, last))
+	first, _ = store.FirstIndex()
+	last, _ = store.LastIndex()
+	require.Equal(t, uint64(0), first)
+	require.Equal(t, uint64(0), last)
+	require.NoError(t, store.StoreLog(fakeRaftLog(200, "log200")))
+	first, _ = store.FirstIndex()
+	require.Equal(t, uint64(200), first)
+
+}
+
+func TestFileLogTornWrite(t *testing.T) {
+
+	store, path, closeF := openFileLog(t)
+	defer closeF()
+
+	require.NoError(t, store.StoreLogs([]*raft.Log{
+		fakeRaftLog(1, "log1"),
+		fakeRaftLog(2, "log2"),
+	}))
+	seg := store.segments[0]
+	require.NoError(t, store.Close())
+
+	// cut the last record in half
+	require.NoError(t, os.Truncate(seg.file.Name(), seg.size-2))
+
+	store, err := newFileLog(path, true)
+	require.NoError(t, err)
+	defer store.Close()
+
+	last, _ := store.LastIndex()
+	require.Equal(t, uint64(1), last)
+	require.NoError(t, store.StoreLog(fakeRaftLog(2, "log2")))
+	require.NoError(t, store.GetLog(2, new(raft.Log)))
+
+}
+
+func TestFileLogSetGet(t *testing.T) {
+
+	store, path, closeF := openFileLog(t)
+	defer closeF()
+
+	// Returns error on non-existent key
+	_, err := store.Get([]byte("bad"))
+	require.Equal(t, ErrKeyNotFound, err)
+	_, err = store.GetUint64([]byte("bad"))
+	require.Equal(t, ErrKeyNotFound, err)
+
+	require.NoError(t, store.Set([]byte("hello"), []byte("world")))
+	require.NoError(t, store.SetUint64([]byte("abc"), 123))
+
+	// Values survive a restart
+	require.NoError(t, store.Close())
+	store, err = newFileLog(path, true)
+	require.NoError(t, err)
+	defer store.Close()
+
+	val, err := store.Get([]byte("hello"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("world"), val)
+	n, err := store.GetUint64([]byte("abc"))
+	require.NoError(t, err)
+	require.Equal(t, uint64(123), n)
+
+}
+
+func openFileLog(t testing.TB) (*fileLog, string, func()) {
+	path := mustTempDir()
+	store, err := newFileLog(path, true)
+	if err != nil {
+		t.Errorf("Error opening fileLog store: %v", err)
+		t.FailNow()
+	}
+
+	return store, path, func() {
+		store.Close()
+		deleteFile(path)
+	}
+}
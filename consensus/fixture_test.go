@@ -0,0 +1,60 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package consensus
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/bbva/qed/balloon"
+	"github.com/bbva/qed/crypto/hashing"
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/storage"
+)
+
+// newTestNode builds a node around store without raft, loading the
+// settings, balloon and state stored in it as a starting node does.
+func newTestNode(t *testing.T, store storage.ManagedStore) *RaftNode {
+	node := &RaftNode{db: store, log: log.L()}
+	node.metrics = newRaftNodeMetrics(node)
+	require.NoError(t, node.loadSettings())
+	var err error
+	node.balloon, err = balloon.NewBalloon(store, node.hasherF)
+	require.NoError(t, err)
+	require.NoError(t, node.loadState())
+	return node
+}
+
+// addBulks adds bulks of events to the balloon in store as a node would,
+// and returns their snapshots.
+func addBulks(t *testing.T, store storage.ManagedStore, bulks, size int) []*balloon.Snapshot {
+	node := newTestNode(t, store)
+	hasher := node.hasherF()
+	var snapshots []*balloon.Snapshot
+	for i := 0; i < bulks; i++ {
+		var digests []hashing.Digest
+		for j := 0; j < size; j++ {
+			digests = append(digests, hasher.Do([]byte(fmt.Sprintf("event %d", node.balloon.Version()+uint64(j)))))
+		}
+		state := &fsmState{node.state.Index + 1, node.balloon.Version() + uint64(size) - 1}
+		resp := node.applyAdd(digests, state)
+		snapshots = append(snapshots, resp.val.([]*balloon.Snapshot)...)
+	}
+	return snapshots
+}
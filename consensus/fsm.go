@@ -37,6 +37,7 @@ type fsmResponse struct {
 type VersionMetadata struct {
 	PreviousVersion uint64
 	NewVersion      uint64
+	Settings        bool // The batch changes the cluster settings, not the balloon.
 }
 
 func (m *VersionMetadata) encode() ([]byte, error) {
@@ -110,22 +111,37 @@ func (n *RaftNode) Add(event []byte) (*balloon.Snapshot, error) {
 // As a result, it returns a bulk of shapshots, but previously it sends each snapshot
 // of the bulk to the agents channel, in order to be published/queried.
 func (n *RaftNode) AddBulk(bulk [][]byte) ([]*balloon.Snapshot, error) {
+	if err := n.beginWrite(); err != nil {
+		return nil, err
+	}
+	defer n.endWrite()
+
+	n.settingsLock.RLock()
+	hasherF, readOnly := n.hasherF, n.settings.ReadOnly
+	n.settingsLock.RUnlock()
+	if readOnly {
+		return nil, ErrReadOnly
+	}
+
 	// Hash events
 	var eventHashBulk []hashing.Digest
 	for _, event := range bulk {
-		eventHashBulk = append(eventHashBulk, n.hasherF().Do(event))
+		eventHashBulk = append(eventHashBulk, hasherF().Do(event))
 	}
 
-	// Create and apply command.
-	cmd := newCommand(addEventCommandType)
-	cmd.encode(eventHashBulk)
-	resp, err := n.propose(cmd)
+	// Merge with concurrent adds into a single command if group commit
+	// is enabled, or apply it on its own otherwise.
+	var snapshotBulk []*balloon.Snapshot
+	var err error
+	if n.batcher != nil {
+		snapshotBulk, err = n.batcher.add(eventHashBulk)
+	} else {
+		snapshotBulk, err = n.proposeAdd(eventHashBulk)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	snapshotBulk := resp.(*fsmResponse).val.([]*balloon.Snapshot)
-
 	//Send snapshot to the snapshot channel
 	// TODO move this to an upper layer (shard manager?)
 	for _, s := range snapshotBulk {
@@ -136,38 +152,54 @@ func (n *RaftNode) AddBulk(bulk [][]byte) ([]*balloon.Snapshot, error) {
 	return snapshotBulk, nil
 }
 
+// proposeAdd creates and applies an add command with the given hashes,
+// returning their snapshots in the same order.
+func (n *RaftNode) proposeAdd(hashes []hashing.Digest) ([]*balloon.Snapshot, error) {
+	cmd := newCommand(addEventCommandType)
+	cmd.encode(hashes)
+	resp, err := n.propose(cmd)
+	if err != nil {
+		return nil, err
+	}
+	fsmResp := resp.(*fsmResponse)
+	if fsmResp.err != nil {
+		return nil, fsmResp.err
+	}
+	return fsmResp.val.([]*balloon.Snapshot), nil
+}
+
 // QueryDigestMembershipConsistency acts as a passthrough when an event digest is given to
 // request a membership proof against a certain balloon version.
 func (n *RaftNode) QueryDigestMembershipConsistency(keyDigest hashing.Digest, version uint64) (*balloon.MembershipProof, error) {
 	n.metrics.DigestMembershipQueries.Inc()
-	return n.balloon.QueryDigestMembershipConsistency(keyDigest, version)
+	return n.currentBalloon().QueryDigestMembershipConsistency(keyDigest, version)
 }
 
 // QueryMembershipConsistency acts as a passthrough when an event is given to request a
 // membership proof against a certain balloon version.
 func (n *RaftNode) QueryMembershipConsistency(event []byte, version uint64) (*balloon.MembershipProof, error) {
 	n.metrics.MembershipQueries.Inc()
-	return n.balloon.QueryMembershipConsistency(event, version)
+	return n.currentBalloon().QueryMembershipConsistency(event, version)
 }
 
 // QueryDigestMembership acts as a passthrough when an event digest is given to request a
 // membership proof against the last balloon version.
 func (n *RaftNode) QueryDigestMembership(keyDigest hashing.Digest) (*balloon.MembershipProof, error) {
 	n.metrics.DigestMembershipQueries.Inc()
-	return n.balloon.QueryDigestMembership(keyDigest)
+	return n.currentBalloon().QueryDigestMembership(keyDigest)
 }
 
 // QueryMembership acts as a passthrough when an event is given to request a membership proof
 // against the last balloon version.
 func (n *RaftNode) QueryMembership(event []byte) (*balloon.MembershipProof, error) {
 	n.metrics.MembershipQueries.Inc()
-	return n.balloon.QueryMembership(event)
+	return n.currentBalloon().QueryMembership(event)
 }
 
 // QueryConsistency acts as a passthrough when requesting an incremental proof.
 func (n *RaftNode) QueryConsistency(start, end uint64) (*balloon.IncrementalProof, error) {
 	n.metrics.IncrementalQueries.Inc()
-	return n.balloon.QueryConsistency(start, end)
+	return n.currentBalloon().QueryConsistency(start, end)
 }
 
 /**************** END OF API ******************/
@@ -186,12 +218,18 @@ func (n *RaftNode) Apply(l *raft.Log) interface{} {
 		if err := cmd.decode(&eventDigests); err != nil {
 			panic(fmt.Sprintf("Unable to decode command: %v", err))
 		}
-		newState := &fsmState{l.Index, n.balloon.Version() + uint64(len(eventDigests)) - 1}
+		if n.currentSettings().ReadOnly {
+			return &fsmResponse{ErrReadOnly, nil}
+		}
+		newState := &fsmState{l.Index, n.currentBalloon().Version() + uint64(len(eventDigests)) - 1}
 		if n.state.shouldApply(newState) {
 			return n.applyAdd(eventDigests, newState)
 		}
 		return &fsmResponse{fmt.Errorf("state already applied!: %+v -> %+v", n.state, newState), nil}
 
+	case setSigningKeyCommandType, setHashingCommandType, setReadOnlyCommandType, setBackupRetentionCommandType:
+		return n.applyAdmin(cmd, l.Index)
+
 	default:
 		// ignore
 		n.log.Warnf("Unknown command: %v", cmd.id)
@@ -212,8 +250,8 @@ func (n *RaftNode) StoreConfiguration(index uint64, config raft.Configuration) {
 // guarantees that this function will not be called concurrently with Apply.
 func (n *RaftNode) Snapshot() (raft.FSMSnapshot, error) {
 	lastSeqNum := n.db.LastWALSequenceNumber()
-	n.log.Debugf("Generating snapshot until seqNum: %d (balloon version %d)", lastSeqNum, n.balloon.Version())
-	return &fsmSnapshot{lastSeqNum, n.balloon.Version()}, nil
+	n.log.Debugf("Generating snapshot until seqNum: %d (balloon version %d)", lastSeqNum, n.currentBalloon().Version())
+	return &fsmSnapshot{lastSeqNum, n.currentBalloon().Version()}, nil
 }
 
 // Restore restores the node to a previous state.
@@ -233,18 +271,16 @@ func (n *RaftNode) Restore(rc io.ReadCloser) error {
 	if n.raft != nil { // we are not restoring on startup
 
 		// we make a remote call to fetch the snapshot
-		reader, err := n.attemptToFetchSnapshot(snap.LastSeqNum, n.state.BalloonVersion)
-		if err != nil {
-			return err
-		}
-
-		if err := n.db.LoadSnapshot(reader); err != nil {
+		if err := n.fetchAndLoadSnapshot(snap.LastSeqNum); err != nil {
 			return err
 		}
 	}
 
 	n.loadState()
-	n.balloon.RefreshVersion()
+	if err := n.loadSettings(); err != nil {
+		return err
+	}
+	n.currentBalloon().RefreshVersion()
 
 	n.log.Infof("Recovering finished, new version: %d", n.state.BalloonVersion)
 
@@ -254,7 +290,7 @@ func (n *RaftNode) Restore(rc io.ReadCloser) error {
 func (n *RaftNode) applyAdd(hashes []hashing.Digest, state *fsmState) *fsmResponse {
 
 	resp := new(fsmResponse)
-	snapshotBulk, mutations, err := n.balloon.AddBulk(hashes)
+	snapshotBulk, mutations, err := n.currentBalloon().AddBulk(hashes)
 	if err != nil {
 		n.log.Panicf("Unable to add bulk: %v", err)
 	}
@@ -29,6 +29,9 @@ import (
 func (n *RaftNode) FetchNodeInfo(ctx context.Context, req *InfoRequest) (*InfoResponse, error) {
 	resp := new(InfoResponse)
 	resp.NodeInfo = n.info
+	if n.raft != nil {
+		resp.LastIndex = n.raft.LastIndex()
+	}
 	return resp, nil
 }
 
@@ -60,15 +63,18 @@ func (n *RaftNode) ClusterInfo() *ClusterInfo {
 	wg.Add(len(servers))
 	for _, srv := range servers {
 
-		go func(addr string) {
+		go func(addr string, nonvoter bool) {
 			defer wg.Done()
 			resp, err := n.grpcFetchInfo(ctx, addr)
 			if err != nil {
 				n.log.Infof("Error getting node info from %s: %v", addr, err)
 				return
 			}
+			// the cluster configuration is the source of truth for the
+			// suffrage, as servers may have been demoted since they joined.
+			resp.NodeInfo.Nonvoter = nonvoter
 			infoCh <- resp.NodeInfo
-		}(string(srv.Address))
+		}(string(srv.Address), srv.Suffrage != raft.Voter)
 	}
 
 	go func() {
@@ -113,16 +119,7 @@ func (n *RaftNode) listServers(ctx context.Context, r *raft.Raft) []raft.Server
 }
 
 func (n *RaftNode) grpcFetchInfo(ctx context.Context, addr string) (*InfoResponse, error) {
-	conf, err := n.tlsConfigurator.OutgoingTLSConfig()
-	if err != nil {
-		return nil, err
-	}
-	var conn *grpc.ClientConn
-	if conf != nil {
-		conn, err = grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(credentials.NewTLS(conf)))
-	} else {
-		conn, err = grpc.DialContext(ctx, addr, grpc.WithInsecure())
-	}
+	conn, err := n.dial(ctx, addr)
 	if err != nil {
 		return nil, err
 	}
@@ -134,3 +131,15 @@ func (n *RaftNode) grpcFetchInfo(ctx context.Context, addr string) (*InfoRespons
 	}
 	return resp, nil
 }
+
+// dial opens a gRPC connection to the cluster service of the node at addr.
+func (n *RaftNode) dial(ctx context.Context, addr string) (*grpc.ClientConn, error) {
+	conf, err := n.tlsConfigurator.OutgoingTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if conf != nil {
+		return grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(credentials.NewTLS(conf)))
+	}
+	return grpc.DialContext(ctx, addr, grpc.WithInsecure())
+}
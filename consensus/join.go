@@ -0,0 +1,114 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package consensus
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// ErrJoinUnauthorized is raised when a node asks to join the cluster
+// without a valid join token or an allowed certificate.
+var ErrJoinUnauthorized = errors.New("Unauthorized join request")
+
+// joinAuthorizer decides whether a node is allowed to join the cluster.
+// A node is allowed if it sends the pre-shared join token, or if its
+// verified TLS client certificate matches one of the identities allowed
+// for its node ID. With neither a token nor an allow-list, every join is
+// accepted.
+type joinAuthorizer struct {
+	token     string
+	allowList map[string][]string // node ID -> certificate identities
+}
+
+// newJoinAuthorizer parses an allow-list of "<node-id>=<identity>" pairs,
+// where the identity is the common name or a DNS name of the node
+// certificate.
+func newJoinAuthorizer(token string, allowList []string) (*joinAuthorizer, error) {
+	a := &joinAuthorizer{
+		token:     token,
+		allowList: make(map[string][]string),
+	}
+	for _, entry := range allowList {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid join allow-list entry %q, expected <node-id>=<identity>", entry)
+		}
+		a.allowList[parts[0]] = append(a.allowList[parts[0]], parts[1])
+	}
+	return a, nil
+}
+
+func (a *joinAuthorizer) enabled() bool {
+	return a.token != "" || len(a.allowList) > 0
+}
+
+// authorize returns nil if the request is allowed, or the reason why
+// it is not.
+func (a *joinAuthorizer) authorize(ctx context.Context, req *RaftJoinRequest) error {
+	if !a.enabled() {
+		return nil
+	}
+
+	if a.token != "" && req.JoinToken != "" {
+		if subtle.ConstantTimeCompare([]byte(a.token), []byte(req.JoinToken)) == 1 {
+			return nil
+		}
+		return errors.New("invalid join token")
+	}
+
+	if len(a.allowList) == 0 {
+		return errors.New("missing join token")
+	}
+	allowed, ok := a.allowList[req.NodeId]
+	if !ok {
+		return errors.New("node not in the join allow-list")
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return errors.New("unknown peer")
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 {
+		return errors.New("no verified client certificate")
+	}
+	cert := info.State.VerifiedChains[0][0]
+	identities := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	for _, identity := range identities {
+		for _, allowedIdentity := range allowed {
+			if identity == allowedIdentity {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("certificate %q not allowed for node %q", cert.Subject.CommonName, req.NodeId)
+}
+
+// peerAddr returns the address of the gRPC caller, if known.
+func peerAddr(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return p.Addr.String()
+	}
+	return "unknown"
+}
@@ -0,0 +1,78 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package consensus
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+func TestJoinAuthorizerDisabled(t *testing.T) {
+	a, err := newJoinAuthorizer("", nil)
+	require.NoError(t, err)
+	require.False(t, a.enabled())
+	require.NoError(t, a.authorize(context.Background(), &RaftJoinRequest{NodeId: "node1"}))
+}
+
+func TestJoinAuthorizerToken(t *testing.T) {
+	a, err := newJoinAuthorizer("secret", nil)
+	require.NoError(t, err)
+
+	require.NoError(t, a.authorize(context.Background(), &RaftJoinRequest{NodeId: "node1", JoinToken: "secret"}))
+	require.Error(t, a.authorize(context.Background(), &RaftJoinRequest{NodeId: "node1", JoinToken: "wrong"}))
+	require.Error(t, a.authorize(context.Background(), &RaftJoinRequest{NodeId: "node1"}))
+}
+
+func TestJoinAuthorizerAllowList(t *testing.T) {
+	_, err := newJoinAuthorizer("", []string{"node1"})
+	require.Error(t, err)
+
+	a, err := newJoinAuthorizer("secret", []string{"node1=node1.qed", "node2=node2.qed"})
+	require.NoError(t, err)
+
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "node1"},
+		DNSNames: []string{"node1.qed"},
+	}
+	ctx := peerContext(cert)
+
+	require.NoError(t, a.authorize(ctx, &RaftJoinRequest{NodeId: "node1"}))
+	require.Error(t, a.authorize(ctx, &RaftJoinRequest{NodeId: "node2"}), "Certificate of another node")
+	require.Error(t, a.authorize(ctx, &RaftJoinRequest{NodeId: "node3"}), "Node not in the allow-list")
+	require.Error(t, a.authorize(ctx, &RaftJoinRequest{NodeId: "node1", JoinToken: "wrong"}), "A wrong token is never accepted")
+	require.Error(t, a.authorize(peerContext(nil), &RaftJoinRequest{NodeId: "node1"}), "No verified certificate")
+	require.Error(t, a.authorize(context.Background(), &RaftJoinRequest{NodeId: "node1"}), "Unknown peer")
+}
+
+func peerContext(cert *x509.Certificate) context.Context {
+	var state tls.ConnectionState
+	if cert != nil {
+		state.VerifiedChains = [][]*x509.Certificate{{cert}}
+	}
+	return peer.NewContext(context.Background(), &peer.Peer{
+		Addr:     &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8500},
+		AuthInfo: credentials.TLSInfo{State: state},
+	})
+}
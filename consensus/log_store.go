@@ -0,0 +1,151 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package consensus
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/metrics"
+	"github.com/hashicorp/raft"
+)
+
+const (
+	// RocksDBLogStore keeps the Raft log in a RocksDB instance.
+	RocksDBLogStore = "rocksdb"
+	// FileLogStore keeps the Raft log in plain segment files.
+	FileLogStore = "file"
+
+	rocksDBLogDir = "/wal"
+	fileLogDir    = "/log"
+)
+
+// raftLogNamespace is the leading part of all published metrics for the Raft log.
+const raftLogNamespace = "qed_wal"
+
+const ioSubsystem = "io" // sub-system associated with metrics for I/O.
+
+var (
+	// ErrKeyNotFound is an error indicating a given key does not exist
+	ErrKeyNotFound = errors.New("not found")
+)
+
+// table groups related key-value pairs under a
+// consistent space.
+type table uint32
+
+const (
+	defaultTable table = iota
+	stableTable
+	logTable
+)
+
+func (t table) String() string {
+	var s string
+	switch t {
+	case defaultTable:
+		s = "default"
+	case stableTable:
+		s = "stable"
+	case logTable:
+		s = "log"
+	}
+	return s
+}
+
+// logStore is a persistent Raft log and stable store.
+type logStore interface {
+	raft.LogStore
+	raft.StableStore
+	Close() error
+	RegisterMetrics(registry metrics.Registry)
+}
+
+// openLogStore opens the Raft log store of the given kind under path.
+// The first time the file log store is opened over an existing RocksDB
+// log, the entries and the stable store are migrated to it. The RocksDB
+// log is kept in place, so a node can be rolled back.
+func openLogStore(kind, path string, noSync bool, logger log.Logger) (logStore, error) {
+	if kind == "" {
+		kind = DefaultLogStore
+	}
+	switch kind {
+	case RocksDBLogStore:
+		return openRocksDBLog(path+rocksDBLogDir, noSync, true)
+	case FileLogStore:
+		if !exists(path+fileLogDir) && exists(path+rocksDBLogDir) {
+			logger.Infof("Migrating Raft log at %s to the file log store", path+rocksDBLogDir)
+			if err := migrateRaftLog(path+rocksDBLogDir, path+fileLogDir); err != nil {
+				return nil, fmt.Errorf("cannot migrate the Raft log: %v", err)
+			}
+		}
+		return newFileLog(path+fileLogDir, noSync)
+	default:
+		return nil, fmt.Errorf("unknown Raft log store %q", kind)
+	}
+}
+
+// openExistingLogStore opens whichever Raft log store is found under path,
+// preferring the file log store.
+func openExistingLogStore(path string) (logStore, error) {
+	switch {
+	case exists(path + fileLogDir):
+		return newFileLog(path+fileLogDir, true)
+	case exists(path + rocksDBLogDir):
+		return openRocksDBLog(path+rocksDBLogDir, true, false)
+	default:
+		return nil, fmt.Errorf("cannot find a Raft log at %s", path)
+	}
+}
+
+func copyLogs(src, dst raft.LogStore) error {
+	first, err := src.FirstIndex()
+	if err != nil {
+		return err
+	}
+	last, err := src.LastIndex()
+	if err != nil {
+		return err
+	}
+	if first == 0 {
+		return nil
+	}
+
+	const batchSize = 1024
+	batch := make([]*raft.Log, 0, batchSize)
+	for index := first; index <= last; index++ {
+		l := new(raft.Log)
+		if err := src.GetLog(index, l); err != nil {
+			return err
+		}
+		batch = append(batch, l)
+		if len(batch) == batchSize || index == last {
+			if err := dst.StoreLogs(batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	return nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,37 @@
+//go:build !cgo
+// +build !cgo
+
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package consensus
+
+import (
+	"errors"
+)
+
+// DefaultLogStore is the Raft log store used when none is configured.
+const DefaultLogStore = FileLogStore
+
+var errRocksDBLogUnavailable = errors.New("the rocksdb Raft log store requires a build with cgo")
+
+func openRocksDBLog(path string, noSync, statistics bool) (logStore, error) {
+	return nil, errRocksDBLogUnavailable
+}
+
+func migrateRaftLog(from, to string) error {
+	return errRocksDBLogUnavailable
+}
@@ -0,0 +1,71 @@
+//go:build cgo
+// +build cgo
+
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package consensus
+
+import (
+	"os"
+)
+
+// DefaultLogStore is the Raft log store used when none is configured.
+const DefaultLogStore = RocksDBLogStore
+
+func openRocksDBLog(path string, noSync, statistics bool) (logStore, error) {
+	store, err := newRaftLogOpts(raftLogOptions{
+		Path:             path,
+		NoSync:           noSync,
+		EnableStatistics: statistics,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// migrateRaftLog copies a RocksDB Raft log into a new file log. The copy is
+// built in a temporary directory and renamed once complete, so an
+// interrupted migration is started over.
+func migrateRaftLog(from, to string) error {
+	src, err := newRaftLogOpts(raftLogOptions{Path: from, NoSync: true})
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := to + ".migrating"
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	dst, err := newFileLog(tmp, false)
+	if err != nil {
+		return err
+	}
+
+	err = src.forEachStable(dst.Set)
+	if err == nil {
+		err = copyLogs(src, dst)
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, to)
+}
@@ -0,0 +1,66 @@
+//go:build cgo
+// +build cgo
+
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package consensus
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bbva/qed/log"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateRaftLog(t *testing.T) {
+
+	path := mustTempDir()
+	defer deleteFile(path)
+
+	src, err := newRaftLog(path + rocksDBLogDir)
+	require.NoError(t, err)
+	require.NoError(t, src.SetUint64([]byte("CurrentTerm"), 7))
+	require.NoError(t, src.StoreLogs([]*raft.Log{
+		fakeRaftLog(1, "log1"),
+		fakeRaftLog(2, "log2"),
+		fakeRaftLog(3, "log3"),
+	}))
+	require.NoError(t, src.DeleteRange(1, 1))
+	require.NoError(t, src.Close())
+
+	store, err := openLogStore(FileLogStore, path, true, log.Default())
+	require.NoError(t, err)
+	defer store.Close()
+
+	term, err := store.GetUint64([]byte("CurrentTerm"))
+	require.NoError(t, err)
+	require.Equal(t, uint64(7), term)
+	first, _ := store.FirstIndex()
+	last, _ := store.LastIndex()
+	require.Equal(t, uint64(2), first)
+	require.Equal(t, uint64(3), last)
+	var l raft.Log
+	require.NoError(t, store.GetLog(3, &l))
+	require.Equal(t, []byte("log3"), l.Data)
+
+	_, err = os.Stat(filepath.Join(path, fileLogDir+".migrating"))
+	require.True(t, os.IsNotExist(err))
+
+}
@@ -0,0 +1,143 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package consensus
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/bbva/qed/protocol"
+)
+
+const (
+	serverStateLeader      = "Leader"
+	serverStateFollower    = "Follower"
+	serverStateUnreachable = "Unreachable"
+)
+
+// ErrUnknownServer is raised when a membership operation targets a node
+// that is not part of the cluster configuration.
+var ErrUnknownServer = errors.New("Unknown cluster server")
+
+// Servers returns the members of the Raft cluster configuration, along with
+// their suffrage and their current state as seen from this node.
+func (n *RaftNode) Servers() ([]*protocol.RaftServer, error) {
+	configFuture := n.raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return nil, err
+	}
+	servers := configFuture.Configuration().Servers
+	leaderAddr := n.raft.Leader()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	list := make([]*protocol.RaftServer, len(servers))
+	var wg sync.WaitGroup
+	wg.Add(len(servers))
+	for i, srv := range servers {
+		list[i] = &protocol.RaftServer{
+			NodeId:   string(srv.ID),
+			RaftAddr: string(srv.Address),
+			Suffrage: srv.Suffrage.String(),
+			State:    serverStateUnreachable,
+		}
+		go func(rs *protocol.RaftServer, leader bool) {
+			defer wg.Done()
+			resp, err := n.grpcFetchInfo(ctx, rs.RaftAddr)
+			if err != nil {
+				n.log.Infof("Error getting node info from %s: %v", rs.RaftAddr, err)
+				return
+			}
+			rs.MgmtAddr = resp.NodeInfo.MgmtAddr
+			if leader {
+				rs.State = serverStateLeader
+			} else {
+				rs.State = serverStateFollower
+			}
+		}(list[i], srv.Address == leaderAddr)
+	}
+	wg.Wait()
+
+	return list, nil
+}
+
+// RemoveServer removes the given node from the cluster configuration.
+// This must be called from the Leader or it will fail.
+func (n *RaftNode) RemoveServer(nodeID string) error {
+	srv, err := n.lookupServer(nodeID)
+	if err != nil {
+		return err
+	}
+	n.log.Infof("Removing server %q at %q from the cluster", srv.ID, srv.Address)
+	return n.raft.RemoveServer(srv.ID, 0, 0).Error()
+}
+
+// DemoteServer takes away the vote of the given node, which keeps
+// receiving log entries as a non-voter. This must be called from the
+// Leader or it will fail.
+func (n *RaftNode) DemoteServer(nodeID string) error {
+	srv, err := n.lookupServer(nodeID)
+	if err != nil {
+		return err
+	}
+	if srv.Suffrage == raft.Nonvoter {
+		return nil
+	}
+	n.log.Infof("Demoting server %q at %q to non-voter", srv.ID, srv.Address)
+	return n.raft.DemoteVoter(srv.ID, 0, 0).Error()
+}
+
+// TransferLeadership hands the leadership over to the given node. If no
+// node is given, the most up to date follower is chosen.
+// This must be called from the Leader or it will fail.
+func (n *RaftNode) TransferLeadership(nodeID string) error {
+	if nodeID == "" {
+		if !n.IsLeader() {
+			return ErrNotLeader
+		}
+		n.log.Info("Transferring leadership to the most up to date follower")
+		return n.leaveLeadership()
+	}
+	srv, err := n.lookupServer(nodeID)
+	if err != nil {
+		return err
+	}
+	n.log.Infof("Transferring leadership to server %q at %q", srv.ID, srv.Address)
+	return n.raft.LeadershipTransferToServer(srv.ID, srv.Address).Error()
+}
+
+// lookupServer finds a node in the current cluster configuration.
+func (n *RaftNode) lookupServer(nodeID string) (*raft.Server, error) {
+	if !n.IsLeader() {
+		return nil, ErrNotLeader
+	}
+	configFuture := n.raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return nil, err
+	}
+	for _, srv := range configFuture.Configuration().Servers {
+		if srv.ID == raft.ServerID(nodeID) {
+			return &srv, nil
+		}
+	}
+	return nil, ErrUnknownServer
+}
@@ -30,6 +30,15 @@ type raftNodeMetrics struct {
 	MembershipQueries       prometheus.Counter
 	DigestMembershipQueries prometheus.Counter
 	IncrementalQueries      prometheus.Counter
+	LinearizableReads       prometheus.Counter
+	StaleReads              prometheus.Counter
+	AdminCommands           prometheus.Counter
+	SnapshotBytesSent       prometheus.Counter
+	SnapshotBytesReceived   prometheus.Counter
+	SnapshotChecksumErrors  prometheus.Counter
+	SnapshotResumes         prometheus.Counter
+	SnapshotLoadedSeqNum    prometheus.Gauge
+	JoinRejections          prometheus.Counter
 }
 
 func newRaftNodeMetrics(n *RaftNode) *raftNodeMetrics {
@@ -42,7 +51,7 @@ func newRaftNodeMetrics(n *RaftNode) *raftNodeMetrics {
 				Help:      "Current balloon version.",
 			},
 			func() float64 {
-				return float64(n.balloon.Version() - 1)
+				return float64(n.currentBalloon().Version() - 1)
 			},
 		),
 		Adds: prometheus.NewCounter(
@@ -77,6 +86,78 @@ func newRaftNodeMetrics(n *RaftNode) *raftNodeMetrics {
 				Help:      "Number of incremental queries.",
 			},
 		),
+		LinearizableReads: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Subsystem: subSystem,
+				Name:      "linearizable_reads",
+				Help:      "Number of queries served with linearizable consistency.",
+			},
+		),
+		StaleReads: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Subsystem: subSystem,
+				Name:      "stale_reads",
+				Help:      "Number of queries rejected because the node lags behind the required version.",
+			},
+		),
+		AdminCommands: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Subsystem: subSystem,
+				Name:      "admin_commands",
+				Help:      "Number of admin commands applied to the cluster settings.",
+			},
+		),
+		SnapshotBytesSent: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Subsystem: subSystem,
+				Name:      "snapshot_bytes_sent",
+				Help:      "Number of snapshot bytes sent to other nodes.",
+			},
+		),
+		SnapshotBytesReceived: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Subsystem: subSystem,
+				Name:      "snapshot_bytes_received",
+				Help:      "Number of verified snapshot bytes received from the leader.",
+			},
+		),
+		SnapshotChecksumErrors: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Subsystem: subSystem,
+				Name:      "snapshot_checksum_errors",
+				Help:      "Number of snapshot chunks or streams received with a wrong checksum.",
+			},
+		),
+		SnapshotResumes: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Subsystem: subSystem,
+				Name:      "snapshot_resumes",
+				Help:      "Number of snapshot transfers resumed after a failure.",
+			},
+		),
+		SnapshotLoadedSeqNum: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: subSystem,
+				Name:      "snapshot_loaded_seq_num",
+				Help:      "Last WAL sequence number loaded from a snapshot transfer.",
+			},
+		),
+		JoinRejections: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Subsystem: subSystem,
+				Name:      "join_rejections",
+				Help:      "Number of join requests rejected for lack of authorization.",
+			},
+		),
 	}
 }
 
@@ -88,5 +169,14 @@ func (m *raftNodeMetrics) collectors() []prometheus.Collector {
 		m.MembershipQueries,
 		m.DigestMembershipQueries,
 		m.IncrementalQueries,
+		m.LinearizableReads,
+		m.StaleReads,
+		m.AdminCommands,
+		m.SnapshotBytesSent,
+		m.SnapshotBytesReceived,
+		m.SnapshotChecksumErrors,
+		m.SnapshotResumes,
+		m.SnapshotLoadedSeqNum,
+		m.JoinRejections,
 	}
 }
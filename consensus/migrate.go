@@ -0,0 +1,143 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package consensus
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/bbva/qed/balloon"
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/storage/encrypted"
+	"github.com/bbva/qed/storage/engine"
+)
+
+// migrateBatchSize is the number of key-value pairs written per batch
+// when migrating a database.
+const migrateBatchSize = 1000
+
+// MigrateOptions configures an offline migration of a database to
+// another location or storage engine.
+type MigrateOptions struct {
+	FromEngine, FromPath string // Database of the node, which must be stopped. An empty engine is detected.
+	ToEngine, ToPath     string // Empty directory and engine of the new database.
+
+	// Keys the database is encrypted with, if any. The new database is
+	// encrypted with them too.
+	Keyring *encrypted.Keyring
+}
+
+// MigrateResult summarizes a migration.
+type MigrateResult struct {
+	Pairs map[storage.Table]int // Number of key-value pairs copied per table.
+	Roots *balloon.Roots        // Roots of the last version of both balloons, nil if they are empty.
+}
+
+// Migrate copies every table of a database, including the FSM state and
+// the cluster settings, into a new database, possibly with another
+// storage engine, and checks that the roots of the last balloon version
+// of both databases match. Backups and the WAL are not copied.
+func Migrate(opts *MigrateOptions) (*MigrateResult, error) {
+	return MigrateWithLogger(opts, log.L())
+}
+
+// MigrateWithLogger is like Migrate with a custom logger.
+func MigrateWithLogger(opts *MigrateOptions, logger log.Logger) (*MigrateResult, error) {
+
+	fromEngine := opts.FromEngine
+	if fromEngine == "" {
+		fromEngine = engine.Detect(opts.FromPath)
+	}
+	if fromEngine == "" {
+		return nil, fmt.Errorf("no database found at %s", opts.FromPath)
+	}
+	if err := ensureEmptyDir(opts.ToPath); err != nil {
+		return nil, err
+	}
+
+	source, err := engine.OpenWithOptions(fromEngine, opts.FromPath, engine.Options{Keyring: opts.Keyring})
+	if err != nil {
+		return nil, err
+	}
+	defer source.Close()
+
+	target, err := engine.OpenWithOptions(opts.ToEngine, opts.ToPath, engine.Options{Keyring: opts.Keyring})
+	if err != nil {
+		return nil, err
+	}
+	defer target.Close()
+
+	result := &MigrateResult{Pairs: make(map[storage.Table]int)}
+	for _, table := range storage.Tables {
+		logger.Infof("Copying table %s", table)
+		n, err := copyTable(source, target, table)
+		if err != nil {
+			return nil, fmt.Errorf("cannot copy table %s: %v", table, err)
+		}
+		result.Pairs[table] = n
+	}
+
+	sourceRoots, err := storeRoots(source, logger.Named("source"))
+	if err != nil {
+		return nil, err
+	}
+	result.Roots, err = storeRoots(target, logger.Named("target"))
+	if err != nil {
+		return nil, err
+	}
+	if !sameRoots(sourceRoots, result.Roots) {
+		return nil, fmt.Errorf("the roots of the database at %s do not match the source ones", opts.ToPath)
+	}
+	return result, nil
+}
+
+// copyTable copies every key-value pair of a table, returning how many
+// were copied.
+func copyTable(source, target storage.Store, table storage.Table) (int, error) {
+	reader := source.GetAll(table)
+	defer reader.Close()
+
+	var copied int
+	buffer := make([]*storage.KVPair, migrateBatchSize)
+	for {
+		n, err := reader.Read(buffer)
+		if err != nil {
+			return copied, err
+		}
+		if n == 0 {
+			return copied, nil
+		}
+		mutations := make([]*storage.Mutation, n)
+		for i, pair := range buffer[:n] {
+			mutations[i] = storage.NewMutation(table, pair.Key, pair.Value)
+		}
+		if err := target.Mutate(mutations, nil); err != nil {
+			return copied, err
+		}
+		copied += n
+	}
+}
+
+func sameRoots(a, b *balloon.Roots) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Version == b.Version &&
+		bytes.Equal(a.HistoryDigest, b.HistoryDigest) &&
+		bytes.Equal(a.HyperDigest, b.HyperDigest)
+}
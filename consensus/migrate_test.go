@@ -0,0 +1,68 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package consensus
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/storage/encrypted"
+	"github.com/bbva/qed/storage/engine"
+)
+
+func TestMigrate(t *testing.T) {
+	path := mustTempDir()
+	defer os.RemoveAll(path)
+	fromPath := filepath.Join(path, "lsm")
+
+	keyring, err := encrypted.ParseKeyring("1:000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	require.NoError(t, err)
+
+	store, err := engine.OpenWithOptions(engine.LSM, fromPath, engine.Options{Keyring: keyring})
+	require.NoError(t, err)
+	snapshots := addBulks(t, store, 10, 100)
+	require.NoError(t, store.Close())
+
+	opts := &MigrateOptions{
+		FromPath: fromPath,
+		ToEngine: engine.BPlus,
+		ToPath:   filepath.Join(path, "bplus"),
+		Keyring:  keyring,
+	}
+	result, err := Migrate(opts)
+	require.NoError(t, err)
+	require.Equal(t, 2, result.Pairs[storage.FSMStateTable], "The FSM state and the encryption marker should be copied")
+	require.True(t, result.Pairs[storage.HistoryTable] > 0)
+	last := snapshots[len(snapshots)-1]
+	require.Equal(t, last.Version, result.Roots.Version)
+	require.Equal(t, last.HistoryDigest, result.Roots.HistoryDigest)
+	require.Equal(t, last.HyperDigest, result.Roots.HyperDigest)
+	require.Equal(t, engine.BPlus, engine.Detect(opts.ToPath))
+
+	// the target must be empty
+	_, err = Migrate(opts)
+	require.Error(t, err)
+
+	// the keys are required
+	opts.ToPath, opts.Keyring = filepath.Join(path, "plain"), nil
+	_, err = Migrate(opts)
+	require.Error(t, err)
+}
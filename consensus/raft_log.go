@@ -1,3 +1,6 @@
+//go:build cgo
+// +build cgo
+
 /*
    Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
 
@@ -17,8 +20,6 @@
 package consensus
 
 import (
-	"errors"
-
 	"github.com/bbva/qed/metrics"
 	"github.com/bbva/qed/rocksdb"
 	"github.com/bbva/qed/util"
@@ -26,34 +27,6 @@ import (
 	"github.com/hashicorp/raft"
 )
 
-var (
-	// ErrKeyNotFound is an error indicating a given key does not exist
-	ErrKeyNotFound = errors.New("not found")
-)
-
-// table groups related key-value pairs under a
-// consistent space.
-type table uint32
-
-const (
-	defaultTable table = iota
-	stableTable
-	logTable
-)
-
-func (t table) String() string {
-	var s string
-	switch t {
-	case defaultTable:
-		s = "default"
-	case stableTable:
-		s = "stable"
-	case logTable:
-		s = "log"
-	}
-	return s
-}
-
 // raftLog implements both the raft LogStore and Stable interfaces. This is used
 // by raft to store logs and configuration changes.
 type raftLog struct {
@@ -360,6 +333,22 @@ func (s *raftLog) GetUint64(key []byte) (uint64, error) {
 	return util.BytesAsUint64(val), nil
 }
 
+// forEachStable calls fn for every key/value pair of the stable store.
+func (s *raftLog) forEachStable(fn func(key, val []byte) error) error {
+	it := s.db.NewIteratorCF(s.ro, s.cfHandles[stableTable])
+	defer it.Close()
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		key, val := it.Key(), it.Value()
+		err := fn(key.Data(), val.Data())
+		key.Free()
+		val.Free()
+		if err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
 func (s *raftLog) RegisterMetrics(registry metrics.Registry) {
 	if registry != nil {
 		registry.MustRegister(s.metrics.collectors()...)
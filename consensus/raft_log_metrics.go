@@ -1,3 +1,6 @@
+//go:build cgo
+// +build cgo
+
 /*
    Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
 
@@ -24,14 +27,10 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-// namespace is the leading part of all published metrics for the Storage service.
-const raftLogNamespace = "qed_wal"
-
 const blockCacheSubsystem = "block"  // sub-system associated with metrics for block cache.
 const filterSubsystem = "filter"     // sub-system associated with metrics for bloom filters.
 const memtableSubsystem = "memtable" // sub-system associated with metrics for memtable.
 const getSubsystem = "get"           // sub-system associated with metrics for gets.
-const ioSubsystem = "io"             // sub-system associated with metrics for I/O.
 const compressSybsystem = "compress" // sub-system associated with metrics for compression.
 
 type raftLogMetrics struct {
@@ -1,3 +1,6 @@
+//go:build cgo
+// +build cgo
+
 /*
    Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
 
@@ -16,8 +19,6 @@
 package consensus
 
 import (
-	"fmt"
-	"io/ioutil"
 	"os"
 	"testing"
 
@@ -272,26 +273,3 @@ func openRaftLog(t testing.TB) (*raftLog, string, func()) {
 		deleteFile(path)
 	}
 }
-
-func mustTempDir() string {
-	var err error
-	path, err := ioutil.TempDir("/var/tmp", "raftlog-test-")
-	if err != nil {
-		panic("failed to create temp dir")
-	}
-	return path
-}
-
-func deleteFile(path string) {
-	err := os.RemoveAll(path)
-	if err != nil {
-		fmt.Printf("Unable to remove db file %s", err)
-	}
-}
-
-func fakeRaftLog(idx uint64, data string) *raft.Log {
-	return &raft.Log{
-		Data:  []byte(data),
-		Index: idx,
-	}
-}
@@ -0,0 +1,148 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package consensus
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/bbva/qed/protocol"
+)
+
+const (
+	readIndexWaitDelay = 5 * time.Millisecond
+)
+
+var (
+	// ErrStaleRead is raised when the local balloon has not applied the
+	// version required by the consistency level of a read.
+	ErrStaleRead = errors.New("Node has not applied the required version")
+
+	// ErrNoLeader is raised when a linearizable read cannot be served
+	// because the cluster has no leader.
+	ErrNoLeader = errors.New("No cluster leader")
+
+	// ErrUnknownConsistency is raised when a read asks for an unknown
+	// consistency level.
+	ErrUnknownConsistency = errors.New("Unknown read consistency level")
+)
+
+// ReadIndex returns the balloon version a linearizable read must observe.
+// It is the version after the last event acknowledged by the leader, which
+// confirms its leadership before answering.
+// This must be called from the Leader or it will fail.
+func (n *RaftNode) ReadIndex(ctx context.Context, req *ReadIndexRequest) (*ReadIndexResponse, error) {
+	if !n.IsLeader() {
+		return nil, ErrNotLeader
+	}
+	if err := n.raft.VerifyLeader().Error(); err != nil {
+		return nil, err
+	}
+	return &ReadIndexResponse{Version: n.currentBalloon().Version()}, nil
+}
+
+// WaitForConsistency blocks until the local balloon satisfies the given
+// consistency level, so a proof can be served from it:
+//   - stale reads are served right away.
+//   - bounded reads are rejected with ErrStaleRead if the balloon has not
+//     applied minVersion yet.
+//   - linearizable reads get a read index from the leader and wait until
+//     the balloon has applied it, or fail with ErrStaleRead on timeout.
+func (n *RaftNode) WaitForConsistency(level protocol.ReadConsistency, minVersion uint64) error {
+	switch level {
+	case "", protocol.ReadStale:
+		return nil
+
+	case protocol.ReadBounded:
+		if n.currentBalloon().Version() <= minVersion {
+			n.metrics.StaleReads.Inc()
+			return ErrStaleRead
+		}
+		return nil
+
+	case protocol.ReadLinearizable:
+		n.metrics.LinearizableReads.Inc()
+		version, err := n.readIndex()
+		if err != nil {
+			return err
+		}
+		if err := n.waitForVersion(version, n.applyTimeout); err != nil {
+			n.metrics.StaleReads.Inc()
+			return err
+		}
+		return nil
+
+	default:
+		return ErrUnknownConsistency
+	}
+}
+
+// readIndex asks the leader for the version a linearizable read must
+// observe, or computes it locally if this node is the leader.
+func (n *RaftNode) readIndex() (uint64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), n.applyTimeout)
+	defer cancel()
+
+	if n.IsLeader() {
+		resp, err := n.ReadIndex(ctx, new(ReadIndexRequest))
+		if err != nil {
+			return 0, err
+		}
+		return resp.Version, nil
+	}
+
+	leaderAddr := string(n.raft.Leader())
+	if leaderAddr == "" {
+		return 0, ErrNoLeader
+	}
+	conn, err := n.dial(ctx, leaderAddr)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	resp, err := NewClusterServiceClient(conn).ReadIndex(ctx, new(ReadIndexRequest))
+	if err != nil {
+		n.log.Infof("Error getting read index from leader %s: %v", leaderAddr, err)
+		return 0, ErrNoLeader
+	}
+	return resp.Version, nil
+}
+
+// waitForVersion waits until the local balloon reaches the given version or
+// time is out.
+func (n *RaftNode) waitForVersion(version uint64, timeout time.Duration) error {
+	if n.currentBalloon().Version() >= version {
+		return nil
+	}
+
+	tck := time.NewTicker(readIndexWaitDelay)
+	defer tck.Stop()
+	tmr := time.NewTimer(timeout)
+	defer tmr.Stop()
+
+	for {
+		select {
+		case <-tck.C:
+			if n.currentBalloon().Version() >= version {
+				return nil
+			}
+		case <-tmr.C:
+			return ErrStaleRead
+		}
+	}
+}
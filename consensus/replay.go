@@ -0,0 +1,240 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package consensus
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/bbva/qed/balloon"
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/storage/encrypted"
+	"github.com/bbva/qed/storage/engine"
+)
+
+// ErrCompactedLog is raised when replaying a Raft log whose first entries
+// have been truncated after a snapshot, so the balloon cannot be rebuilt
+// from scratch.
+var ErrCompactedLog = errors.New("Raft log has been compacted")
+
+// ReplayOptions configures an offline replay of a Raft log.
+type ReplayOptions struct {
+	RaftLogPath  string // Path to the Raft storage directory of the node, which must be stopped.
+	ScratchPath  string // Empty directory where a fresh balloon is rebuilt.
+	CheckDBPath  string // Optional path to an existing database to compare the replay with.
+	UntilIndex   uint64 // Last log index to replay. Zero replays the whole log.
+	UntilVersion int64  // Balloon version where the replay stops. Negative replays every version.
+
+	Keyring *encrypted.Keyring // Keys the databases are encrypted with, if any.
+}
+
+// ReplayStep is the outcome of applying a single log entry.
+type ReplayStep struct {
+	Index     uint64
+	Type      raft.LogType
+	Command   string              // Command name, for LogCommand entries.
+	Snapshots []*balloon.Snapshot // Snapshots produced by add commands.
+	Err       error               // Error returned by the FSM, e.g. adds rejected in read-only mode.
+}
+
+// ReplayMismatch describes the first version where the replayed balloon
+// differs from the existing database.
+type ReplayMismatch struct {
+	Index   uint64
+	Version uint64
+	Reason  string
+}
+
+// ReplayResult summarizes an offline replay.
+type ReplayResult struct {
+	FirstIndex, LastIndex uint64
+	Version               uint64 // Next version of the rebuilt balloon.
+	Mismatch              *ReplayMismatch
+}
+
+// Replay reads the Raft log of a stopped node, applies every entry to a
+// fresh balloon in opts.ScratchPath using the same FSM code as a running
+// node, and calls fn after each entry. If opts.CheckDBPath is given, the
+// replay stops at the first version whose history digest differs from the
+// existing database.
+func Replay(opts *ReplayOptions, fn func(*ReplayStep)) (*ReplayResult, error) {
+	return ReplayWithLogger(opts, fn, log.L())
+}
+
+// ReplayWithLogger is like Replay with a custom logger.
+func ReplayWithLogger(opts *ReplayOptions, fn func(*ReplayStep), logger log.Logger) (*ReplayResult, error) {
+
+	if err := ensureEmptyDir(opts.ScratchPath); err != nil {
+		return nil, err
+	}
+
+	raftLog, err := openExistingLogStore(opts.RaftLogPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open the Raft log: %v", err)
+	}
+	defer raftLog.Close()
+
+	scratch, err := engine.OpenWithOptions(engine.Default, opts.ScratchPath, engine.Options{Keyring: opts.Keyring})
+	if err != nil {
+		return nil, err
+	}
+	defer scratch.Close()
+
+	// an offline node applies the entries with the same FSM code
+	node := &RaftNode{db: scratch, log: logger}
+	node.metrics = newRaftNodeMetrics(node)
+	if err := node.loadSettings(); err != nil {
+		return nil, err
+	}
+	node.balloon, err = balloon.NewBalloonWithLogger(scratch, node.hasherF, logger.Named("balloon"))
+	if err != nil {
+		return nil, err
+	}
+	if err := node.loadState(); err != nil {
+		return nil, err
+	}
+
+	var checker *replayChecker
+	if opts.CheckDBPath != "" {
+		checker, err = newReplayChecker(opts.CheckDBPath, opts.Keyring, logger)
+		if err != nil {
+			return nil, err
+		}
+		defer checker.close()
+	}
+
+	first, err := raftLog.FirstIndex()
+	if err != nil {
+		return nil, err
+	}
+	last, err := raftLog.LastIndex()
+	if err != nil {
+		return nil, err
+	}
+	result := &ReplayResult{FirstIndex: first}
+	if last == 0 {
+		return result, nil
+	}
+	if first > 1 {
+		return nil, fmt.Errorf("%v: first index is %d", ErrCompactedLog, first)
+	}
+	if opts.UntilIndex > 0 && opts.UntilIndex < last {
+		last = opts.UntilIndex
+	}
+
+	for index := first; index <= last; index++ {
+		var l raft.Log
+		if err := raftLog.GetLog(index, &l); err != nil {
+			return result, fmt.Errorf("cannot read log entry %d: %v", index, err)
+		}
+		result.LastIndex = index
+
+		step := &ReplayStep{Index: index, Type: l.Type}
+		if l.Type == raft.LogCommand {
+			step.Command = newCommandFromRaft(l.Data).id.String()
+			if resp, ok := node.Apply(&l).(*fsmResponse); ok {
+				step.Err = resp.err
+				step.Snapshots, _ = resp.val.([]*balloon.Snapshot)
+			}
+		}
+		fn(step)
+
+		for _, s := range step.Snapshots {
+			if checker == nil {
+				break
+			}
+			if reason := checker.check(s); reason != "" {
+				result.Mismatch = &ReplayMismatch{index, s.Version, reason}
+				result.Version = node.balloon.Version()
+				return result, nil
+			}
+		}
+		if n := len(step.Snapshots); n > 0 && opts.UntilVersion >= 0 && step.Snapshots[n-1].Version >= uint64(opts.UntilVersion) {
+			break
+		}
+	}
+
+	result.Version = node.balloon.Version()
+	return result, nil
+}
+
+// replayChecker compares replayed snapshots with an existing database.
+type replayChecker struct {
+	store   storage.ManagedStore
+	balloon *balloon.Balloon
+}
+
+func newReplayChecker(path string, keyring *encrypted.Keyring, logger log.Logger) (*replayChecker, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("cannot find the database to check: %v", err)
+	}
+	store, err := engine.OpenWithOptions(engine.Detect(path), path, engine.Options{Keyring: keyring})
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := openBalloon(store, logger.Named("check"))
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	return &replayChecker{store, b}, nil
+}
+
+// check returns the reason why the given snapshot does not match the
+// existing database, or an empty string if it does.
+func (c *replayChecker) check(s *balloon.Snapshot) string {
+	if s.Version >= c.balloon.Version() {
+		return fmt.Sprintf("version not found, the database ends at version %d", int64(c.balloon.Version())-1)
+	}
+	// the proof is built from the existing history tree, so it only
+	// verifies if both trees have the same root at this version.
+	proof, err := c.balloon.QueryConsistency(s.Version, s.Version)
+	if err != nil {
+		return err.Error()
+	}
+	if !proof.Verify(s, s) {
+		return fmt.Sprintf("history digest %x does not match the database", s.HistoryDigest)
+	}
+	return ""
+}
+
+func (c *replayChecker) close() {
+	c.store.Close()
+}
+
+func ensureEmptyDir(path string) error {
+	if path == "" {
+		return errors.New("directory is required")
+	}
+	files, err := ioutil.ReadDir(path)
+	if os.IsNotExist(err) {
+		return os.MkdirAll(path, 0755)
+	}
+	if err != nil {
+		return err
+	}
+	if len(files) > 0 {
+		return fmt.Errorf("directory %s is not empty", path)
+	}
+	return nil
+}
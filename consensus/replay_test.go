@@ -0,0 +1,91 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package consensus
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bbva/qed/balloon"
+)
+
+func TestReplay(t *testing.T) {
+	node, clean, err := newSeed(t.Name(), 0)
+	require.NoError(t, err)
+	defer clean(true)
+
+	_, err = node.AddBulk([][]byte{[]byte("event 0"), []byte("event 1")})
+	require.NoError(t, err)
+	_, err = node.Add([]byte("event 2"))
+	require.NoError(t, err)
+	require.NoError(t, node.SetReadOnly(true))
+	_, err = node.Add([]byte("event 3"))
+	require.Equal(t, ErrReadOnly, err)
+	require.NoError(t, node.Close(true))
+
+	dir := fmt.Sprintf("/var/tmp/cluster-test/node_%s_0", t.Name())
+	opts := &ReplayOptions{
+		RaftLogPath:  dir + "/raft",
+		ScratchPath:  dir + "/scratch",
+		CheckDBPath:  dir + "/db",
+		UntilVersion: -1,
+	}
+
+	var snapshots []*balloon.Snapshot
+	var rejected int
+	result, err := Replay(opts, func(step *ReplayStep) {
+		if step.Type == raft.LogCommand && step.Err != nil {
+			rejected++
+		}
+		snapshots = append(snapshots, step.Snapshots...)
+	})
+	require.NoError(t, err)
+	require.Nil(t, result.Mismatch, "The replay should match the node database")
+	require.Equal(t, uint64(3), result.Version, "Wrong rebuilt version")
+	require.Equal(t, 3, len(snapshots), "Wrong number of snapshots")
+	require.Equal(t, 1, rejected, "The add in read-only mode should be rejected")
+
+	// the scratch directory must be empty
+	_, err = Replay(opts, func(*ReplayStep) {})
+	require.Error(t, err)
+
+	// stop at a given version
+	opts.UntilVersion = 1
+	opts.ScratchPath = dir + "/scratch-until"
+	result, err = Replay(opts, func(*ReplayStep) {})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), result.Version, "The replay should stop after version 1")
+
+	// a database built from other events does not match
+	other, cleanOther, err := newSeed(t.Name(), 1)
+	require.NoError(t, err)
+	defer cleanOther(true)
+	_, err = other.Add([]byte("another event"))
+	require.NoError(t, err)
+	require.NoError(t, other.Close(true))
+
+	opts.UntilVersion = -1
+	opts.ScratchPath = dir + "/scratch-mismatch"
+	opts.CheckDBPath = fmt.Sprintf("/var/tmp/cluster-test/node_%s_1/db", t.Name())
+	result, err = Replay(opts, func(*ReplayStep) {})
+	require.NoError(t, err)
+	require.NotNil(t, result.Mismatch, "The replay should not match another database")
+	require.Equal(t, uint64(0), result.Mismatch.Version, "The first version should differ")
+}
@@ -0,0 +1,212 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package consensus
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/bbva/qed/balloon"
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/storage/encrypted"
+	"github.com/bbva/qed/storage/engine"
+)
+
+// errRestoreTargetReached stops reading the WAL once a batch goes beyond
+// the version being restored.
+var errRestoreTargetReached = errors.New("target version reached")
+
+// RestoreOptions configures an offline point-in-time restore.
+type RestoreOptions struct {
+	DBPath      string        // Path to the database of the node, which must be stopped.
+	RestorePath string        // Empty directory where the database is restored.
+	Version     uint64        // Balloon version to restore.
+	WALTtl      time.Duration // WAL TTL the node runs with, so the WAL is not purged when opening it.
+
+	Keyring *encrypted.Keyring // Keys the database is encrypted with, if any.
+}
+
+// RestoreResult summarizes a point-in-time restore.
+type RestoreResult struct {
+	BackupID      uint32           // Backup the restore started from. Zero if none was used.
+	BackupVersion uint64           // Next version of the restored backup.
+	Batches       int              // Write batches replayed from the WAL.
+	Version       uint64           // Next version of the restored balloon.
+	Reached       int64            // Last restored version, -1 if the balloon is empty.
+	Roots         *balloon.Roots   // Roots of the last restored version, nil if the balloon is empty.
+	NextBatch     *VersionMetadata // First batch beyond the restored version, if any.
+}
+
+// RestoreToVersion restores the database of a stopped node as it was at
+// the given balloon version. The backup with the highest version not
+// beyond the target is restored into opts.RestorePath, and then the write
+// batches following it in the WAL of the node are loaded as long as their
+// versions stay at or below the target.
+//
+// Events are added in bulks and their write batches cannot be split, so
+// if the target version falls in the middle of a batch, the restore stops
+// at the previous batch and the result tells which batch comes next. The
+// restore also stops short if the WAL ends before the target. In both
+// cases result.Reached is below opts.Version, so callers must check it.
+//
+// Only the database is restored: the raft log is not replayed, so events
+// committed to the log but not yet applied to the database are missing.
+func RestoreToVersion(opts *RestoreOptions) (*RestoreResult, error) {
+	return RestoreToVersionWithLogger(opts, log.L())
+}
+
+// RestoreToVersionWithLogger is like RestoreToVersion with a custom logger.
+func RestoreToVersionWithLogger(opts *RestoreOptions, logger log.Logger) (*RestoreResult, error) {
+
+	if err := ensureEmptyDir(opts.RestorePath); err != nil {
+		return nil, err
+	}
+	name := engine.Detect(opts.DBPath)
+	if name == "" {
+		return nil, fmt.Errorf("no database found at %s", opts.DBPath)
+	}
+	source, err := engine.OpenWithOptions(name, opts.DBPath, engine.Options{WALTtl: opts.WALTtl, Keyring: opts.Keyring})
+	if err != nil {
+		return nil, err
+	}
+	defer source.Close()
+
+	return restoreToVersion(source, name, opts, logger)
+}
+
+// restoreToVersion restores the database of the given engine read from
+// source, which may be in use, into opts.RestorePath at opts.Version.
+func restoreToVersion(source storage.ManagedStore, name string, opts *RestoreOptions, logger log.Logger) (*RestoreResult, error) {
+
+	result := new(RestoreResult)
+	if b := nearestBackup(source.GetBackupsInfo(), opts.Version); b != nil {
+		result.BackupID = uint32(b.ID)
+		logger.Infof("Restoring backup %d with metadata %s", b.ID, b.Metadata)
+		if err := source.RestoreFromBackup(result.BackupID, opts.RestorePath, opts.RestorePath); err != nil {
+			return nil, err
+		}
+	}
+
+	target, err := engine.OpenWithOptions(name, opts.RestorePath, engine.Options{Keyring: opts.Keyring})
+	if err != nil {
+		return nil, err
+	}
+	defer target.Close()
+
+	result.BackupVersion, err = balloonVersion(target, logger)
+	if err != nil {
+		return nil, err
+	}
+	if result.BackupVersion > opts.Version+1 {
+		return nil, fmt.Errorf("backup %d ends after version %d", result.BackupID, opts.Version)
+	}
+
+	since, until := target.LastWALSequenceNumber(), source.LastWALSequenceNumber()
+	logger.Infof("Replaying WAL from sequence number %d to %d", since, until)
+
+	r, w := io.Pipe()
+	go func() {
+		err := source.FetchSnapshot(nopCloser{w}, since, until, restoreValidateF(result, opts.Version))
+		if err == errRestoreTargetReached {
+			err = nil
+		}
+		w.CloseWithError(err)
+	}()
+	if err := target.LoadSnapshot(r); err != nil {
+		return nil, err
+	}
+
+	b, err := openBalloon(target, logger)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+
+	result.Version = b.Version()
+	result.Reached = int64(result.Version) - 1
+	result.Roots, err = b.Roots()
+	if err == balloon.ErrVersionOutOfRange {
+		err = nil
+	}
+	return result, err
+}
+
+// restoreValidateF accepts the write batches that follow the restored
+// backup, up to the last one whose versions are not beyond the target.
+// Settings batches are kept too, as long as they were written before it.
+func restoreValidateF(result *RestoreResult, version uint64) storage.ValidateF {
+	next := result.BackupVersion
+	return func(meta []byte) (bool, error) {
+		metadata := new(VersionMetadata)
+		if err := decodeMsgPack(meta, metadata); err != nil {
+			return false, nil
+		}
+		if metadata.Settings {
+			result.Batches++
+			return true, nil
+		}
+		if metadata.NewVersion < next {
+			// already in the backup
+			return false, nil
+		}
+		if next > 0 && metadata.PreviousVersion+1 != next || next == 0 && metadata.PreviousVersion != 0 {
+			return false, fmt.Errorf("Gap found between versions %d and %d", int64(next)-1, metadata.PreviousVersion)
+		}
+		if metadata.NewVersion > version {
+			result.NextBatch = metadata
+			return false, errRestoreTargetReached
+		}
+		next = metadata.NewVersion + 1
+		result.Batches++
+		return true, nil
+	}
+}
+
+// nearestBackup returns the backup with the highest version not beyond
+// the given one. Backups with invalid metadata are ignored.
+func nearestBackup(backups []*storage.BackupInfo, version uint64) *storage.BackupInfo {
+	var nearest *storage.BackupInfo
+	var nearestVersion int64
+	for _, b := range backups {
+		v, err := strconv.ParseUint(b.Metadata, 10, 64)
+		if err != nil {
+			continue
+		}
+		// the metadata of a backup of an empty balloon wraps around to -1
+		if int64(v) > int64(version) {
+			continue
+		}
+		if nearest == nil || int64(v) > nearestVersion || int64(v) == nearestVersion && b.ID > nearest.ID {
+			nearest, nearestVersion = b, int64(v)
+		}
+	}
+	return nearest
+}
+
+// balloonVersion returns the next version of the balloon stored in store.
+func balloonVersion(store storage.Store, logger log.Logger) (uint64, error) {
+	b, err := openBalloon(store, logger)
+	if err != nil {
+		return 0, err
+	}
+	defer b.Close()
+	return b.Version(), nil
+}
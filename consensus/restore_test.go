@@ -0,0 +1,87 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package consensus
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/bbva/qed/storage/engine"
+)
+
+func TestRestoreToVersion(t *testing.T) {
+	path := mustTempDir()
+	defer os.RemoveAll(path)
+	dbPath := filepath.Join(path, "db")
+
+	store, err := engine.Open(engine.BPlus, dbPath, 0)
+	require.NoError(t, err)
+
+	// four bulks of three events: versions 0-2, 3-5, 6-8 and 9-11,
+	// with a backup after the first one
+	snapshots := addBulks(t, store, 1, 3)
+	require.NoError(t, store.Backup("2"))
+	snapshots = append(snapshots, addBulks(t, store, 3, 3)...)
+	require.NoError(t, store.Close())
+
+	// the target is the last version of a batch
+	opts := &RestoreOptions{DBPath: dbPath, RestorePath: filepath.Join(path, "v8"), Version: 8}
+	result, err := RestoreToVersion(opts)
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), result.BackupID, "The restore should start from the backup")
+	require.Equal(t, uint64(3), result.BackupVersion)
+	require.Equal(t, 2, result.Batches)
+	require.Equal(t, uint64(9), result.Version)
+	require.Equal(t, int64(8), result.Reached)
+	require.Equal(t, snapshots[8].HistoryDigest, result.Roots.HistoryDigest)
+	require.Equal(t, snapshots[8].HyperDigest, result.Roots.HyperDigest)
+	require.Equal(t, uint64(11), result.NextBatch.NewVersion)
+
+	// the restore path must be empty
+	_, err = RestoreToVersion(opts)
+	require.Error(t, err)
+
+	// the target is in the middle of a batch
+	opts.RestorePath, opts.Version = filepath.Join(path, "v7"), 7
+	result, err = RestoreToVersion(opts)
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), result.Version, "The batch of version 7 cannot be split")
+	require.Equal(t, int64(5), result.Reached)
+	require.Equal(t, snapshots[5].HistoryDigest, result.Roots.HistoryDigest)
+	require.Equal(t, uint64(5), result.NextBatch.PreviousVersion)
+
+	// the target is before the backup
+	opts.RestorePath, opts.Version = filepath.Join(path, "v1"), 1
+	result, err = RestoreToVersion(opts)
+	require.NoError(t, err)
+	require.Equal(t, uint32(0), result.BackupID, "No backup should be used")
+	require.Equal(t, uint64(0), result.Version)
+	require.Equal(t, int64(-1), result.Reached)
+	require.Nil(t, result.Roots)
+
+	// the target is beyond the last version
+	opts.RestorePath, opts.Version = filepath.Join(path, "v20"), 20
+	result, err = RestoreToVersion(opts)
+	require.NoError(t, err)
+	require.Equal(t, uint64(12), result.Version)
+	require.Equal(t, int64(11), result.Reached, "The WAL ends before version 20")
+	require.Equal(t, snapshots[11].HyperDigest, result.Roots.HyperDigest)
+	require.Nil(t, result.NextBatch)
+}
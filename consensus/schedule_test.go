@@ -0,0 +1,84 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package consensus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSchedule(t *testing.T) {
+	for _, spec := range []string{
+		"* * * * *",
+		"0 3 * * *",
+		"*/15 0-6,22,23 1 */2 1-5",
+		"30 2 * * 7",
+	} {
+		_, err := ParseSchedule(spec)
+		require.NoError(t, err, "Schedule %q should be valid", spec)
+	}
+
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+	} {
+		_, err := ParseSchedule(spec)
+		require.Error(t, err, "Schedule %q should not be valid", spec)
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	// Wednesday
+	now := time.Date(2019, time.May, 15, 10, 20, 30, 0, time.UTC)
+
+	testCases := []struct {
+		spec string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2019, time.May, 15, 10, 21, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2019, time.May, 15, 10, 30, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2019, time.May, 16, 3, 0, 0, 0, time.UTC)},
+		{"20 10 * * *", time.Date(2019, time.May, 16, 10, 20, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2019, time.May, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2019, time.May, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2019, time.June, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 1 *", time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2020, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		// either the day of month or the day of week
+		{"0 0 1 * 5", time.Date(2019, time.May, 17, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, c := range testCases {
+		s, err := ParseSchedule(c.spec)
+		require.NoError(t, err)
+		require.Equal(t, c.next, s.Next(now), "Wrong next time for %q", c.spec)
+	}
+
+	s, err := ParseSchedule("0 0 31 2 *")
+	require.NoError(t, err)
+	require.True(t, s.Next(now).IsZero(), "February 31st never happens")
+}
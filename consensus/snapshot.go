@@ -20,7 +20,9 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"hash/crc32"
 	io "io"
+	"time"
 
 	"github.com/bbva/qed/storage"
 	"github.com/hashicorp/raft"
@@ -28,6 +30,11 @@ import (
 	"google.golang.org/grpc/credentials"
 )
 
+const (
+	snapshotFetchAttempts = 5
+	snapshotFetchBackoff  = 500 * time.Millisecond
+)
+
 type fsmSnapshot struct {
 	LastSeqNum     uint64
 	BalloonVersion uint64
@@ -63,28 +70,62 @@ func (f *fsmSnapshot) decode(in []byte) error {
 	return decodeMsgPack(in, f)
 }
 
+// crcTable is used to checksum every chunk of a snapshot transfer, and
+// the whole stream.
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	// ErrChunkChecksum is raised when a snapshot chunk is corrupted.
+	ErrChunkChecksum = errors.New("Snapshot chunk checksum mismatch")
+
+	// ErrStreamChecksum is raised when a snapshot stream is corrupted.
+	ErrStreamChecksum = errors.New("Snapshot stream checksum mismatch")
+)
+
 type chunkWriter struct {
-	srv ClusterService_FetchSnapshotServer
+	srv       ClusterService_FetchSnapshotServer
+	checksums bool   // the follower verifies checksums
+	checksum  uint32 // checksum of the content sent so far
+	metrics   *raftNodeMetrics
 }
 
-func (c chunkWriter) Write(data []byte) (int, error) {
+func (c *chunkWriter) Write(data []byte) (int, error) {
 	chunk := new(Chunk)
 	chunk.Content = data
+	if c.checksums {
+		chunk.Checksum = crc32.Checksum(data, crcTable)
+		chunk.Checksummed = true
+	}
 	if err := c.srv.Send(chunk); err != nil {
 		return 0, err
 	}
+	c.checksum = crc32.Update(c.checksum, crcTable, data)
+	c.metrics.SnapshotBytesSent.Add(float64(len(data)))
 	return len(data), nil
 }
 
-func (c chunkWriter) Close() error {
+func (c *chunkWriter) Close() error {
 	return nil
 }
 
+// finish sends the trailer chunk carrying the checksum of the stream,
+// if the follower verifies checksums.
+func (c *chunkWriter) finish() error {
+	if !c.checksums {
+		return nil
+	}
+	return c.srv.Send(&Chunk{Last: true, Checksummed: true, StreamChecksum: c.checksum})
+}
+
 type chunkReader struct {
-	conn   *grpc.ClientConn
-	stream ClusterService_FetchSnapshotClient
-	buf    *bytes.Buffer
-	done   bool
+	conn        *grpc.ClientConn
+	stream      ClusterService_FetchSnapshotClient
+	buf         *bytes.Buffer
+	done        bool
+	checksummed bool   // the leader sends checksums
+	checksum    uint32 // checksum of the content received so far
+	last        bool   // the trailer chunk has been received
+	metrics     *raftNodeMetrics
 }
 
 func (c *chunkReader) Read(p []byte) (int, error) {
@@ -92,11 +133,9 @@ func (c *chunkReader) Read(p []byte) (int, error) {
 	var err error
 	for {
 		if c.buf.Len() == 0 {
-			chunk, err := c.stream.Recv()
-			if err != nil {
+			if err := c.recv(); err != nil {
 				return 0, err
 			}
-			c.buf.Write(chunk.Content)
 		}
 		n, err = c.buf.Read(p)
 		if err == nil {
@@ -107,6 +146,53 @@ func (c *chunkReader) Read(p []byte) (int, error) {
 	return n, err
 }
 
+// recv reads the next chunk into the buffer, verifying its checksum. The
+// end of the stream is only reported once the trailer has been verified,
+// so a broken transfer is never mistaken for a complete one.
+// Leaders that predate checksums send neither chunk checksums nor the
+// trailer, and their streams are read unverified.
+func (c *chunkReader) recv() error {
+	if c.last {
+		return io.EOF
+	}
+	chunk, err := c.stream.Recv()
+	if err == io.EOF {
+		if !c.checksummed {
+			return io.EOF
+		}
+		return io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+	if !chunk.Checksummed {
+		if c.checksummed {
+			c.metrics.SnapshotChecksumErrors.Inc()
+			return ErrChunkChecksum
+		}
+		c.metrics.SnapshotBytesReceived.Add(float64(len(chunk.Content)))
+		c.buf.Write(chunk.Content)
+		return nil
+	}
+	c.checksummed = true
+	if chunk.Last {
+		c.last = true
+		if chunk.StreamChecksum != c.checksum {
+			c.metrics.SnapshotChecksumErrors.Inc()
+			return ErrStreamChecksum
+		}
+		return io.EOF
+	}
+	if crc32.Checksum(chunk.Content, crcTable) != chunk.Checksum {
+		c.metrics.SnapshotChecksumErrors.Inc()
+		return ErrChunkChecksum
+	}
+	c.checksum = crc32.Update(c.checksum, crcTable, chunk.Content)
+	c.metrics.SnapshotBytesReceived.Add(float64(len(chunk.Content)))
+	c.buf.Write(chunk.Content)
+	return nil
+}
+
 func (c *chunkReader) Close() error {
 	if err := c.stream.CloseSend(); err != nil {
 		return err
@@ -114,17 +200,18 @@ func (c *chunkReader) Close() error {
 	return c.conn.Close()
 }
 
-func newChunkReader(conn *grpc.ClientConn, stream ClusterService_FetchSnapshotClient) *chunkReader {
+func newChunkReader(conn *grpc.ClientConn, stream ClusterService_FetchSnapshotClient, metrics *raftNodeMetrics) *chunkReader {
 	cr := new(chunkReader)
 	cr.conn = conn
 	cr.stream = stream
 	cr.buf = new(bytes.Buffer)
 	cr.done = true
+	cr.metrics = metrics
 	return cr
 }
 
 func (n *RaftNode) FetchSnapshot(req *FetchSnapshotRequest, srv ClusterService_FetchSnapshotServer) error {
-	chunker := &chunkWriter{srv: srv}
+	chunker := &chunkWriter{srv: srv, checksums: req.Checksums, metrics: n.metrics}
 
 	validateF := func(lastAppliedVersion uint64) storage.ValidateF {
 		lastSnapshotAppliedVersion := lastAppliedVersion
@@ -134,6 +221,10 @@ func (n *RaftNode) FetchSnapshot(req *FetchSnapshotRequest, srv ClusterService_F
 			if err != nil {
 				return false, nil
 			}
+			if metadata.Settings {
+				// settings are overwritten as a whole, so replaying them is safe.
+				return true, nil
+			}
 			if metadata.PreviousVersion > lastSnapshotAppliedVersion {
 				return false, errors.New("Gap found between versions")
 			}
@@ -149,7 +240,11 @@ func (n *RaftNode) FetchSnapshot(req *FetchSnapshotRequest, srv ClusterService_F
 		}
 	}
 
-	return n.db.FetchSnapshot(chunker, req.StartSeqNum, req.EndSeqNum, validateF(req.LastAppliedVersion))
+	err := n.db.FetchSnapshot(chunker, req.StartSeqNum, req.EndSeqNum, validateF(req.LastAppliedVersion))
+	if err != nil {
+		return err
+	}
+	return chunker.finish()
 }
 
 func (n *RaftNode) attemptToFetchSnapshot(lastSeqNum, lastAppliedVersion uint64) (io.ReadCloser, error) {
@@ -171,10 +266,43 @@ func (n *RaftNode) attemptToFetchSnapshot(lastSeqNum, lastAppliedVersion uint64)
 	stream, err := client.FetchSnapshot(context.Background(), &FetchSnapshotRequest{
 		LastAppliedVersion: lastAppliedVersion,
 		StartSeqNum:        n.db.LastWALSequenceNumber(),
-		EndSeqNum:          lastSeqNum})
+		EndSeqNum:          lastSeqNum,
+		Checksums:          true})
 	if err != nil {
 		return nil, err
 	}
 
-	return newChunkReader(conn, stream), nil
+	return newChunkReader(conn, stream, n.metrics), nil
+}
+
+// fetchAndLoadSnapshot transfers the leader database up to the given WAL
+// sequence number. Every batch is verified before being loaded, so if the
+// transfer breaks, it is resumed from the last batch loaded instead of
+// starting over: as loaded batches keep their sequence numbers, the local
+// WAL sequence number tells where the leader has to continue from.
+func (n *RaftNode) fetchAndLoadSnapshot(lastSeqNum uint64) error {
+	var err error
+	for attempt := 0; attempt < snapshotFetchAttempts; attempt++ {
+		if attempt > 0 {
+			n.metrics.SnapshotResumes.Inc()
+			n.log.Warnf("Snapshot transfer failed: %v. Resuming from WAL sequence number %d...", err, n.db.LastWALSequenceNumber())
+			time.Sleep(snapshotFetchBackoff * time.Duration(attempt))
+			// reload the state of the batches already loaded
+			if err = n.loadState(); err != nil {
+				return err
+			}
+		}
+
+		var reader io.ReadCloser
+		reader, err = n.attemptToFetchSnapshot(lastSeqNum, n.state.BalloonVersion)
+		if err != nil {
+			continue
+		}
+		err = n.db.LoadSnapshot(reader)
+		n.metrics.SnapshotLoadedSeqNum.Set(float64(n.db.LastWALSequenceNumber()))
+		if err == nil {
+			return nil
+		}
+	}
+	return err
 }
@@ -18,9 +18,13 @@ package consensus
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
 	"testing"
 	"time"
 
+	"google.golang.org/grpc"
+
 	"github.com/bbva/qed/crypto/hashing"
 	"github.com/bbva/qed/storage/rocks"
 	utilrand "github.com/bbva/qed/testutils/rand"
@@ -545,3 +549,92 @@ func TestRestoreOldNodeFromChangedLeader(t *testing.T) {
 	require.Equalf(t, fsmSnap1.LastSeqNum, fsmSnap3.LastSeqNum, "The lastSeqNum should be only 1")
 
 }
+
+type fakeSnapshotServer struct {
+	grpc.ServerStream
+	chunks []*Chunk
+}
+
+func (s *fakeSnapshotServer) Send(chunk *Chunk) error {
+	s.chunks = append(s.chunks, chunk)
+	return nil
+}
+
+type fakeSnapshotClient struct {
+	grpc.ClientStream
+	chunks []*Chunk
+}
+
+func (c *fakeSnapshotClient) Recv() (*Chunk, error) {
+	if len(c.chunks) == 0 {
+		return nil, io.EOF
+	}
+	chunk := c.chunks[0]
+	c.chunks = c.chunks[1:]
+	return chunk, nil
+}
+
+func TestSnapshotChunksChecksum(t *testing.T) {
+	metrics := newRaftNodeMetrics(&RaftNode{})
+
+	sendWith := func(checksums bool) []*Chunk {
+		srv := &fakeSnapshotServer{}
+		w := &chunkWriter{srv: srv, checksums: checksums, metrics: metrics}
+		for i := 0; i < 3; i++ {
+			_, err := w.Write([]byte(fmt.Sprintf("batch %d", i)))
+			require.NoError(t, err)
+		}
+		require.NoError(t, w.finish())
+		return srv.chunks
+	}
+	send := func() []*Chunk {
+		return sendWith(true)
+	}
+
+	receive := func(chunks []*Chunk) ([]byte, error) {
+		r := newChunkReader(nil, &fakeSnapshotClient{chunks: chunks}, metrics)
+		return ioutil.ReadAll(r)
+	}
+
+	// a complete stream
+	data, err := receive(send())
+	require.NoError(t, err)
+	require.Equal(t, []byte("batch 0batch 1batch 2"), data)
+
+	// a corrupted chunk
+	chunks := send()
+	chunks[1].Content = []byte("batch X")
+	_, err = receive(chunks)
+	require.Equal(t, ErrChunkChecksum, err)
+
+	// a lost chunk
+	chunks = send()
+	_, err = receive(append(chunks[:1], chunks[2:]...))
+	require.Equal(t, ErrStreamChecksum, err)
+
+	// a broken stream without trailer
+	chunks = send()
+	_, err = receive(chunks[:2])
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// followers that do not ask for checksums get plain chunks
+	chunks = sendWith(false)
+	require.Len(t, chunks, 3, "No trailer should be sent")
+	for _, chunk := range chunks {
+		require.False(t, chunk.Checksummed)
+	}
+
+	// and so do followers from leaders without checksums
+	data, err = receive(chunks)
+	require.NoError(t, err)
+	require.Equal(t, []byte("batch 0batch 1batch 2"), data)
+	data, err = receive(nil)
+	require.NoError(t, err)
+	require.Empty(t, data)
+
+	// a checksummed stream cannot switch to plain chunks
+	chunks = send()
+	chunks[1].Checksummed = false
+	_, err = receive(chunks)
+	require.Equal(t, ErrChunkChecksum, err)
+}
@@ -84,6 +84,24 @@ func NewEd25519SignerFromFile(privateKeyPath string) (Signer, error) {
 
 }
 
+// NewEd25519SignerFromKey creates an ed25519 signer from the raw bytes of
+// a private key, deriving the public key from it.
+func NewEd25519SignerFromKey(privateKey []byte) (Signer, error) {
+	if len(privateKey) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("invalid private key size %d, expected %d", len(privateKey), ed25519.PrivateKeySize)
+	}
+	key := ed25519.PrivateKey(append([]byte{}, privateKey...))
+	return &Ed25519Signer{
+		key,
+		key.Public().(ed25519.PublicKey),
+	}, nil
+}
+
+// PublicKey returns the public key used to verify signatures.
+func (s *Ed25519Signer) PublicKey() []byte {
+	return s.publicKey
+}
+
 func (s *Ed25519Signer) Sign(message []byte) ([]byte, error) {
 	return ed25519.Sign(s.privateKey, message), nil
 }
@@ -36,6 +36,18 @@ func testSign(t *testing.T, signer Signer) {
 
 func TestEdSign(t *testing.T) { testSign(t, NewEd25519Signer()) }
 
+func TestEdSignFromKey(t *testing.T) {
+	original := NewEd25519Signer().(*Ed25519Signer)
+
+	signer, err := NewEd25519SignerFromKey(original.privateKey)
+	require.NoError(t, err)
+	testSign(t, signer)
+	require.Equal(t, original.PublicKey(), signer.(*Ed25519Signer).PublicKey(), "Public key must be derived from the private one")
+
+	_, err = NewEd25519SignerFromKey([]byte("short key"))
+	require.Error(t, err)
+}
+
 func syncBenchmark(b *testing.B, signer Signer, iterations int) {
 
 	b.N = iterations
@@ -12,6 +12,7 @@ require (
 	github.com/hashicorp/memberlist v0.1.5
 	github.com/hashicorp/raft v1.1.1
 	github.com/imdario/mergo v0.3.7
+	github.com/klauspost/compress v1.10.10
 	github.com/kr/pretty v0.1.0 // indirect
 	github.com/octago/sflags v0.2.0
 	github.com/pkg/errors v0.8.1
@@ -0,0 +1,85 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package protocol
+
+import "fmt"
+
+// ErrorCode is a stable, machine readable identifier of an API error.
+type ErrorCode string
+
+const (
+	// ErrCodeNotLeader means the node cannot serve the request because it is
+	// not the cluster leader and the leader is unknown.
+	ErrCodeNotLeader ErrorCode = "not_leader"
+	// ErrCodeInvalidRange means the requested range of versions is malformed,
+	// i.e. its start is greater than its end.
+	ErrCodeInvalidRange ErrorCode = "invalid_range"
+	// ErrCodeVersionOutOfRange means the request refers to a version that
+	// the balloon has not reached yet.
+	ErrCodeVersionOutOfRange ErrorCode = "version_out_of_range"
+	// ErrCodePayloadTooLarge means the request body exceeds the size limit.
+	ErrCodePayloadTooLarge ErrorCode = "payload_too_large"
+	// ErrCodeRateLimited means the client is sending events faster than
+	// its rate limit allows. The Retry-After header tells when to retry.
+	ErrCodeRateLimited ErrorCode = "rate_limited"
+	// ErrCodeQuotaExceeded means the client has used up its daily quota of
+	// events. The Retry-After header tells when the quota is reset.
+	ErrCodeQuotaExceeded ErrorCode = "quota_exceeded"
+	// ErrCodeStaleRead means the node has not applied the version required
+	// by the consistency level of the request. Another node may serve it.
+	ErrCodeStaleRead ErrorCode = "stale_read"
+	// ErrCodeReadOnly means the cluster has been switched to read-only mode
+	// and does not accept new events.
+	ErrCodeReadOnly ErrorCode = "read_only"
+	// ErrCodeConflict means the request conflicts with the current state of
+	// the cluster, e.g. changing the hashing scheme of a non empty balloon.
+	ErrCodeConflict ErrorCode = "conflict"
+	// ErrCodeBadRequest means the request body or parameters cannot be parsed.
+	ErrCodeBadRequest ErrorCode = "bad_request"
+	// ErrCodeNotFound means the requested resource does not exist.
+	ErrCodeNotFound ErrorCode = "not_found"
+	// ErrCodeTicketUnknown means the ticket was not issued by the node
+	// that received the request: tickets are only kept by the leader that
+	// accepted the events, so it is returned after a leadership change or
+	// a restart. The events may have been added anyway, so check them with
+	// a membership query.
+	ErrCodeTicketUnknown ErrorCode = "ticket_unknown"
+	// ErrCodeUnavailable means the node is temporarily unable to accept
+	// the request.
+	ErrCodeUnavailable ErrorCode = "unavailable"
+	// ErrCodeInternal means an unexpected error happened on the server.
+	ErrCodeInternal ErrorCode = "internal"
+)
+
+// Error is the public struct that every API handler returns in the body
+// of an unsuccessful response.
+type Error struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+// NewError returns a new API error with the given code and message.
+func NewError(code ErrorCode, format string, args ...interface{}) *Error {
+	return &Error{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
@@ -40,18 +40,38 @@ type EventsBulk struct {
 	Events [][]byte
 }
 
+// ReadConsistency sets how up to date the balloon of the node serving a
+// proof must be.
+type ReadConsistency string
+
+const (
+	// ReadStale serves the proof from the local balloon of the node, which
+	// may lag behind the leader. It is the default level.
+	ReadStale ReadConsistency = "stale"
+	// ReadBounded serves the proof only if the local balloon has already
+	// applied the minimum version given in the request.
+	ReadBounded ReadConsistency = "bounded"
+	// ReadLinearizable serves the proof only after the local balloon has
+	// applied every event acknowledged by the leader when the request arrived.
+	ReadLinearizable ReadConsistency = "linearizable"
+)
+
 // MembershipQuery is the public struct that apihttp.Membership
 // Handler uses to parse the post params.
 type MembershipQuery struct {
-	Key     []byte
-	Version *uint64
+	Key         []byte
+	Version     *uint64
+	Consistency ReadConsistency
+	MinVersion  uint64
 }
 
 // MembershipDigest is the public struct that apihttp.DigestMembership
 // Handler uses to parse the post params.
 type MembershipDigest struct {
-	KeyDigest hashing.Digest
-	Version   *uint64
+	KeyDigest   hashing.Digest
+	Version     *uint64
+	Consistency ReadConsistency
+	MinVersion  uint64
 }
 
 // Snapshot is the public struct that apihttp.Add Handler call returns.
@@ -117,8 +137,10 @@ type MembershipResult struct {
 
 // IncrementalRequest is the information structure needed to ask for an incremental request.
 type IncrementalRequest struct {
-	Start uint64
-	End   uint64
+	Start       uint64
+	End         uint64
+	Consistency ReadConsistency
+	MinVersion  uint64
 }
 
 // IncrementalResponse is the information structure expected from an incremental proof request.
@@ -206,3 +228,53 @@ type BackupInfo struct {
 	NumFiles  int32
 	Metadata  string
 }
+
+// BackupVerification is the result of recomputing the roots of the last
+// balloon version found in a backup. Version is 18446744073709551615 for
+// backups of an empty balloon, like the version stored in the metadata.
+type BackupVerification struct {
+	BackupID      uint32
+	Metadata      string
+	Version       uint64
+	HistoryDigest hashing.Digest
+	HyperDigest   hashing.Digest
+	Mismatch      string // Why the roots do not match the metadata, if they don't.
+}
+
+// Checkpoint is a consistent copy of the database of a node, cut to be
+// served by a query-only server. Version is the last balloon version it
+// holds and the digests are the roots of that version.
+type Checkpoint struct {
+	Path          string
+	Version       uint64
+	HistoryDigest hashing.Digest
+	HyperDigest   hashing.Digest
+}
+
+// Change is a write batch committed to the balloon database, as sent by
+// the change feed. Seq is the WAL sequence number of the batch, which
+// consumers pass to resume the feed after it.
+type Change struct {
+	Seq       uint64
+	Metadata  *ChangeMetadata // Nil for batches written by maintenance tools.
+	Mutations []*ChangeMutation
+}
+
+// ChangeMetadata is the version metadata of a write batch. NewVersion is
+// the last balloon version after applying the batch and PreviousVersion
+// the last one before it. Batches adding events carry their digests, in
+// order.
+type ChangeMetadata struct {
+	PreviousVersion uint64
+	NewVersion      uint64
+	Settings        bool // The batch changes the cluster settings, not the balloon.
+	EventDigests    []hashing.Digest
+}
+
+// ChangeMutation is a key written to a table of the balloon database by
+// a write batch.
+type ChangeMutation struct {
+	Table string
+	Key   []byte
+	Value []byte
+}
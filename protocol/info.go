@@ -23,10 +23,20 @@ const (
 	Https Scheme = "https"
 )
 
+// NodeType tells whether a node takes part in the Raft elections and
+// commits or is a read replica.
+type NodeType string
+
+const (
+	VoterNode    NodeType = "voter"
+	NonvoterNode NodeType = "nonvoter"
+)
+
 // ShardDetail is the information required to define a Shard.
 type ShardDetail struct {
-	NodeId   string `json:"nodeId"`
-	HTTPAddr string `json:"httpAddr"`
+	NodeId   string   `json:"nodeId"`
+	HTTPAddr string   `json:"httpAddr"`
+	Type     NodeType `json:"type"`
 }
 
 // Shards is the public struct that apihttp.InfoShardsHandler call returns.
@@ -44,4 +54,15 @@ type NodeInfo struct {
 	MgmtAddr    string `json:"mgmt_addr"`
 	HttpAddr    string `json:"http_addr"`
 	MetricsAddr string `json:"metrics_addr"`
+	Nonvoter    bool   `json:"nonvoter"`
+}
+
+// RaftServer is the public struct that mgmthttp.ListServers call returns
+// for each member of the Raft cluster.
+type RaftServer struct {
+	NodeId   string `json:"nodeId"`
+	RaftAddr string `json:"raftAddr"`
+	MgmtAddr string `json:"mgmtAddr"`
+	Suffrage string `json:"suffrage"` // Voter, Nonvoter or Staging
+	State    string `json:"state"`    // Leader, Follower or Unreachable
 }
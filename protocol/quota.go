@@ -0,0 +1,32 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package protocol
+
+import "time"
+
+// QuotaUsage is the public struct that apihttp.QuotaHandler returns.
+// It describes the ingestion limits of the calling client and how much
+// of them has been used. A zero Rate or DailyQuota means unlimited.
+type QuotaUsage struct {
+	Client          string
+	Rate            float64
+	Burst           int
+	AvailableTokens float64
+	DailyQuota      uint64
+	DailyUsed       uint64
+	ResetsAt        time.Time
+}
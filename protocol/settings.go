@@ -0,0 +1,34 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package protocol
+
+// ClusterSettings is the public struct that mgmthttp.GetSettings call
+// returns. It holds the cluster-wide settings changed through replicated
+// admin commands, which every node switches at the same Raft log index.
+type ClusterSettings struct {
+	Index            uint64 `json:"index"`                      // Raft log index of the last change
+	SigningPublicKey []byte `json:"signingPublicKey,omitempty"` // empty if each node signs with its own key
+	HashingScheme    string `json:"hashingScheme"`
+	ReadOnly         bool   `json:"readOnly"`
+	BackupRetention  uint32 `json:"backupRetention"` // 0 keeps every backup
+}
+
+// SigningKeyRequest is the body of the mgmthttp.SetSigningKey call. The
+// key is an Ed25519 public key, base64 encoded in JSON.
+type SigningKeyRequest struct {
+	PublicKey []byte `json:"publicKey"`
+}
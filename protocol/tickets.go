@@ -0,0 +1,46 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package protocol
+
+// TicketState is the processing state of an asynchronous ingestion request.
+type TicketState string
+
+const (
+	// TicketPending means the events have not been committed yet.
+	TicketPending TicketState = "pending"
+	// TicketDone means the events have been committed and the snapshots
+	// are available.
+	TicketDone TicketState = "done"
+	// TicketFailed means the events could not be committed.
+	TicketFailed TicketState = "failed"
+)
+
+// Ticket is the public struct that apihttp.AddAsync and apihttp.AddBulkAsync
+// handlers return when an event or a bulk of events is accepted.
+type Ticket struct {
+	ID string
+}
+
+// TicketStatus is the public struct that apihttp.TicketHandler returns.
+// Snapshots is only filled once the ticket is done, and Error only
+// when the ticket has failed.
+type TicketStatus struct {
+	ID        string
+	State     TicketState
+	Snapshots []*Snapshot
+	Error     string
+}
@@ -21,6 +21,9 @@ import (
 	"os"
 	"path/filepath"
 	"time"
+
+	"github.com/bbva/qed/consensus"
+	"github.com/bbva/qed/storage/engine"
 )
 
 type Config struct {
@@ -48,12 +51,47 @@ type Config struct {
 	// (protocol://host:port).
 	RaftJoinAddr []string
 
+	// Pre-shared token sent when joining the cluster and required from
+	// the nodes joining it.
+	JoinToken string
+
+	// Certificate identities allowed to join the cluster, as
+	// "<node-id>=<identity>" pairs, where the identity is the common name
+	// or a DNS name of the node certificate. Requires mutual TLS.
+	JoinAllowList []string
+
+	// Join the cluster as a non-voting read replica. Replicas apply every
+	// event and serve proofs, but never vote nor become leaders.
+	Nonvoter bool
+
 	// Path to storage directory.
 	DBPath string
 
+	// Storage engine: "rocksdb", "lsm" or "bplus". The LSM engine is
+	// written in pure Go and is the default in builds without cgo. The
+	// bplus engine keeps the whole database in memory and only fits
+	// small deployments.
+	Storage string
+
+	// Path to a backup engine directory used to seed the database of a
+	// new node. Ignored if the database already exists.
+	SeedBackupPath string
+
+	// Backup ID restored from SeedBackupPath. Zero means the latest one.
+	SeedBackupID uint32
+
+	// Path to a RocksDB checkpoint used to seed the database of a new
+	// node. Ignored if the database already exists.
+	SeedCheckpointPath string
+
 	// Path to Raft storage directory.
 	RaftPath string
 
+	// Raft log store: "rocksdb" or "file". The file store does not depend
+	// on RocksDB and migrates an existing RocksDB log the first time. It
+	// is the default in builds without cgo.
+	RaftLogStore string
+
 	// Gossip management server bind address/port.
 	GossipAddr string
 
@@ -63,6 +101,11 @@ type Config struct {
 	// Path to the private key file used to sign snapshots.
 	PrivateKeyPath string
 
+	// Directory with the private keys that can be chosen as the cluster
+	// signing key with qed admin signing-key. Only their public keys are
+	// replicated, so every node must hold the same keys.
+	SigningKeysPath string
+
 	// Enable TLS service
 	EnableTLS bool
 
@@ -93,11 +136,77 @@ type Config struct {
 	// DB WAL TTL
 	DbWalTtl time.Duration
 
+	// Path to the file with the keys used to encrypt the database at
+	// rest, one "<id>:<hex key>" per line. If not set, the keys are read
+	// from the QED_ENCRYPTION_KEYS environment variable, and if it is not
+	// set either, the database is not encrypted.
+	EncryptionKeysPath string
+
 	RaftHeartbeatTimeout time.Duration
 
 	RaftElectionTimeout time.Duration
 
 	RaftLeaseTimeout time.Duration
+
+	// Maximum time an add request waits for concurrent ones to be
+	// committed together in a single Raft entry.
+	GroupCommitWindow time.Duration
+
+	// Maximum number of events committed together in a single Raft
+	// entry. Zero, the default, disables group commit: set it to enable
+	// grouping concurrent adds for GroupCommitWindow.
+	GroupCommitMaxSize int
+
+	// Maximum time spent draining the node on shutdown: rejecting new
+	// writes, finishing the pending ones, handing the leadership over and
+	// flushing the snapshots queue. Zero skips the drain.
+	DrainTimeout time.Duration
+
+	// Maximum rate of events per second accepted from each client, see
+	// QuotaAPIKeys. Zero disables rate limiting.
+	RateLimit float64
+
+	// Maximum burst of events accepted from each client. Defaults to the
+	// rate limit.
+	RateBurst int
+
+	// Maximum number of events accepted per day from each client. Zero
+	// disables quotas.
+	DailyQuota uint64
+
+	// API keys identifying the clients the ingestion limits are applied
+	// to, besides verified client certificates. Requests with any other
+	// key, or none, share the limits of a single anonymous client.
+	QuotaAPIKeys []string
+
+	// Time between scheduled backups. Zero disables them, unless a
+	// backup schedule is set.
+	BackupInterval time.Duration
+
+	// Cron-like schedule of backups: "minute hour day-of-month month
+	// day-of-week". Takes precedence over the backup interval.
+	BackupSchedule string
+
+	// Node taking the scheduled backups. If not set, the leader takes
+	// them.
+	BackupNode string
+
+	// Number of most recent backups kept after a scheduled backup. If
+	// none of the keep options is set, scheduled backups are pruned
+	// with the cluster backup retention instead.
+	BackupKeepLast int
+
+	// Number of days whose newest backup is kept after a scheduled
+	// backup.
+	BackupKeepDaily int
+
+	// Number of weeks whose newest backup is kept after a scheduled
+	// backup.
+	BackupKeepWeekly int
+
+	// List of notification service endpoints alerted when a scheduled
+	// backup fails (http://ip1:port1/path1,http://ip2:port2/path2...).
+	BackupAlertEndpoint []string
 }
 
 func DefaultConfig() *Config {
@@ -111,10 +220,14 @@ func DefaultConfig() *Config {
 		MgmtAddr:                "127.0.0.1:8700",
 		MetricsAddr:             "127.0.0.1:8600",
 		RaftJoinAddr:            []string{},
+		JoinAllowList:           []string{},
+		Nonvoter:                false,
 		GossipAddr:              "127.0.0.1:8400",
 		GossipJoinAddr:          []string{},
 		DBPath:                  currentDir + "/db",
+		Storage:                 engine.Default,
 		RaftPath:                currentDir + "/raft",
+		RaftLogStore:            consensus.DefaultLogStore,
 		EnableTLS:               false,
 		EnableProfiling:         false,
 		ProfilingAddr:           "127.0.0.1:6060",
@@ -125,10 +238,26 @@ func DefaultConfig() *Config {
 		TLSMutualAuth:           false,
 		TLSVerifyServerHostname: false,
 		PrivateKeyPath:          "",
+		SigningKeysPath:         "",
 		DbWalTtl:                0,
+		EncryptionKeysPath:      "",
 		RaftHeartbeatTimeout:    1000 * time.Millisecond,
 		RaftElectionTimeout:     1000 * time.Millisecond,
 		RaftLeaseTimeout:        1000 * time.Millisecond,
+		GroupCommitWindow:       time.Millisecond,
+		GroupCommitMaxSize:      0,
+		DrainTimeout:            10 * time.Second,
+		RateLimit:               0,
+		RateBurst:               0,
+		DailyQuota:              0,
+		QuotaAPIKeys:            []string{},
+		BackupInterval:          0,
+		BackupSchedule:          "",
+		BackupNode:              "",
+		BackupKeepLast:          0,
+		BackupKeepDaily:         0,
+		BackupKeepWeekly:        0,
+		BackupAlertEndpoint:     []string{},
 	}
 }
 
@@ -0,0 +1,121 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/bbva/qed/api/apihttp"
+	"github.com/bbva/qed/consensus"
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/storage/encrypted"
+)
+
+// QueryOnlyServer serves the proofs of the balloon frozen in a checkpoint
+// through the HTTP API, so audit jobs do not compete with ingestion for
+// the database of a running node. It does not join any cluster and
+// cannot add events.
+type QueryOnlyServer struct {
+	conf       *Config
+	node       *consensus.QueryOnlyNode
+	httpServer *http.Server
+	log        log.Logger
+}
+
+// NewQueryOnlyServerWithLogger opens the checkpoint at the given path and
+// creates a server for its proofs on the HTTP address of the config.
+func NewQueryOnlyServerWithLogger(conf *Config, checkpoint string, logger log.Logger) (*QueryOnlyServer, error) {
+
+	keyring, err := encrypted.LoadKeyringOrEnv(conf.EncryptionKeysPath)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := consensus.OpenQueryOnlyNode(checkpoint, keyring, logger.Named("checkpoint"))
+	if err != nil {
+		return nil, err
+	}
+	logger.Infof("Checkpoint at %s opened with version %d", checkpoint, node.Version())
+
+	server := &QueryOnlyServer{
+		conf: conf,
+		node: node,
+		log:  logger,
+	}
+
+	httpMux := apihttp.NewQueryOnlyApiHttp(node)
+	if conf.EnableTLS {
+		server.httpServer = newTLSServer(conf.HTTPAddr, httpMux, logger.Named("api"))
+	} else {
+		server.httpServer = newHTTPServer(conf.HTTPAddr, httpMux, logger.Named("api"))
+	}
+
+	return server, nil
+}
+
+// Version returns the balloon version served.
+func (s *QueryOnlyServer) Version() uint64 {
+	return s.node.Version()
+}
+
+// Start will start the server in a non-blockable fashion.
+func (s *QueryOnlyServer) Start() error {
+	s.log.Infof("Starting QED query-only server for version %d", s.node.Version())
+
+	if s.conf.EnableTLS {
+		go func() {
+			s.log.Infof("\t* Starting QED API HTTPS server in addr: %s", s.conf.HTTPAddr)
+			err := s.httpServer.ListenAndServeTLS(
+				s.conf.TLSCertPath,
+				s.conf.TLSKeyPath,
+			)
+			if err != http.ErrServerClosed {
+				s.log.Fatalf("Can't start QED API HTTP Server: %v", err)
+			}
+		}()
+	} else {
+		go func() {
+			s.log.Infof("\t* Starting QED API HTTP server in addr: %s", s.conf.HTTPAddr)
+			if err := s.httpServer.ListenAndServe(); err != http.ErrServerClosed {
+				s.log.Fatalf("Can't start QED API HTTP Server: %v", err)
+			}
+		}()
+	}
+
+	return nil
+}
+
+// Stop shuts down the HTTP server and closes the checkpoint.
+func (s *QueryOnlyServer) Stop() error {
+	s.log.Info("Shutting down QED query-only server")
+
+	s.log.Info("Stopping API HTTP server...")
+	if err := s.httpServer.Shutdown(context.Background()); err != nil {
+		s.log.Errorf("Unable to stop API HTTP server: %v", err)
+		return err
+	}
+
+	s.log.Info("Closing checkpoint...")
+	if err := s.node.Close(); err != nil {
+		s.log.Errorf("Unable to close checkpoint: %v", err)
+		return err
+	}
+
+	s.log.Info("Done. Exiting...")
+	return nil
+}
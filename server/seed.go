@@ -0,0 +1,58 @@
+//go:build cgo
+// +build cgo
+
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package server
+
+import (
+	"errors"
+
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/storage/engine"
+	"github.com/bbva/qed/storage/rocks"
+)
+
+// seedDB restores the configured backup or checkpoint into an empty
+// database directory. The node then loads its state from the seed and,
+// once it joins the cluster, only catches up the raft entries and WAL
+// range it is missing.
+func seedDB(conf *Config, logger log.Logger) error {
+	seeded := conf.SeedBackupPath != "" || conf.SeedCheckpointPath != ""
+	if seeded && conf.Storage != engine.RocksDB {
+		return errors.New("seeding the database is only supported by the rocksdb storage engine")
+	}
+
+	var err error
+	switch {
+	case conf.SeedBackupPath != "" && conf.SeedCheckpointPath != "":
+		return errors.New("seed backup and seed checkpoint are mutually exclusive")
+	case conf.SeedBackupPath != "":
+		logger.Infof("Seeding database at %s from backup %d in %s", conf.DBPath, conf.SeedBackupID, conf.SeedBackupPath)
+		err = rocks.SeedFromBackup(conf.SeedBackupPath, conf.SeedBackupID, conf.DBPath)
+	case conf.SeedCheckpointPath != "":
+		logger.Infof("Seeding database at %s from checkpoint %s", conf.DBPath, conf.SeedCheckpointPath)
+		err = rocks.SeedFromCheckpoint(conf.SeedCheckpointPath, conf.DBPath)
+	default:
+		return nil
+	}
+	if err == rocks.ErrDatabaseExists {
+		logger.Infof("Database at %s already exists, skipping seed", conf.DBPath)
+		return nil
+	}
+	return err
+}
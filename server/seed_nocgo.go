@@ -0,0 +1,35 @@
+//go:build !cgo
+// +build !cgo
+
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package server
+
+import (
+	"errors"
+
+	"github.com/bbva/qed/log"
+)
+
+// seedDB fails if a seed is configured: backups and checkpoints can
+// only be restored by the rocksdb storage engine.
+func seedDB(conf *Config, logger log.Logger) error {
+	if conf.SeedBackupPath != "" || conf.SeedCheckpointPath != "" {
+		return errors.New("seeding the database requires a build with cgo")
+	}
+	return nil
+}
@@ -17,6 +17,7 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -151,6 +152,27 @@ func (s Sender) batcher(id int, ch chan *protocol.Snapshot) {
 	}
 }
 
+// Flush waits until the snapshots queued in ch have been signed and
+// published, or the context is done.
+func (s Sender) Flush(ctx context.Context, ch chan *protocol.Snapshot) error {
+	ticker := time.NewTicker(s.Interval / 2)
+	defer ticker.Stop()
+	for len(ch) > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	// give the batchers a couple of ticks to publish their last batch
+	select {
+	case <-time.After(2 * s.Interval):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (s Sender) Stop() {
 	QedSenderInstancesCount.Dec()
 	close(s.quitCh)
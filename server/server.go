@@ -23,6 +23,7 @@ import (
 	"crypto/tls"
 	"net/http"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/bbva/qed/api/apihttp"
@@ -34,7 +35,8 @@ import (
 	"github.com/bbva/qed/log"
 	"github.com/bbva/qed/metrics"
 	"github.com/bbva/qed/protocol"
-	"github.com/bbva/qed/storage/rocks"
+	"github.com/bbva/qed/storage/encrypted"
+	"github.com/bbva/qed/storage/engine"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -51,6 +53,8 @@ type Server struct {
 	signer             sign.Signer
 	sender             *Sender
 	agent              *gossip.Agent
+	backupScheduler    *consensus.BackupScheduler
+	notifier           gossip.Notifier
 	snapshotsCh        chan *protocol.Snapshot
 	log                log.Logger
 }
@@ -85,17 +89,36 @@ func NewServerWithLogger(conf *Config, logger log.Logger) (*Server, error) {
 		return nil, err
 	}
 
-	// Open RocksDB store
-	store, err := rocks.NewRocksDBStore(conf.DBPath, conf.DbWalTtl)
+	if err := seedDB(conf, logger); err != nil {
+		return nil, err
+	}
+
+	// Load the encryption keys, if any
+	keyring, err := encrypted.LoadKeyringOrEnv(conf.EncryptionKeysPath)
+	if err != nil {
+		return nil, err
+	}
+	if keyring != nil {
+		logger.Infof("Encryption at rest enabled with key %d", keyring.Active())
+	}
+
+	// Open the storage engine
+	store, err := engine.OpenWithOptions(conf.Storage, conf.DBPath, engine.Options{
+		WALTtl:  conf.DbWalTtl,
+		Keyring: keyring,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	// Create signer
-	server.signer, err = sign.NewEd25519SignerFromFile(conf.PrivateKeyPath)
+	// Create signer. Once the raft node is up, the signing key replicated
+	// through the cluster settings takes precedence over the local one.
+	localSigner, err := sign.NewEd25519SignerFromFile(conf.PrivateKeyPath)
 	if err != nil {
 		return nil, err
 	}
+	signer := &clusterSigner{local: localSigner}
+	server.signer = signer
 
 	// Create metrics server
 	server.metricsServer = metrics.NewServer(conf.MetricsAddr)
@@ -148,12 +171,21 @@ func NewServerWithLogger(conf *Config, logger log.Logger) (*Server, error) {
 	clusterOpts.Addr = conf.RaftAddr
 	clusterOpts.HttpAddr = conf.HTTPAddr
 	clusterOpts.RaftLogPath = conf.RaftPath
+	clusterOpts.RaftLogStore = conf.RaftLogStore
 	clusterOpts.MgmtAddr = conf.MgmtAddr
 	clusterOpts.Bootstrap = bootstrap
+	clusterOpts.Nonvoter = conf.Nonvoter
+	clusterOpts.JoinToken = conf.JoinToken
+	clusterOpts.JoinAllowList = conf.JoinAllowList
 	clusterOpts.RaftLogging = true
 	clusterOpts.RaftHeartbeatTimeout = conf.RaftHeartbeatTimeout
 	clusterOpts.RaftElectionTimeout = conf.RaftElectionTimeout
 	clusterOpts.RaftLeaseTimeout = conf.RaftLeaseTimeout
+	clusterOpts.GroupCommitWindow = conf.GroupCommitWindow
+	clusterOpts.GroupCommitMaxSize = conf.GroupCommitMaxSize
+	clusterOpts.Keyring = keyring
+	clusterOpts.CheckpointsPath = filepath.Join(conf.DBPath, "checkpoints")
+	clusterOpts.SigningKeysPath = conf.SigningKeysPath
 	if !bootstrap {
 		clusterOpts.Seeds = conf.RaftJoinAddr
 	}
@@ -162,9 +194,46 @@ func NewServerWithLogger(conf *Config, logger log.Logger) (*Server, error) {
 	if err != nil {
 		return nil, err
 	}
+	signer.cluster = server.raftNode.Signer
+
+	// Create backup scheduler
+	if conf.BackupInterval > 0 || conf.BackupSchedule != "" {
+		opts := &consensus.BackupSchedulerOptions{
+			Interval: conf.BackupInterval,
+			Schedule: conf.BackupSchedule,
+			Node:     conf.BackupNode,
+			Retention: consensus.BackupRetentionPolicy{
+				KeepLast:   conf.BackupKeepLast,
+				KeepDaily:  conf.BackupKeepDaily,
+				KeepWeekly: conf.BackupKeepWeekly,
+			},
+		}
+		if len(conf.BackupAlertEndpoint) > 0 {
+			notifierConf := gossip.DefaultSimpleNotifierConfig()
+			notifierConf.Endpoint = conf.BackupAlertEndpoint
+			server.notifier = gossip.NewSimpleNotifierFromConfig(notifierConf, server.log.Named("notifier"))
+			opts.Alert = func(msg string) {
+				_ = server.notifier.Alert(msg)
+			}
+		}
+		server.backupScheduler, err = consensus.NewBackupScheduler(server.raftNode, opts, server.log.Named("backups"))
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	// Create http endpoints
-	httpMux := apihttp.NewApiHttp(server.raftNode)
+	var limiter *apihttp.Limiter
+	if conf.RateLimit > 0 || conf.DailyQuota > 0 {
+		limiter = apihttp.NewLimiter(apihttp.QuotaConfig{
+			Rate:       conf.RateLimit,
+			Burst:      conf.RateBurst,
+			DailyQuota: conf.DailyQuota,
+			APIKeys:    conf.QuotaAPIKeys,
+		})
+		logger.Infof("Ingestion limits enabled: rate %v events/s, burst %d, daily quota %d", conf.RateLimit, conf.RateBurst, conf.DailyQuota)
+	}
+	httpMux := apihttp.NewApiHttpWithLimiter(server.raftNode, limiter)
 	if conf.EnableTLS {
 		server.httpServer = newTLSServer(conf.HTTPAddr, httpMux, logger.Named("api"))
 	} else {
@@ -182,6 +251,9 @@ func NewServerWithLogger(conf *Config, logger log.Logger) (*Server, error) {
 	store.RegisterMetrics(server.metricsServer)
 	server.raftNode.RegisterMetrics(server.metricsServer)
 	server.sender.RegisterMetrics(server.metricsServer)
+	if server.backupScheduler != nil {
+		server.backupScheduler.RegisterMetrics(server.metricsServer)
+	}
 
 	return server, nil
 }
@@ -236,6 +308,14 @@ func (s *Server) Start() error {
 	s.log.Info("Starting snapshots sender...")
 	s.sender.Start(s.snapshotsCh)
 
+	if s.backupScheduler != nil {
+		s.log.Info("Starting backup scheduler...")
+		if s.notifier != nil {
+			s.notifier.Start()
+		}
+		s.backupScheduler.Start()
+	}
+
 	if err := s.raftNode.WaitForLeader(5 * time.Second); err != nil {
 		return err
 	}
@@ -250,6 +330,18 @@ func (s *Server) Stop() error {
 	s.metrics.Instances.Dec()
 	s.log.Infof("Shutting down QED server. Node ID: %s", s.conf.NodeID)
 
+	if s.backupScheduler != nil {
+		s.log.Info("Stopping backup scheduler...")
+		s.backupScheduler.Stop()
+		if s.notifier != nil {
+			s.notifier.Stop()
+		}
+	}
+
+	if s.conf.DrainTimeout > 0 {
+		s.drain()
+	}
+
 	s.log.Info("Metrics enabled: stopping server...")
 	s.metricsServer.Shutdown()
 
@@ -287,6 +379,25 @@ func (s *Server) Stop() error {
 	return nil
 }
 
+// drain stops accepting writes, finishes the pending ones, hands the
+// leadership over and flushes the snapshots queue, so clients do not
+// wait for an election timeout. Errors are logged but do not stop the
+// shutdown.
+func (s *Server) drain() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.conf.DrainTimeout)
+	defer cancel()
+
+	s.log.Info("Draining RAFT node...")
+	if err := s.raftNode.Drain(ctx); err != nil {
+		s.log.Warnf("Unable to drain raft node: %v", err)
+	}
+
+	s.log.Info("Flushing QED sender...")
+	if err := s.sender.Flush(ctx, s.snapshotsCh); err != nil {
+		s.log.Warnf("Unable to flush sender: %v", err)
+	}
+}
+
 func (s *Server) RegisterMetrics(registry metrics.Registry) {
 	if registry != nil {
 		registry.MustRegister(s.metrics.collectors()...)
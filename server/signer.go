@@ -0,0 +1,45 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package server
+
+import (
+	"github.com/bbva/qed/crypto/sign"
+)
+
+// clusterSigner signs snapshots with the key replicated through the
+// cluster settings, falling back to the node's own key until one is set.
+type clusterSigner struct {
+	local   sign.Signer
+	cluster func() sign.Signer
+}
+
+func (s *clusterSigner) current() sign.Signer {
+	if s.cluster != nil {
+		if signer := s.cluster(); signer != nil {
+			return signer
+		}
+	}
+	return s.local
+}
+
+func (s *clusterSigner) Sign(message []byte) ([]byte, error) {
+	return s.current().Sign(message)
+}
+
+func (s *clusterSigner) Verify(message, sig []byte) (bool, error) {
+	return s.current().Verify(message, sig)
+}
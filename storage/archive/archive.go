@@ -0,0 +1,332 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package archive packs a database directory into a portable archive and
+// extracts it back.
+//
+// An archive is a zstd compressed tar stream. Its first entry is a JSON
+// manifest describing the database: the storage engine, the balloon
+// version and roots it holds, and the size and SHA-256 checksum of every
+// file that follows. Archives are written and read as streams, so they
+// can be served over HTTP without staging them on disk.
+package archive
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ManifestName is the name of the manifest entry.
+const ManifestName = "MANIFEST.json"
+
+// FormatVersion is the version of the archive format written by Write.
+const FormatVersion = 1
+
+var (
+	// ErrNoManifest is returned when the first entry of an archive is
+	// not a manifest.
+	ErrNoManifest = errors.New("archive does not start with a manifest")
+	// ErrChecksum is returned when a file does not match the checksum or
+	// size recorded in the manifest.
+	ErrChecksum = errors.New("archive file does not match its checksum")
+)
+
+// Manifest describes the content of an archive.
+type Manifest struct {
+	Format         int    `json:"format"`
+	BackupID       uint32 `json:"backupId"`
+	Timestamp      int64  `json:"timestamp"` // Creation time of the backup, in seconds.
+	Metadata       string `json:"metadata"`  // Metadata of the backup.
+	Engine         string `json:"engine"`    // Storage engine of the database.
+	BalloonVersion uint64 `json:"balloonVersion"`
+	HistoryDigest  string `json:"historyDigest"` // Hex encoded roots at BalloonVersion.
+	HyperDigest    string `json:"hyperDigest"`
+	Files          []File `json:"files"`
+}
+
+// File is an entry of the manifest.
+type File struct {
+	Name   string `json:"name"` // Slash separated path relative to the database directory.
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Write archives the files under dir to w, skipping the top level
+// directories listed in exclude. The Files and Format of the manifest
+// are filled in by Write.
+func Write(w io.Writer, dir string, m *Manifest, exclude ...string) error {
+	files, err := listFiles(dir, exclude)
+	if err != nil {
+		return err
+	}
+	m.Format = FormatVersion
+	m.Files = files
+
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(zw)
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{
+		Name:    ManifestName,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+
+	for _, f := range m.Files {
+		if err := writeFile(tw, dir, f); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func writeFile(tw *tar.Writer, dir string, f File) error {
+	in, err := os.Open(filepath.Join(dir, filepath.FromSlash(f.Name)))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	hdr := &tar.Header{
+		Name:    f.Name,
+		Mode:    0644,
+		Size:    f.Size,
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	// the file must not change between the checksum and the copy
+	h := sha256.New()
+	if _, err := io.CopyN(io.MultiWriter(tw, h), in, f.Size); err != nil {
+		return err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != f.SHA256 {
+		return fmt.Errorf("%s changed while being archived", f.Name)
+	}
+	return nil
+}
+
+// listFiles returns the regular files under dir with their checksums.
+func listFiles(dir string, exclude []string) ([]File, error) {
+	files := make([]File, 0)
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if info.IsDir() {
+			for _, e := range exclude {
+				if name == e {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		sum, err := checksum(p)
+		if err != nil {
+			return err
+		}
+		files = append(files, File{Name: name, Size: info.Size(), SHA256: sum})
+		return nil
+	})
+	return files, err
+}
+
+func checksum(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ReadManifest returns the manifest of an archive without extracting it.
+func ReadManifest(r io.Reader) (*Manifest, error) {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return readManifest(tar.NewReader(zr))
+}
+
+func readManifest(tr *tar.Reader) (*Manifest, error) {
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != ManifestName {
+		return nil, ErrNoManifest
+	}
+	m := new(Manifest)
+	if err := json.NewDecoder(tr).Decode(m); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %v", err)
+	}
+	if m.Format != FormatVersion {
+		return nil, fmt.Errorf("unsupported archive format %d", m.Format)
+	}
+	return m, nil
+}
+
+// Extract extracts an archive into dir, which must not exist or be
+// empty, checking every file against the manifest. The files are
+// extracted to a temporary directory next to dir, which is renamed once
+// the whole archive has been verified, so dir is never left with a
+// partial database.
+func Extract(r io.Reader, dir string) (*Manifest, error) {
+	if err := ensureEmpty(dir); err != nil {
+		return nil, err
+	}
+
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+
+	m, err := readManifest(tr)
+	if err != nil {
+		return nil, err
+	}
+	expected := make(map[string]File, len(m.Files))
+	for _, f := range m.Files {
+		expected[f.Name] = f
+	}
+
+	tmp := strings.TrimRight(dir, string(filepath.Separator)) + ".extract"
+	if err := os.RemoveAll(tmp); err != nil {
+		return nil, err
+	}
+	err = func() error {
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			f, ok := expected[hdr.Name]
+			if !ok {
+				return fmt.Errorf("unexpected file %s in archive", hdr.Name)
+			}
+			delete(expected, hdr.Name)
+			if err := extractFile(tr, tmp, f); err != nil {
+				return err
+			}
+		}
+		for name := range expected {
+			return fmt.Errorf("file %s missing from archive", name)
+		}
+		if err := os.MkdirAll(tmp, 0755); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+		return os.Rename(tmp, dir)
+	}()
+	if err != nil {
+		os.RemoveAll(tmp)
+		return nil, err
+	}
+	return m, nil
+}
+
+func extractFile(r io.Reader, dir string, f File) error {
+	clean := path.Clean(f.Name)
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("invalid file name %s in archive", f.Name)
+	}
+	p := filepath.Join(dir, filepath.FromSlash(clean))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(out, h), r)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if n != f.Size || hex.EncodeToString(h.Sum(nil)) != f.SHA256 {
+		return fmt.Errorf("%v: %s", ErrChecksum, f.Name)
+	}
+	return nil
+}
+
+func ensureEmpty(dir string) error {
+	d, err := os.Open(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if _, err := d.Readdirnames(1); err != io.EOF {
+		return fmt.Errorf("directory %s is not empty", dir)
+	}
+	return nil
+}
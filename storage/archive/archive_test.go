@@ -0,0 +1,122 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteAndExtract(t *testing.T) {
+	src := mustTempDir(t)
+	defer os.RemoveAll(src)
+	mustWriteFile(t, filepath.Join(src, "MANIFEST"), "manifest")
+	mustWriteFile(t, filepath.Join(src, "000001.sst"), "table")
+	mustWriteFile(t, filepath.Join(src, "wal", "000002.log"), "wal")
+	mustWriteFile(t, filepath.Join(src, "backups", "1", "BACKUP"), "excluded")
+
+	var buf bytes.Buffer
+	m := &Manifest{BackupID: 1, Engine: "lsm", BalloonVersion: 9, HistoryDigest: "aa", HyperDigest: "bb"}
+	require.NoError(t, Write(&buf, src, m, "backups"))
+	require.Len(t, m.Files, 3, "The backups directory should be excluded")
+
+	read, err := ReadManifest(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, m, read)
+
+	dst := filepath.Join(src, "restored")
+	extracted, err := Extract(bytes.NewReader(buf.Bytes()), dst)
+	require.NoError(t, err)
+	require.Equal(t, m, extracted)
+
+	data, err := ioutil.ReadFile(filepath.Join(dst, "wal", "000002.log"))
+	require.NoError(t, err)
+	require.Equal(t, "wal", string(data))
+	_, err = os.Stat(filepath.Join(dst, "backups"))
+	require.True(t, os.IsNotExist(err))
+
+	_, err = Extract(bytes.NewReader(buf.Bytes()), dst)
+	require.Error(t, err, "Extracting over an existing database should fail")
+}
+
+func TestExtractCorrupted(t *testing.T) {
+	dir := mustTempDir(t)
+	defer os.RemoveAll(dir)
+
+	m := &Manifest{
+		Format: FormatVersion,
+		Files:  []File{{Name: "MANIFEST", Size: 8, SHA256: "00"}},
+	}
+	archive := buildArchive(t, m, map[string]string{"MANIFEST": "manifest"})
+	dst := filepath.Join(dir, "db")
+	_, err := Extract(bytes.NewReader(archive), dst)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), ErrChecksum.Error())
+	_, err = os.Stat(dst)
+	require.True(t, os.IsNotExist(err), "A corrupted archive should not leave files behind")
+
+	m.Files[0].Name = "../MANIFEST"
+	archive = buildArchive(t, m, map[string]string{"../MANIFEST": "manifest"})
+	_, err = Extract(bytes.NewReader(archive), dst)
+	require.Error(t, err)
+
+	archive = buildArchive(t, nil, map[string]string{"MANIFEST": "manifest"})
+	_, err = Extract(bytes.NewReader(archive), dst)
+	require.Equal(t, ErrNoManifest, err)
+}
+
+func buildArchive(t *testing.T, m *Manifest, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw, err := zstd.NewWriter(&buf)
+	require.NoError(t, err)
+	tw := tar.NewWriter(zw)
+	write := func(name string, data []byte) {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data))}))
+		_, err := tw.Write(data)
+		require.NoError(t, err)
+	}
+	if m != nil {
+		data, err := json.Marshal(m)
+		require.NoError(t, err)
+		write(ManifestName, data)
+	}
+	for name, content := range files {
+		write(name, []byte(content))
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func mustTempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "archive-test-")
+	require.NoError(t, err)
+	return dir
+}
+
+func mustWriteFile(t *testing.T, path, content string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+}
@@ -0,0 +1,181 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Record types of the RocksDB write batch format.
+const (
+	batchHeaderSize = 12 // sequence number (8) + count (4)
+
+	typeValue             = 0x1
+	typeLogData           = 0x3
+	typeColumnFamilyValue = 0x5
+)
+
+// ErrCorruptedBatch is returned when a write batch cannot be decoded.
+var ErrCorruptedBatch = errors.New("corrupted write batch")
+
+// WriteBatch is a set of mutations applied atomically along with their
+// metadata. It is encoded in the RocksDB write batch format, where each
+// table is a column family with the same ID, so the snapshots of every
+// store can be exchanged with nodes running on RocksDB.
+type WriteBatch struct {
+	Seq       uint64
+	Metadata  []byte
+	Mutations []*Mutation
+}
+
+// Count returns the number of mutations, which is the amount the
+// sequence number advances when the batch is written.
+func (b *WriteBatch) Count() uint64 {
+	return uint64(len(b.Mutations))
+}
+
+// Encode returns the RocksDB representation of the batch.
+func (b *WriteBatch) Encode() []byte {
+	size := batchHeaderSize + 1 + binary.MaxVarintLen32 + len(b.Metadata)
+	for _, m := range b.Mutations {
+		size += 1 + 3*binary.MaxVarintLen32 + len(m.Key) + len(m.Value)
+	}
+	buf := make([]byte, batchHeaderSize, size)
+	binary.LittleEndian.PutUint64(buf, b.Seq)
+	binary.LittleEndian.PutUint32(buf[8:], uint32(len(b.Mutations)))
+
+	// the metadata goes first, as in RocksDBStore.Mutate
+	buf = append(buf, typeLogData)
+	buf = appendVarString(buf, b.Metadata)
+	for _, m := range b.Mutations {
+		if m.Table == DefaultTable {
+			buf = append(buf, typeValue)
+		} else {
+			buf = append(buf, typeColumnFamilyValue)
+			buf = appendUvarint(buf, uint64(m.Table))
+		}
+		buf = appendVarString(buf, m.Key)
+		buf = appendVarString(buf, m.Value)
+	}
+	return buf
+}
+
+// DecodeWriteBatch decodes a write batch in the RocksDB representation.
+// The returned mutations share memory with data.
+func DecodeWriteBatch(data []byte) (*WriteBatch, error) {
+	if len(data) < batchHeaderSize {
+		return nil, ErrCorruptedBatch
+	}
+	b := &WriteBatch{
+		Seq: binary.LittleEndian.Uint64(data),
+	}
+	count := binary.LittleEndian.Uint32(data[8:])
+	b.Mutations = make([]*Mutation, 0, count)
+
+	rest := data[batchHeaderSize:]
+	for len(rest) > 0 {
+		typ := rest[0]
+		rest = rest[1:]
+		switch typ {
+		case typeLogData:
+			var blob []byte
+			if blob, rest = readVarString(rest); rest == nil {
+				return nil, ErrCorruptedBatch
+			}
+			if b.Metadata == nil {
+				b.Metadata = blob
+			}
+		case typeValue, typeColumnFamilyValue:
+			table := DefaultTable
+			if typ == typeColumnFamilyValue {
+				cf, n := binary.Uvarint(rest)
+				if n <= 0 {
+					return nil, ErrCorruptedBatch
+				}
+				table, rest = Table(cf), rest[n:]
+			}
+			var key, value []byte
+			if key, rest = readVarString(rest); rest == nil {
+				return nil, ErrCorruptedBatch
+			}
+			if value, rest = readVarString(rest); rest == nil {
+				return nil, ErrCorruptedBatch
+			}
+			b.Mutations = append(b.Mutations, NewMutation(table, key, value))
+		default:
+			return nil, errors.New("unsupported write batch record type")
+		}
+	}
+
+	if uint32(len(b.Mutations)) != count {
+		return nil, ErrCorruptedBatch
+	}
+	return b, nil
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+func appendVarString(buf, s []byte) []byte {
+	buf = appendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// readVarString reads a length prefixed string. The returned rest
+// is nil if the input is truncated.
+func readVarString(buf []byte) (s, rest []byte) {
+	size, n := binary.Uvarint(buf)
+	if n <= 0 || uint64(len(buf)-n) < size {
+		return nil, nil
+	}
+	end := n + int(size)
+	return buf[n:end:end], buf[end:]
+}
+
+// ReadChunk reads a write batch written to a snapshot stream by
+// WriteChunk.
+func ReadChunk(r io.Reader) ([]byte, error) {
+	sizeBuff := make([]byte, 8)
+	if _, err := io.ReadFull(r, sizeBuff); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint64(sizeBuff)
+	chunk := make([]byte, size)
+	if _, err := io.ReadFull(r, chunk); err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("Corrupted chunk")
+		}
+		return nil, err
+	}
+	return chunk, nil
+}
+
+// WriteChunk writes a write batch to a snapshot stream, prefixed by its
+// size as FetchSnapshot does in every store.
+func WriteChunk(w io.Writer, data []byte) error {
+	size := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint64(size, uint64(len(data)))
+	_, err := w.Write(append(size, data...))
+	return err
+}
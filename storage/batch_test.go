@@ -0,0 +1,68 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteBatchFormat(t *testing.T) {
+	b := &WriteBatch{
+		Seq:      7,
+		Metadata: []byte("m"),
+		Mutations: []*Mutation{
+			{Table: DefaultTable, Key: []byte("a"), Value: []byte("b")},
+			{Table: HistoryTable, Key: []byte("k"), Value: []byte("vv")},
+		},
+	}
+
+	// RocksDB write batch representation
+	expected := []byte{
+		0x7, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, // sequence number
+		0x2, 0x0, 0x0, 0x0, // count
+		0x3, 0x1, 'm', // log data
+		0x1, 0x1, 'a', 0x1, 'b', // put in the default column family
+		0x5, 0x3, 0x1, 'k', 0x2, 'v', 'v', // put in column family 3
+	}
+	data := b.Encode()
+	require.Equal(t, expected, data)
+
+	decoded, err := DecodeWriteBatch(data)
+	require.NoError(t, err)
+	require.Equal(t, b, decoded)
+
+	_, err = DecodeWriteBatch(data[:len(data)-1])
+	require.Error(t, err)
+}
+
+func TestReadWriteChunk(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteChunk(&buf, []byte("first")))
+	require.NoError(t, WriteChunk(&buf, []byte("second")))
+
+	chunk, err := ReadChunk(&buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte("first"), chunk)
+	chunk, err = ReadChunk(&buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte("second"), chunk)
+	_, err = ReadChunk(&buf)
+	require.Error(t, err)
+}
@@ -0,0 +1,232 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package bplus
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/bbva/qed/storage"
+)
+
+// Backups are stored in <path>/backups/<id>, each one holding a dump of
+// the store at the time of the backup plus a file with its metadata.
+const (
+	backupsDir     = "backups"
+	backupMetaFile = "BACKUP"
+)
+
+type backupMeta struct {
+	ID        uint32
+	Timestamp int64
+	Metadata  string
+}
+
+func (s *BPlusTreeStore) backupDir(id uint32) string {
+	return filepath.Join(s.path, backupsDir, strconv.FormatUint(uint64(id), 10))
+}
+
+// Backup creates a new backup with the given metadata. Only persistent
+// stores can be backed up.
+func (s *BPlusTreeStore) Backup(metadata string) error {
+	if s.path == "" {
+		return ErrNotPersistent
+	}
+	if s.readOnly {
+		return storage.ErrReadOnly
+	}
+
+	s.Lock()
+	db, seq := s.db.Clone(), s.seq
+	s.Unlock()
+
+	var id uint32 = 1
+	for _, info := range s.GetBackupsInfo() {
+		if uint32(info.ID) >= id {
+			id = uint32(info.ID) + 1
+		}
+	}
+	dir := s.backupDir(id)
+	tmp := dir + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	if err := os.Mkdir(tmp, 0755); err != nil {
+		return err
+	}
+
+	err := func() error {
+		if err := writeDumpFile(filepath.Join(tmp, snapshotFile), db, seq); err != nil {
+			return err
+		}
+		data, err := json.Marshal(&backupMeta{
+			ID:        id,
+			Timestamp: time.Now().Unix(),
+			Metadata:  metadata,
+		})
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(tmp, backupMetaFile), data, 0644); err != nil {
+			return err
+		}
+		return os.Rename(tmp, dir)
+	}()
+	if err != nil {
+		os.RemoveAll(tmp)
+	}
+	return err
+}
+
+// Checkpoint writes an openable copy of the store into dir, which must
+// not exist: a dump of its current contents and an empty WAL following
+// it. Only persistent stores can be checkpointed.
+func (s *BPlusTreeStore) Checkpoint(dir string) error {
+	if s.path == "" {
+		return ErrNotPersistent
+	}
+	if s.readOnly {
+		return storage.ErrReadOnly
+	}
+
+	s.Lock()
+	db, seq := s.db.Clone(), s.seq
+	s.Unlock()
+
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return err
+	}
+	err := func() error {
+		if err := writeDumpFile(filepath.Join(dir, snapshotFile), db, seq); err != nil {
+			return err
+		}
+		header := make([]byte, walHeaderSize)
+		binary.BigEndian.PutUint64(header, seq)
+		return ioutil.WriteFile(filepath.Join(dir, walFile), header, 0644)
+	}()
+	if err != nil {
+		os.RemoveAll(dir)
+	}
+	return err
+}
+
+// GetBackupsInfo lists the backups ordered by ID.
+func (s *BPlusTreeStore) GetBackupsInfo() []*storage.BackupInfo {
+	if s.path == "" {
+		return nil
+	}
+	dirs, err := ioutil.ReadDir(filepath.Join(s.path, backupsDir))
+	if err != nil {
+		return nil
+	}
+	backupsInfo := make([]*storage.BackupInfo, 0, len(dirs))
+	for _, d := range dirs {
+		if _, err := strconv.ParseUint(d.Name(), 10, 32); err != nil || !d.IsDir() {
+			continue
+		}
+		dir := filepath.Join(s.path, backupsDir, d.Name())
+		data, err := ioutil.ReadFile(filepath.Join(dir, backupMetaFile))
+		if err != nil {
+			continue
+		}
+		var meta backupMeta
+		if err := json.Unmarshal(data, &meta); err != nil {
+			continue
+		}
+		info := &storage.BackupInfo{
+			ID:        int64(meta.ID),
+			Timestamp: meta.Timestamp,
+			Metadata:  meta.Metadata,
+		}
+		files, _ := ioutil.ReadDir(dir)
+		for _, f := range files {
+			info.Size += f.Size()
+			info.NumFiles++
+		}
+		backupsInfo = append(backupsInfo, info)
+	}
+	sort.Slice(backupsInfo, func(i, j int) bool {
+		return backupsInfo[i].ID < backupsInfo[j].ID
+	})
+	return backupsInfo
+}
+
+// DeleteBackup deletes the backup identified by backupID.
+func (s *BPlusTreeStore) DeleteBackup(backupID uint32) error {
+	if s.path == "" {
+		return ErrNotPersistent
+	}
+	if s.readOnly {
+		return storage.ErrReadOnly
+	}
+	dir := s.backupDir(backupID)
+	if _, err := os.Stat(filepath.Join(dir, backupMetaFile)); err != nil {
+		return fmt.Errorf("backup %d not found", backupID)
+	}
+	return os.RemoveAll(dir)
+}
+
+// RestoreFromBackup restores the backup identified by backupID into
+// dbDir, replacing any store found there. The WAL lives inside the
+// store directory, so the WAL directory argument is ignored.
+func (s *BPlusTreeStore) RestoreFromBackup(backupID uint32, dbDir, _ string) error {
+	if s.path == "" {
+		return ErrNotPersistent
+	}
+	dir := s.backupDir(backupID)
+	if _, err := os.Stat(filepath.Join(dir, backupMetaFile)); err != nil {
+		return fmt.Errorf("backup %d not found", backupID)
+	}
+
+	if err := os.MkdirAll(dbDir, 0755); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(dbDir, walFile)); err != nil {
+		return err
+	}
+
+	in, err := os.Open(filepath.Join(dir, snapshotFile))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := filepath.Join(dbDir, snapshotFile+".tmp")
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dbDir, snapshotFile))
+}
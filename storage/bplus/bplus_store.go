@@ -14,35 +14,303 @@
    limitations under the License.
 */
 
+// Package bplus implements a store backed by an in-memory B-tree.
+//
+// Stores created with NewBPlusTreeStore live in memory only. Stores
+// opened with OpenBPlusTreeStore are persisted to a directory: every
+// write batch is appended to a write-ahead log and the whole tree is
+// periodically written to a snapshot file, so reopening the store only
+// replays the batches written after the last snapshot. The WAL is kept
+// whole to serve FetchSnapshot, so this store fits tests and small
+// deployments.
 package bplus
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
 	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 
 	"github.com/bbva/qed/metrics"
-
 	"github.com/bbva/qed/storage"
 	"github.com/google/btree"
 )
 
+const (
+	snapshotFile = "snapshot"
+	walFile      = "wal"
+
+	dumpMagic       = 0x7165646270747231 // "qedbptr1"
+	walHeaderSize   = 8                  // sequence number before the first batch
+	walRecordHeader = 8                  // crc32c (4) + length (4)
+)
+
+var (
+	// ErrNotPersistent is returned by the operations that need a store
+	// opened with a path.
+	ErrNotPersistent = errors.New("operation not supported by in-memory stores")
+	// ErrWALPurged is returned when the write batches requested to
+	// FetchSnapshot predate the WAL, after loading a dump.
+	ErrWALPurged = storage.ErrWALPurged
+	// ErrCorrupted is returned when a dump or the WAL fail their checksums.
+	ErrCorrupted = errors.New("corrupted bplus store file")
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
 type BPlusTreeStore struct {
-	db *btree.BTree
+	sync.RWMutex
+	db       *btree.BTree
+	path     string // empty for in-memory stores
+	readOnly bool
+
+	// seq is the sequence number of the last write batch applied.
+	seq uint64
+	// The WAL holds the write batches applied after walBase, in a file
+	// for persistent stores or in memory otherwise.
+	walBase uint64
+	walFile *os.File
+	walBuf  []byte
+	walSize int64
+
+	keysWritten uint64 // atomic
+	metrics     *bplusMetrics
 }
 
 func NewBPlusTreeStore() *BPlusTreeStore {
-	return &BPlusTreeStore{btree.New(2)}
+	s := &BPlusTreeStore{db: btree.New(2)}
+	s.metrics = newBPlusMetrics(s)
+	return s
 }
 
-func (s *BPlusTreeStore) Mutate(mutations []*storage.Mutation, metadata []byte) error {
-	for _, m := range mutations {
+// OpenBPlusTreeStore opens or creates a persistent store in path.
+func OpenBPlusTreeStore(path string) (*BPlusTreeStore, error) {
+	if err := os.MkdirAll(filepath.Join(path, backupsDir), 0755); err != nil {
+		return nil, err
+	}
+	return openPersistentStore(path, false)
+}
+
+// OpenBPlusTreeStoreReadOnly opens the persistent store in path without
+// writing to its files, so several processes can open it at once. The
+// WAL is replayed but not truncated, no snapshot is written on close,
+// and writes fail with storage.ErrReadOnly.
+func OpenBPlusTreeStoreReadOnly(path string) (*BPlusTreeStore, error) {
+	return openPersistentStore(path, true)
+}
+
+func openPersistentStore(path string, readOnly bool) (*BPlusTreeStore, error) {
+	s := &BPlusTreeStore{db: btree.New(2), path: path, readOnly: readOnly}
+
+	f, err := os.Open(filepath.Join(path, snapshotFile))
+	if err == nil {
+		s.db, s.seq, err = readDump(bufio.NewReader(f))
+		f.Close()
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err := s.openWAL(); err != nil {
+		return nil, err
+	}
+	s.metrics = newBPlusMetrics(s)
+	return s, nil
+}
+
+// openWAL opens the WAL file and replays the batches written after the
+// snapshot. A torn record at the end, left by a crash in the middle of a
+// write, is truncated, or just skipped by read-only stores.
+func (s *BPlusTreeStore) openWAL() error {
+	path := filepath.Join(s.path, walFile)
+	var f *os.File
+	var err error
+	if s.readOnly {
+		f, err = os.Open(path)
+		if os.IsNotExist(err) {
+			s.walBase = s.seq
+			return nil
+		}
+	} else {
+		f, err = os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	}
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if info.Size() < walHeaderSize {
+		if s.readOnly {
+			f.Close()
+			s.walBase = s.seq
+			return nil
+		}
+		s.walFile = f
+		return s.resetWAL(s.seq)
+	}
+
+	header := make([]byte, walHeaderSize)
+	if _, err := f.ReadAt(header, 0); err != nil {
+		f.Close()
+		return err
+	}
+	s.walBase = binary.BigEndian.Uint64(header)
+	if s.walBase > s.seq {
+		f.Close()
+		return errors.New("the WAL starts after the snapshot, some write batches are missing")
+	}
+
+	r := io.NewSectionReader(f, walHeaderSize, info.Size()-walHeaderSize)
+	valid, err := readWAL(bufio.NewReader(r), func(data []byte) error {
+		b, err := storage.DecodeWriteBatch(data)
+		if err != nil {
+			return err
+		}
+		if b.Seq+b.Count() > s.seq+1 {
+			s.apply(b)
+		}
+		return nil
+	})
+	if err != nil && err != io.ErrUnexpectedEOF && err != ErrCorrupted {
+		f.Close()
+		return err
+	}
+	if s.readOnly {
+		s.walFile, s.walSize = f, valid
+		return nil
+	}
+	if err := f.Truncate(walHeaderSize + valid); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Seek(walHeaderSize+valid, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	s.walFile, s.walSize = f, valid
+	return nil
+}
+
+// resetWAL empties the WAL, which starts after the given sequence number.
+func (s *BPlusTreeStore) resetWAL(base uint64) error {
+	s.walBase, s.walSize, s.walBuf = base, 0, nil
+	if s.walFile == nil {
+		return nil
+	}
+	header := make([]byte, walHeaderSize)
+	binary.BigEndian.PutUint64(header, base)
+	if err := s.walFile.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := s.walFile.WriteAt(header, 0); err != nil {
+		return err
+	}
+	if _, err := s.walFile.Seek(walHeaderSize, io.SeekStart); err != nil {
+		return err
+	}
+	return s.walFile.Sync()
+}
+
+func (s *BPlusTreeStore) appendWAL(data []byte) error {
+	record := make([]byte, walRecordHeader, walRecordHeader+len(data))
+	binary.BigEndian.PutUint32(record, crc32.Checksum(data, crcTable))
+	binary.BigEndian.PutUint32(record[4:], uint32(len(data)))
+	record = append(record, data...)
+
+	if s.walFile == nil {
+		s.walBuf = append(s.walBuf, record...)
+	} else {
+		if _, err := s.walFile.Write(record); err != nil {
+			return err
+		}
+		if err := s.walFile.Sync(); err != nil {
+			return err
+		}
+	}
+	s.walSize += int64(len(record))
+	return nil
+}
+
+// walReader returns a reader over the WAL records written so far. It
+// must be called with the lock held.
+func (s *BPlusTreeStore) walReader() io.Reader {
+	if s.walFile == nil {
+		return bytes.NewReader(s.walBuf[:s.walSize])
+	}
+	return bufio.NewReader(io.NewSectionReader(s.walFile, walHeaderSize, s.walSize))
+}
+
+// readWAL calls fn for every record and returns the size of the records
+// read.
+func readWAL(r io.Reader, fn func(data []byte) error) (int64, error) {
+	var offset int64
+	header := make([]byte, walRecordHeader)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return offset, nil
+			}
+			return offset, io.ErrUnexpectedEOF
+		}
+		data := make([]byte, binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			return offset, io.ErrUnexpectedEOF
+		}
+		if crc32.Checksum(data, crcTable) != binary.BigEndian.Uint32(header) {
+			return offset, ErrCorrupted
+		}
+		if err := fn(data); err != nil {
+			return offset, err
+		}
+		offset += int64(walRecordHeader + len(data))
+	}
+}
+
+// apply must be called with the lock held.
+func (s *BPlusTreeStore) apply(b *storage.WriteBatch) {
+	for _, m := range b.Mutations {
 		key := append([]byte{m.Table.Prefix()}, m.Key...)
 		s.db.ReplaceOrInsert(KVItem{key, m.Value})
 	}
+	if b.Count() > 0 {
+		s.seq = b.Seq + b.Count() - 1
+	}
+}
+
+func (s *BPlusTreeStore) write(b *storage.WriteBatch) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.readOnly {
+		return storage.ErrReadOnly
+	}
+	b.Seq = s.seq + 1
+	if err := s.appendWAL(b.Encode()); err != nil {
+		return err
+	}
+	s.apply(b)
+	atomic.AddUint64(&s.keysWritten, b.Count())
 	return nil
 }
 
-func (s BPlusTreeStore) GetRange(table storage.Table, start, end []byte) (storage.KVRange, error) {
+func (s *BPlusTreeStore) Mutate(mutations []*storage.Mutation, metadata []byte) error {
+	return s.write(&storage.WriteBatch{
+		Metadata:  metadata,
+		Mutations: mutations,
+	})
+}
+
+func (s *BPlusTreeStore) GetRange(table storage.Table, start, end []byte) (storage.KVRange, error) {
+	s.RLock()
+	defer s.RUnlock()
 	result := make(storage.KVRange, 0)
 	startKey := append([]byte{table.Prefix()}, start...)
 	endKey := append([]byte{table.Prefix()}, end...)
@@ -57,7 +325,9 @@ func (s BPlusTreeStore) GetRange(table storage.Table, start, end []byte) (storag
 	return result, nil
 }
 
-func (s BPlusTreeStore) Get(table storage.Table, key []byte) (*storage.KVPair, error) {
+func (s *BPlusTreeStore) Get(table storage.Table, key []byte) (*storage.KVPair, error) {
+	s.RLock()
+	defer s.RUnlock()
 	result := new(storage.KVPair)
 	result.Key = key
 	k := append([]byte{table.Prefix()}, key...)
@@ -70,59 +340,189 @@ func (s BPlusTreeStore) Get(table storage.Table, key []byte) (*storage.KVPair, e
 	}
 }
 
-func (s BPlusTreeStore) GetLast(table storage.Table) (*storage.KVPair, error) {
-	result := new(storage.KVPair)
-	s.db.DescendGreaterThan(KVItem{[]byte{table.Prefix()}, nil}, func(i btree.Item) bool {
+func (s *BPlusTreeStore) GetLast(table storage.Table) (*storage.KVPair, error) {
+	s.RLock()
+	defer s.RUnlock()
+	prefix := table.Prefix()
+	// the last key of the table is the greatest one lower than the
+	// prefix of the next table
+	limit := []byte{prefix + 1}
+	var result *storage.KVPair
+	s.db.DescendLessOrEqual(KVItem{limit, nil}, func(i btree.Item) bool {
 		item := i.(KVItem)
-		result.Key = item.Key[1:]
-		result.Value = item.Value
+		if item.Key[0] != prefix {
+			return item.Key[0] > prefix
+		}
+		result = &storage.KVPair{Key: item.Key[1:], Value: item.Value}
 		return false
 	})
-	if result.Key == nil {
+	if result == nil {
 		return nil, storage.ErrKeyNotFound
 	}
 	return result, nil
 }
 
-func (s BPlusTreeStore) GetAll(table storage.Table) storage.KVPairReader {
-	return NewBPlusKVPairReader(table, s.db)
+// GetAll returns a reader over a copy-on-write snapshot of the table.
+func (s *BPlusTreeStore) GetAll(table storage.Table) storage.KVPairReader {
+	s.Lock()
+	defer s.Unlock()
+	return NewBPlusKVPairReader(table, s.db.Clone())
 }
 
-func (s BPlusTreeStore) Close() error {
+// Close writes a snapshot of persistent stores, so the next open does
+// not replay the WAL, and releases the tree.
+func (s *BPlusTreeStore) Close() error {
+	var err error
+	if s.path != "" && !s.readOnly {
+		_, err = s.Snapshot()
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.walFile != nil {
+		if cerr := s.walFile.Close(); err == nil {
+			err = cerr
+		}
+		s.walFile = nil
+	}
 	s.db.Clear(false)
-	return nil
+	return err
 }
 
-func (s BPlusTreeStore) Dump(w io.Writer, id uint64) error {
-	panic("Not implemented")
+// Dump writes a copy of the whole store, along with the sequence number
+// of the last write batch applied, to w.
+func (s *BPlusTreeStore) Dump(w io.Writer) error {
+	s.Lock()
+	db, seq := s.db.Clone(), s.seq
+	s.Unlock()
+	return writeDump(w, db, seq)
 }
 
-func (s BPlusTreeStore) Load(r io.Reader) error {
-	panic("Not implemented")
+// Load replaces the content of the store with a dump. The WAL starts
+// over after the sequence number of the dump, so FetchSnapshot cannot
+// serve the batches before it.
+func (s *BPlusTreeStore) Load(r io.Reader) error {
+	if s.readOnly {
+		return storage.ErrReadOnly
+	}
+	db, seq, err := readDump(bufio.NewReader(r))
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.db, s.seq = db, seq
+	if s.path != "" {
+		if err := s.writeSnapshot(db, seq); err != nil {
+			return err
+		}
+	}
+	return s.resetWAL(seq)
 }
 
-func (s BPlusTreeStore) Snapshot() (uint64, error) {
-	panic("Not implemented")
+// Snapshot writes the tree to the snapshot file of a persistent store
+// and returns the sequence number it covers.
+func (s *BPlusTreeStore) Snapshot() (uint64, error) {
+	if s.path == "" {
+		return 0, ErrNotPersistent
+	}
+	if s.readOnly {
+		return 0, storage.ErrReadOnly
+	}
+	s.Lock()
+	db, seq := s.db.Clone(), s.seq
+	s.Unlock()
+	return seq, s.writeSnapshot(db, seq)
 }
 
-func (s BPlusTreeStore) Backup(metatada string) error {
-	panic("Not implemented")
+func (s *BPlusTreeStore) writeSnapshot(db *btree.BTree, seq uint64) error {
+	return writeDumpFile(filepath.Join(s.path, snapshotFile), db, seq)
 }
 
-func (s *BPlusTreeStore) GetBackupsInfo() []*storage.BackupInfo {
-	panic("Not implemented")
+// FetchSnapshot fetches all WAL transactions from the first available
+// seq_num to the last one specified in the lastSeqNum parameter, and dumps
+// them to the given writer.
+func (s *BPlusTreeStore) FetchSnapshot(w io.WriteCloser, since, until uint64, valid storage.ValidateF) error {
+	defer w.Close()
+
+	s.RLock()
+	base, last, r := s.walBase, s.seq, s.walReader()
+	s.RUnlock()
+	if since < base && since < last {
+		return ErrWALPurged
+	}
+
+	errStop := errors.New("stop")
+	_, err := readWAL(r, func(data []byte) error {
+		seqNum := binary.LittleEndian.Uint64(data)
+		if seqNum <= since {
+			return nil
+		}
+		if seqNum > until {
+			return errStop
+		}
+
+		b, err := storage.DecodeWriteBatch(data)
+		if err != nil {
+			return err
+		}
+		ok, err := valid(b.Metadata)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		return storage.WriteChunk(w, data)
+	})
+	if err == errStop {
+		return nil
+	}
+	return err
 }
 
-func (s *BPlusTreeStore) DeleteBackup(backupID uint32) error {
-	panic("Not implemented")
+// LoadSnapshot reads a list of serialized batches from a reader,
+// rehydrates them and write to the database.
+// Batches are written as they are read, so if the reader fails halfway,
+// the batches loaded so far are kept and LastWALSequenceNumber tells
+// where to resume the transfer from.
+func (s *BPlusTreeStore) LoadSnapshot(r io.ReadCloser) error {
+	defer r.Close()
+
+	for {
+		buff, err := storage.ReadChunk(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		batch, err := storage.DecodeWriteBatch(buff)
+		if err != nil {
+			return err
+		}
+		if err := s.write(batch); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-func (s BPlusTreeStore) RestoreFromBackup(backupID uint32, dbDir, walDir string) error {
-	panic("Not implemented")
+// LastWALSequenceNumber returns the sequence number of the
+// last transaction applied to the WAL. This sequence
+// number can be used as upper limit when fetching transactions
+// from the WAL.
+func (s *BPlusTreeStore) LastWALSequenceNumber() uint64 {
+	s.RLock()
+	defer s.RUnlock()
+	return s.seq
 }
 
-func (s BPlusTreeStore) RegisterMetrics(registry metrics.Registry) {
-	panic("Not implemented")
+func (s *BPlusTreeStore) RegisterMetrics(registry metrics.Registry) {
+	if registry != nil {
+		registry.MustRegister(s.metrics.collectors()...)
+	}
 }
 
 type KVItem struct {
@@ -154,8 +554,12 @@ func (r *BPlusKVPairReader) Read(buffer []*storage.KVPair) (n int, err error) {
 			return false
 		}
 		key := i.(KVItem).Key
+		if key[0] != r.prefix {
+			// past the end of the table
+			return false
+		}
 
-		if bytes.Compare(key[:1], r.lastKey[:1]) == 0 && bytes.Compare(key, r.lastKey) != 0 {
+		if bytes.Compare(key, r.lastKey) != 0 {
 			buffer[n] = &storage.KVPair{key[1:], i.(KVItem).Value}
 			n++
 		}
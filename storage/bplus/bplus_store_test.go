@@ -17,6 +17,11 @@
 package bplus
 
 import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/bbva/qed/storage"
@@ -164,6 +169,29 @@ func TestGetAll(t *testing.T) {
 
 }
 
+func TestGetAllStopsAtTableEnd(t *testing.T) {
+	store, closeF := openBPlusTreeStore()
+	defer closeF()
+
+	require.NoError(t, store.Mutate([]*storage.Mutation{
+		{Table: storage.HyperCacheTable, Key: []byte{1}, Value: []byte{1}},
+		{Table: storage.HistoryTable, Key: []byte{2}, Value: []byte{2}},
+		{Table: storage.HyperTable, Key: []byte{3}, Value: []byte{3}},
+		{Table: storage.FSMStateTable, Key: []byte{4}, Value: []byte{4}},
+	}, nil))
+
+	for _, table := range []storage.Table{storage.HyperCacheTable, storage.HistoryTable, storage.HyperTable, storage.FSMStateTable} {
+		reader := store.GetAll(table)
+		entries := make([]*storage.KVPair, 10)
+		n, err := reader.Read(entries)
+		require.NoError(t, err)
+		require.Equalf(t, 1, n, "Only the keys of table %s should be read", table)
+		n, _ = reader.Read(entries)
+		require.Equal(t, 0, n)
+		reader.Close()
+	}
+}
+
 func TestGetLast(t *testing.T) {
 	store, closeF := openBPlusTreeStore()
 	defer closeF()
@@ -190,6 +218,271 @@ func TestGetLast(t *testing.T) {
 	require.Equalf(t, key, kv.Value, "The value should match the last inserted element")
 }
 
+func TestGetLastSkipsOtherTables(t *testing.T) {
+	store, closeF := openBPlusTreeStore()
+	defer closeF()
+
+	_, err := store.GetLast(storage.HistoryTable)
+	require.Equal(t, storage.ErrKeyNotFound, err)
+
+	require.NoError(t, store.Mutate([]*storage.Mutation{
+		{Table: storage.HistoryTable, Key: []byte{1}, Value: []byte{1}},
+		{Table: storage.HyperTable, Key: []byte{2}, Value: []byte{2}},
+	}, nil))
+
+	kv, err := store.GetLast(storage.HistoryTable)
+	require.NoError(t, err)
+	require.Equal(t, []byte{1}, kv.Key)
+}
+
+func TestReopen(t *testing.T) {
+	path := mustTempDir(t)
+	defer os.RemoveAll(path)
+
+	store, err := OpenBPlusTreeStore(path)
+	require.NoError(t, err)
+	for i := uint64(0); i < 10; i++ {
+		key := util.Uint64AsBytes(i)
+		require.NoError(t, store.Mutate([]*storage.Mutation{
+			{Table: storage.HistoryTable, Key: key, Value: key},
+		}, nil))
+		if i == 4 {
+			seq, err := store.Snapshot()
+			require.NoError(t, err)
+			require.Equal(t, uint64(5), seq)
+		}
+	}
+	// simulate a crash: the last batches are only in the WAL
+	require.NoError(t, store.walFile.Close())
+
+	store, err = OpenBPlusTreeStore(path)
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), store.LastWALSequenceNumber())
+	kv, err := store.GetLast(storage.HistoryTable)
+	require.NoError(t, err)
+	require.Equal(t, util.Uint64AsBytes(9), kv.Value)
+	require.NoError(t, store.Close())
+
+	store, err = OpenBPlusTreeStore(path)
+	require.NoError(t, err)
+	defer store.Close()
+	require.Equal(t, uint64(10), store.LastWALSequenceNumber())
+	_, err = store.Get(storage.HistoryTable, util.Uint64AsBytes(7))
+	require.NoError(t, err)
+}
+
+func TestReopenWithTornWAL(t *testing.T) {
+	path := mustTempDir(t)
+	defer os.RemoveAll(path)
+
+	store, err := OpenBPlusTreeStore(path)
+	require.NoError(t, err)
+	for i := uint64(0); i < 3; i++ {
+		require.NoError(t, store.Mutate([]*storage.Mutation{
+			{Table: storage.HistoryTable, Key: util.Uint64AsBytes(i), Value: []byte("value")},
+		}, nil))
+	}
+	require.NoError(t, store.walFile.Truncate(walHeaderSize+store.walSize-3))
+	require.NoError(t, store.walFile.Close())
+
+	store, err = OpenBPlusTreeStore(path)
+	require.NoError(t, err)
+	defer store.Close()
+	require.Equal(t, uint64(2), store.LastWALSequenceNumber())
+
+	require.NoError(t, store.Mutate([]*storage.Mutation{
+		{Table: storage.HistoryTable, Key: []byte("new"), Value: []byte("value")},
+	}, nil))
+	require.Equal(t, uint64(3), store.LastWALSequenceNumber())
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	path := mustTempDir(t)
+	defer os.RemoveAll(path)
+
+	store, err := OpenBPlusTreeStore(path)
+	require.NoError(t, err)
+	for i := uint64(0); i < 10; i++ {
+		key := util.Uint64AsBytes(i)
+		require.NoError(t, store.Mutate([]*storage.Mutation{
+			{Table: storage.HistoryTable, Key: key, Value: key},
+		}, nil))
+		if i == 4 {
+			_, err := store.Snapshot()
+			require.NoError(t, err)
+		}
+	}
+	// leave the last batches in the WAL and a torn record at its end
+	require.NoError(t, store.walFile.Truncate(walHeaderSize+store.walSize-3))
+	require.NoError(t, store.walFile.Close())
+	files := readFiles(t, path)
+
+	// several stores can share the same files
+	first, err := OpenBPlusTreeStoreReadOnly(path)
+	require.NoError(t, err)
+	second, err := OpenBPlusTreeStoreReadOnly(path)
+	require.NoError(t, err)
+	for _, store := range []*BPlusTreeStore{first, second} {
+		require.Equal(t, uint64(9), store.LastWALSequenceNumber())
+		kv, err := store.GetLast(storage.HistoryTable)
+		require.NoError(t, err)
+		require.Equal(t, util.Uint64AsBytes(8), kv.Value)
+
+		err = store.Mutate([]*storage.Mutation{
+			{Table: storage.HistoryTable, Key: []byte("new"), Value: []byte("value")},
+		}, nil)
+		require.Equal(t, storage.ErrReadOnly, err)
+		require.Equal(t, storage.ErrReadOnly, store.Backup("metadata"))
+		require.Equal(t, storage.ErrReadOnly, store.Load(bytes.NewReader(nil)))
+	}
+	require.NoError(t, first.Close())
+	require.NoError(t, second.Close())
+	require.Equal(t, files, readFiles(t, path))
+}
+
+func TestDumpAndLoad(t *testing.T) {
+	store, closeF := openBPlusTreeStore()
+	defer closeF()
+	for i := uint64(0); i < 100; i++ {
+		key := util.Uint64AsBytes(i)
+		require.NoError(t, store.Mutate([]*storage.Mutation{
+			{Table: storage.HyperTable, Key: key, Value: key},
+		}, nil))
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, store.Dump(&buf))
+
+	path := mustTempDir(t)
+	defer os.RemoveAll(path)
+	restored, err := OpenBPlusTreeStore(path)
+	require.NoError(t, err)
+	require.NoError(t, restored.Load(bytes.NewReader(buf.Bytes())))
+	require.Equal(t, uint64(100), restored.LastWALSequenceNumber())
+	require.NoError(t, restored.Close())
+
+	restored, err = OpenBPlusTreeStore(path)
+	require.NoError(t, err)
+	defer restored.Close()
+	kv, err := restored.GetLast(storage.HyperTable)
+	require.NoError(t, err)
+	require.Equal(t, util.Uint64AsBytes(99), kv.Value)
+
+	// the batches before the dump cannot be fetched anymore
+	require.Equal(t, ErrWALPurged, restored.FetchSnapshot(nopWriteCloser{ioutil.Discard}, 0, 100, acceptAll))
+
+	corrupted := buf.Bytes()
+	corrupted[30] ^= 0xff
+	require.Equal(t, ErrCorrupted, restored.Load(bytes.NewReader(corrupted)))
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	path := mustTempDir(t)
+	defer os.RemoveAll(path)
+
+	store, err := OpenBPlusTreeStore(path)
+	require.NoError(t, err)
+	defer store.Close()
+
+	for i := uint64(0); i < 10; i++ {
+		key := util.Uint64AsBytes(i)
+		require.NoError(t, store.Mutate([]*storage.Mutation{
+			{Table: storage.HistoryTable, Key: key, Value: key},
+		}, nil))
+		if i%5 == 4 {
+			require.NoError(t, store.Backup("metadata"))
+		}
+	}
+
+	backups := store.GetBackupsInfo()
+	require.Len(t, backups, 2)
+	require.Equal(t, int64(1), backups[0].ID)
+	require.Equal(t, "metadata", backups[0].Metadata)
+
+	restorePath := filepath.Join(path, "restore")
+	require.NoError(t, store.RestoreFromBackup(1, restorePath, ""))
+	restored, err := OpenBPlusTreeStore(restorePath)
+	require.NoError(t, err)
+	defer restored.Close()
+	require.Equal(t, uint64(5), restored.LastWALSequenceNumber())
+	kv, err := restored.GetLast(storage.HistoryTable)
+	require.NoError(t, err)
+	require.Equal(t, util.Uint64AsBytes(4), kv.Value)
+
+	require.NoError(t, store.DeleteBackup(1))
+	require.Len(t, store.GetBackupsInfo(), 1)
+	require.Error(t, store.DeleteBackup(1))
+
+	memStore := NewBPlusTreeStore()
+	require.Equal(t, ErrNotPersistent, memStore.Backup(""))
+	require.Nil(t, memStore.GetBackupsInfo())
+}
+
+func TestCheckpoint(t *testing.T) {
+	path := mustTempDir(t)
+	defer os.RemoveAll(path)
+
+	store, err := OpenBPlusTreeStore(path)
+	require.NoError(t, err)
+	defer store.Close()
+
+	checkpointPath := filepath.Join(path, "checkpoint")
+	for i := uint64(0); i < 10; i++ {
+		key := util.Uint64AsBytes(i)
+		require.NoError(t, store.Mutate([]*storage.Mutation{
+			{Table: storage.HistoryTable, Key: key, Value: key},
+		}, nil))
+		if i == 4 {
+			require.NoError(t, store.Checkpoint(checkpointPath))
+		}
+	}
+	require.Error(t, store.Checkpoint(checkpointPath), "The checkpoint directory must not exist")
+
+	checkpoint, err := OpenBPlusTreeStore(checkpointPath)
+	require.NoError(t, err)
+	defer checkpoint.Close()
+	require.Equal(t, uint64(5), checkpoint.LastWALSequenceNumber())
+	kv, err := checkpoint.GetLast(storage.HistoryTable)
+	require.NoError(t, err)
+	require.Equal(t, util.Uint64AsBytes(4), kv.Value)
+
+	require.Equal(t, ErrNotPersistent, NewBPlusTreeStore().Checkpoint(checkpointPath))
+}
+
+func TestFetchAndLoadSnapshot(t *testing.T) {
+	store, closeF := openBPlusTreeStore()
+	defer closeF()
+
+	for i := uint64(0); i < 10; i++ {
+		key := util.Uint64AsBytes(i)
+		require.NoError(t, store.Mutate([]*storage.Mutation{
+			{Table: storage.HistoryTable, Key: key, Value: key},
+		}, key))
+	}
+	require.Equal(t, uint64(10), store.LastWALSequenceNumber())
+
+	// skip the batches with an even index
+	odd := func(meta []byte) (bool, error) {
+		return util.BytesAsUint64(meta)%2 == 1, nil
+	}
+	var buf bytes.Buffer
+	require.NoError(t, store.FetchSnapshot(nopWriteCloser{&buf}, 2, 8, odd))
+
+	target := NewBPlusTreeStore()
+	defer target.Close()
+	require.NoError(t, target.LoadSnapshot(ioutil.NopCloser(&buf)))
+	require.Equal(t, uint64(3), target.LastWALSequenceNumber())
+
+	for i := uint64(0); i < 10; i++ {
+		_, err := target.Get(storage.HistoryTable, util.Uint64AsBytes(i))
+		if i >= 2 && i < 8 && i%2 == 1 {
+			require.NoError(t, err, "batch %d should have been transferred", i)
+		} else {
+			require.Equal(t, storage.ErrKeyNotFound, err, "batch %d should not have been transferred", i)
+		}
+	}
+}
+
 func BenchmarkMutate(b *testing.B) {
 	store, closeF := openBPlusTreeStore()
 	defer closeF()
@@ -267,3 +560,38 @@ func openBPlusTreeStore() (*BPlusTreeStore, func()) {
 		store.Close()
 	}
 }
+
+func mustTempDir(t *testing.T) string {
+	path, err := ioutil.TempDir("", "bplus-test-")
+	require.NoError(t, err)
+	return path
+}
+
+func readFiles(t *testing.T, path string) map[string][]byte {
+	files := make(map[string][]byte)
+	err := filepath.Walk(path, func(name string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		files[name], err = ioutil.ReadFile(name)
+		return err
+	})
+	require.NoError(t, err)
+	return files
+}
+
+func acceptAll([]byte) (bool, error) {
+	return true, nil
+}
+
+type nopWriteCloser struct {
+	w io.Writer
+}
+
+func (n nopWriteCloser) Write(p []byte) (int, error) {
+	return n.w.Write(p)
+}
+
+func (n nopWriteCloser) Close() error {
+	return nil
+}
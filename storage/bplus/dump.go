@@ -0,0 +1,167 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package bplus
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/google/btree"
+)
+
+// A dump holds a header with a magic number, the sequence number of the
+// last write batch applied and the number of entries, followed by the
+// entries as length-prefixed keys and values and a crc32c of everything
+// before it.
+
+func writeDump(w io.Writer, db *btree.BTree, seq uint64) error {
+	crc := crc32.New(crcTable)
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+
+	buf := make([]byte, 24)
+	binary.BigEndian.PutUint64(buf, dumpMagic)
+	binary.BigEndian.PutUint64(buf[8:], seq)
+	binary.BigEndian.PutUint64(buf[16:], uint64(db.Len()))
+	if _, err := bw.Write(buf); err != nil {
+		return err
+	}
+
+	var err error
+	db.Ascend(func(i btree.Item) bool {
+		item := i.(KVItem)
+		if err = writeBytes(bw, item.Key); err != nil {
+			return false
+		}
+		err = writeBytes(bw, item.Value)
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	binary.BigEndian.PutUint32(buf, crc.Sum32())
+	_, err = w.Write(buf[:4])
+	return err
+}
+
+// writeDumpFile writes a dump to a temporary file and renames it to path
+// once synced, so a crash never leaves a partial dump behind.
+func writeDumpFile(path string, db *btree.BTree, seq uint64) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	err = writeDump(f, db, seq)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func readDump(r *bufio.Reader) (*btree.BTree, uint64, error) {
+	crc := crc32.New(crcTable)
+	cr := &checksumReader{r: r, crc: crc}
+
+	buf := make([]byte, 24)
+	if _, err := io.ReadFull(cr, buf); err != nil {
+		return nil, 0, ErrCorrupted
+	}
+	if binary.BigEndian.Uint64(buf) != dumpMagic {
+		return nil, 0, ErrCorrupted
+	}
+	seq := binary.BigEndian.Uint64(buf[8:])
+	count := binary.BigEndian.Uint64(buf[16:])
+
+	db := btree.New(2)
+	for i := uint64(0); i < count; i++ {
+		key, err := readBytes(cr)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, err := readBytes(cr)
+		if err != nil {
+			return nil, 0, err
+		}
+		db.ReplaceOrInsert(KVItem{key, value})
+	}
+
+	sum := crc.Sum32()
+	if _, err := io.ReadFull(r, buf[:4]); err != nil || binary.BigEndian.Uint32(buf) != sum {
+		return nil, 0, ErrCorrupted
+	}
+	return db, seq, nil
+}
+
+func writeBytes(w io.Writer, b []byte) error {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, uint64(len(b)))
+	if _, err := w.Write(buf[:n]); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+func readBytes(r *checksumReader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, ErrCorrupted
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, ErrCorrupted
+	}
+	return b, nil
+}
+
+// checksumReader feeds the bytes read to a checksum.
+type checksumReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.crc.Write(p[:n])
+	return n, err
+}
+
+func (c *checksumReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.crc.Write([]byte{b})
+	}
+	return b, err
+}
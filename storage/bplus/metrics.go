@@ -0,0 +1,80 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package bplus
+
+import (
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// namespace is the leading part of all published metrics for the Storage service.
+const namespace = "qed_storage"
+
+const ioSubsystem = "io"       // sub-system associated with metrics for I/O.
+const bplusSubsystem = "bplus" // sub-system associated with metrics for the tree.
+
+type bplusMetrics struct {
+	KeysWritten  prometheus.GaugeFunc
+	WALFileBytes prometheus.GaugeFunc
+	NumKeys      prometheus.GaugeFunc
+}
+
+func newBPlusMetrics(store *BPlusTreeStore) *bplusMetrics {
+	gauge := func(subsystem, name, help string, f func() float64) prometheus.GaugeFunc {
+		return prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: subsystem,
+				Name:      name,
+				Help:      help,
+			},
+			f,
+		)
+	}
+
+	return &bplusMetrics{
+		KeysWritten: gauge(ioSubsystem, "keys_written", "Number of keys written via puts and writes.",
+			func() float64 {
+				return float64(atomic.LoadUint64(&store.keysWritten))
+			},
+		),
+		WALFileBytes: gauge(ioSubsystem, "wal_file_bytes", "Number of bytes in the WAL.",
+			func() float64 {
+				store.RLock()
+				defer store.RUnlock()
+				return float64(store.walSize)
+			},
+		),
+		NumKeys: gauge(bplusSubsystem, "num_keys", "Number of keys in the tree.",
+			func() float64 {
+				store.RLock()
+				defer store.RUnlock()
+				return float64(store.db.Len())
+			},
+		),
+	}
+}
+
+// collectors satisfies the prom.PrometheusCollector interface.
+func (m *bplusMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.KeysWritten,
+		m.WALFileBytes,
+		m.NumKeys,
+	}
+}
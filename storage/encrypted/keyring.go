@@ -0,0 +1,228 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package encrypted
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// KeysEnv is the environment variable holding the encryption keys when
+// no key file is given, with one "<id>:<hex key>" entry per line or
+// separated by commas.
+const KeysEnv = "QED_ENCRYPTION_KEYS"
+
+// KeySize is the size of the AES-256 keys.
+const KeySize = 32
+
+const (
+	formatVersion byte = 1
+	headerSize         = 1 + 4 // format version and key id
+)
+
+var (
+	// ErrUnknownKey is raised when a value was encrypted with a key
+	// missing from the keyring.
+	ErrUnknownKey = errors.New("value encrypted with an unknown key")
+
+	// ErrCorrupted is raised when a value cannot be authenticated.
+	ErrCorrupted = errors.New("encrypted value is corrupted")
+)
+
+// Keyring holds the keys used to encrypt and decrypt values. Values are
+// always encrypted with the active key, the one with the highest id,
+// and decrypted with the key they were encrypted with, so a key is
+// rotated by adding a new one with a higher id.
+type Keyring struct {
+	keys   map[uint32]cipher.AEAD
+	active uint32
+}
+
+// ParseKeyring parses a list of "<id>:<hex key>" entries separated by
+// new lines or commas. Empty lines and lines starting with # are
+// ignored.
+func ParseKeyring(text string) (*Keyring, error) {
+	k := &Keyring{keys: make(map[uint32]cipher.AEAD)}
+	scanner := bufio.NewScanner(strings.NewReader(strings.Replace(text, ",", "\n", -1)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid key entry %q: expected <id>:<hex key>", line)
+		}
+		id, err := strconv.ParseUint(parts[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key id %q", parts[0])
+		}
+		if err := k.add(uint32(id), parts[1]); err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(k.keys) == 0 {
+		return nil, errors.New("no encryption keys found")
+	}
+	return k, nil
+}
+
+func (k *Keyring) add(id uint32, hexKey string) error {
+	if _, ok := k.keys[id]; ok {
+		return fmt.Errorf("duplicated key id %d", id)
+	}
+	key, err := hex.DecodeString(hexKey)
+	if err != nil || len(key) != KeySize {
+		return fmt.Errorf("key %d must be %d hex encoded bytes", id, KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	k.keys[id] = aead
+	if len(k.keys) == 1 || id > k.active {
+		k.active = id
+	}
+	return nil
+}
+
+// LoadKeyring reads the keys from a file.
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	k, err := ParseKeyring(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return k, nil
+}
+
+// KeyringFromEnv reads the keys from the KeysEnv environment variable.
+// It returns nil if the variable is not set.
+func KeyringFromEnv() (*Keyring, error) {
+	text := os.Getenv(KeysEnv)
+	if text == "" {
+		return nil, nil
+	}
+	k, err := ParseKeyring(text)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", KeysEnv, err)
+	}
+	return k, nil
+}
+
+// LoadKeyringOrEnv reads the keys from the file at path or, if path is
+// empty, from the KeysEnv environment variable. It returns nil if none
+// of them is set.
+func LoadKeyringOrEnv(path string) (*Keyring, error) {
+	if path != "" {
+		return LoadKeyring(path)
+	}
+	return KeyringFromEnv()
+}
+
+// Active returns the id of the key new values are encrypted with.
+func (k *Keyring) Active() uint32 {
+	return k.active
+}
+
+// seal encrypts value with the active key. The additional data binds
+// the value to its location, so it cannot be moved to another key.
+func (k *Keyring) seal(value, additionalData []byte) []byte {
+	aead := k.keys[k.active]
+	out := make([]byte, headerSize+aead.NonceSize(), headerSize+aead.NonceSize()+len(value)+aead.Overhead())
+	out[0] = formatVersion
+	binary.BigEndian.PutUint32(out[1:headerSize], k.active)
+	nonce := out[headerSize:]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		panic(fmt.Sprintf("unable to generate nonce: %v", err))
+	}
+	return aead.Seal(out, nonce, value, additionalData)
+}
+
+// open decrypts a value sealed with any of the keys.
+func (k *Keyring) open(value, additionalData []byte) ([]byte, error) {
+	if len(value) < headerSize || value[0] != formatVersion {
+		return nil, ErrCorrupted
+	}
+	aead, ok := k.keys[binary.BigEndian.Uint32(value[1:headerSize])]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if len(value) < headerSize+aead.NonceSize() {
+		return nil, ErrCorrupted
+	}
+	nonce := value[headerSize : headerSize+aead.NonceSize()]
+	plain, err := aead.Open(nil, nonce, value[headerSize+aead.NonceSize():], additionalData)
+	if err != nil {
+		return nil, ErrCorrupted
+	}
+	return plain, nil
+}
+
+// NewKeyFile appends a new random key to the key file at path, creating
+// it if needed. The new key gets the highest id, so it becomes the
+// active one and the previous keys are kept to decrypt older values.
+func NewKeyFile(path string) (uint32, error) {
+	var id uint32 = 1
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	if err == nil && len(strings.TrimSpace(string(data))) > 0 {
+		k, err := ParseKeyring(string(data))
+		if err != nil {
+			return 0, fmt.Errorf("%s: %v", path, err)
+		}
+		id = k.active + 1
+	}
+
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return 0, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := fmt.Fprintf(f, "%d:%x\n", id, key); err != nil {
+		f.Close()
+		return 0, err
+	}
+	return id, f.Close()
+}
@@ -0,0 +1,242 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package encrypted implements encryption at rest for the storage
+// engines. Values are encrypted with AES-256-GCM before reaching the
+// underlying store and decrypted when read, so its files, WAL, snapshots
+// and backups only hold encrypted values. Keys and batch metadata are
+// not encrypted.
+package encrypted
+
+import (
+	"errors"
+
+	"github.com/bbva/qed/storage"
+)
+
+// ErrNotEncrypted is raised when opening a database with data that was
+// not encrypted.
+var ErrNotEncrypted = errors.New("the database is not encrypted")
+
+// markerValue is stored encrypted at storage.EncryptionTableKey, so an
+// encrypted database can be told apart, and a wrong keyring detected,
+// when it is opened.
+var markerValue = []byte("qed")
+
+// rekeyBatchSize is the number of values written per batch when
+// re-encrypting a database.
+const rekeyBatchSize = 1000
+
+// EncryptedStore encrypts the values of an underlying store.
+type EncryptedStore struct {
+	storage.ManagedStore
+	keys *Keyring
+}
+
+// NewEncryptedStore wraps store to encrypt its values with the given
+// keys. An empty store is marked as encrypted. A store with data must
+// have been encrypted with any of the keys, or ErrNotEncrypted or
+// ErrUnknownKey are returned.
+func NewEncryptedStore(store storage.ManagedStore, keys *Keyring) (*EncryptedStore, error) {
+	s := &EncryptedStore{store, keys}
+
+	encrypted, err := IsEncrypted(store)
+	if err != nil {
+		return nil, err
+	}
+	if encrypted {
+		if _, err := s.Get(storage.FSMStateTable, storage.EncryptionTableKey); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+
+	empty, err := isEmpty(store)
+	if err != nil {
+		return nil, err
+	}
+	if !empty {
+		return nil, ErrNotEncrypted
+	}
+	if err := s.mark(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// IsEncrypted tells whether the store has been marked as encrypted.
+func IsEncrypted(store storage.Store) (bool, error) {
+	_, err := store.Get(storage.FSMStateTable, storage.EncryptionTableKey)
+	if err == storage.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// isEmpty tells whether the store has no balloon state nor settings,
+// which are written along with any other change.
+func isEmpty(store storage.Store) (bool, error) {
+	for _, key := range [][]byte{storage.FSMStateTableKey, storage.FSMSettingsTableKey} {
+		_, err := store.Get(storage.FSMStateTable, key)
+		if err == nil {
+			return false, nil
+		}
+		if err != storage.ErrKeyNotFound {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (s *EncryptedStore) mark() error {
+	return s.Mutate([]*storage.Mutation{
+		storage.NewMutation(storage.FSMStateTable, storage.EncryptionTableKey, markerValue),
+	}, nil)
+}
+
+func additionalData(table storage.Table, key []byte) []byte {
+	return append([]byte{table.Prefix()}, key...)
+}
+
+// Mutate encrypts the values of the mutations before writing them.
+func (s *EncryptedStore) Mutate(mutations []*storage.Mutation, metadata []byte) error {
+	encrypted := make([]*storage.Mutation, len(mutations))
+	for i, m := range mutations {
+		value := s.keys.seal(m.Value, additionalData(m.Table, m.Key))
+		encrypted[i] = storage.NewMutation(m.Table, m.Key, value)
+	}
+	return s.ManagedStore.Mutate(encrypted, metadata)
+}
+
+func (s *EncryptedStore) Get(table storage.Table, key []byte) (*storage.KVPair, error) {
+	pair, err := s.ManagedStore.Get(table, key)
+	if err != nil {
+		return nil, err
+	}
+	return s.decrypt(table, pair)
+}
+
+func (s *EncryptedStore) GetRange(table storage.Table, start, end []byte) (storage.KVRange, error) {
+	kvs, err := s.ManagedStore.GetRange(table, start, end)
+	if err != nil {
+		return nil, err
+	}
+	for i := range kvs {
+		pair, err := s.decrypt(table, &kvs[i])
+		if err != nil {
+			return nil, err
+		}
+		kvs[i] = *pair
+	}
+	return kvs, nil
+}
+
+func (s *EncryptedStore) GetLast(table storage.Table) (*storage.KVPair, error) {
+	pair, err := s.ManagedStore.GetLast(table)
+	if err != nil {
+		return nil, err
+	}
+	return s.decrypt(table, pair)
+}
+
+func (s *EncryptedStore) GetAll(table storage.Table) storage.KVPairReader {
+	return &kvPairReader{s.ManagedStore.GetAll(table), s, table}
+}
+
+func (s *EncryptedStore) decrypt(table storage.Table, pair *storage.KVPair) (*storage.KVPair, error) {
+	value, err := s.keys.open(pair.Value, additionalData(table, pair.Key))
+	if err != nil {
+		return nil, err
+	}
+	return &storage.KVPair{Key: pair.Key, Value: value}, nil
+}
+
+// DecryptMutation returns a copy of a mutation read from the underlying
+// store, like the ones of the write batches in its WAL, with the value
+// decrypted.
+func (s *EncryptedStore) DecryptMutation(m *storage.Mutation) (*storage.Mutation, error) {
+	value, err := s.keys.open(m.Value, additionalData(m.Table, m.Key))
+	if err != nil {
+		return nil, err
+	}
+	return storage.NewMutation(m.Table, m.Key, value), nil
+}
+
+type kvPairReader struct {
+	storage.KVPairReader
+	store *EncryptedStore
+	table storage.Table
+}
+
+func (r *kvPairReader) Read(buffer []*storage.KVPair) (int, error) {
+	n, err := r.KVPairReader.Read(buffer)
+	for i := 0; i < n; i++ {
+		pair, derr := r.store.decrypt(r.table, buffer[i])
+		if derr != nil {
+			return i, derr
+		}
+		buffer[i] = pair
+	}
+	return n, err
+}
+
+// Rekey re-encrypts every value of store with the active key of keys,
+// so older keys can be removed from the keyring. A store that is not
+// encrypted yet is encrypted. The store must not be in use.
+func Rekey(store storage.ManagedStore, keys *Keyring) error {
+	encrypted, err := IsEncrypted(store)
+	if err != nil {
+		return err
+	}
+	s := &EncryptedStore{store, keys}
+	for _, table := range storage.Tables {
+		if err := s.rekeyTable(table, encrypted); err != nil {
+			return err
+		}
+	}
+	if !encrypted {
+		return s.mark()
+	}
+	return nil
+}
+
+func (s *EncryptedStore) rekeyTable(table storage.Table, encrypted bool) error {
+	var reader storage.KVPairReader
+	if encrypted {
+		reader = s.GetAll(table)
+	} else {
+		reader = s.ManagedStore.GetAll(table)
+	}
+	defer reader.Close()
+
+	buffer := make([]*storage.KVPair, rekeyBatchSize)
+	for {
+		n, err := reader.Read(buffer)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+		mutations := make([]*storage.Mutation, n)
+		for i, pair := range buffer[:n] {
+			mutations[i] = storage.NewMutation(table, pair.Key, pair.Value)
+		}
+		if err := s.Mutate(mutations, nil); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,218 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package encrypted
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/storage/bplus"
+)
+
+const (
+	key1 = "1:000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	key2 = "2:1f1e1d1c1b1a191817161514131211100f0e0d0c0b0a09080706050403020100"
+)
+
+func mustKeyring(t *testing.T, text string) *Keyring {
+	k, err := ParseKeyring(text)
+	require.NoError(t, err)
+	return k
+}
+
+func TestParseKeyring(t *testing.T) {
+	k := mustKeyring(t, "# keys\n"+key1+"\n\n"+key2+"\n")
+	require.Equal(t, uint32(2), k.Active(), "The highest id should be the active key")
+	require.Equal(t, uint32(2), mustKeyring(t, key2+","+key1).Active())
+
+	for _, text := range []string{
+		"",
+		"# no keys",
+		"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+		"a:000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+		"1:0001",
+		"1:zz",
+		key1 + "\n" + key1,
+	} {
+		_, err := ParseKeyring(text)
+		require.Error(t, err, "Keyring %q should not be valid", text)
+	}
+}
+
+func TestSealAndOpen(t *testing.T) {
+	k := mustKeyring(t, key1)
+	sealed := k.seal([]byte("value"), []byte("key"))
+	require.False(t, bytes.Contains(sealed, []byte("value")))
+
+	value, err := k.open(sealed, []byte("key"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value"), value)
+
+	_, err = k.open(sealed, []byte("other key"))
+	require.Equal(t, ErrCorrupted, err, "A value moved to another key should not be opened")
+
+	sealed[len(sealed)-1] ^= 0xff
+	_, err = k.open(sealed, []byte("key"))
+	require.Equal(t, ErrCorrupted, err)
+
+	_, err = mustKeyring(t, key2).open(k.seal([]byte("value"), nil), nil)
+	require.Equal(t, ErrUnknownKey, err)
+}
+
+func TestNewKeyFile(t *testing.T) {
+	path, err := ioutil.TempDir("/var/tmp", "encrypted-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(path)
+	file := filepath.Join(path, "keys")
+
+	for i := 1; i <= 3; i++ {
+		id, err := NewKeyFile(file)
+		require.NoError(t, err)
+		require.Equal(t, uint32(i), id)
+	}
+	k, err := LoadKeyring(file)
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), k.Active())
+	require.Equal(t, 3, len(k.keys))
+}
+
+func TestEncryptedStore(t *testing.T) {
+	path, err := ioutil.TempDir("/var/tmp", "encrypted-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(path)
+
+	raw, err := bplus.OpenBPlusTreeStore(path)
+	require.NoError(t, err)
+	defer raw.Close()
+
+	encrypted, err := IsEncrypted(raw)
+	require.NoError(t, err)
+	require.False(t, encrypted)
+
+	store, err := NewEncryptedStore(raw, mustKeyring(t, key1))
+	require.NoError(t, err)
+	encrypted, err = IsEncrypted(raw)
+	require.NoError(t, err)
+	require.True(t, encrypted, "An empty store should be marked as encrypted")
+
+	var mutations []*storage.Mutation
+	for i := 0; i < 10; i++ {
+		mutations = append(mutations, storage.NewMutation(storage.HistoryTable, []byte{byte(i)}, []byte(fmt.Sprintf("value %d", i))))
+	}
+	mutations = append(mutations, storage.NewMutation(storage.FSMStateTable, storage.FSMStateTableKey, []byte("state")))
+	require.NoError(t, store.Mutate(mutations, nil))
+
+	// the underlying store only holds encrypted values
+	kv, err := raw.Get(storage.HistoryTable, []byte{0x1})
+	require.NoError(t, err)
+	require.False(t, strings.Contains(string(kv.Value), "value"))
+
+	kv, err = store.Get(storage.HistoryTable, []byte{0x1})
+	require.NoError(t, err)
+	require.Equal(t, []byte("value 1"), kv.Value)
+
+	kvs, err := store.GetRange(storage.HistoryTable, []byte{0x2}, []byte{0x4})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(kvs))
+	require.Equal(t, []byte("value 4"), kvs[2].Value)
+
+	kv, err = store.GetLast(storage.HistoryTable)
+	require.NoError(t, err)
+	require.Equal(t, []byte("value 9"), kv.Value)
+
+	reader := store.GetAll(storage.HistoryTable)
+	buffer := make([]*storage.KVPair, 20)
+	n, err := reader.Read(buffer)
+	reader.Close()
+	require.NoError(t, err)
+	require.Equal(t, 10, n)
+	require.Equal(t, []byte("value 0"), buffer[0].Value)
+
+	// the keys must include the one the store was encrypted with
+	_, err = NewEncryptedStore(raw, mustKeyring(t, key2))
+	require.Equal(t, ErrUnknownKey, err)
+	_, err = NewEncryptedStore(raw, mustKeyring(t, key1+","+key2))
+	require.NoError(t, err)
+}
+
+func TestNotEncryptedStore(t *testing.T) {
+	path, err := ioutil.TempDir("/var/tmp", "encrypted-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(path)
+
+	raw, err := bplus.OpenBPlusTreeStore(path)
+	require.NoError(t, err)
+	defer raw.Close()
+	require.NoError(t, raw.Mutate([]*storage.Mutation{
+		storage.NewMutation(storage.HistoryTable, []byte{0x1}, []byte("value")),
+		storage.NewMutation(storage.FSMStateTable, storage.FSMStateTableKey, []byte("state")),
+	}, nil))
+
+	_, err = NewEncryptedStore(raw, mustKeyring(t, key1))
+	require.Equal(t, ErrNotEncrypted, err)
+
+	// rekeying encrypts it
+	require.NoError(t, Rekey(raw, mustKeyring(t, key1)))
+	store, err := NewEncryptedStore(raw, mustKeyring(t, key1))
+	require.NoError(t, err)
+	kv, err := store.Get(storage.HistoryTable, []byte{0x1})
+	require.NoError(t, err)
+	require.Equal(t, []byte("value"), kv.Value)
+}
+
+func TestRekey(t *testing.T) {
+	path, err := ioutil.TempDir("/var/tmp", "encrypted-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(path)
+
+	raw, err := bplus.OpenBPlusTreeStore(path)
+	require.NoError(t, err)
+	defer raw.Close()
+
+	store, err := NewEncryptedStore(raw, mustKeyring(t, key1))
+	require.NoError(t, err)
+	require.NoError(t, store.Mutate([]*storage.Mutation{
+		storage.NewMutation(storage.HyperTable, []byte{0x1}, []byte("old")),
+	}, nil))
+
+	// values written after the rotation use the new key
+	rotated, err := NewEncryptedStore(raw, mustKeyring(t, key1+","+key2))
+	require.NoError(t, err)
+	require.NoError(t, rotated.Mutate([]*storage.Mutation{
+		storage.NewMutation(storage.HyperTable, []byte{0x2}, []byte("new")),
+	}, nil))
+	_, err = store.Get(storage.HyperTable, []byte{0x2})
+	require.Equal(t, ErrUnknownKey, err)
+
+	// after rekeying, the old key is no longer needed
+	require.NoError(t, Rekey(raw, mustKeyring(t, key1+","+key2)))
+	store, err = NewEncryptedStore(raw, mustKeyring(t, key2))
+	require.NoError(t, err)
+	for key, value := range map[byte]string{0x1: "old", 0x2: "new"} {
+		kv, err := store.Get(storage.HyperTable, []byte{key})
+		require.NoError(t, err)
+		require.Equal(t, []byte(value), kv.Value)
+	}
+}
@@ -0,0 +1,178 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package engine opens the storage engine selected by name.
+//
+// The RocksDB engine is only available in builds with cgo. Static
+// builds, with CGO_ENABLED=0, use the pure-Go LSM engine. The B+ tree
+// engine keeps the whole database in memory, persisted to a snapshot
+// and a WAL, for tests and small deployments.
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/storage/bplus"
+	"github.com/bbva/qed/storage/encrypted"
+	"github.com/bbva/qed/storage/lsm"
+)
+
+const (
+	// RocksDB is the RocksDB storage engine.
+	RocksDB = "rocksdb"
+	// LSM is the pure-Go LSM storage engine.
+	LSM = "lsm"
+	// BPlus is the in-memory B+ tree storage engine.
+	BPlus = "bplus"
+)
+
+// ErrUnavailable is returned when opening the RocksDB engine in a build
+// without cgo.
+var ErrUnavailable = errors.New("the rocksdb storage engine requires a build with cgo")
+
+// Options configures how a database is opened.
+type Options struct {
+	// Time archived WAL files are kept.
+	WALTtl time.Duration
+
+	// Keys used to encrypt the values of the database. If nil, the
+	// database is not encrypted.
+	Keyring *encrypted.Keyring
+
+	// ReadOnly opens an existing database without writing to its
+	// files, so several processes can share it. Writes fail with
+	// storage.ErrReadOnly.
+	ReadOnly bool
+}
+
+// Open opens the database at path with the given engine. It refuses to
+// open a database created by another engine.
+func Open(name, path string, walTtl time.Duration) (storage.ManagedStore, error) {
+	return OpenWithOptions(name, path, Options{WALTtl: walTtl})
+}
+
+// OpenWithOptions is like Open with custom options. A database opened
+// with a keyring is encrypted at rest, and an encrypted database cannot
+// be opened without one.
+func OpenWithOptions(name, path string, opts Options) (storage.ManagedStore, error) {
+	store, err := open(name, path, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Keyring != nil {
+		encryptedStore, err := encrypted.NewEncryptedStore(store, opts.Keyring)
+		if err != nil {
+			store.Close()
+			return nil, fmt.Errorf("cannot open database at %s: %v", path, err)
+		}
+		return encryptedStore, nil
+	}
+
+	isEncrypted, err := encrypted.IsEncrypted(store)
+	if err == nil && isEncrypted {
+		err = fmt.Errorf("database at %s is encrypted, its keys are required", path)
+	}
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// Rekey re-encrypts the values of the database at path with the active
+// key of the keyring, encrypting it if it was not. The database must not
+// be in use.
+func Rekey(name, path string, keyring *encrypted.Keyring) error {
+	store, err := open(name, path, Options{})
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	return encrypted.Rekey(store, keyring)
+}
+
+func open(name, path string, opts Options) (storage.ManagedStore, error) {
+	found := Detect(path)
+	if found != "" && found != name {
+		return nil, fmt.Errorf("database at %s uses the %s storage engine, not %s", path, found, name)
+	}
+	if opts.ReadOnly && found == "" {
+		return nil, fmt.Errorf("no database found at %s", path)
+	}
+	switch name {
+	case RocksDB:
+		return openRocksDB(path, opts)
+	case LSM:
+		lsmOpts := lsm.DefaultOptions()
+		lsmOpts.Path = path
+		lsmOpts.WALTtl = opts.WALTtl
+		lsmOpts.ReadOnly = opts.ReadOnly
+		store, err := lsm.NewLSMStoreWithOpts(lsmOpts)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	case BPlus:
+		openBPlus := bplus.OpenBPlusTreeStore
+		if opts.ReadOnly {
+			openBPlus = bplus.OpenBPlusTreeStoreReadOnly
+		}
+		store, err := openBPlus(path)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown storage engine %q", name)
+	}
+}
+
+// Detect returns the engine of the database at path, or an empty
+// string if there is none.
+func Detect(path string) string {
+	switch {
+	case exists(filepath.Join(path, "CURRENT")):
+		return RocksDB
+	case exists(filepath.Join(path, "MANIFEST")), isDir(filepath.Join(path, "wal")):
+		// an LSM database has no manifest until its first flush
+		return LSM
+	case isFile(filepath.Join(path, "wal")):
+		return BPlus
+	default:
+		return ""
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
@@ -0,0 +1,102 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package engine
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/storage/encrypted"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpen(t *testing.T) {
+	path, err := ioutil.TempDir("/var/tmp", "engine-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(path)
+
+	require.Equal(t, "", Detect(path))
+
+	store, err := Open(LSM, path, 0)
+	require.NoError(t, err)
+	require.Equal(t, LSM, Detect(path), "An LSM database should be detected before its first flush")
+	require.NoError(t, store.Mutate([]*storage.Mutation{
+		storage.NewMutation(storage.HistoryTable, []byte{0x1}, []byte{0x1}),
+	}, nil))
+	require.NoError(t, store.Backup(""))
+	require.NoError(t, store.Close())
+	require.Equal(t, LSM, Detect(path))
+
+	_, err = Open(RocksDB, path, 0)
+	require.Error(t, err, "Opening a database with another engine should fail")
+
+	_, err = Open("unknown", path, 0)
+	require.Error(t, err)
+}
+
+func TestOpenBPlus(t *testing.T) {
+	path, err := ioutil.TempDir("/var/tmp", "engine-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(path)
+
+	store, err := Open(BPlus, path, 0)
+	require.NoError(t, err)
+	require.NoError(t, store.Mutate([]*storage.Mutation{
+		storage.NewMutation(storage.HistoryTable, []byte{0x1}, []byte{0x1}),
+	}, nil))
+	require.NoError(t, store.Close())
+	require.Equal(t, BPlus, Detect(path))
+
+	_, err = Open(LSM, path, 0)
+	require.Error(t, err, "Opening a database with another engine should fail")
+
+	store, err = Open(BPlus, path, 0)
+	require.NoError(t, err)
+	defer store.Close()
+	_, err = store.Get(storage.HistoryTable, []byte{0x1})
+	require.NoError(t, err)
+}
+
+func TestOpenEncrypted(t *testing.T) {
+	path, err := ioutil.TempDir("/var/tmp", "engine-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(path)
+
+	keyring, err := encrypted.ParseKeyring("1:000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	require.NoError(t, err)
+
+	store, err := OpenWithOptions(BPlus, path, Options{Keyring: keyring})
+	require.NoError(t, err)
+	require.NoError(t, store.Mutate([]*storage.Mutation{
+		storage.NewMutation(storage.HistoryTable, []byte{0x1}, []byte{0x1}),
+	}, nil))
+	require.NoError(t, store.Close())
+
+	_, err = Open(BPlus, path, 0)
+	require.Error(t, err, "Opening an encrypted database without keys should fail")
+
+	require.NoError(t, Rekey(BPlus, path, keyring))
+
+	store, err = OpenWithOptions(BPlus, path, Options{Keyring: keyring})
+	require.NoError(t, err)
+	defer store.Close()
+	kv, err := store.Get(storage.HistoryTable, []byte{0x1})
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x1}, kv.Value)
+}
@@ -0,0 +1,40 @@
+//go:build cgo
+// +build cgo
+
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package engine
+
+import (
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/storage/rocks"
+)
+
+// Default is the engine used when none is configured.
+const Default = RocksDB
+
+func openRocksDB(path string, opts Options) (storage.ManagedStore, error) {
+	rocksOpts := rocks.DefaultOptions()
+	rocksOpts.Path = path
+	rocksOpts.WALTtlSeconds = uint64(opts.WALTtl.Seconds())
+	rocksOpts.ReadOnly = opts.ReadOnly
+	store, err := rocks.NewRocksDBStoreWithOpts(rocksOpts)
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
@@ -0,0 +1,266 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package lsm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/bbva/qed/storage"
+)
+
+// Backups are stored in <path>/backups/<id>, each one holding the table
+// files and the manifest of the database at the time of the backup plus
+// a file with its metadata. Table files are immutable so they are hard
+// linked when possible.
+const (
+	backupsDir     = "backups"
+	backupMetaFile = "BACKUP"
+)
+
+type backupMeta struct {
+	ID        uint32
+	Timestamp int64
+	Metadata  string
+}
+
+func (s *LSMStore) backupDir(id uint32) string {
+	return filepath.Join(s.path, backupsDir, strconv.FormatUint(uint64(id), 10))
+}
+
+// Backup flushes the memtable and creates a new backup with the given
+// metadata. Writes are blocked while the files are linked.
+func (s *LSMStore) Backup(metadata string) error {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if s.opts.ReadOnly {
+		return storage.ErrReadOnly
+	}
+	if err := s.flush(); err != nil {
+		return err
+	}
+
+	var id uint32 = 1
+	for _, info := range s.GetBackupsInfo() {
+		if uint32(info.ID) >= id {
+			id = uint32(info.ID) + 1
+		}
+	}
+	dir := s.backupDir(id)
+	tmp := dir + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	if err := os.Mkdir(tmp, 0755); err != nil {
+		return err
+	}
+
+	err := func() error {
+		if err := s.writeTables(tmp); err != nil {
+			return err
+		}
+		data, err := json.Marshal(&backupMeta{
+			ID:        id,
+			Timestamp: time.Now().Unix(),
+			Metadata:  metadata,
+		})
+		if err != nil {
+			return err
+		}
+		if err := writeFile(filepath.Join(tmp, backupMetaFile), data); err != nil {
+			return err
+		}
+		return os.Rename(tmp, dir)
+	}()
+	if err != nil {
+		os.RemoveAll(tmp)
+	}
+	return err
+}
+
+// writeTables links the table files of the database into dir and writes
+// a manifest listing them, so dir can be opened as a database. The
+// memtable must have been flushed with writes blocked.
+func (s *LSMStore) writeTables(dir string) error {
+	s.manifestLock.Lock()
+	_, tables := s.current()
+	m := &manifest{
+		Tables:     tableNums(tables),
+		NextFile:   atomic.LoadUint64(&s.nextFile),
+		FlushedSeq: s.flushedSeq,
+	}
+	s.manifestLock.Unlock()
+	defer unrefTables(tables)
+
+	for _, t := range tables {
+		if err := linkOrCopy(t.path, filepath.Join(dir, tableName(t.num))); err != nil {
+			return err
+		}
+	}
+	return writeManifest(dir, m)
+}
+
+// Checkpoint flushes the memtable and writes an openable copy of the
+// database into dir, which must not exist. Table files are hard linked
+// when possible. Writes are blocked while the files are linked.
+func (s *LSMStore) Checkpoint(dir string) error {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if s.opts.ReadOnly {
+		return storage.ErrReadOnly
+	}
+	if err := s.flush(); err != nil {
+		return err
+	}
+
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return err
+	}
+	if err := s.writeTables(dir); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	return nil
+}
+
+// GetBackupsInfo lists the backups ordered by ID.
+func (s *LSMStore) GetBackupsInfo() []*storage.BackupInfo {
+	dirs, err := ioutil.ReadDir(filepath.Join(s.path, backupsDir))
+	if err != nil {
+		return nil
+	}
+	backupsInfo := make([]*storage.BackupInfo, 0, len(dirs))
+	for _, d := range dirs {
+		if _, err := strconv.ParseUint(d.Name(), 10, 32); err != nil || !d.IsDir() {
+			continue
+		}
+		dir := filepath.Join(s.path, backupsDir, d.Name())
+		data, err := ioutil.ReadFile(filepath.Join(dir, backupMetaFile))
+		if err != nil {
+			continue
+		}
+		var meta backupMeta
+		if err := json.Unmarshal(data, &meta); err != nil {
+			continue
+		}
+		info := &storage.BackupInfo{
+			ID:        int64(meta.ID),
+			Timestamp: meta.Timestamp,
+			Metadata:  meta.Metadata,
+		}
+		files, _ := ioutil.ReadDir(dir)
+		for _, f := range files {
+			info.Size += f.Size()
+			info.NumFiles++
+		}
+		backupsInfo = append(backupsInfo, info)
+	}
+	sort.Slice(backupsInfo, func(i, j int) bool {
+		return backupsInfo[i].ID < backupsInfo[j].ID
+	})
+	return backupsInfo
+}
+
+// DeleteBackup deletes the backup identified by backupID.
+func (s *LSMStore) DeleteBackup(backupID uint32) error {
+	if s.opts.ReadOnly {
+		return storage.ErrReadOnly
+	}
+	dir := s.backupDir(backupID)
+	if _, err := os.Stat(filepath.Join(dir, backupMetaFile)); err != nil {
+		return fmt.Errorf("backup %d not found", backupID)
+	}
+	return os.RemoveAll(dir)
+}
+
+// RestoreFromBackup restores the backup identified by backupID into
+// dbDir, replacing any database found there. The WAL lives inside the
+// database directory, so the WAL directory argument is ignored.
+func (s *LSMStore) RestoreFromBackup(backupID uint32, dbDir, _ string) error {
+	dir := s.backupDir(backupID)
+	m, err := readManifest(dir)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(dir, backupMetaFile)); err != nil {
+		return fmt.Errorf("backup %d not found", backupID)
+	}
+
+	if err := os.MkdirAll(dbDir, 0755); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(dbDir, walDir)); err != nil {
+		return err
+	}
+	files, err := ioutil.ReadDir(dbDir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if _, ok := parseNum(f.Name(), tableSuffix); ok || f.Name() == manifestFile {
+			if err := os.Remove(filepath.Join(dbDir, f.Name())); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, num := range m.Tables {
+		name := tableName(num)
+		if err := linkOrCopy(filepath.Join(dir, name), filepath.Join(dbDir, name)); err != nil {
+			return err
+		}
+	}
+	return writeManifest(dbDir, m)
+}
+
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
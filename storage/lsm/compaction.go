@@ -0,0 +1,156 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package lsm
+
+import (
+	"github.com/bbva/qed/log"
+)
+
+// Tables are merged following a size-tiered strategy. Every table is
+// assigned a size class, a power of sizeRatio of the memtable size, and
+// a run of compactionTrigger or more consecutive tables of the same
+// class is merged into a single table of the next class. This keeps
+// the number of tables logarithmic with the size of the database while
+// each key is rewritten a logarithmic number of times.
+//
+// Only consecutive tables are merged, so the list of tables remains
+// ordered from the newest to the oldest.
+const (
+	compactionTrigger = 4
+	sizeRatio         = 4
+	maxTables         = 24
+)
+
+func sizeClass(size, base int64) int {
+	class := 0
+	for limit := base * sizeRatio; size >= limit; limit *= sizeRatio {
+		class++
+	}
+	return class
+}
+
+// pickCompaction returns the position and length of the run of tables
+// to merge. The length is zero when no compaction is needed.
+func pickCompaction(tables []*table, base int64) (int, int) {
+	for i := 0; i < len(tables); {
+		class := sizeClass(tables[i].size, base)
+		j := i + 1
+		for j < len(tables) && sizeClass(tables[j].size, base) == class {
+			j++
+		}
+		if j-i >= compactionTrigger {
+			return i, j - i
+		}
+		i = j
+	}
+	// unusual sequences of sizes, after loading snapshots for
+	// instance, may not form runs of the same class.
+	if len(tables) > maxTables {
+		return 0, compactionTrigger
+	}
+	return 0, 0
+}
+
+func (s *LSMStore) triggerCompaction() {
+	select {
+	case s.compactc <- struct{}{}:
+	default:
+	}
+}
+
+func (s *LSMStore) compactionLoop() {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.quitc:
+			return
+		case <-s.compactc:
+		}
+		for {
+			select {
+			case <-s.quitc:
+				return
+			default:
+			}
+			done, err := s.compact()
+			if err != nil {
+				log.L().Errorf("Unable to compact the table files of %s: %v", s.path, err)
+				break
+			}
+			if !done {
+				break
+			}
+		}
+	}
+}
+
+// compact merges a run of tables if needed and tells whether
+// it did.
+func (s *LSMStore) compact() (bool, error) {
+	s.mu.RLock()
+	start, n := pickCompaction(s.tables, int64(s.opts.MemtableSize))
+	inputs := append([]*table(nil), s.tables[start:start+n]...)
+	for _, t := range inputs {
+		t.ref()
+	}
+	s.mu.RUnlock()
+	if n == 0 {
+		return false, nil
+	}
+	defer unrefTables(inputs)
+
+	var read int64
+	its := make([]iterator, len(inputs))
+	for i, t := range inputs {
+		its[i] = t.iterator(nil)
+		read += t.size
+	}
+	it := newMergeIterator(its)
+	t, written, err := s.writeTable(it)
+	it.close()
+	if err != nil {
+		return false, err
+	}
+
+	s.manifestLock.Lock()
+	s.mu.Lock()
+	// flushes may have added newer tables in the meantime
+	pos := 0
+	for s.tables[pos] != inputs[0] {
+		pos++
+	}
+	tables := make([]*table, 0, len(s.tables)-n+1)
+	tables = append(tables, s.tables[:pos]...)
+	tables = append(tables, t)
+	tables = append(tables, s.tables[pos+n:]...)
+	s.tables = tables
+	nums := tableNums(tables)
+	s.mu.Unlock()
+	err = s.saveManifest(nums)
+	s.manifestLock.Unlock()
+	if err != nil {
+		// keep the files of the inputs, the manifest on
+		// disk still references them.
+		return false, err
+	}
+
+	for _, t := range inputs {
+		t.release()
+	}
+	s.metrics.compacted(read, written)
+	return true, nil
+}
@@ -0,0 +1,123 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package lsm
+
+import (
+	"bytes"
+
+	"github.com/bbva/qed/storage"
+)
+
+type iterator interface {
+	valid() bool
+	key() []byte
+	value() []byte
+	next()
+	err() error
+	close()
+}
+
+// mergeIterator merges sorted iterators ordered from the newest to the
+// oldest source. When a key is present in several sources, the value of
+// the newest one wins.
+type mergeIterator struct {
+	its []iterator
+	cur int
+}
+
+func newMergeIterator(its []iterator) *mergeIterator {
+	m := &mergeIterator{its: its}
+	m.find()
+	return m
+}
+
+func (m *mergeIterator) find() {
+	m.cur = -1
+	for i, it := range m.its {
+		if !it.valid() {
+			continue
+		}
+		if m.cur < 0 || bytes.Compare(it.key(), m.its[m.cur].key()) < 0 {
+			m.cur = i
+		}
+	}
+}
+
+func (m *mergeIterator) valid() bool {
+	return m.cur >= 0
+}
+
+func (m *mergeIterator) key() []byte {
+	return m.its[m.cur].key()
+}
+
+func (m *mergeIterator) value() []byte {
+	return m.its[m.cur].value()
+}
+
+func (m *mergeIterator) next() {
+	key := m.key()
+	for _, it := range m.its {
+		if it.valid() && bytes.Equal(it.key(), key) {
+			it.next()
+		}
+	}
+	m.find()
+}
+
+func (m *mergeIterator) err() error {
+	for _, it := range m.its {
+		if err := it.err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *mergeIterator) close() {
+	for _, it := range m.its {
+		it.close()
+	}
+}
+
+// LSMKVPairReader reads all the entries of a table in key order.
+type LSMKVPairReader struct {
+	it  iterator
+	end []byte
+}
+
+func (r *LSMKVPairReader) Read(buffer []*storage.KVPair) (n int, err error) {
+	for n = 0; r.it.valid() && n < len(buffer); r.it.next() {
+		if bytes.Compare(r.it.key(), r.end) >= 0 {
+			break
+		}
+		buffer[n] = &storage.KVPair{
+			Key:   copyBytes(r.it.key()[1:]),
+			Value: copyBytes(r.it.value()),
+		}
+		n++
+	}
+	return n, r.it.err()
+}
+
+func (r *LSMKVPairReader) Close() {
+	r.it.close()
+}
+
+func copyBytes(b []byte) []byte {
+	return append(make([]byte, 0, len(b)), b...)
+}
@@ -0,0 +1,506 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package lsm implements a pure-Go log-structured merge tree store.
+//
+// It is an alternative to the RocksDB store for environments where cgo
+// is not available, such as static binaries. Writes go to a write-ahead
+// log and a memtable, which is flushed to immutable table files once it
+// grows past a threshold. Table files are merged in the background by a
+// size-tiered compaction. Write batches use the RocksDB format, so
+// snapshots can be exchanged with replicas running on RocksDB.
+package lsm
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bbva/qed/metrics"
+	"github.com/bbva/qed/storage"
+)
+
+// ErrClosed is returned when writing to a closed store.
+var ErrClosed = errors.New("store closed")
+
+type LSMStore struct {
+	path string
+	opts *Options
+
+	// writeLock serializes writes, flushes and backups.
+	writeLock sync.Mutex
+	closed    bool
+
+	// mu protects the swap of the memtable and the list of tables.
+	mu     sync.RWMutex
+	mem    *memtable
+	tables []*table // from the newest to the oldest
+
+	// manifestLock serializes the manifest updates of flushes
+	// and compactions.
+	manifestLock sync.Mutex
+	flushedSeq   uint64
+
+	lastSeq  uint64 // atomic
+	nextFile uint64 // atomic
+
+	wal *wal
+
+	compactc chan struct{}
+	quitc    chan struct{}
+	wg       sync.WaitGroup
+
+	metrics *lsmMetrics
+}
+
+type Options struct {
+	Path string
+	// MemtableSize is the size in bytes the memtable can reach
+	// before being flushed to a table file.
+	MemtableSize int
+	// WALTtl is the time archived WAL segments are kept. Zero
+	// keeps them until WALSizeLimit is reached.
+	WALTtl time.Duration
+	// WALSizeLimit is the maximum size in bytes of the WAL.
+	WALSizeLimit int64
+	// NoSync disables the sync of the WAL after every write.
+	NoSync bool
+	// ReadOnly opens an existing database without writing to its
+	// files: the WAL is replayed but not truncated nor rolled, and
+	// there are no compactions. Writes fail with storage.ErrReadOnly.
+	ReadOnly bool
+}
+
+func DefaultOptions() *Options {
+	return &Options{
+		MemtableSize: 64 * 1024 * 1024, // 64MB
+		WALTtl:       0,
+		WALSizeLimit: 1 << 40, // 1TB
+	}
+}
+
+func NewLSMStore(path string, ttl time.Duration) (*LSMStore, error) {
+	opts := DefaultOptions()
+	opts.Path = path
+	opts.WALTtl = ttl
+	return NewLSMStoreWithOpts(opts)
+}
+
+func NewLSMStoreWithOpts(opts *Options) (*LSMStore, error) {
+	if !opts.ReadOnly {
+		if err := os.MkdirAll(filepath.Join(opts.Path, backupsDir), 0755); err != nil {
+			return nil, err
+		}
+	}
+	m, err := readManifest(opts.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &LSMStore{
+		path:       opts.Path,
+		opts:       opts,
+		mem:        newMemtable(),
+		flushedSeq: m.FlushedSeq,
+		lastSeq:    m.FlushedSeq,
+		nextFile:   m.NextFile,
+		compactc:   make(chan struct{}, 1),
+		quitc:      make(chan struct{}),
+	}
+	for _, num := range m.Tables {
+		t, err := openTable(filepath.Join(s.path, tableName(num)), num)
+		if err != nil {
+			s.releaseTables()
+			return nil, err
+		}
+		s.tables = append(s.tables, t)
+	}
+	if !opts.ReadOnly {
+		if err := s.removeOrphans(m); err != nil {
+			s.releaseTables()
+			return nil, err
+		}
+	}
+
+	if err := s.recover(); err != nil {
+		s.releaseTables()
+		return nil, err
+	}
+
+	s.metrics = newLSMMetrics(s)
+	if !opts.ReadOnly {
+		s.wg.Add(1)
+		go s.compactionLoop()
+		s.triggerCompaction()
+	}
+
+	return s, nil
+}
+
+// removeOrphans removes the table files left by a flush or compaction
+// interrupted before updating the manifest.
+func (s *LSMStore) removeOrphans(m *manifest) error {
+	live := make(map[string]bool, len(m.Tables))
+	for _, num := range m.Tables {
+		live[tableName(num)] = true
+	}
+	files, err := ioutil.ReadDir(s.path)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		name := f.Name()
+		orphan := strings.HasSuffix(name, tableSuffix) && !live[name]
+		if orphan || strings.HasSuffix(name, ".tmp") {
+			if err := os.Remove(filepath.Join(s.path, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// recover replays the write batches of the WAL not yet flushed to
+// the table files and starts a new WAL segment, unless the store is
+// read-only.
+func (s *LSMStore) recover() error {
+	w, err := openWAL(filepath.Join(s.path, walDir), s.opts.WALTtl, s.opts.WALSizeLimit, s.opts.NoSync, s.opts.ReadOnly)
+	if err != nil {
+		return err
+	}
+	segments, err := w.segments()
+	if err != nil {
+		return err
+	}
+	for _, seg := range segments {
+		if seg.num >= s.nextFile {
+			s.nextFile = seg.num + 1
+		}
+	}
+
+	err = w.replay(func(data []byte) error {
+		b, err := storage.DecodeWriteBatch(data)
+		if err != nil {
+			return err
+		}
+		if b.Seq+b.Count() <= s.flushedSeq+1 {
+			return nil
+		}
+		s.apply(b)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.wal = w
+	if s.opts.ReadOnly {
+		return nil
+	}
+	return w.roll(s.newFileNum())
+}
+
+func (s *LSMStore) newFileNum() uint64 {
+	return atomic.AddUint64(&s.nextFile, 1) - 1
+}
+
+func internalKey(table storage.Table, key []byte) []byte {
+	return append([]byte{table.Prefix()}, key...)
+}
+
+// apply adds the batch to the memtable. It must be called with the
+// write lock held.
+func (s *LSMStore) apply(b *storage.WriteBatch) {
+	for _, m := range b.Mutations {
+		s.mem.put(internalKey(m.Table, m.Key), copyBytes(m.Value))
+	}
+	if b.Count() > 0 {
+		atomic.StoreUint64(&s.lastSeq, b.Seq+b.Count()-1)
+	}
+}
+
+func (s *LSMStore) write(b *storage.WriteBatch) error {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if s.opts.ReadOnly {
+		return storage.ErrReadOnly
+	}
+
+	b.Seq = atomic.LoadUint64(&s.lastSeq) + 1
+	data := b.Encode()
+	if err := s.wal.append(data); err != nil {
+		return err
+	}
+	s.apply(b)
+	s.metrics.write(len(b.Mutations), len(data), !s.opts.NoSync)
+
+	if s.mem.bytes() >= s.opts.MemtableSize {
+		return s.flush()
+	}
+	return nil
+}
+
+// flush writes the memtable to a new table file and starts a new WAL
+// segment. It must be called with the write lock held.
+func (s *LSMStore) flush() error {
+	if s.mem.len() == 0 {
+		return nil
+	}
+	it := s.mem.iterator(nil)
+	t, written, err := s.writeTable(it)
+	it.close()
+	if err != nil {
+		return err
+	}
+	walNum := s.newFileNum()
+
+	s.manifestLock.Lock()
+	s.mu.Lock()
+	s.tables = append([]*table{t}, s.tables...)
+	s.mem = newMemtable()
+	tables := tableNums(s.tables)
+	s.mu.Unlock()
+	s.flushedSeq = atomic.LoadUint64(&s.lastSeq)
+	err = s.saveManifest(tables)
+	s.manifestLock.Unlock()
+	if err != nil {
+		return err
+	}
+	s.metrics.flushed(written)
+
+	if err := s.wal.roll(walNum); err != nil {
+		return err
+	}
+	if err := s.wal.purge(); err != nil {
+		return err
+	}
+	s.triggerCompaction()
+	return nil
+}
+
+// saveManifest must be called with the manifest lock held.
+func (s *LSMStore) saveManifest(tables []uint64) error {
+	return writeManifest(s.path, &manifest{
+		Tables:     tables,
+		NextFile:   atomic.LoadUint64(&s.nextFile),
+		FlushedSeq: s.flushedSeq,
+	})
+}
+
+func (s *LSMStore) writeTable(it iterator) (*table, int64, error) {
+	num := s.newFileNum()
+	path := filepath.Join(s.path, tableName(num))
+	w, err := newTableWriter(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	for ; it.valid(); it.next() {
+		if err := w.add(it.key(), it.value()); err != nil {
+			w.abort()
+			return nil, 0, err
+		}
+	}
+	if err := it.err(); err != nil {
+		w.abort()
+		return nil, 0, err
+	}
+	if err := w.finish(); err != nil {
+		return nil, 0, err
+	}
+	t, err := openTable(path, num)
+	if err != nil {
+		os.Remove(path)
+		return nil, 0, err
+	}
+	return t, t.size, nil
+}
+
+func tableNums(tables []*table) []uint64 {
+	nums := make([]uint64, len(tables))
+	for i, t := range tables {
+		nums[i] = t.num
+	}
+	return nums
+}
+
+// current returns the memtable and a referenced copy of the table list.
+// The tables must be unreferenced once done.
+func (s *LSMStore) current() (*memtable, []*table) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, t := range s.tables {
+		t.ref()
+	}
+	return s.mem, append([]*table(nil), s.tables...)
+}
+
+func unrefTables(tables []*table) {
+	for _, t := range tables {
+		t.unref()
+	}
+}
+
+func (s *LSMStore) newIterator(start []byte) iterator {
+	mem, tables := s.current()
+	its := make([]iterator, 0, len(tables)+1)
+	its = append(its, mem.iterator(start))
+	for _, t := range tables {
+		its = append(its, t.iterator(start))
+	}
+	unrefTables(tables)
+	return newMergeIterator(its)
+}
+
+func (s *LSMStore) Mutate(mutations []*storage.Mutation, metadata []byte) error {
+	return s.write(&storage.WriteBatch{
+		Metadata:  metadata,
+		Mutations: mutations,
+	})
+}
+
+func (s *LSMStore) Get(table storage.Table, key []byte) (*storage.KVPair, error) {
+	k := internalKey(table, key)
+	mem, tables := s.current()
+	defer unrefTables(tables)
+
+	if v, ok := mem.get(k); ok {
+		s.metrics.read(true, len(v))
+		return &storage.KVPair{Key: key, Value: copyBytes(v)}, nil
+	}
+	for _, t := range tables {
+		v, ok, err := t.get(k)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			s.metrics.read(false, len(v))
+			return &storage.KVPair{Key: key, Value: copyBytes(v)}, nil
+		}
+	}
+	s.metrics.read(false, 0)
+	return nil, storage.ErrKeyNotFound
+}
+
+func (s *LSMStore) GetRange(table storage.Table, start, end []byte) (storage.KVRange, error) {
+	result := make(storage.KVRange, 0)
+	endKey := internalKey(table, end)
+	it := s.newIterator(internalKey(table, start))
+	defer it.close()
+	for ; it.valid(); it.next() {
+		if bytes.Compare(it.key(), endKey) > 0 {
+			break
+		}
+		result = append(result, storage.KVPair{
+			Key:   copyBytes(it.key()[1:]),
+			Value: copyBytes(it.value()),
+		})
+	}
+	return result, it.err()
+}
+
+func (s *LSMStore) GetLast(table storage.Table) (*storage.KVPair, error) {
+	prefix := table.Prefix()
+	limit := []byte{prefix + 1}
+	mem, tables := s.current()
+	defer unrefTables(tables)
+
+	var key, value []byte
+	if k, v, ok := mem.last(limit); ok && k[0] == prefix {
+		key, value = k, v
+	}
+	for _, t := range tables {
+		k, v, ok, err := t.last(limit)
+		if err != nil {
+			return nil, err
+		}
+		if ok && k[0] == prefix && (key == nil || bytes.Compare(k, key) > 0) {
+			key, value = k, v
+		}
+	}
+	if key == nil {
+		return nil, storage.ErrKeyNotFound
+	}
+	return &storage.KVPair{
+		Key:   copyBytes(key[1:]),
+		Value: copyBytes(value),
+	}, nil
+}
+
+func (s *LSMStore) GetAll(table storage.Table) storage.KVPairReader {
+	prefix := table.Prefix()
+	return &LSMKVPairReader{
+		it:  s.newIterator([]byte{prefix}),
+		end: []byte{prefix + 1},
+	}
+}
+
+// LastWALSequenceNumber returns the sequence number of the
+// last transaction applied to the WAL. This sequence
+// number can be used as upper limit when fetching transactions
+// from the WAL.
+func (s *LSMStore) LastWALSequenceNumber() uint64 {
+	return atomic.LoadUint64(&s.lastSeq)
+}
+
+func (s *LSMStore) RegisterMetrics(registry metrics.Registry) {
+	if registry != nil {
+		registry.MustRegister(s.metrics.collectors()...)
+	}
+}
+
+// Close stops the background compaction and closes the files. The
+// memtable is not flushed: it is rebuilt from the WAL on the next open.
+func (s *LSMStore) Close() error {
+	s.writeLock.Lock()
+	if s.closed {
+		s.writeLock.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.writeLock.Unlock()
+
+	close(s.quitc)
+	s.wg.Wait()
+
+	err := s.wal.close()
+	s.releaseTables()
+	return err
+}
+
+func (s *LSMStore) releaseTables() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unrefTables(s.tables)
+	s.tables = nil
+}
+
+func parseNum(name, suffix string) (uint64, bool) {
+	if !strings.HasSuffix(name, suffix) {
+		return 0, false
+	}
+	num, err := strconv.ParseUint(strings.TrimSuffix(name, suffix), 10, 64)
+	return num, err == nil
+}
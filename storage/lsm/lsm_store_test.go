@@ -0,0 +1,533 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package lsm
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memtable sizes to test with data only in memory and spread
+// across several table files.
+var memtableSizes = []int{64 * 1024 * 1024, 512}
+
+func TestMutate(t *testing.T) {
+	for _, size := range memtableSizes {
+		store, closeF := openLSMStore(t, size)
+
+		for i := uint64(0); i < 100; i++ {
+			key := util.Uint64AsBytes(i)
+			err := store.Mutate([]*storage.Mutation{
+				{Table: storage.HistoryTable, Key: key, Value: key},
+				{Table: storage.HyperTable, Key: key, Value: []byte("v1")},
+			}, key)
+			require.NoError(t, err)
+		}
+		// updates must shadow older values
+		for i := uint64(0); i < 100; i += 2 {
+			key := util.Uint64AsBytes(i)
+			err := store.Mutate([]*storage.Mutation{
+				{Table: storage.HyperTable, Key: key, Value: []byte("v2")},
+			}, nil)
+			require.NoError(t, err)
+		}
+		require.Equal(t, uint64(250), store.LastWALSequenceNumber())
+
+		for i := uint64(0); i < 100; i++ {
+			key := util.Uint64AsBytes(i)
+			kv, err := store.Get(storage.HistoryTable, key)
+			require.NoError(t, err)
+			require.Equal(t, key, kv.Value)
+
+			expected := []byte("v1")
+			if i%2 == 0 {
+				expected = []byte("v2")
+			}
+			kv, err = store.Get(storage.HyperTable, key)
+			require.NoError(t, err)
+			require.Equalf(t, expected, kv.Value, "Wrong value for key %d with memtable size %d", i, size)
+		}
+
+		_, err := store.Get(storage.FSMStateTable, util.Uint64AsBytes(0))
+		require.Equal(t, storage.ErrKeyNotFound, err)
+		_, err = store.Get(storage.HistoryTable, util.Uint64AsBytes(100))
+		require.Equal(t, storage.ErrKeyNotFound, err)
+
+		closeF()
+	}
+}
+
+func TestGetRange(t *testing.T) {
+	var testCases = []struct {
+		size       int
+		start, end byte
+	}{
+		{40, 10, 50},
+		{0, 1, 9},
+		{11, 1, 20},
+		{10, 40, 60},
+		{0, 60, 100},
+		{0, 20, 10},
+	}
+
+	for _, memtableSize := range memtableSizes {
+		store, closeF := openLSMStore(t, memtableSize)
+
+		table := storage.HistoryTable
+		for i := 10; i < 50; i++ {
+			require.NoError(t, store.Mutate([]*storage.Mutation{
+				{Table: table, Key: []byte{byte(i)}, Value: []byte("Value")},
+				{Table: storage.HyperTable, Key: []byte{byte(i)}, Value: []byte("Other")},
+			}, nil))
+		}
+
+		for _, test := range testCases {
+			slice, err := store.GetRange(table, []byte{test.start}, []byte{test.end})
+			require.NoError(t, err)
+			require.Equalf(t, test.size, len(slice), "Slice length invalid: expected %d, actual %d", test.size, len(slice))
+			for _, kv := range slice {
+				require.Equal(t, []byte("Value"), kv.Value)
+			}
+		}
+
+		closeF()
+	}
+}
+
+func TestGetAll(t *testing.T) {
+
+	table := storage.HyperTable
+	numElems := uint16(1000)
+	testCases := []struct {
+		batchSize    int
+		numBatches   int
+		lastBatchLen int
+	}{
+		{10, 100, 10},
+		{20, 50, 20},
+		{17, 59, 14},
+	}
+
+	for _, memtableSize := range memtableSizes {
+		store, closeF := openLSMStore(t, memtableSize)
+
+		// insert
+		for i := uint16(0); i < numElems; i++ {
+			key := util.Uint16AsBytes(i)
+			require.NoError(t, store.Mutate([]*storage.Mutation{
+				{Table: table, Key: key, Value: key},
+				{Table: storage.HistoryTable, Key: key, Value: key},
+			}, nil))
+		}
+
+		for i, c := range testCases {
+			reader := store.GetAll(table)
+			numBatches := 0
+			var lastBatchLen int
+			var last []byte
+			for {
+				entries := make([]*storage.KVPair, c.batchSize)
+				n, err := reader.Read(entries)
+				require.NoError(t, err)
+				if n == 0 {
+					break
+				}
+				for _, kv := range entries[:n] {
+					require.True(t, bytes.Compare(last, kv.Key) < 0, "Keys should be sorted")
+					last = kv.Key
+				}
+				numBatches++
+				lastBatchLen = n
+			}
+			reader.Close()
+			assert.Equalf(t, c.numBatches, numBatches, "The number of batches should match for test case %d", i)
+			assert.Equal(t, c.lastBatchLen, lastBatchLen, "The size of the last batch len should match for test case %d", i)
+		}
+
+		closeF()
+	}
+}
+
+func TestGetLast(t *testing.T) {
+	for _, memtableSize := range memtableSizes {
+		store, closeF := openLSMStore(t, memtableSize)
+
+		_, err := store.GetLast(storage.HistoryTable)
+		require.Equal(t, storage.ErrKeyNotFound, err)
+
+		// insert
+		numElems := uint64(20)
+		tables := []storage.Table{storage.HistoryTable, storage.HyperTable}
+		for _, table := range tables {
+			for i := uint64(0); i < numElems; i++ {
+				key := util.Uint64AsBytes(i)
+				require.NoError(t, store.Mutate([]*storage.Mutation{
+					{Table: table, Key: key, Value: key},
+				}, nil))
+			}
+		}
+
+		// get last element for history table
+		kv, err := store.GetLast(storage.HistoryTable)
+		require.NoError(t, err)
+		require.Equalf(t, util.Uint64AsBytes(numElems-1), kv.Key, "The key should match the last inserted element")
+		require.Equalf(t, util.Uint64AsBytes(numElems-1), kv.Value, "The value should match the last inserted element")
+
+		closeF()
+	}
+}
+
+func TestReopen(t *testing.T) {
+	path := mustTempDir()
+	defer deleteFile(path)
+
+	opts := DefaultOptions()
+	opts.Path = path
+	opts.MemtableSize = 1024
+
+	store, err := NewLSMStoreWithOpts(opts)
+	require.NoError(t, err)
+	numElems := uint64(500)
+	mutateElems(t, store, storage.HistoryTable, 0, numElems)
+	require.NoError(t, store.Close())
+
+	// a torn write at the end of the WAL is discarded
+	segments, err := store.wal.segments()
+	require.NoError(t, err)
+	f, err := os.OpenFile(segments[len(segments)-1].path, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0x1, 0x2, 0x3})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	store, err = NewLSMStoreWithOpts(opts)
+	require.NoError(t, err)
+	defer store.Close()
+
+	require.Equal(t, numElems, store.LastWALSequenceNumber())
+	for i := uint64(0); i < numElems; i++ {
+		kv, err := store.Get(storage.HistoryTable, util.Uint64AsBytes(i))
+		require.NoError(t, err)
+		require.Equal(t, util.Uint64AsBytes(i), kv.Value)
+	}
+
+	// keep writing after the recovery
+	mutateElems(t, store, storage.HistoryTable, numElems, 2*numElems)
+	require.Equal(t, 2*numElems, store.LastWALSequenceNumber())
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	path := mustTempDir()
+	defer deleteFile(path)
+
+	opts := DefaultOptions()
+	opts.Path = path
+	opts.MemtableSize = 1024
+
+	// leave some batches in table files and the rest only in the WAL
+	store, err := NewLSMStoreWithOpts(opts)
+	require.NoError(t, err)
+	numElems := uint64(500)
+	mutateElems(t, store, storage.HistoryTable, 0, numElems)
+	require.NoError(t, store.Close())
+	files := readFiles(t, path)
+
+	// several stores can share the same files
+	opts.ReadOnly = true
+	first, err := NewLSMStoreWithOpts(opts)
+	require.NoError(t, err)
+	second, err := NewLSMStoreWithOpts(opts)
+	require.NoError(t, err)
+	for _, store := range []*LSMStore{first, second} {
+		require.Equal(t, numElems, store.LastWALSequenceNumber())
+		for i := uint64(0); i < numElems; i++ {
+			kv, err := store.Get(storage.HistoryTable, util.Uint64AsBytes(i))
+			require.NoError(t, err)
+			require.Equal(t, util.Uint64AsBytes(i), kv.Value)
+		}
+
+		err = store.Mutate([]*storage.Mutation{
+			{Table: storage.HistoryTable, Key: []byte("new"), Value: []byte("value")},
+		}, nil)
+		require.Equal(t, storage.ErrReadOnly, err)
+		require.Equal(t, storage.ErrReadOnly, store.Backup("metadata"))
+	}
+	require.NoError(t, first.Close())
+	require.NoError(t, second.Close())
+	require.Equal(t, files, readFiles(t, path))
+}
+
+func TestCompaction(t *testing.T) {
+	store, closeF := openLSMStore(t, 1024)
+	defer closeF()
+
+	numElems := uint64(5000)
+	for i := uint64(0); i < numElems; i++ {
+		key := util.Uint64AsBytes(i % 1000)
+		require.NoError(t, store.Mutate([]*storage.Mutation{
+			{Table: storage.HyperTable, Key: key, Value: util.Uint64AsBytes(i)},
+		}, nil))
+	}
+
+	require.Eventually(t, func() bool {
+		store.mu.RLock()
+		defer store.mu.RUnlock()
+		_, n := pickCompaction(store.tables, int64(store.opts.MemtableSize))
+		return n == 0
+	}, 10*time.Second, 10*time.Millisecond, "Compactions should finish")
+
+	store.mu.RLock()
+	numTables := len(store.tables)
+	store.mu.RUnlock()
+	require.True(t, numTables < maxTables, "Tables should have been merged")
+
+	// the newest values survive the merges
+	for i := uint64(0); i < 1000; i++ {
+		kv, err := store.Get(storage.HyperTable, util.Uint64AsBytes(i))
+		require.NoError(t, err)
+		require.Equal(t, util.Uint64AsBytes(numElems-1000+i), kv.Value)
+	}
+
+	// obsolete table files are removed
+	files, err := filepath.Glob(filepath.Join(store.path, "*"+tableSuffix))
+	require.NoError(t, err)
+	require.Equal(t, numTables, len(files))
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	store, closeF := openLSMStore(t, 4096)
+	defer closeF()
+
+	numElems := uint64(200)
+	mutateElems(t, store, storage.HistoryTable, 0, numElems)
+	require.NoError(t, store.Backup("first"))
+	mutateElems(t, store, storage.HistoryTable, numElems, 2*numElems)
+	require.NoError(t, store.Backup("second"))
+
+	backups := store.GetBackupsInfo()
+	require.Equal(t, 2, len(backups))
+	require.Equal(t, int64(1), backups[0].ID)
+	require.Equal(t, "first", backups[0].Metadata)
+	require.Equal(t, int64(2), backups[1].ID)
+	require.Equal(t, "second", backups[1].Metadata)
+
+	restorePath := mustTempDir()
+	defer deleteFile(restorePath)
+	require.NoError(t, store.RestoreFromBackup(1, restorePath, restorePath))
+
+	restore, err := NewLSMStore(restorePath, 0)
+	require.NoError(t, err)
+	defer restore.Close()
+	require.Equal(t, numElems, restore.LastWALSequenceNumber())
+	for i := uint64(0); i < 2*numElems; i++ {
+		_, err := restore.Get(storage.HistoryTable, util.Uint64AsBytes(i))
+		if i < numElems {
+			require.NoError(t, err)
+		} else {
+			require.Equal(t, storage.ErrKeyNotFound, err)
+		}
+	}
+
+	require.NoError(t, store.DeleteBackup(1))
+	require.Error(t, store.DeleteBackup(1))
+	backups = store.GetBackupsInfo()
+	require.Equal(t, 1, len(backups))
+	require.Equal(t, int64(2), backups[0].ID)
+}
+
+func TestCheckpoint(t *testing.T) {
+	store, closeF := openLSMStore(t, 4096)
+	defer closeF()
+
+	numElems := uint64(200)
+	mutateElems(t, store, storage.HistoryTable, 0, numElems)
+	checkpointPath := filepath.Join(mustTempDir(), "checkpoint")
+	defer deleteFile(filepath.Dir(checkpointPath))
+	require.NoError(t, store.Checkpoint(checkpointPath))
+	require.Error(t, store.Checkpoint(checkpointPath), "The checkpoint directory must not exist")
+	mutateElems(t, store, storage.HistoryTable, numElems, 2*numElems)
+
+	checkpoint, err := NewLSMStore(checkpointPath, 0)
+	require.NoError(t, err)
+	defer checkpoint.Close()
+	require.Equal(t, numElems, checkpoint.LastWALSequenceNumber())
+	kv, err := checkpoint.GetLast(storage.HistoryTable)
+	require.NoError(t, err)
+	require.Equal(t, util.Uint64AsBytes(numElems-1), kv.Key)
+}
+
+func TestFetchAndLoadSnapshot(t *testing.T) {
+	for _, memtableSize := range memtableSizes {
+		store, closeF := openLSMStore(t, memtableSize)
+
+		// insert
+		numElems := uint64(100)
+		tables := []storage.Table{storage.HistoryTable, storage.HyperTable}
+		for j, table := range tables {
+			for i := uint64(0); i < numElems; i++ {
+				key := util.Uint64AsBytes(i)
+				err := store.Mutate(
+					[]*storage.Mutation{
+						{Table: table, Key: key, Value: key},
+					},
+					util.Uint64AsBytes(numElems*uint64(j)+i),
+				)
+				require.NoError(t, err)
+			}
+		}
+
+		// get last WAL seq num
+		until := store.LastWALSequenceNumber()
+		require.Equal(t, numElems*uint64(len(tables)), until)
+
+		// fetch snapshot
+		ioBuf := new(bufCloser)
+		require.NoError(t, store.FetchSnapshot(ioBuf, 0, until, func(meta []byte) (bool, error) {
+			return util.BytesAsUint64(meta) >= numElems/2, nil
+		}))
+
+		// load snapshot in another instance
+		restore, recloseF := openLSMStore(t, memtableSize)
+		require.NoError(t, restore.LoadSnapshot(ioBuf))
+		require.Equal(t, until-numElems/2, restore.LastWALSequenceNumber())
+
+		// check elements
+		for j, table := range tables {
+			for i := uint64(0); i < numElems; i++ {
+				kv, err := restore.Get(table, util.Uint64AsBytes(i))
+				if uint64(j)*numElems+i < numElems/2 {
+					require.Equal(t, storage.ErrKeyNotFound, err)
+					continue
+				}
+				require.NoError(t, err)
+				require.Equal(t, util.Uint64AsBytes(i), kv.Value, "The values should match")
+			}
+		}
+
+		// fetch only the last half
+		ioBuf = new(bufCloser)
+		require.NoError(t, store.FetchSnapshot(ioBuf, until/2, until, func(meta []byte) (bool, error) {
+			return true, nil
+		}))
+		n := 0
+		for {
+			chunk, err := storage.ReadChunk(ioBuf)
+			if err != nil {
+				break
+			}
+			b, err := storage.DecodeWriteBatch(chunk)
+			require.NoError(t, err)
+			require.True(t, b.Seq > until/2)
+			n++
+		}
+		require.Equal(t, int(until/2), n)
+
+		recloseF()
+		closeF()
+	}
+}
+
+func TestFetchSnapshotPurgedWAL(t *testing.T) {
+	path := mustTempDir()
+	defer deleteFile(path)
+
+	opts := DefaultOptions()
+	opts.Path = path
+	opts.MemtableSize = 512
+	opts.WALSizeLimit = 0
+	store, err := NewLSMStoreWithOpts(opts)
+	require.NoError(t, err)
+	defer store.Close()
+
+	mutateElems(t, store, storage.HistoryTable, 0, 100)
+	err = store.FetchSnapshot(new(bufCloser), 0, store.LastWALSequenceNumber(), func(meta []byte) (bool, error) {
+		return true, nil
+	})
+	require.Equal(t, ErrWALPurged, err)
+}
+
+func mutateElems(t *testing.T, store *LSMStore, table storage.Table, from, to uint64) {
+	for i := from; i < to; i++ {
+		key := util.Uint64AsBytes(i)
+		require.NoError(t, store.Mutate([]*storage.Mutation{
+			{Table: table, Key: key, Value: key},
+		}, key))
+	}
+}
+
+func openLSMStore(t require.TestingT, memtableSize int) (*LSMStore, func()) {
+	path := mustTempDir()
+	opts := DefaultOptions()
+	opts.Path = path
+	opts.MemtableSize = memtableSize
+	store, err := NewLSMStoreWithOpts(opts)
+	if err != nil {
+		t.Errorf("Error opening lsm store: %v", err)
+		t.FailNow()
+	}
+	return store, func() {
+		store.Close()
+		deleteFile(path)
+	}
+}
+
+func mustTempDir() string {
+	var err error
+	path, err := ioutil.TempDir("/var/tmp", "lsmstore-test-")
+	if err != nil {
+		panic("failed to create temp dir")
+	}
+	return path
+}
+
+func readFiles(t *testing.T, path string) map[string][]byte {
+	files := make(map[string][]byte)
+	err := filepath.Walk(path, func(name string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		files[name], err = ioutil.ReadFile(name)
+		return err
+	})
+	require.NoError(t, err)
+	return files
+}
+
+func deleteFile(path string) {
+	err := os.RemoveAll(path)
+	if err != nil {
+		fmt.Printf("Unable to remove db file %s", err)
+	}
+}
+
+type bufCloser struct {
+	bytes.Buffer
+}
+
+func (b bufCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,136 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package lsm
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/google/btree"
+)
+
+// memtable holds the most recent writes in memory until they are
+// flushed to a table file. Keys are prefixed with the table byte.
+type memtable struct {
+	sync.RWMutex
+	tree *btree.BTree
+	size int
+}
+
+type memItem struct {
+	key, value []byte
+}
+
+func (i memItem) Less(than btree.Item) bool {
+	return bytes.Compare(i.key, than.(memItem).key) < 0
+}
+
+func newMemtable() *memtable {
+	return &memtable{tree: btree.New(32)}
+}
+
+func (m *memtable) put(key, value []byte) {
+	m.Lock()
+	defer m.Unlock()
+	if old := m.tree.ReplaceOrInsert(memItem{key, value}); old != nil {
+		m.size -= len(old.(memItem).key) + len(old.(memItem).value)
+	}
+	m.size += len(key) + len(value)
+}
+
+func (m *memtable) get(key []byte) ([]byte, bool) {
+	m.RLock()
+	defer m.RUnlock()
+	item := m.tree.Get(memItem{key: key})
+	if item == nil {
+		return nil, false
+	}
+	return item.(memItem).value, true
+}
+
+// last returns the greatest entry with a key lower than limit.
+func (m *memtable) last(limit []byte) (key, value []byte, ok bool) {
+	m.RLock()
+	defer m.RUnlock()
+	m.tree.DescendLessOrEqual(memItem{key: limit}, func(i btree.Item) bool {
+		item := i.(memItem)
+		if bytes.Equal(item.key, limit) {
+			return true
+		}
+		key, value, ok = item.key, item.value, true
+		return false
+	})
+	return
+}
+
+func (m *memtable) bytes() int {
+	m.RLock()
+	defer m.RUnlock()
+	return m.size
+}
+
+func (m *memtable) len() int {
+	m.RLock()
+	defer m.RUnlock()
+	return m.tree.Len()
+}
+
+// iterator returns an iterator over a copy-on-write snapshot of the
+// memtable, so it does not block nor see later writes.
+func (m *memtable) iterator(start []byte) *memIterator {
+	m.Lock()
+	tree := m.tree.Clone()
+	m.Unlock()
+	it := &memIterator{tree: tree}
+	it.fill(start, true)
+	return it
+}
+
+// memIterator walks a btree in chunks.
+type memIterator struct {
+	tree  *btree.BTree
+	items []memItem
+	pos   int
+}
+
+const memIteratorChunk = 256
+
+func (it *memIterator) fill(from []byte, inclusive bool) {
+	it.items, it.pos = it.items[:0], 0
+	it.tree.AscendGreaterOrEqual(memItem{key: from}, func(i btree.Item) bool {
+		item := i.(memItem)
+		if !inclusive && bytes.Equal(item.key, from) {
+			return true
+		}
+		it.items = append(it.items, item)
+		return len(it.items) < memIteratorChunk
+	})
+}
+
+func (it *memIterator) valid() bool   { return it.pos < len(it.items) }
+func (it *memIterator) key() []byte   { return it.items[it.pos].key }
+func (it *memIterator) value() []byte { return it.items[it.pos].value }
+func (it *memIterator) err() error    { return nil }
+func (it *memIterator) close()        {}
+
+func (it *memIterator) next() {
+	it.pos++
+	if it.pos == len(it.items) && len(it.items) == memIteratorChunk {
+		last := it.items[len(it.items)-1].key
+		it.fill(last, false)
+	}
+}
@@ -0,0 +1,182 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package lsm
+
+import (
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// namespace is the leading part of all published metrics for the Storage service.
+const namespace = "qed_storage"
+
+const memtableSubsystem = "memtable" // sub-system associated with metrics for memtable.
+const ioSubsystem = "io"             // sub-system associated with metrics for I/O.
+const lsmSubsystem = "lsm"           // sub-system associated with metrics for table files.
+
+// lsmMetrics are the metrics of the LSM store. The ones with an
+// equivalent in the RocksDB store are published with the same names.
+type lsmMetrics struct {
+	memtableHits      uint64
+	memtableMisses    uint64
+	keysWritten       uint64
+	keysRead          uint64
+	bytesWritten      uint64
+	bytesRead         uint64
+	syncs             uint64
+	compactReadBytes  uint64
+	compactWriteBytes uint64
+	flushBytes        uint64
+	flushes           uint64
+	compactions       uint64
+
+	MemtableHits      prometheus.GaugeFunc
+	MemtableMisses    prometheus.GaugeFunc
+	KeysWritten       prometheus.GaugeFunc
+	KeysRead          prometheus.GaugeFunc
+	BytesRead         prometheus.GaugeFunc
+	BytesWritten      prometheus.GaugeFunc
+	WALFilesSynced    prometheus.GaugeFunc
+	WALFileBytes      prometheus.GaugeFunc
+	CompactReadBytes  prometheus.GaugeFunc
+	CompactWriteBytes prometheus.GaugeFunc
+	CompactFlushBytes prometheus.GaugeFunc
+	Flushes           prometheus.GaugeFunc
+	Compactions       prometheus.GaugeFunc
+	MemtableSize      prometheus.GaugeFunc
+	NumTables         prometheus.GaugeFunc
+	TablesSize        prometheus.GaugeFunc
+	WALSize           prometheus.GaugeFunc
+}
+
+func newLSMMetrics(store *LSMStore) *lsmMetrics {
+	m := new(lsmMetrics)
+	counter := func(v *uint64) func() float64 {
+		return func() float64 {
+			return float64(atomic.LoadUint64(v))
+		}
+	}
+	gauge := func(subsystem, name, help string, f func() float64) prometheus.GaugeFunc {
+		return prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: subsystem,
+				Name:      name,
+				Help:      help,
+			},
+			f,
+		)
+	}
+
+	m.MemtableHits = gauge(memtableSubsystem, "hit", "Number of memtable hits.", counter(&m.memtableHits))
+	m.MemtableMisses = gauge(memtableSubsystem, "miss", "Number of memtable misses.", counter(&m.memtableMisses))
+	m.KeysWritten = gauge(ioSubsystem, "keys_written", "Number of keys written via puts and writes.", counter(&m.keysWritten))
+	m.KeysRead = gauge(ioSubsystem, "keys_read", "Number of keys read.", counter(&m.keysRead))
+	m.BytesRead = gauge(ioSubsystem, "bytes_read", "Number of uncompressed bytes read.", counter(&m.bytesRead))
+	m.BytesWritten = gauge(ioSubsystem, "bytes_written", "Number of uncompressed bytes written.", counter(&m.bytesWritten))
+	m.WALFilesSynced = gauge(ioSubsystem, "wal_files_synced", "Number of times WAL sync is done.", counter(&m.syncs))
+	m.WALFileBytes = gauge(ioSubsystem, "wal_file_bytes", "Number of bytes written to WAL.", counter(&m.bytesWritten))
+	m.CompactReadBytes = gauge(ioSubsystem, "compact_read_bytes", "Number of bytes read during compaction.", counter(&m.compactReadBytes))
+	m.CompactWriteBytes = gauge(ioSubsystem, "compact_write_bytes", "Number of bytes written during compaction.", counter(&m.compactWriteBytes))
+	m.CompactFlushBytes = gauge(ioSubsystem, "compact_flush_bytes", "Number of bytes written during flush.", counter(&m.flushBytes))
+	m.Flushes = gauge(lsmSubsystem, "flushes", "Number of memtable flushes.", counter(&m.flushes))
+	m.Compactions = gauge(lsmSubsystem, "compactions", "Number of compactions.", counter(&m.compactions))
+	m.MemtableSize = gauge(lsmSubsystem, "cur_size_active_memtable", "Approximate size of active memtable (bytes).",
+		func() float64 {
+			store.mu.RLock()
+			defer store.mu.RUnlock()
+			return float64(store.mem.bytes())
+		},
+	)
+	m.NumTables = gauge(lsmSubsystem, "num_tables", "Number of table files.",
+		func() float64 {
+			store.mu.RLock()
+			defer store.mu.RUnlock()
+			return float64(len(store.tables))
+		},
+	)
+	m.TablesSize = gauge(lsmSubsystem, "total_sst_files_size", "Total size (bytes) of all table files.",
+		func() float64 {
+			store.mu.RLock()
+			defer store.mu.RUnlock()
+			var size int64
+			for _, t := range store.tables {
+				size += t.size
+			}
+			return float64(size)
+		},
+	)
+	m.WALSize = gauge(lsmSubsystem, "wal_size", "Total size (bytes) of the WAL, including archived segments.",
+		func() float64 {
+			return float64(store.wal.bytes())
+		},
+	)
+	return m
+}
+
+func (m *lsmMetrics) write(keys, bytes int, synced bool) {
+	atomic.AddUint64(&m.keysWritten, uint64(keys))
+	atomic.AddUint64(&m.bytesWritten, uint64(bytes))
+	if synced {
+		atomic.AddUint64(&m.syncs, 1)
+	}
+}
+
+func (m *lsmMetrics) read(memtableHit bool, bytes int) {
+	if memtableHit {
+		atomic.AddUint64(&m.memtableHits, 1)
+	} else {
+		atomic.AddUint64(&m.memtableMisses, 1)
+	}
+	atomic.AddUint64(&m.keysRead, 1)
+	atomic.AddUint64(&m.bytesRead, uint64(bytes))
+}
+
+func (m *lsmMetrics) flushed(bytes int64) {
+	atomic.AddUint64(&m.flushes, 1)
+	atomic.AddUint64(&m.flushBytes, uint64(bytes))
+}
+
+func (m *lsmMetrics) compacted(read, written int64) {
+	atomic.AddUint64(&m.compactions, 1)
+	atomic.AddUint64(&m.compactReadBytes, uint64(read))
+	atomic.AddUint64(&m.compactWriteBytes, uint64(written))
+}
+
+// collectors satisfies the prom.PrometheusCollector interface.
+func (m *lsmMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.MemtableHits,
+		m.MemtableMisses,
+		m.KeysWritten,
+		m.KeysRead,
+		m.BytesRead,
+		m.BytesWritten,
+		m.WALFilesSynced,
+		m.WALFileBytes,
+		m.CompactReadBytes,
+		m.CompactWriteBytes,
+		m.CompactFlushBytes,
+		m.Flushes,
+		m.Compactions,
+		m.MemtableSize,
+		m.NumTables,
+		m.TablesSize,
+		m.WALSize,
+	}
+}
@@ -0,0 +1,136 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package lsm
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/bbva/qed/storage"
+)
+
+var errStopReading = errors.New("stop reading")
+
+// FetchSnapshot fetches all WAL transactions from the first available
+// seq_num to the last one specified in the lastSeqNum parameter, and dumps
+// them to the given writer.
+func (s *LSMStore) FetchSnapshot(w io.WriteCloser, since, until uint64, valid storage.ValidateF) error {
+	defer w.Close()
+
+	segments, err := s.wal.acquire()
+	if err != nil {
+		return err
+	}
+	defer s.wal.release()
+
+	if since < s.LastWALSequenceNumber() {
+		first, err := firstSeq(segments)
+		if err != nil {
+			return err
+		}
+		if first == 0 || first > since+1 {
+			return ErrWALPurged
+		}
+	}
+
+	for i, seg := range segments {
+		_, err := readSegment(seg.path, func(data []byte) error {
+			seqNum := binary.LittleEndian.Uint64(data)
+			if seqNum <= since {
+				return nil
+			}
+			if seqNum > until {
+				return errStopReading
+			}
+
+			b, err := storage.DecodeWriteBatch(data)
+			if err != nil {
+				return err
+			}
+			ok, err := valid(b.Metadata)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return nil
+			}
+
+			return storage.WriteChunk(w, data)
+		})
+		if err == errStopReading {
+			return nil
+		}
+		// the last segment may have a write in progress
+		if err == io.ErrUnexpectedEOF && i == len(segments)-1 {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// firstSeq returns the sequence number of the first write batch
+// available in the WAL, or zero if it is empty.
+func firstSeq(segments []walSegment) (uint64, error) {
+	for _, seg := range segments {
+		var seq uint64
+		_, err := readSegment(seg.path, func(data []byte) error {
+			seq = binary.LittleEndian.Uint64(data)
+			return errStopReading
+		})
+		if err != nil && err != errStopReading && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		if seq > 0 {
+			return seq, nil
+		}
+	}
+	return 0, nil
+}
+
+// LoadSnapshot reads a list of serialized batches from a reader,
+// rehydrates them and write to the database.
+// Batches are written as they are read, so if the reader fails halfway,
+// the batches loaded so far are kept and LastWALSequenceNumber tells
+// where to resume the transfer from.
+func (s *LSMStore) LoadSnapshot(r io.ReadCloser) error {
+	defer r.Close()
+
+	for {
+		buff, err := storage.ReadChunk(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		batch, err := storage.DecodeWriteBatch(buff)
+		if err != nil {
+			return err
+		}
+		if err := s.write(batch); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,515 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package lsm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"sort"
+	"sync/atomic"
+)
+
+// Table files are immutable sorted runs of key-value pairs:
+//
+//	[data block]...[index][filter][footer]
+//
+// Each data block is a sequence of length prefixed key-value pairs
+// followed by its CRC32-C. The index holds the first key, offset and
+// length of every block plus the last key of the file. The footer
+// locates the index and the bloom filter.
+const (
+	blockSize       = 16 * 1024
+	footerSize      = 8 + 8 + 8 + 8 + 4 + 8
+	tableMagic      = 0x716564736c736d31 // "qedslsm1"
+	bloomBitsPerKey = 10
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrCorruptedTable is returned when a table file fails its checksums.
+var ErrCorruptedTable = errors.New("corrupted table file")
+
+type blockHandle struct {
+	firstKey []byte
+	offset   uint64
+	length   uint64
+}
+
+// tableWriter builds a table file from keys added in order.
+type tableWriter struct {
+	file    *os.File
+	w       *bufio.Writer
+	offset  uint64
+	block   []byte
+	first   []byte
+	last    []byte
+	index   []blockHandle
+	hashes  []uint32
+	entries uint64
+}
+
+func newTableWriter(path string) (*tableWriter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &tableWriter{
+		file: file,
+		w:    bufio.NewWriterSize(file, 4*blockSize),
+	}, nil
+}
+
+func (t *tableWriter) add(key, value []byte) error {
+	if len(t.block) == 0 {
+		t.first = append(t.first[:0], key...)
+	}
+	t.block = appendVarString(t.block, key)
+	t.block = appendVarString(t.block, value)
+	t.last = append(t.last[:0], key...)
+	t.hashes = append(t.hashes, bloomHash(key))
+	t.entries++
+	if len(t.block) >= blockSize {
+		return t.flushBlock()
+	}
+	return nil
+}
+
+func (t *tableWriter) flushBlock() error {
+	if len(t.block) == 0 {
+		return nil
+	}
+	t.index = append(t.index, blockHandle{
+		firstKey: append([]byte(nil), t.first...),
+		offset:   t.offset,
+		length:   uint64(len(t.block)),
+	})
+	t.block = appendUint32(t.block, crc32.Checksum(t.block, crcTable))
+	if _, err := t.w.Write(t.block); err != nil {
+		return err
+	}
+	t.offset += uint64(len(t.block))
+	t.block = t.block[:0]
+	return nil
+}
+
+// finish writes the index, filter and footer and syncs the file.
+func (t *tableWriter) finish() error {
+	if err := t.flushBlock(); err != nil {
+		t.abort()
+		return err
+	}
+
+	index := appendUvarint(nil, uint64(len(t.index)))
+	for _, h := range t.index {
+		index = appendVarString(index, h.firstKey)
+		index = appendUvarint(index, h.offset)
+		index = appendUvarint(index, h.length)
+	}
+	index = appendVarString(index, t.last)
+	filter := newBloomFilter(t.hashes, bloomBitsPerKey)
+
+	footer := make([]byte, 0, footerSize)
+	footer = appendUint64(footer, t.offset)
+	footer = appendUint64(footer, uint64(len(index)))
+	footer = appendUint64(footer, uint64(len(filter)))
+	footer = appendUint64(footer, t.entries)
+	crc := crc32.Update(crc32.Checksum(index, crcTable), crcTable, filter)
+	footer = appendUint32(footer, crc)
+	footer = appendUint64(footer, tableMagic)
+
+	for _, b := range [][]byte{index, filter, footer} {
+		if _, err := t.w.Write(b); err != nil {
+			t.abort()
+			return err
+		}
+	}
+	if err := t.w.Flush(); err != nil {
+		t.abort()
+		return err
+	}
+	if err := t.file.Sync(); err != nil {
+		t.abort()
+		return err
+	}
+	return t.file.Close()
+}
+
+func (t *tableWriter) abort() {
+	t.file.Close()
+	os.Remove(t.file.Name())
+}
+
+// table is an open table file. Tables are reference counted so that
+// files replaced by a compaction are only removed once every reader
+// using them is done.
+type table struct {
+	num      uint64
+	path     string
+	file     *os.File
+	size     int64
+	entries  uint64
+	index    []blockHandle
+	lastKey  []byte
+	filter   bloomFilter
+	refs     int32
+	obsolete int32
+}
+
+func openTable(path string, num uint64) (*table, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	t, err := readTable(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	t.num, t.path, t.refs = num, path, 1
+	return t, nil
+}
+
+func readTable(file *os.File) (*table, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if size < footerSize {
+		return nil, ErrCorruptedTable
+	}
+	footer := make([]byte, footerSize)
+	if _, err := file.ReadAt(footer, size-footerSize); err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint64(footer[36:]) != tableMagic {
+		return nil, ErrCorruptedTable
+	}
+	indexOffset := binary.BigEndian.Uint64(footer)
+	indexLen := binary.BigEndian.Uint64(footer[8:])
+	filterLen := binary.BigEndian.Uint64(footer[16:])
+	if indexOffset+indexLen+filterLen+footerSize != uint64(size) {
+		return nil, ErrCorruptedTable
+	}
+	meta := make([]byte, indexLen+filterLen)
+	if _, err := file.ReadAt(meta, int64(indexOffset)); err != nil {
+		return nil, err
+	}
+	if crc32.Checksum(meta, crcTable) != binary.BigEndian.Uint32(footer[32:]) {
+		return nil, ErrCorruptedTable
+	}
+
+	t := &table{
+		file:    file,
+		size:    size,
+		entries: binary.BigEndian.Uint64(footer[24:]),
+		filter:  bloomFilter(meta[indexLen:]),
+	}
+	rest := meta[:indexLen]
+	count, n := binary.Uvarint(rest)
+	if n <= 0 {
+		return nil, ErrCorruptedTable
+	}
+	rest = rest[n:]
+	t.index = make([]blockHandle, count)
+	for i := range t.index {
+		h := &t.index[i]
+		if h.firstKey, rest = readVarString(rest); rest == nil {
+			return nil, ErrCorruptedTable
+		}
+		if h.offset, n = binary.Uvarint(rest); n <= 0 {
+			return nil, ErrCorruptedTable
+		}
+		rest = rest[n:]
+		if h.length, n = binary.Uvarint(rest); n <= 0 {
+			return nil, ErrCorruptedTable
+		}
+		rest = rest[n:]
+	}
+	if t.lastKey, rest = readVarString(rest); rest == nil {
+		return nil, ErrCorruptedTable
+	}
+	return t, nil
+}
+
+func (t *table) ref() {
+	atomic.AddInt32(&t.refs, 1)
+}
+
+func (t *table) unref() {
+	if atomic.AddInt32(&t.refs, -1) == 0 {
+		t.file.Close()
+		if atomic.LoadInt32(&t.obsolete) == 1 {
+			os.Remove(t.path)
+		}
+	}
+}
+
+// release drops the reference held by the store and removes the file
+// once no reader uses it anymore.
+func (t *table) release() {
+	atomic.StoreInt32(&t.obsolete, 1)
+	t.unref()
+}
+
+func (t *table) readBlock(i int) ([]byte, error) {
+	h := t.index[i]
+	buf := make([]byte, h.length+4)
+	if _, err := t.file.ReadAt(buf, int64(h.offset)); err != nil {
+		return nil, err
+	}
+	data := buf[:h.length]
+	if crc32.Checksum(data, crcTable) != binary.BigEndian.Uint32(buf[h.length:]) {
+		return nil, ErrCorruptedTable
+	}
+	return data, nil
+}
+
+// findBlock returns the index of the last block whose first key is
+// lower than or equal to key, or -1.
+func (t *table) findBlock(key []byte) int {
+	return sort.Search(len(t.index), func(i int) bool {
+		return bytes.Compare(t.index[i].firstKey, key) > 0
+	}) - 1
+}
+
+func (t *table) get(key []byte) ([]byte, bool, error) {
+	if len(t.index) == 0 || bytes.Compare(key, t.lastKey) > 0 || !t.filter.mayContain(key) {
+		return nil, false, nil
+	}
+	i := t.findBlock(key)
+	if i < 0 {
+		return nil, false, nil
+	}
+	block, err := t.readBlock(i)
+	if err != nil {
+		return nil, false, err
+	}
+	for len(block) > 0 {
+		var k, v []byte
+		if k, v, block, err = readEntry(block); err != nil {
+			return nil, false, err
+		}
+		switch bytes.Compare(k, key) {
+		case 0:
+			return v, true, nil
+		case 1:
+			return nil, false, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// last returns the greatest entry with a key lower than limit.
+func (t *table) last(limit []byte) (key, value []byte, ok bool, err error) {
+	i := t.findBlock(limit)
+	if i >= 0 && bytes.Equal(t.index[i].firstKey, limit) {
+		i--
+	}
+	if i < 0 {
+		return nil, nil, false, nil
+	}
+	block, err := t.readBlock(i)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	for len(block) > 0 {
+		var k, v []byte
+		if k, v, block, err = readEntry(block); err != nil {
+			return nil, nil, false, err
+		}
+		if bytes.Compare(k, limit) >= 0 {
+			break
+		}
+		key, value, ok = k, v, true
+	}
+	return key, value, ok, nil
+}
+
+func (t *table) iterator(start []byte) *tableIterator {
+	t.ref()
+	it := &tableIterator{t: t, blockIdx: t.findBlock(start)}
+	if it.blockIdx < 0 {
+		it.blockIdx = 0
+	}
+	it.loadBlock()
+	for it.valid() && bytes.Compare(it.k, start) < 0 {
+		it.next()
+	}
+	return it
+}
+
+func readEntry(block []byte) (key, value, rest []byte, err error) {
+	if key, rest = readVarString(block); rest == nil {
+		return nil, nil, nil, ErrCorruptedTable
+	}
+	if value, rest = readVarString(rest); rest == nil {
+		return nil, nil, nil, ErrCorruptedTable
+	}
+	return key, value, rest, nil
+}
+
+// tableIterator walks a table file block by block.
+type tableIterator struct {
+	t        *table
+	blockIdx int
+	block    []byte
+	k, v     []byte
+	ok       bool
+	e        error
+}
+
+func (it *tableIterator) loadBlock() {
+	it.ok = false
+	for it.blockIdx < len(it.t.index) {
+		block, err := it.t.readBlock(it.blockIdx)
+		if err != nil {
+			it.e = err
+			return
+		}
+		it.block = block
+		if len(it.block) > 0 {
+			it.next()
+			return
+		}
+		it.blockIdx++
+	}
+}
+
+func (it *tableIterator) next() {
+	if len(it.block) == 0 {
+		it.blockIdx++
+		it.loadBlock()
+		return
+	}
+	var err error
+	if it.k, it.v, it.block, err = readEntry(it.block); err != nil {
+		it.e, it.ok = err, false
+		return
+	}
+	it.ok = true
+}
+
+func (it *tableIterator) valid() bool   { return it.ok && it.e == nil }
+func (it *tableIterator) key() []byte   { return it.k }
+func (it *tableIterator) value() []byte { return it.v }
+func (it *tableIterator) err() error    { return it.e }
+
+func (it *tableIterator) close() {
+	if it.t != nil {
+		it.t.unref()
+		it.t = nil
+	}
+}
+
+// bloomFilter is a bloom filter over the keys of a table, built with
+// the double hashing scheme used by LevelDB. The last byte holds the
+// number of probes.
+type bloomFilter []byte
+
+func newBloomFilter(hashes []uint32, bitsPerKey int) bloomFilter {
+	k := uint8(float64(bitsPerKey) * 0.69) // ln(2)
+	if k < 1 {
+		k = 1
+	}
+	if k > 30 {
+		k = 30
+	}
+	nBits := len(hashes) * bitsPerKey
+	if nBits < 64 {
+		nBits = 64
+	}
+	nBytes := (nBits + 7) / 8
+	nBits = nBytes * 8
+	filter := make([]byte, nBytes+1)
+	for _, h := range hashes {
+		delta := h>>17 | h<<15
+		for j := uint8(0); j < k; j++ {
+			pos := h % uint32(nBits)
+			filter[pos/8] |= 1 << (pos % 8)
+			h += delta
+		}
+	}
+	filter[nBytes] = k
+	return filter
+}
+
+func (f bloomFilter) mayContain(key []byte) bool {
+	if len(f) < 2 {
+		return true
+	}
+	nBits := uint32(len(f)-1) * 8
+	k := f[len(f)-1]
+	h := bloomHash(key)
+	delta := h>>17 | h<<15
+	for j := uint8(0); j < k; j++ {
+		pos := h % nBits
+		if f[pos/8]&(1<<(pos%8)) == 0 {
+			return false
+		}
+		h += delta
+	}
+	return true
+}
+
+// bloomHash is the 32 bits FNV-1a hash of the key.
+func bloomHash(key []byte) uint32 {
+	h := uint32(2166136261)
+	for _, c := range key {
+		h ^= uint32(c)
+		h *= 16777619
+	}
+	return h
+}
+
+func appendUint32(buf []byte, v uint32) []byte {
+	var tmp [4]byte
+	binary.BigEndian.PutUint32(tmp[:], v)
+	return append(buf, tmp[:]...)
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	var tmp [8]byte
+	binary.BigEndian.PutUint64(tmp[:], v)
+	return append(buf, tmp[:]...)
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+func appendVarString(buf, s []byte) []byte {
+	buf = appendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// readVarString reads a length prefixed string. The returned rest
+// is nil if the input is truncated.
+func readVarString(buf []byte) (s, rest []byte) {
+	size, n := binary.Uvarint(buf)
+	if n <= 0 || uint64(len(buf)-n) < size {
+		return nil, nil
+	}
+	end := n + int(size)
+	return buf[n:end:end], buf[end:]
+}
@@ -0,0 +1,303 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package lsm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bbva/qed/storage"
+)
+
+// The write-ahead log is a sequence of segment files named after their
+// file number. Each record holds an encoded write batch:
+//
+//	[crc32c uint32][length uint32][batch]
+//
+// A new segment is started every time the memtable is flushed. Old
+// segments are archived, as RocksDB does, so that FetchSnapshot can
+// ship write batches to other replicas. They are purged after a TTL or
+// once they exceed a size limit.
+const (
+	walDir          = "wal"
+	walSuffix       = ".log"
+	walRecordHeader = 8
+)
+
+var (
+	// ErrCorruptedWAL is returned when a WAL record fails its checksum.
+	ErrCorruptedWAL = errors.New("corrupted WAL record")
+	// ErrWALPurged is returned when the write batches requested to
+	// FetchSnapshot are no longer available in the WAL.
+	ErrWALPurged = storage.ErrWALPurged
+)
+
+type wal struct {
+	sync.Mutex
+	dir       string
+	noSync    bool
+	file      *os.File
+	num       uint64
+	size      int64
+	ttl       time.Duration
+	sizeLimit int64
+	readOnly  bool
+
+	// number of FetchSnapshot calls reading the archived segments
+	readers int
+}
+
+type walSegment struct {
+	num  uint64
+	path string
+	size int64
+	mod  time.Time
+}
+
+// openWAL opens the WAL in dir. A read-only WAL only replays and
+// fetches the records of the existing segments, and a missing dir is
+// read as an empty WAL.
+func openWAL(dir string, ttl time.Duration, sizeLimit int64, noSync, readOnly bool) (*wal, error) {
+	if !readOnly {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &wal{
+		dir:       dir,
+		noSync:    noSync,
+		ttl:       ttl,
+		sizeLimit: sizeLimit,
+		readOnly:  readOnly,
+	}, nil
+}
+
+func walSegmentName(num uint64) string {
+	return fmt.Sprintf("%020d%s", num, walSuffix)
+}
+
+// segments lists the segment files in order.
+func (w *wal) segments() ([]walSegment, error) {
+	files, err := ioutil.ReadDir(w.dir)
+	if os.IsNotExist(err) && w.readOnly {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	segments := make([]walSegment, 0, len(files))
+	for _, f := range files {
+		name := f.Name()
+		num, ok := parseNum(name, walSuffix)
+		if !ok || !f.Mode().IsRegular() {
+			continue
+		}
+		segments = append(segments, walSegment{
+			num:  num,
+			path: filepath.Join(w.dir, name),
+			size: f.Size(),
+			mod:  f.ModTime(),
+		})
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].num < segments[j].num })
+	return segments, nil
+}
+
+// replay calls fn for every record in the WAL. A torn record at the end
+// of the last segment, left by a crash in the middle of a write, is
+// truncated, or just skipped by a read-only WAL.
+func (w *wal) replay(fn func(data []byte) error) error {
+	segments, err := w.segments()
+	if err != nil {
+		return err
+	}
+	for i, s := range segments {
+		valid, err := readSegment(s.path, fn)
+		if err == io.ErrUnexpectedEOF || err == ErrCorruptedWAL {
+			if i != len(segments)-1 {
+				return fmt.Errorf("%s: %v", s.path, err)
+			}
+			if w.readOnly {
+				continue
+			}
+			if err := os.Truncate(s.path, valid); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSegment calls fn for every record of a segment and returns
+// the size of the records read.
+func readSegment(path string, fn func(data []byte) error) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	var offset int64
+	header := make([]byte, walRecordHeader)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return offset, nil
+			}
+			return offset, io.ErrUnexpectedEOF
+		}
+		data := make([]byte, binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			return offset, io.ErrUnexpectedEOF
+		}
+		if crc32.Checksum(data, crcTable) != binary.BigEndian.Uint32(header) {
+			return offset, ErrCorruptedWAL
+		}
+		if err := fn(data); err != nil {
+			return offset, err
+		}
+		offset += int64(walRecordHeader + len(data))
+	}
+}
+
+// roll starts a new segment.
+func (w *wal) roll(num uint64) error {
+	w.Lock()
+	defer w.Unlock()
+	file, err := os.OpenFile(filepath.Join(w.dir, walSegmentName(num)), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	w.file, w.num, w.size = file, num, 0
+	return nil
+}
+
+func (w *wal) append(data []byte) error {
+	w.Lock()
+	defer w.Unlock()
+	record := make([]byte, walRecordHeader, walRecordHeader+len(data))
+	binary.BigEndian.PutUint32(record, crc32.Checksum(data, crcTable))
+	binary.BigEndian.PutUint32(record[4:], uint32(len(data)))
+	record = append(record, data...)
+	if _, err := w.file.Write(record); err != nil {
+		return err
+	}
+	w.size += int64(len(record))
+	if w.noSync {
+		return nil
+	}
+	return w.file.Sync()
+}
+
+// purge removes the archived segments older than the TTL or beyond the
+// size limit. Segments are kept while they are being read. It must only
+// be called right after a flush, when every segment but the current one
+// is already persisted in table files.
+func (w *wal) purge() error {
+	w.Lock()
+	defer w.Unlock()
+	if w.readers > 0 {
+		return nil
+	}
+	segments, err := w.segments()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, s := range segments {
+		total += s.size
+	}
+	for _, s := range segments {
+		if s.num >= w.num {
+			break
+		}
+		expired := w.ttl > 0 && time.Since(s.mod) > w.ttl
+		if !expired && total <= w.sizeLimit {
+			break
+		}
+		if err := os.Remove(s.path); err != nil {
+			return err
+		}
+		total -= s.size
+	}
+	return nil
+}
+
+// acquire prevents segments from being purged while they are read.
+func (w *wal) acquire() ([]walSegment, error) {
+	w.Lock()
+	defer w.Unlock()
+	segments, err := w.segments()
+	if err != nil {
+		return nil, err
+	}
+	w.readers++
+	return segments, nil
+}
+
+func (w *wal) release() {
+	w.Lock()
+	defer w.Unlock()
+	w.readers--
+}
+
+// bytes returns the size of the WAL, including archived segments.
+func (w *wal) bytes() int64 {
+	w.Lock()
+	defer w.Unlock()
+	segments, err := w.segments()
+	if err != nil {
+		return 0
+	}
+	var total int64
+	for _, s := range segments {
+		total += s.size
+	}
+	return total
+}
+
+func (w *wal) close() error {
+	w.Lock()
+	defer w.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
@@ -31,8 +31,9 @@ import (
 )
 
 type RocksDBStore struct {
-	path string
-	db   *rocksdb.DB
+	path     string
+	db       *rocksdb.DB
+	readOnly bool
 
 	stats *rocksdb.Statistics
 
@@ -66,6 +67,10 @@ type Options struct {
 	MaxTotalWalSize  uint64
 	WALSizeLimitMB   uint64
 	WALTtlSeconds    uint64
+	// ReadOnly opens the database with OpenDBForReadOnly, so several
+	// processes can share it. There is no backup engine and every
+	// write fails with storage.ErrReadOnly.
+	ReadOnly bool
 }
 
 func DefaultOptions() *Options {
@@ -126,6 +131,28 @@ func NewRocksDBStoreWithOpts(opts *Options) (*RocksDBStore, error) {
 		getFsmStateTableOpts(),
 	}
 
+	if opts.ReadOnly {
+		db, cfHandles, err := rocksdb.OpenDBForReadOnlyColumnFamilies(opts.Path, globalOpts, cfNames, cfOpts, false)
+		if err != nil {
+			return nil, err
+		}
+		store := &RocksDBStore{
+			path:       opts.Path,
+			db:         db,
+			readOnly:   true,
+			stats:      stats,
+			cfHandles:  cfHandles,
+			blockCache: blockCache,
+			globalOpts: globalOpts,
+			cfOpts:     cfOpts,
+			ro:         rocksdb.NewDefaultReadOptions(),
+		}
+		if stats != nil {
+			store.metrics = newRocksDBMetrics(store)
+		}
+		return store, nil
+	}
+
 	db, cfHandles, err := rocksdb.OpenDBColumnFamilies(opts.Path, globalOpts, cfNames, cfOpts)
 	if err != nil {
 		return nil, err
@@ -338,6 +365,9 @@ func getFsmStateTableOpts() *rocksdb.Options {
 }
 
 func (s *RocksDBStore) Mutate(mutations []*storage.Mutation, metadata []byte) error {
+	if s.readOnly {
+		return storage.ErrReadOnly
+	}
 	batch := rocksdb.NewWriteBatch()
 	defer batch.Destroy()
 	// IMPORTANT: This line must go before the PutCF. For some reason, if we set it after,
@@ -458,6 +488,9 @@ func (s *RocksDBStore) Close() error {
 // Backup uses the backupEngine to create backups with metadata. The backup directory has been
 // set up previously.
 func (s *RocksDBStore) Backup(metadata string) error {
+	if s.readOnly {
+		return storage.ErrReadOnly
+	}
 	err := s.backupEngine.CreateNewBackupWithMetadata(s.db, metadata)
 	if err != nil {
 		return err
@@ -469,6 +502,9 @@ func (s *RocksDBStore) Backup(metadata string) error {
 // it to the given paths.This can be used to restore the database from a backup
 // made by calling DB.Backup().
 func (s *RocksDBStore) RestoreFromLatestBackup(dbDir, walDir string) error {
+	if s.readOnly {
+		return storage.ErrReadOnly
+	}
 	err := s.backupEngine.RestoreDBFromLatestBackup(dbDir, walDir, s.restoreOpts)
 	if err != nil {
 		return err
@@ -480,6 +516,9 @@ func (s *RocksDBStore) RestoreFromLatestBackup(dbDir, walDir string) error {
 // it to the given paths. This can be used to restore the database from a backup
 // made by calling DB.Backup().
 func (s *RocksDBStore) RestoreFromBackup(backupID uint32, dbDir, walDir string) error {
+	if s.readOnly {
+		return storage.ErrReadOnly
+	}
 	err := s.backupEngine.RestoreDBFromBackup(backupID, dbDir, walDir, s.restoreOpts)
 	if err != nil {
 		return err
@@ -490,6 +529,9 @@ func (s *RocksDBStore) RestoreFromBackup(backupID uint32, dbDir, walDir string)
 // GetBackupsInfo function extract a list of backups from a backup engine, and iterate over them
 // to parse its information.
 func (s *RocksDBStore) GetBackupsInfo() []*storage.BackupInfo {
+	if s.readOnly {
+		return nil
+	}
 	bi := s.backupEngine.GetInfo()
 	defer bi.Destroy()
 	if bi == nil {
@@ -512,6 +554,9 @@ func (s *RocksDBStore) GetBackupsInfo() []*storage.BackupInfo {
 
 // DeleteBackup uses the backupEngine to delete the backup identified by backupID.
 func (s *RocksDBStore) DeleteBackup(backupID uint32) error {
+	if s.readOnly {
+		return storage.ErrReadOnly
+	}
 	err := s.backupEngine.DeleteBackup(backupID)
 	if err != nil {
 		return err
@@ -519,6 +564,21 @@ func (s *RocksDBStore) DeleteBackup(backupID uint32) error {
 	return nil
 }
 
+// Checkpoint creates an openable snapshot of the database in dir, which
+// must not exist. Its SST files are hard linked when dir is on the same
+// filesystem as the database.
+func (s *RocksDBStore) Checkpoint(dir string) error {
+	if s.readOnly {
+		return storage.ErrReadOnly
+	}
+	checkpoint, err := s.db.NewCheckpoint()
+	if err != nil {
+		return err
+	}
+	defer checkpoint.Destroy()
+	return checkpoint.CreateCheckpoint(dir, 0)
+}
+
 // FetchSnapshot fetches all WAL transactions from the first available
 // seq_num to the last one specified in the lastSeqNum parameter, and dumps
 // them to the given writer.
@@ -538,9 +598,17 @@ func (s *RocksDBStore) FetchSnapshot(w io.WriteCloser, since, until uint64, vali
 		w.Close()
 	}()
 
+	// the iterator silently starts at the first available seq_num when
+	// the requested ones have been purged, so the first batch must hold
+	// or follow since
+	first := true
 	for ; it.Valid(); it.Next() {
 		batch, seqNum := it.GetBatch()
 		defer batch.Destroy()
+		if first && seqNum > since+1 {
+			return storage.ErrWALPurged
+		}
+		first = false
 		if seqNum <= since {
 			continue
 		}
@@ -564,6 +632,9 @@ func (s *RocksDBStore) FetchSnapshot(w io.WriteCloser, since, until uint64, vali
 			return err
 		}
 	}
+	if first && since < s.LastWALSequenceNumber() {
+		return storage.ErrWALPurged
+	}
 
 	return nil
 }
@@ -571,20 +642,18 @@ func (s *RocksDBStore) FetchSnapshot(w io.WriteCloser, since, until uint64, vali
 func readChunk(r io.Reader) ([]byte, error) {
 
 	sizeBuff := make([]byte, 8)
-	n, err := r.Read(sizeBuff)
-	if err != nil || n != 8 {
+	if _, err := io.ReadFull(r, sizeBuff); err != nil {
 		return nil, err
 	}
 
 	size := util.BytesAsUint64(sizeBuff)
 	chunk := make([]byte, size)
-	n, err = r.Read(chunk)
-	if err != nil {
+	if _, err := io.ReadFull(r, chunk); err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("Corrupted chunk")
+		}
 		return nil, err
 	}
-	if uint64(n) != size {
-		return nil, fmt.Errorf("Corrupted chunk")
-	}
 	return chunk, nil
 
 }
@@ -592,10 +661,16 @@ func readChunk(r io.Reader) ([]byte, error) {
 // LoadSnapshot reads a list of serialized batches from a reader,
 // rehydrates them and write to the database if they fulfill the validation
 // condition specified as parameter.
+// Batches are written as they are read, so if the reader fails halfway,
+// the batches loaded so far are kept and LastWALSequenceNumber tells
+// where to resume the transfer from.
 // This method should be called on a database that is not running
 // any other concurrent transactions while it is running.
 func (s *RocksDBStore) LoadSnapshot(r io.ReadCloser) error {
 	defer r.Close()
+	if s.readOnly {
+		return storage.ErrReadOnly
+	}
 	wo := rocksdb.NewDefaultWriteOptions()
 
 	for {
@@ -612,7 +687,7 @@ func (s *RocksDBStore) LoadSnapshot(r io.ReadCloser) error {
 
 		err = s.db.Write(wo, batch)
 		if err != nil {
-			return nil
+			return err
 		}
 	}
 
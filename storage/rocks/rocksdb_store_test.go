@@ -191,6 +191,31 @@ func TestGetLast(t *testing.T) {
 	require.Equalf(t, util.Uint64AsBytes(numElems-1), kv.Value, "The value should match the last inserted element")
 }
 
+func TestCheckpoint(t *testing.T) {
+	store, closeF := openRocksDBStore(t)
+	defer closeF()
+
+	numElems := uint64(20)
+	for i := uint64(0); i < 2*numElems; i++ {
+		if i == numElems {
+			checkpointPath := filepath.Join(filepath.Dir(store.path), "checkpoint")
+			require.NoError(t, store.Checkpoint(checkpointPath))
+			require.Error(t, store.Checkpoint(checkpointPath), "The checkpoint directory must not exist")
+		}
+		key := util.Uint64AsBytes(i)
+		require.NoError(t, store.Mutate([]*storage.Mutation{
+			{storage.HistoryTable, key, key},
+		}, nil))
+	}
+
+	checkpoint, err := NewRocksDBStore(filepath.Join(filepath.Dir(store.path), "checkpoint"), 0)
+	require.NoError(t, err)
+	defer checkpoint.Close()
+	kv, err := checkpoint.GetLast(storage.HistoryTable)
+	require.NoError(t, err)
+	require.Equalf(t, util.Uint64AsBytes(numElems-1), kv.Key, "The checkpoint should not have later writes")
+}
+
 func TestFetchAndLoadSnapshot(t *testing.T) {
 	store, closeF := openRocksDBStore(t)
 	defer closeF()
@@ -0,0 +1,114 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package rocks
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/bbva/qed/rocksdb"
+)
+
+// ErrDatabaseExists is returned when seeding a directory that already
+// holds a database.
+var ErrDatabaseExists = errors.New("database already exists")
+
+// SeedFromBackup restores a backup from the backup engine at backupPath
+// into dbDir, which must not hold a database yet. A zero backupID restores
+// the latest backup. Restored databases keep the WAL sequence numbers of
+// the original one, so a node seeded this way only needs to fetch the
+// missing WAL range from the leader.
+func SeedFromBackup(backupPath string, backupID uint32, dbDir string) error {
+	if hasDatabase(dbDir) {
+		return ErrDatabaseExists
+	}
+
+	opts := rocksdb.NewDefaultOptions()
+	defer opts.Destroy()
+	be, err := rocksdb.OpenBackupEngine(opts, backupPath)
+	if err != nil {
+		return err
+	}
+	defer be.Close()
+
+	ro := rocksdb.NewRestoreOptions()
+	defer ro.Destroy()
+	if backupID == 0 {
+		return be.RestoreDBFromLatestBackup(dbDir, dbDir, ro)
+	}
+	return be.RestoreDBFromBackup(backupID, dbDir, dbDir, ro)
+}
+
+// SeedFromCheckpoint copies the checkpoint at checkpointPath into dbDir,
+// which must not hold a database yet.
+func SeedFromCheckpoint(checkpointPath, dbDir string) error {
+	if hasDatabase(dbDir) {
+		return ErrDatabaseExists
+	}
+	if !hasDatabase(checkpointPath) {
+		return errors.New("not a checkpoint directory: " + checkpointPath)
+	}
+
+	if err := os.MkdirAll(dbDir, 0755); err != nil {
+		return err
+	}
+	files, err := ioutil.ReadDir(checkpointPath)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		err := copyFile(filepath.Join(checkpointPath, f.Name()), filepath.Join(dbDir, f.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasDatabase tells whether dir holds a RocksDB database.
+func hasDatabase(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, "CURRENT"))
+	return err == nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,113 @@
+/*
+   Copyright 2018-2019 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package rocks
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeedFromBackup(t *testing.T) {
+	store, closeF := openRocksDBStore(t)
+	defer closeF()
+
+	numElems := uint64(100)
+	mutateElems(t, store, 0, numElems)
+	require.NoError(t, store.Backup("seed"))
+	// mutations after the backup must be fetched from the leader
+	mutateElems(t, store, numElems, 2*numElems)
+
+	path := mustTempDir()
+	defer deleteFile(path)
+	dbDir := filepath.Join(path, "seeded.db")
+
+	require.NoError(t, SeedFromBackup(filepath.Join(store.path, "backups"), 0, dbDir))
+	require.Equal(t, ErrDatabaseExists, SeedFromBackup(filepath.Join(store.path, "backups"), 0, dbDir))
+
+	seeded, err := NewRocksDBStore(dbDir, 0)
+	require.NoError(t, err)
+	defer seeded.Close()
+
+	require.Equal(t, numElems, seeded.LastWALSequenceNumber())
+	for i := uint64(0); i < numElems; i++ {
+		kv, err := seeded.Get(storage.HistoryTable, util.Uint64AsBytes(i))
+		require.NoError(t, err)
+		require.Equal(t, util.Uint64AsBytes(i), kv.Value)
+	}
+
+	// catch up the missing WAL range
+	ioBuf := new(bufCloser)
+	require.NoError(t, store.FetchSnapshot(ioBuf, seeded.LastWALSequenceNumber(), store.LastWALSequenceNumber(), func([]byte) (bool, error) {
+		return true, nil
+	}))
+	require.NoError(t, seeded.LoadSnapshot(ioBuf))
+	require.Equal(t, store.LastWALSequenceNumber(), seeded.LastWALSequenceNumber())
+	for i := numElems; i < 2*numElems; i++ {
+		_, err := seeded.Get(storage.HistoryTable, util.Uint64AsBytes(i))
+		require.NoError(t, err)
+	}
+}
+
+func TestSeedFromCheckpoint(t *testing.T) {
+	store, closeF := openRocksDBStore(t)
+	defer closeF()
+
+	numElems := uint64(100)
+	mutateElems(t, store, 0, numElems)
+
+	path := mustTempDir()
+	defer deleteFile(path)
+	checkDir := filepath.Join(path, "checkpoint")
+	dbDir := filepath.Join(path, "seeded.db")
+
+	checkpoint, err := store.db.NewCheckpoint()
+	require.NoError(t, err)
+	defer checkpoint.Destroy()
+	require.NoError(t, checkpoint.CreateCheckpoint(checkDir, 0))
+
+	require.Error(t, SeedFromCheckpoint(dbDir, checkDir))
+	require.NoError(t, SeedFromCheckpoint(checkDir, dbDir))
+	require.Equal(t, ErrDatabaseExists, SeedFromCheckpoint(checkDir, dbDir))
+
+	seeded, err := NewRocksDBStore(dbDir, 0)
+	require.NoError(t, err)
+	defer seeded.Close()
+
+	require.Equal(t, store.LastWALSequenceNumber(), seeded.LastWALSequenceNumber())
+	for i := uint64(0); i < numElems; i++ {
+		kv, err := seeded.Get(storage.HistoryTable, util.Uint64AsBytes(i))
+		require.NoError(t, err)
+		require.Equal(t, util.Uint64AsBytes(i), kv.Value)
+	}
+}
+
+func mutateElems(t *testing.T, store *RocksDBStore, from, to uint64) {
+	for i := from; i < to; i++ {
+		key := util.Uint64AsBytes(i)
+		err := store.Mutate(
+			[]*storage.Mutation{
+				{Table: storage.HistoryTable, Key: key, Value: key},
+			},
+			key,
+		)
+		require.NoError(t, err)
+	}
+}
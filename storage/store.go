@@ -43,9 +43,20 @@ const (
 	FSMStateTable
 )
 
+// Tables lists the tables holding data, which excludes the default one.
+var Tables = []Table{HyperTable, HyperCacheTable, HistoryTable, FSMStateTable}
+
 // FSMStateTableKey single key to persist fsm state.
 var FSMStateTableKey = []byte{0xab}
 
+// FSMSettingsTableKey single key to persist the cluster settings changed
+// through admin commands.
+var FSMSettingsTableKey = []byte{0xac}
+
+// EncryptionTableKey single key marking the database as encrypted at
+// rest.
+var EncryptionTableKey = []byte{0xad}
+
 // String returns a string representation of the table.
 func (t Table) String() string {
 	var s string
@@ -85,6 +96,11 @@ func (t Table) Prefix() byte {
 
 var (
 	ErrKeyNotFound = errors.New("key not found")
+	// ErrWALPurged is returned by FetchSnapshot when the first write
+	// batch requested is no longer available in the WAL.
+	ErrWALPurged = errors.New("requested WAL entries have been purged")
+	// ErrReadOnly is returned when writing to a store opened read-only.
+	ErrReadOnly = errors.New("store opened read-only")
 )
 
 type Store interface {
@@ -102,6 +118,7 @@ type ManagedStore interface {
 	GetBackupsInfo() []*BackupInfo
 	DeleteBackup(backupID uint32) error
 	RestoreFromBackup(backupID uint32, dbDir, walDir string) error
+	Checkpoint(dir string) error
 	FetchSnapshot(w io.WriteCloser, since, until uint64, validate ValidateF) error
 	LoadSnapshot(r io.ReadCloser) error
 	LastWALSequenceNumber() uint64